@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// access levels of the routes
+const (
+	accessPublic = "public"
+	accessAPIKey = "api-key"
+	accessAdmin  = "admin"
+)
+
+// levels of the routes without an access section, or not in its routes
+var defaultRouteAccess = map[string]string{
+	"/admin":  accessAdmin,
+	"/admin/": accessAdmin,
+}
+
+// AccessConfig sets who may use each route, see access.go.
+type AccessConfig struct {
+	// Level of each route by its path, "public", "api-key" or "admin". A
+	// path ending in / covers the paths under it, the longest one matching
+	// the request. Routes not given are public, /admin and /admin/ admin.
+	Routes map[string]string `json:"routes"`
+	// Keys the api-key routes need as the key parameter or a bearer token.
+	// The key of the admin section goes too.
+	Keys []string `json:"keys"`
+	// Addresses and networks the admin routes may be used from, e.g.
+	// "127.0.0.1" or "10.0.0.0/8", from anywhere if none
+	AdminAllow []string `json:"adminAllow"`
+	// Addresses and networks of reverse proxies in front of keli, whose
+	// requests are taken to come from the address in X-Forwarded-For
+	TrustedProxies []string `json:"trustedProxies"`
+
+	adminAllow     []netip.Prefix
+	trustedProxies []netip.Prefix
+}
+
+func (a *AccessConfig) check() error {
+	for path, level := range a.Routes {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("Invalid route \"%s\", expected a path starting with /", path)
+		}
+		if level != accessPublic && level != accessAPIKey && level != accessAdmin {
+			return fmt.Errorf("Invalid level \"%s\" of %s, expected public, api-key or admin", level, path)
+		}
+		if level == accessAPIKey && len(a.Keys) == 0 {
+			return fmt.Errorf("Missing 'keys' of the api-key routes")
+		}
+	}
+	var err error
+	if a.adminAllow, err = parsePrefixes(a.AdminAllow); err != nil {
+		return fmt.Errorf("Error in adminAllow: %v", err)
+	}
+	if a.trustedProxies, err = parsePrefixes(a.TrustedProxies); err != nil {
+		return fmt.Errorf("Error in trustedProxies: %v", err)
+	}
+	return nil
+}
+
+// parsePrefixes parses addresses and networks like "127.0.0.1" and
+// "10.0.0.0/8", an address being a network of its own.
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, item := range list {
+		if addr, err := netip.ParseAddr(item); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid address \"%s\", expected an address or a network like 10.0.0.0/8", item)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// inPrefixes tells whether the address is in one of the networks.
+func inPrefixes(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return slices.ContainsFunc(prefixes, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// routeAccess returns the level of the path: that of the route matching it
+// exactly, or else that of the longest route ending in / it is under.
+func routeAccess(c *Config, path string) string {
+	routes := defaultRouteAccess
+	if c.Access != nil && len(c.Access.Routes) > 0 {
+		routes = make(map[string]string, len(defaultRouteAccess)+len(c.Access.Routes))
+		for route, level := range defaultRouteAccess {
+			routes[route] = level
+		}
+		for route, level := range c.Access.Routes {
+			routes[route] = level
+		}
+	}
+
+	if level, found := routes[path]; found {
+		return level
+	}
+	level, longest := accessPublic, ""
+	for route, l := range routes {
+		if strings.HasSuffix(route, "/") && strings.HasPrefix(path, route) && len(route) > len(longest) {
+			level, longest = l, route
+		}
+	}
+	return level
+}
+
+// clientAddr returns the address the request comes from, the one in
+// X-Forwarded-For when it comes through a trusted proxy.
+func clientAddr(c *Config, r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	if c.Access == nil || !inPrefixes(c.Access.trustedProxies, addr) {
+		return addr
+	}
+	// the last address is the one the proxy saw, the others are the
+	// client's word
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if forwardedAddr, err := netip.ParseAddr(strings.TrimSpace(forwarded[len(forwarded)-1])); err == nil {
+		return forwardedAddr
+	}
+	return addr
+}
+
+// validAPIKey tells whether the request has one of the keys of the api-key
+// routes, or the key of the admin section.
+func validAPIKey(c *Config, r *http.Request) bool {
+	if validAdmin(c.Admin, r) {
+		return true
+	}
+	if c.Access == nil {
+		return false
+	}
+	return slices.ContainsFunc(c.Access.Keys, func(key string) bool { return validKey(r, key) })
+}
+
+// withAccess serves the requests the level of their route allows: public
+// routes to anyone, api-key routes with one of the keys and admin routes
+// with the key of the admin section from the allowed addresses.
+func withAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the same config for all the checks, even if it is reloaded meanwhile
+		c := config()
+		switch routeAccess(c, r.URL.Path) {
+		case accessAPIKey:
+			if !validAPIKey(c, r) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="keli"`)
+				http.Error(w, "Needs a valid API key", http.StatusUnauthorized)
+				return
+			}
+
+		case accessAdmin:
+			if c.Admin == nil {
+				http.NotFound(w, r)
+				return
+			}
+			if c.Access != nil && len(c.Access.adminAllow) > 0 {
+				if addr := clientAddr(c, r); !inPrefixes(c.Access.adminAllow, addr) {
+					logf(r.Context(), "Refused %s to %s, not in adminAllow", r.URL.Path, addr)
+					http.Error(w, "Admin is not allowed from this address", http.StatusForbidden)
+					return
+				}
+			}
+			if !validAdmin(c.Admin, r) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="keli admin"`)
+				http.Error(w, "Admin needs a valid key", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// errors kept for the admin page
+const adminErrorCount = 50
+
+// AdminConfig enables /admin, a page for looking after the running server,
+// see admin.go.
+type AdminConfig struct {
+	// Key the page needs as the password of HTTP basic auth, a bearer token
+	// or the key parameter
+	Key string `json:"key"`
+}
+
+// AdminPage is what the admin page shows.
+type AdminPage struct {
+	Cache   []AdminCacheEntry
+	Sources []SourceStatus
+	// The latest errors logged, newest first
+	Errors []LoggedError
+	// What the last action did
+	Message string
+}
+
+// AdminCacheEntry is the weather of a city in the cache.
+type AdminCacheEntry struct {
+	City    string
+	Updated time.Time
+	Age     time.Duration
+	// Older than cacheTTL, fetched again when next asked for
+	Stale bool
+}
+
+// LoggedError is an error written to the log.
+type LoggedError struct {
+	Time    time.Time
+	Message string
+}
+
+var (
+	// sources disabled on the admin page until the server restarts
+	disabledSources = make(map[string]bool)
+
+	loggedErrors      []LoggedError
+	loggedErrorsMutex sync.Mutex
+)
+
+func (a *AdminConfig) check() error {
+	if a.Key == "" {
+		return fmt.Errorf("Missing 'key'")
+	}
+	return nil
+}
+
+// errorLog keeps the errors written to the log for the admin page.
+type errorLog struct{}
+
+func (errorLog) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSpace(string(p)), "\n") {
+		if !strings.Contains(line, "Error") && !strings.Contains(line, " failed ") {
+			continue
+		}
+		// without the date and time of the log
+		if len(line) > 20 {
+			if _, err := time.Parse("2006/01/02 15:04:05", line[:19]); err == nil {
+				line = line[20:]
+			}
+		}
+		loggedErrorsMutex.Lock()
+		loggedErrors = append(loggedErrors, LoggedError{Time: time.Now().In(location), Message: line})
+		if len(loggedErrors) > adminErrorCount {
+			loggedErrors = loggedErrors[len(loggedErrors)-adminErrorCount:]
+		}
+		loggedErrorsMutex.Unlock()
+	}
+	return len(p), nil
+}
+
+// StartAdmin starts keeping the errors logged for the admin page.
+func StartAdmin() {
+	log.SetOutput(io.MultiWriter(os.Stderr, errorLog{}))
+}
+
+// validAdmin tells whether the request has the key of the admin page, never
+// when there is no admin section.
+func validAdmin(admin *AdminConfig, r *http.Request) bool {
+	if admin == nil {
+		return false
+	}
+	if validKey(r, admin.Key) {
+		return true
+	}
+	_, password, ok := r.BasicAuth()
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(admin.Key)) == 1
+}
+
+// sameOrigin tells whether a request changing something comes from a page
+// of this server, or from no page at all as with curl, so that other sites
+// can't make a logged in browser post to the admin page, or change the
+// favorites of a browser.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// sourceDisabled tells whether the source is disabled by the config or on
+// the admin page, sourceStatusesMutex must be held.
+func sourceDisabled(name string) bool {
+	return config().Sources[name].Disabled || disabledSources[name]
+}
+
+// NewAdminPage returns the state of the server for the admin page.
+func NewAdminPage(message string) AdminPage {
+	page := AdminPage{Sources: SourceStatuses(), Message: message}
+
+	now := time.Now()
+	cacheMutex.Lock()
+	for city, weather := range cache {
+		age := now.Sub(weather.LastUpdated)
+		page.Cache = append(page.Cache, AdminCacheEntry{
+			City:    city,
+			Updated: weather.LastUpdated.In(location),
+			Age:     age.Round(time.Second),
+			Stale:   age >= cacheTTL(),
+		})
+	}
+	cacheMutex.Unlock()
+	slices.SortFunc(page.Cache, func(a, b AdminCacheEntry) int { return strings.Compare(a.City, b.City) })
+
+	loggedErrorsMutex.Lock()
+	page.Errors = slices.Clone(loggedErrors)
+	loggedErrorsMutex.Unlock()
+	slices.Reverse(page.Errors)
+	return page
+}
+
+// adminHandler serves /admin, the caches, the sources and the latest errors
+// with buttons to act on them, and the actions they post to:
+// /admin/purge with a city, or none for all, /admin/refresh with a city,
+// /admin/source with a source and action=disable or enable and
+// /admin/reload, which reloads the config.
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	admin := config().Admin
+	if admin == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !validAdmin(admin, r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="keli admin"`)
+		http.Error(w, "Admin needs a valid key", http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/admin" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		page := NewAdminPage(r.URL.Query().Get("message"))
+		if err := renderTemplate(w, "admin.html", templateContext{Request: r, Lang: LangEnglish}, page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "Admin actions must come from the admin page", http.StatusForbidden)
+		return
+	}
+
+	var message string
+	city := keli.SanitizeCityName(r.FormValue("city"))
+	switch r.URL.Path {
+	case "/admin/purge":
+		cacheMutex.Lock()
+		if city == "" {
+			clear(cache)
+			message = "Purged the cache"
+		} else {
+			delete(cache, city)
+			message = fmt.Sprintf("Purged %s from the cache", city)
+		}
+		cacheMutex.Unlock()
+
+	case "/admin/refresh":
+		if city == "" {
+			http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+			return
+		}
+		if _, err := RefreshWeatherData(r.Context(), city); err != nil {
+			message = fmt.Sprintf("Error refreshing %s: %v", city, err)
+		} else {
+			message = fmt.Sprintf("Refreshed %s", city)
+		}
+
+	case "/admin/source":
+		name := r.FormValue("source")
+		if !slices.ContainsFunc(keli.Sources, func(s keli.Source) bool { return s.Name == name }) {
+			http.Error(w, fmt.Sprintf("Unknown source \"%s\"", name), http.StatusBadRequest)
+			return
+		}
+		action := r.FormValue("action")
+		if action != "disable" && action != "enable" {
+			http.Error(w, fmt.Sprintf("Invalid action \"%s\", expected disable or enable", action), http.StatusBadRequest)
+			return
+		}
+		sourceStatusesMutex.Lock()
+		disabledSources[name] = action == "disable"
+		sourceStatusesMutex.Unlock()
+		message = fmt.Sprintf("Source %s %sd", name, action)
+		if action == "enable" && config().Sources[name].Disabled {
+			message += ", but the config disables it"
+		}
+
+	case "/admin/reload":
+		if err := ReloadConfig(); err != nil {
+			message = fmt.Sprintf("Error reloading config: %v", err)
+		} else {
+			message = "Reloaded config"
+		}
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	logf(r.Context(), "Admin: %s", message)
+	http.Redirect(w, r, "/admin?message="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// exampleAdminPage returns a made up admin page for checking templates.
+func exampleAdminPage(weather WeatherData) AdminPage {
+	return AdminPage{
+		Cache:   []AdminCacheEntry{{City: weather.City, Updated: weather.LastUpdated, Age: 2 * time.Minute}},
+		Sources: []SourceStatus{{Name: "foreca", URL: "https://www.foreca.fi/Finland/", Enabled: true, Breaker: "closed", Fetches: 3, LastError: "Error <b>", LastErrorTime: &weather.LastUpdated}},
+		Errors:  []LoggedError{{Time: weather.LastUpdated, Message: "Error fetching foreca for Hyvinkää: timeout"}},
+		Message: "Refreshed Hyvinkää",
+	}
+}
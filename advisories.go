@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// the advisories notified of that haven't ended, by the name and the
+	// city of the advisory and the type
+	activeAdvisories      = make(map[string]bool)
+	activeAdvisoriesMutex sync.Mutex
+)
+
+// AdvisoryConfig sets the temperatures giving a city a heat or cold
+// advisory in the advisories section of the config.
+type AdvisoryConfig struct {
+	// Name of the advisory in the logs and the notifications
+	Name string `json:"name"`
+	City string `json:"city"`
+	// The current weather or the hourly forecast going below or above
+	// these (°C) gives an advisory, either can be left out
+	Below *float64 `json:"below"`
+	Above *float64 `json:"above"`
+	// Where the advisories are notified when they start, if anywhere, see
+	// notify.go
+	Notify
+	// Language of the notification text, Finnish by default
+	Lang string `json:"lang"`
+
+	lang Language
+}
+
+// Advisory is a temperature beyond one configured for the city in the
+// current weather or the hourly forecast.
+type Advisory struct {
+	// "cold" or "heat"
+	Type      string  `json:"type"`
+	Threshold float64 `json:"threshold"`
+	// The coldest or hottest temperature beyond the threshold and its hour
+	Temperature float64   `json:"temperature"`
+	At          time.Time `json:"at"`
+}
+
+// AdvisoryNotification is the JSON body posted to the webhook of an
+// advisory.
+type AdvisoryNotification struct {
+	Alert    string   `json:"alert"`
+	City     string   `json:"city"`
+	Advisory Advisory `json:"advisory"`
+	// Text describing the notification, e.g. "Cold advisory for Oulu: down
+	// to -27.0°C at 06:00"
+	Text string `json:"text"`
+}
+
+func (a *AdvisoryConfig) check(email *EmailConfig) error {
+	if a.City == "" {
+		return fmt.Errorf("Missing 'city'")
+	}
+	if a.Below == nil && a.Above == nil {
+		return fmt.Errorf("Missing 'below' or 'above'")
+	}
+	if a.notifies() {
+		if err := a.Notify.check(email); err != nil {
+			return err
+		}
+	}
+	lang, err := ParseLanguage(a.Lang)
+	if err != nil {
+		return err
+	}
+	a.lang = lang
+	if a.Name == "" {
+		a.Name = "advisory " + a.City
+	}
+	return nil
+}
+
+// advisories returns the advisories of the weather by the thresholds of
+// the config, the cold one first.
+func (a AdvisoryConfig) advisories(weather WeatherData) []Advisory {
+	var advisories []Advisory
+	times := ForecastTimes(weather)
+	beyond := func(kind string, threshold float64, worse func(t, than float64) bool) {
+		advisory := Advisory{Type: kind, Threshold: threshold, Temperature: weather.Temperature, At: weather.LastUpdated}
+		for i, h := range weather.HourlyForecast {
+			if worse(h.Temperature, advisory.Temperature) {
+				advisory.Temperature, advisory.At = h.Temperature, times[i]
+			}
+		}
+		if worse(advisory.Temperature, threshold) {
+			advisories = append(advisories, advisory)
+		}
+	}
+	if a.Below != nil {
+		beyond("cold", *a.Below, func(t, than float64) bool { return t < than })
+	}
+	if a.Above != nil {
+		beyond("heat", *a.Above, func(t, than float64) bool { return t > than })
+	}
+	return advisories
+}
+
+// advisoriesFor returns the advisories of the weather of the city from all
+// the thresholds configured for it.
+func advisoriesFor(weather WeatherData, city string) []Advisory {
+	var advisories []Advisory
+	for _, a := range config().Advisories {
+		if strings.EqualFold(a.City, city) || strings.EqualFold(a.City, weather.City) {
+			advisories = append(advisories, a.advisories(weather)...)
+		}
+	}
+	return advisories
+}
+
+// Text describes the advisory of the city, its temperatures in the unit.
+func (advisory Advisory) Text(lang Language, city, unit string) string {
+	key := "advisoryCold"
+	if advisory.Type == "heat" {
+		key = "advisoryHeat"
+	}
+	return lang.T(key, city, lang.Temperature(advisory.Temperature, unit), advisory.At.In(location).Format("15:04"))
+}
+
+// StartAdvisories starts watching the weather of the cities of the
+// advisories that notify somewhere.
+func StartAdvisories(advisories []AdvisoryConfig) {
+	for _, a := range advisories {
+		if a.notifies() {
+			watchCity("advisories", a.City, notifyAdvisories)
+		}
+	}
+}
+
+// notifies tells whether the advisory notifies anywhere.
+func (a AdvisoryConfig) notifies() bool {
+	return a.Webhook != "" || a.Email != "" || a.Telegram != nil
+}
+
+// notifyAdvisories checks the thresholds of the advisories of the city in
+// the config in use whenever its weather is refreshed. An advisory
+// notifies when it starts, not again until it has ended in between.
+func notifyAdvisories(city string, weather WeatherData) {
+	activeAdvisoriesMutex.Lock()
+	defer activeAdvisoriesMutex.Unlock()
+
+	for _, a := range config().Advisories {
+		if !a.notifies() || cityKey(a.City) != city {
+			continue
+		}
+		key := a.Name + "|" + city + "|"
+		now := make(map[string]bool)
+		for _, advisory := range a.advisories(weather) {
+			now[advisory.Type] = true
+			if activeAdvisories[key+advisory.Type] {
+				continue
+			}
+			text := advisory.Text(a.lang, weather.City, "°C")
+			go a.send(a.Name, text, AdvisoryNotification{
+				Alert:    a.Name,
+				City:     weather.City,
+				Advisory: advisory,
+				Text:     text,
+			})
+		}
+		for _, kind := range []string{"cold", "heat"} {
+			activeAdvisories[key+kind] = now[kind]
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	ansiReset = "\033[0m"
+	ansiBold  = "\033[1m"
+	// hours shown in the hourly strip
+	ansiHours = 12
+	// width of one hour column in the hourly strip
+	ansiColumnWidth = 7
+)
+
+// ansiArt holds the ASCII art and 256-color code of each icon, see icons.go.
+// Every picture is five lines of thirteen characters.
+var ansiArt = map[string]struct {
+	Color int
+	Lines [5]string
+}{
+	"clear": {226, [5]string{
+		"    \\   /    ",
+		"     .-.     ",
+		"  ― (   ) ―  ",
+		"     `-'     ",
+		"    /   \\    ",
+	}},
+	"clear-night": {229, [5]string{
+		"      .--.   ",
+		"     /  ,'   ",
+		"    |  (     ",
+		"     \\  `.   ",
+		"      `--'   ",
+	}},
+	"partly-cloudy": {226, [5]string{
+		"   \\  /      ",
+		" _ /\"\".-.    ",
+		"   \\_(   ).  ",
+		"   /(___(__) ",
+		"             ",
+	}},
+	"cloudy": {250, [5]string{
+		"             ",
+		"     .--.    ",
+		"  .-(    ).  ",
+		" (___.__)__) ",
+		"             ",
+	}},
+	"fog": {250, [5]string{
+		"             ",
+		" _ - _ - _ - ",
+		"  _ - _ - _  ",
+		" _ - _ - _ - ",
+		"             ",
+	}},
+	"rain": {111, [5]string{
+		"     .-.     ",
+		"    (   ).   ",
+		"   (___(__)  ",
+		"    ‚‘‚‘‚‘   ",
+		"    ‚’‚’‚’   ",
+	}},
+	"sleet": {153, [5]string{
+		"     .-.     ",
+		"    (   ).   ",
+		"   (___(__)  ",
+		"    ‘ * ‘ *  ",
+		"   * ‘ * ‘   ",
+	}},
+	"snow": {255, [5]string{
+		"     .-.     ",
+		"    (   ).   ",
+		"   (___(__)  ",
+		"    *  *  *  ",
+		"   *  *  *   ",
+	}},
+	"thunder": {228, [5]string{
+		"     .-.     ",
+		"    (   ).   ",
+		"   (___(__)  ",
+		"    ‚‘/_‚‘/_ ",
+		"     ‚ /  ‚/ ",
+	}},
+	"unknown": {250, [5]string{
+		"    .-.      ",
+		"     __)     ",
+		"    (        ",
+		"     `-'     ",
+		"      •      ",
+	}},
+}
+
+// ansiTemperatureColors maps temperatures (°C) to 256-color codes, from cold
+// blues to hot reds. A temperature gets the color of the last limit it is
+// at or above.
+var ansiTemperatureColors = []struct {
+	Limit float64
+	Color int
+}{
+	{-100, 21},
+	{-15, 27},
+	{-10, 33},
+	{-5, 39},
+	{0, 45},
+	{5, 118},
+	{10, 154},
+	{15, 226},
+	{20, 214},
+	{25, 202},
+	{30, 196},
+}
+
+func ansiColor(color int, s string) string {
+	return fmt.Sprintf("\033[38;5;%dm%s%s", color, s, ansiReset)
+}
+
+// ansiPrecipitation colors a formatted amount of precipitation by its kind,
+// marking snow with a snowflake.
+func ansiPrecipitation(formatted string, kind keli.PrecipitationType) string {
+	switch kind {
+	case keli.PrecipitationSnow:
+		return ansiColor(255, formatted+"❄")
+	case keli.PrecipitationSleet:
+		return ansiColor(153, formatted)
+	}
+	return ansiColor(111, formatted)
+}
+
+// ansiTemperature colors a formatted temperature by its value.
+func ansiTemperature(temperature float64, units keli.UnitSystem, formatted string) string {
+	celsius := units.Celsius(temperature)
+	color := ansiTemperatureColors[0].Color
+	for _, c := range ansiTemperatureColors {
+		if celsius >= c.Limit {
+			color = c.Color
+		}
+	}
+	return ansiColor(color, formatted)
+}
+
+// padRight pads s with spaces to the given width, ignoring ANSI escapes.
+// Emoji are counted as two columns wide.
+func padRight(s string, width int) string {
+	visible := 0
+	escape := false
+	for _, r := range s {
+		switch {
+		case r == '\033':
+			escape = true
+		case escape:
+			if r == 'm' {
+				escape = false
+			}
+		case r >= 0x1F000 || r == '⛅' || r == '⛈':
+			visible += 2
+		case r == 0xFE0F:
+			// emoji variation selector takes no space
+		default:
+			visible++
+		}
+	}
+	if visible >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-visible)
+}
+
+// ANSIText returns the weather for terminals, colored with ANSI escapes: a
+// picture of the current weather next to the current conditions, followed
+// by an hourly strip.
+func ANSIText(weather WeatherData, lang Language) string {
+	units := weather.Labels()
+	temperature := func(t float64) string {
+		return ansiTemperature(t, weather.Units, lang.Temperature(t, units.Temperature))
+	}
+
+	art, found := ansiArt[WeatherIconName(weather.SymbolCode)]
+	if !found {
+		art = ansiArt[strings.TrimSuffix(WeatherIconName(weather.SymbolCode), "-night")]
+	}
+
+	details := [5]string{
+		lang.Summary(weather),
+		fmt.Sprintf("%s (%s)", temperature(weather.Temperature), temperature(weather.TemperatureFeelsLike)),
+		fmt.Sprintf("%d %s, %s", weather.WindSpeed, units.WindSpeed, lang.WindDescription(weather.Beaufort)),
+		fmt.Sprintf("%s %s", lang.Precipitation(weather.Rainfall, weather.Units), units.Precipitation),
+		fmt.Sprintf("%s %s  %s %s", lang.T("min"), temperature(weather.TemperatureMin), lang.T("max"), temperature(weather.TemperatureMax)),
+	}
+
+	var output strings.Builder
+	output.WriteString(ansiBold + lang.T("title", weather.City, weather.ObservationHour) + ansiReset + "\n\n")
+	for i, line := range art.Lines {
+		output.WriteString(ansiColor(art.Color, line) + " " + details[i] + "\n")
+	}
+
+	hours := weather.HourlyForecast
+	if len(hours) > ansiHours {
+		hours = hours[:ansiHours]
+	}
+	if len(hours) > 0 {
+		var hourRow, symbolRow, temperatureRow, rainRow string
+		for _, h := range hours {
+			hourRow += padRight(ansiBold+h.Hour+ansiReset, ansiColumnWidth)
+			symbolRow += padRight(h.WeatherSymbol, ansiColumnWidth)
+			temperatureRow += padRight(ansiTemperature(h.Temperature, weather.Units, lang.Decimal(h.Temperature, 0)+"°"), ansiColumnWidth)
+			rainRow += padRight(ansiPrecipitation(lang.Precipitation(h.Rainfall, weather.Units), h.PrecipitationType), ansiColumnWidth)
+		}
+		output.WriteString("\n" + hourRow + "\n" + symbolRow + "\n" + temperatureRow + "\n" + rainRow + "\n")
+	}
+
+	output.WriteString("\n" + lang.T("sunrise", weather.Sunrise) + "  " + lang.T("sunset", weather.Sunset) + "\n")
+
+	return output.String()
+}
+
+func weatherANSIHandler(w http.ResponseWriter, weather WeatherData, lang Language) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	_, err := w.Write([]byte(ANSIText(weather, lang)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
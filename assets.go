@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//go:embed static/css static/js static/icons
+var staticFiles embed.FS
+
+// assets maps the names of the static files, e.g. "css/keli.css", to names
+// with a hash of the content, e.g. "css/keli.3f2a9b1c0d.css". The hashed
+// names change with the content, so they can be cached forever. assetFiles
+// maps the hashed names back.
+var assets, assetFiles = hashAssets()
+
+func hashAssets() (hashed, files map[string]string) {
+	hashed = make(map[string]string)
+	files = make(map[string]string)
+	err := fs.WalkDir(staticFiles, "static", func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := staticFiles.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		name := strings.TrimPrefix(file, "static/")
+		ext := path.Ext(name)
+		hashed[name] = strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:5]) + ext
+		files[hashed[name]] = name
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Error hashing static files: %v", err)
+	}
+	return hashed, files
+}
+
+// assetURL returns the URL of the static file with a hash in the name.
+func assetURL(name string) string {
+	hashed, found := assets[name]
+	if !found {
+		log.Printf("Unknown static file %s", name)
+		return "/static/" + name
+	}
+	return "/static/" + hashed
+}
+
+// iconURL returns the URL of the icon of the symbol code, see assetURL.
+func iconURL(code string) string {
+	return assetURL("icons/" + WeatherIconName(code) + ".svg")
+}
+
+// staticHandler serves /static/. Hashed names are cached for a year, the
+// plain names work too but are only cached for a while.
+func staticHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	cacheControl := "public, max-age=31536000, immutable"
+	if file, found := assetFiles[name]; found {
+		name = file
+	} else {
+		cacheControl = "public, max-age=300"
+	}
+
+	content, err := staticFiles.ReadFile("static/" + name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Write(content)
+}
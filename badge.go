@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// badgeColors maps temperatures (°C) to badge colors like ansiTemperatureColors.
+var badgeColors = []struct {
+	Limit float64
+	Color string
+}{
+	{-100, "#5b6ee1"},
+	{-10, "#4c8eda"},
+	{0, "#3fb0d9"},
+	{10, "#4c1"},
+	{20, "#dfb317"},
+	{25, "#fe7d37"},
+	{30, "#e05d44"},
+}
+
+// Badge is the data of templates/badge.svg
+type Badge struct {
+	Label string
+	Value string
+	Color string
+	// Icon as a data URI, so the badge works as a standalone image
+	Icon template.URL
+	// Widths and text centers (px)
+	Width      int
+	LabelWidth int
+	ValueWidth int
+	LabelX     int
+	ValueX     int
+}
+
+// badgeTextWidth estimates the width of text in 11px Verdana. The estimate
+// does not have to be exact, the text is centered in its box.
+func badgeTextWidth(s string) int {
+	return utf8.RuneCountInString(s) * 7
+}
+
+// NewBadge returns a badge with the weather icon and current temperature.
+func NewBadge(weather WeatherData, lang Language, label string) Badge {
+	if label == "" {
+		label = weather.City
+	}
+	badge := Badge{
+		Label: label,
+		Value: lang.Temperature(weather.Temperature, weather.Labels().Temperature),
+	}
+
+	celsius := weather.Units.Celsius(weather.Temperature)
+	badge.Color = badgeColors[0].Color
+	for _, c := range badgeColors {
+		if celsius >= c.Limit {
+			badge.Color = c.Color
+		}
+	}
+
+	badge.Icon = iconDataURI(weather.SymbolCode)
+
+	// icon and padding on the left
+	badge.LabelWidth = 5 + 14 + 4 + badgeTextWidth(badge.Label) + 6
+	badge.ValueWidth = 6 + badgeTextWidth(badge.Value) + 6
+	badge.Width = badge.LabelWidth + badge.ValueWidth
+	badge.LabelX = 23 + (badge.LabelWidth-23-6)/2
+	badge.ValueX = badge.LabelWidth + badge.ValueWidth/2
+
+	return badge
+}
+
+// badgeHandler serves /badge?city=X as an SVG badge
+func badgeHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	units, err := keli.ParseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+	weather = ConvertUnits(weather, units, "")
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	// image proxies like GitHub's camo cache badges, keep them fresh
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	context := templateContext{Request: r, Lang: lang, Weather: weather}
+	err = renderTemplate(w, "badge.svg", context, NewBadge(weather, lang, r.URL.Query().Get("label")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// cborEncoding keeps floats as small as they can be without losing
+// precision, which matters on microcontroller clients.
+var cborEncoding, _ = cbor.EncOptions{
+	ShortestFloat: cbor.ShortestFloat16,
+	Time:          cbor.TimeUnix,
+}.EncMode()
+
+// MarshalCBOR encodes the weather data as CBOR, keyed like the JSON output.
+func MarshalCBOR(weather WeatherData) ([]byte, error) {
+	return cborEncoding.Marshal(weather)
+}
+
+// MarshalMsgpack encodes the weather data as MessagePack, keyed like the JSON
+// output.
+func MarshalMsgpack(weather WeatherData) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	encoder.UseCompactInts(true)
+	encoder.UseCompactFloats(true)
+	if err := encoder.Encode(weather); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func weatherBinaryHandler(w http.ResponseWriter, weather WeatherData, contentType string, marshal func(WeatherData) ([]byte, error)) {
+	data, err := marshal(weather)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+
+	_, err = w.Write(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
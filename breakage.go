@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	// weights of the latest parse in the recent rates and in the baseline
+	// they are compared to
+	recentWeight   = 0.3
+	baselineWeight = 0.02
+	// parses needed before the baseline is trusted
+	minParses = 20
+	// drop of a recent rate below the baseline flagging a source as broken,
+	// and how close it has to get back to be fine again
+	degradedDrop  = 0.3
+	recoveredDrop = 0.1
+)
+
+// AlertConfig is where alerts about broken sources are posted, see
+// breakage.go.
+type AlertConfig struct {
+	URL string `json:"url"`
+	// Retries of a failed delivery, 3 by default and -1 for none
+	Retries int `json:"retries"`
+}
+
+// SourceAlert is the JSON body posted when a source breaks or recovers.
+type SourceAlert struct {
+	Source string `json:"source"`
+	// "degraded" or "recovered"
+	Status string `json:"status"`
+	// Text describing the alert, e.g. "ampparit is degraded: ..."
+	Text             string    `json:"text"`
+	ParseSuccessRate float64   `json:"parseSuccessRate"`
+	FieldFillRate    float64   `json:"fieldFillRate"`
+	Time             time.Time `json:"time"`
+}
+
+func (a *AlertConfig) check() error {
+	if u, err := url.Parse(a.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid url \"%s\", expected an http or https URL", a.URL)
+	}
+	if a.Retries == 0 {
+		a.Retries = defaultWebhookRetries
+	}
+	return nil
+}
+
+// filledFields returns the fields of the JSON output the data parsed from a
+// source has.
+func filledFields(d keli.Weather) map[string]bool {
+	filled := map[string]bool{
+		"city":            d.City != "",
+		"observationHour": d.ObservationHour != 0 || d.Present("observationHour"),
+		"weatherSummary":  d.WeatherSummary != "",
+		"symbolCode":      d.SymbolCode != "",
+		"weather":         d.WeatherSymbol != "",
+		"rainChance":      d.RainChance != 0 || d.Present("rainChance"),
+		"sunrise":         d.Sunrise != "",
+		"sunset":          d.Sunset != "",
+		"dayLength":       d.DayLength != "",
+		"hourlyForecast":  len(d.HourlyForecast) > 0,
+		"dailyForecast":   len(d.DailyForecast) > 0,
+	}
+	for name, field := range keli.NumericFields {
+		filled[name] = field.Get(d) != 0 || d.Present(name)
+	}
+	return filled
+}
+
+// recordParse tracks how often the source parses and how many of the
+// fields it has had it fills, flagging it as degraded when either suddenly
+// drops below its usual level, as when the site of the source is redesigned.
+// A failure to parse counts as a failure of the source, though not towards
+// opening the breaker, see recordFetch.
+func recordParse(source keli.Source, data keli.Weather, err error) {
+	sourceStatusesMutex.Lock()
+	defer sourceStatusesMutex.Unlock()
+
+	status := sourceStatus(source)
+	now := time.Now()
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+		status.LastErrorTime = &now
+	} else {
+		status.LastSuccess = &now
+	}
+	if status.fieldsSeen == nil {
+		status.fieldsSeen = make(map[string]bool)
+	}
+
+	parsed, fill := 0.0, 0.0
+	if err == nil {
+		parsed = 1
+		filled := filledFields(data)
+		count := 0
+		for field, ok := range filled {
+			if ok {
+				status.fieldsSeen[field] = true
+				count++
+			}
+		}
+		fill = float64(count) / float64(len(status.fieldsSeen))
+	}
+
+	if status.parses == 0 {
+		status.ParseSuccessRate, status.parseBaseline = parsed, parsed
+		status.FieldFillRate, status.fillBaseline = fill, fill
+	} else {
+		status.ParseSuccessRate = roundTo(status.ParseSuccessRate*(1-recentWeight)+parsed*recentWeight, 3)
+		status.FieldFillRate = roundTo(status.FieldFillRate*(1-recentWeight)+fill*recentWeight, 3)
+		// the baseline stays what the source was like before it broke
+		if !status.Degraded {
+			status.parseBaseline = status.parseBaseline*(1-baselineWeight) + parsed*baselineWeight
+			status.fillBaseline = status.fillBaseline*(1-baselineWeight) + fill*baselineWeight
+		}
+	}
+	status.parses++
+	if status.parses < minParses {
+		return
+	}
+
+	var reason string
+	switch {
+	case status.ParseSuccessRate < status.parseBaseline-degradedDrop:
+		reason = fmt.Sprintf("parse success rate dropped to %.0f%% from %.0f%%", status.ParseSuccessRate*100, status.parseBaseline*100)
+	case status.FieldFillRate < status.fillBaseline-degradedDrop:
+		reason = fmt.Sprintf("field fill rate dropped to %.0f%% from %.0f%%", status.FieldFillRate*100, status.fillBaseline*100)
+	}
+	recovered := status.ParseSuccessRate >= status.parseBaseline-recoveredDrop &&
+		status.FieldFillRate >= status.fillBaseline-recoveredDrop
+
+	switch {
+	case !status.Degraded && reason != "":
+		status.Degraded, status.DegradedReason = true, reason
+		alertSource(*status, "degraded", fmt.Sprintf("%s is degraded: %s", source.Name, reason))
+	case status.Degraded && recovered:
+		status.Degraded, status.DegradedReason = false, ""
+		alertSource(*status, "recovered", fmt.Sprintf("%s has recovered", source.Name))
+	}
+}
+
+// alertSource logs the change in the state of a source and posts it to the
+// alert URL if there is one.
+func alertSource(status SourceStatus, state, text string) {
+	log.Printf("Source alert: %s", text)
+	alerts := config().Alerts
+	if alerts == nil {
+		return
+	}
+	hook := Webhook{Name: "alerts", URL: alerts.URL, Retries: alerts.Retries}
+	go deliverWebhook(hook, text, SourceAlert{
+		Source:           status.Name,
+		Status:           state,
+		Text:             text,
+		ParseSuccessRate: status.ParseSuccessRate,
+		FieldFillRate:    status.FieldFillRate,
+		Time:             time.Now(),
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// CalendarEvent is an event in the iCalendar feed.
+type CalendarEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+	End         time.Time
+}
+
+// precipitationEvents maps weather icons to the translation key of their
+// precipitation event. Other icons have no precipitation.
+var precipitationEvents = map[string]string{
+	"rain":    "eventRain",
+	"sleet":   "eventSleet",
+	"snow":    "eventSnow",
+	"thunder": "eventThunder",
+}
+
+// CalendarEvents returns today's sunrise and sunset and the forecast
+// precipitation as events. Consecutive hours with the same kind of
+// precipitation are joined into one event.
+func CalendarEvents(weather WeatherData, lang Language) []CalendarEvent {
+	var events []CalendarEvent
+	city := url.PathEscape(weather.City)
+	today := weather.LastUpdated.In(location)
+
+	for _, sun := range []struct{ name, clock string }{{"sunrise", weather.Sunrise}, {"sunset", weather.Sunset}} {
+		t, err := clockTime(today, sun.clock)
+		if err != nil {
+			continue
+		}
+		key := "eventSunrise"
+		if sun.name == "sunset" {
+			key = "eventSunset"
+		}
+		events = append(events, CalendarEvent{
+			UID:     fmt.Sprintf("%s-%s-%s@keli", t.Format("20060102"), sun.name, city),
+			Summary: lang.T(key) + " " + sun.clock,
+			Start:   t,
+			End:     t,
+		})
+	}
+
+	units := weather.Labels()
+	times := ForecastTimes(weather)
+	var current *CalendarEvent
+	var kind string
+	var amount float64
+
+	finish := func() {
+		if current != nil {
+			current.Description = lang.T("eventAmount", lang.Precipitation(amount, weather.Units), units.Precipitation)
+			events = append(events, *current)
+			current = nil
+		}
+	}
+
+	for i, h := range weather.HourlyForecast {
+		symbol, _ := keli.LookupWeatherSymbol(h.SymbolCode)
+		key, precipitation := precipitationEvents[symbol.Icon]
+		if !precipitation && h.Rainfall > 0 {
+			key, precipitation = "eventRain", true
+		}
+		if !precipitation || key != kind {
+			finish()
+			kind = ""
+		}
+		if !precipitation {
+			continue
+		}
+
+		if current == nil {
+			kind = key
+			amount = 0
+			current = &CalendarEvent{
+				UID:     fmt.Sprintf("%s-%s-%s@keli", times[i].Format("20060102T15"), strings.TrimPrefix(key, "event"), city),
+				Summary: lang.T(key),
+				Start:   times[i],
+			}
+		}
+		current.End = times[i].Add(time.Hour)
+		amount += h.Rainfall
+	}
+	finish()
+
+	return events
+}
+
+// icalEscape escapes a text value for iCalendar.
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icalLine folds a content line to at most 75 bytes per line as iCalendar
+// requires, without splitting UTF-8 characters.
+func icalLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// Calendar returns the events as an iCalendar file.
+func Calendar(weather WeatherData, lang Language, events []CalendarEvent) string {
+	const icalTime = "20060102T150405Z"
+	stamp := weather.LastUpdated.UTC().Format(icalTime)
+
+	var b strings.Builder
+	icalLine(&b, "BEGIN:VCALENDAR")
+	icalLine(&b, "VERSION:2.0")
+	icalLine(&b, "PRODID:-//keli//keli//"+strings.ToUpper(string(lang)))
+	icalLine(&b, "CALSCALE:GREGORIAN")
+	icalLine(&b, "X-WR-CALNAME:"+icalEscape(lang.T("feedTitle", weather.City)))
+	icalLine(&b, "REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	icalLine(&b, "X-PUBLISHED-TTL:PT1H")
+	for _, event := range events {
+		icalLine(&b, "BEGIN:VEVENT")
+		icalLine(&b, "UID:"+event.UID)
+		icalLine(&b, "DTSTAMP:"+stamp)
+		icalLine(&b, "DTSTART:"+event.Start.UTC().Format(icalTime))
+		icalLine(&b, "DTEND:"+event.End.UTC().Format(icalTime))
+		icalLine(&b, "SUMMARY:"+icalEscape(event.Summary))
+		if event.Description != "" {
+			icalLine(&b, "DESCRIPTION:"+icalEscape(event.Description))
+		}
+		icalLine(&b, "TRANSP:TRANSPARENT")
+		icalLine(&b, "END:VEVENT")
+	}
+	icalLine(&b, "END:VCALENDAR")
+
+	return b.String()
+}
+
+// calendarHandler serves /calendar.ics?city=X
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	units, err := keli.ParseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+	weather = ConvertUnits(weather, units, "")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	_, err = w.Write([]byte(Calendar(weather, lang, CalendarEvents(weather, lang))))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"github.com/itsnibsi/keli/pkg/keli"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+const (
+	// size of Open Graph preview images
+	cardWidth  = 1200
+	cardHeight = 630
+	// hours in the sparkline
+	cardHours = 24
+)
+
+var (
+	fontRegular = mustParseFont(goregular.TTF)
+	fontBold    = mustParseFont(gobold.TTF)
+)
+
+func mustParseFont(ttf []byte) *truetype.Font {
+	f, err := truetype.Parse(ttf)
+	if err != nil {
+		log.Fatalf("Error parsing font: %v", err)
+	}
+	return f
+}
+
+func fontFace(f *truetype.Font, size float64) font.Face {
+	return truetype.NewFace(f, &truetype.Options{Size: size})
+}
+
+// Card draws a share card of the weather: the icon and temperature, today's
+// low and high and a sparkline of the hourly temperatures.
+func Card(weather WeatherData, lang Language) image.Image {
+	units := weather.Labels()
+	dc := gg.NewContext(cardWidth, cardHeight)
+
+	background := gg.NewLinearGradient(0, 0, 0, cardHeight)
+	background.AddColorStop(0, color.RGBA{0x1e, 0x3a, 0x8a, 0xff})
+	background.AddColorStop(1, color.RGBA{0x0f, 0x17, 0x2a, 0xff})
+	dc.SetFillStyle(background)
+	dc.DrawRectangle(0, 0, cardWidth, cardHeight)
+	dc.Fill()
+
+	dc.SetHexColor("#ffffff")
+	dc.SetFontFace(fontFace(fontBold, 56))
+	dc.DrawString(weather.City, 80, 110)
+	dc.SetHexColor("#93c5fd")
+	dc.SetFontFace(fontFace(fontRegular, 30))
+	dc.DrawString(lang.FormatDateTime(weather.LastUpdated.In(location)), 80, 160)
+
+	if icon, err := IconImage(weather.SymbolCode, 220); err == nil {
+		dc.DrawImage(icon, 70, 190)
+	} else {
+		log.Printf("Error drawing icon for %s: %v", weather.SymbolCode, err)
+	}
+
+	dc.SetHexColor("#ffffff")
+	dc.SetFontFace(fontFace(fontBold, 130))
+	dc.DrawString(lang.Temperature(weather.Temperature, units.Temperature), 330, 330)
+
+	dc.SetFontFace(fontFace(fontRegular, 36))
+	dc.DrawString(lang.Summary(weather), 340, 390)
+	dc.SetHexColor("#bfdbfe")
+	dc.DrawString(fmt.Sprintf("%s %s   %s %s",
+		lang.T("min"), lang.Temperature(weather.TemperatureMin, units.Temperature),
+		lang.T("max"), lang.Temperature(weather.TemperatureMax, units.Temperature)), 340, 440)
+
+	drawSparkline(dc, weather, lang, 80, 480, cardWidth-160, 90)
+
+	dc.SetHexColor("#64748b")
+	dc.SetFontFace(fontFace(fontBold, 24))
+	dc.DrawStringAnchored("keli", cardWidth-80, 110, 1, 0)
+
+	return dc.Image()
+}
+
+// drawSparkline draws the hourly temperatures as a line in the given box,
+// with every third hour labeled below it.
+func drawSparkline(dc *gg.Context, weather WeatherData, lang Language, x, y, width, height float64) {
+	hours := weather.HourlyForecast
+	if len(hours) > cardHours {
+		hours = hours[:cardHours]
+	}
+	if len(hours) < 2 {
+		return
+	}
+
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, h := range hours {
+		low = math.Min(low, h.Temperature)
+		high = math.Max(high, h.Temperature)
+	}
+	if high-low < 1 {
+		high, low = high+0.5, low-0.5
+	}
+
+	step := width / float64(len(hours)-1)
+	point := func(i int) (float64, float64) {
+		return x + float64(i)*step, y + height - (hours[i].Temperature-low)/(high-low)*height
+	}
+
+	dc.MoveTo(x, y+height)
+	for i := range hours {
+		dc.LineTo(point(i))
+	}
+	dc.LineTo(x+width, y+height)
+	dc.ClosePath()
+	dc.SetRGBA(0.58, 0.77, 0.99, 0.2)
+	dc.Fill()
+
+	for i := range hours {
+		dc.LineTo(point(i))
+	}
+	dc.SetHexColor("#93c5fd")
+	dc.SetLineWidth(4)
+	dc.Stroke()
+
+	dc.SetFontFace(fontFace(fontRegular, 20))
+	for i, h := range hours {
+		if i%3 != 0 {
+			continue
+		}
+		px, py := point(i)
+		dc.SetHexColor("#ffffff")
+		dc.DrawStringAnchored(lang.Decimal(h.Temperature, 0)+"°", px, py-14, 0.5, 0)
+		dc.SetHexColor("#64748b")
+		dc.DrawStringAnchored(h.Hour, px, y+height+30, 0.5, 0)
+	}
+}
+
+// cardHandler serves /card?city=X as a PNG share card
+func cardHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	units, err := keli.ParseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+	weather = ConvertUnits(weather, units, "")
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	if err := png.Encode(w, Card(weather, lang)); err != nil {
+		logf(r.Context(), "Error writing card for %s: %v", city, err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	chartWidth  = 800
+	chartHeight = 260
+	// hours in the chart unless asked otherwise
+	chartHours = 24
+	// hourly rainfall in millimeters filling a rain bar of the hour strip
+	heavyRain = 4.0
+)
+
+// Chart is the data of templates/chart.svg. Coordinates are in pixels.
+type Chart struct {
+	Title                    string
+	Width, Height            int
+	Left, Right, Top, Bottom float64
+	// Points of the temperature curve
+	Line string
+	// Temperature grid lines
+	Grid []ChartLabel
+	// Rainfall bars, scaled so the largest amount reaches the top
+	Bars []ChartBar
+	// Amount of rain at the top of the chart
+	RainLabel string
+	Hours     []ChartLabel
+}
+
+type ChartLabel struct {
+	X, Y  float64
+	Label string
+}
+
+type ChartBar struct {
+	X, Y, Width, Height float64
+	Label               string
+}
+
+// RainBar returns the height of a rain bar in the hour strip of the weather
+// page, in percent. Unlike the chart the bars have a fixed scale, so a
+// drizzle looks like one however dry the rest of the day is.
+func RainBar(rainfall float64, units keli.UnitSystem) float64 {
+	full := heavyRain
+	if units == keli.UnitsImperial {
+		full = heavyRain / 25.4
+	}
+	return roundTo(math.Min(rainfall/full, 1)*100, 1)
+}
+
+// NewChart returns a chart of the temperature and rainfall of the first
+// hours of the hourly forecast.
+func NewChart(weather WeatherData, lang Language, hours int) Chart {
+	units := weather.Labels()
+	forecast := weather.HourlyForecast
+	if hours < len(forecast) {
+		forecast = forecast[:hours]
+	}
+
+	chart := Chart{
+		Title:  lang.T("feedTitle", weather.City),
+		Width:  chartWidth,
+		Height: chartHeight,
+		Left:   44,
+		Right:  chartWidth - 44,
+		Top:    14,
+		Bottom: chartHeight - 26,
+	}
+	if len(forecast) == 0 {
+		return chart
+	}
+
+	low, high, rain := math.Inf(1), math.Inf(-1), 0.0
+	for _, h := range forecast {
+		low = math.Min(low, h.Temperature)
+		high = math.Max(high, h.Temperature)
+		rain = math.Max(rain, h.Rainfall)
+	}
+	// grid lines at whole degrees, at least a couple of degrees apart
+	step := math.Max(1, math.Ceil((high-low)/4))
+	low = math.Floor(low/step) * step
+	high = math.Max(math.Ceil(high/step)*step, low+step)
+
+	plotHeight := chart.Bottom - chart.Top
+	column := (chart.Right - chart.Left) / float64(len(forecast))
+	y := func(t float64) float64 {
+		return chart.Bottom - (t-low)/(high-low)*plotHeight
+	}
+
+	for t := low; t <= high; t += step {
+		chart.Grid = append(chart.Grid, ChartLabel{Y: roundTo(y(t), 1), Label: lang.Decimal(t, 0) + units.Temperature})
+	}
+
+	// label at most 12 hours
+	labelEvery := int(math.Ceil(float64(len(forecast)) / 12))
+	points := make([]string, len(forecast))
+	for i, h := range forecast {
+		x := chart.Left + (float64(i)+0.5)*column
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y(h.Temperature))
+
+		if rain > 0 && h.Rainfall > 0 {
+			height := h.Rainfall / rain * plotHeight
+			chart.Bars = append(chart.Bars, ChartBar{
+				X:      roundTo(x-column*0.35, 1),
+				Y:      roundTo(chart.Bottom-height, 1),
+				Width:  roundTo(column*0.7, 1),
+				Height: roundTo(height, 1),
+				Label:  strings.TrimSpace(lang.Precipitation(h.Rainfall, weather.Units) + " " + units.Precipitation + " " + lang.PrecipitationType(h.PrecipitationType)),
+			})
+		}
+
+		if i%labelEvery == 0 {
+			chart.Hours = append(chart.Hours, ChartLabel{X: roundTo(x, 1), Label: h.Hour})
+		}
+	}
+	chart.Line = strings.Join(points, " ")
+
+	if rain > 0 {
+		chart.RainLabel = lang.Precipitation(rain, weather.Units) + " " + units.Precipitation
+	}
+
+	return chart
+}
+
+// chartHandler serves /chart?city=X&hours=N as an SVG image
+func chartHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	hours := chartHours
+	if h := r.URL.Query().Get("hours"); h != "" {
+		var err error
+		hours, err = strconv.Atoi(h)
+		if err != nil || hours < 1 {
+			http.Error(w, fmt.Sprintf("Invalid hours \"%s\"", h), http.StatusBadRequest)
+			return
+		}
+	}
+
+	units, err := keli.ParseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+	weather = ConvertUnits(weather, units, "")
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	context := templateContext{Request: r, Lang: lang, Weather: weather}
+	err = renderTemplate(w, "chart.svg", context, NewChart(weather, lang, hours))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// runGet runs `keli get [flags] <city>`, which fetches and prints the
+// weather of the city without starting the server. Returns the exit code.
+func runGet(args []string) int {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	format := flags.String("format", "text", "text, json, ansi, short, speech, minimal or a wttr.in format like %t")
+	unitsFlag := flags.String("units", "", "metric or imperial")
+	windUnitFlag := flags.String("wind-unit", "", "m/s, km/h, mph, knots or beaufort")
+	langFlag := flags.String("lang", "", "language, fi, en or sv")
+	provenance := flags.Bool("provenance", false, "add the source of each field to the json format")
+	verbose := flags.Bool("v", false, "log what is fetched")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: keli get [flags] <city>")
+		flags.PrintDefaults()
+	}
+
+	// flags may come after the city too, e.g. keli get Oulu -format json
+	var words []string
+	for rest := args; ; {
+		if err := flags.Parse(rest); err != nil {
+			return 2
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		words = append(words, flags.Arg(0))
+		rest = flags.Args()[1:]
+	}
+	if len(words) == 0 {
+		flags.Usage()
+		return 2
+	}
+	city := strings.Join(words, " ")
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "keli: %v\n", err)
+		return 1
+	}
+
+	units, err := keli.ParseUnitSystem(*unitsFlag)
+	if err != nil {
+		return fail(err)
+	}
+	windUnit, err := keli.ParseWindUnit(*windUnitFlag)
+	if err != nil {
+		return fail(err)
+	}
+	lang, err := ParseLanguage(*langFlag)
+	if err != nil {
+		return fail(err)
+	}
+
+	output, err := getOutput(city, *format, units, windUnit, lang, *provenance)
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Print(output)
+	return 0
+}
+
+// getOutput returns the weather of the city in the format, the same as
+// the server would answer with.
+func getOutput(city, format string, units keli.UnitSystem, windUnit keli.WindUnit, lang Language, provenance bool) (string, error) {
+	weather, err := GetWeatherData(context.Background(), city)
+	if err != nil {
+		return "", err
+	}
+	if IsWttrFormat(format) {
+		if windUnit == "" && units == keli.UnitsMetric {
+			windUnit = keli.WindKilometersPerHour
+		}
+		return WttrText(ConvertUnits(weather, units, windUnit), lang, format, time.Now()), nil
+	}
+	weather = ConvertUnits(weather, units, windUnit)
+
+	switch format {
+	case "text":
+		return Text(weather, lang), nil
+	case "ansi":
+		return ANSIText(weather, lang), nil
+	case "short":
+		return ShortText(weather, lang) + "\n", nil
+	case "speech":
+		return SpeechText(weather, lang) + "\n", nil
+	case "json", "minimal":
+		var data any = weather
+		if format == "minimal" {
+			data = Minimal(weather, lang)
+		} else if provenance {
+			data = provenanceOutput{WeatherData: weather, Provenance: weather.Provenance}
+		}
+		jsonData, err := json.MarshalIndent(data, "", "  ")
+		return string(jsonData) + "\n", err
+	}
+	return "", fmt.Errorf("Unknown format \"%s\", expected text, json, ansi, short, speech, minimal or a wttr.in format", format)
+}
@@ -0,0 +1,273 @@
+// Package client is the Go API of keli for other programs, a typed client
+// of the JSON API of a keli server:
+//
+//	c := client.New(client.Options{Server: "https://keli.example.com"})
+//	weather, err := c.Get(ctx, "Oulu")
+//
+// The server adds the weather of FMI, advisories and the rest to what the
+// sources have. Package keli in pkg/keli fetches and merges the sources
+// without a server.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// Options configure a Client. Only Server is required.
+type Options struct {
+	// Address of the keli server, e.g. "http://localhost:8080"
+	Server string
+	// Units is "metric" (the default) or "imperial"
+	Units string
+	// WindUnit is "m/s", "km/h", "mph", "knots" or "beaufort", by default
+	// the one of the unit system
+	WindUnit string
+	// How long the weather of a city is kept before asking again, nothing
+	// is kept if zero. The server has a cache of its own too.
+	CacheDuration time.Duration
+	// Provenance asks for the source of each field in Weather.Provenance
+	Provenance bool
+	// Ice asks for the ice of the lakes and the coast in Weather.Ice
+	Ice bool
+	// Electricity asks for the electricity spot prices in
+	// Weather.Electricity
+	Electricity bool
+	// HTTP client to use, http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// Client gets the weather from a keli server. It is safe for concurrent use.
+type Client struct {
+	options Options
+
+	cache      map[string]*Weather
+	cacheMutex sync.Mutex
+}
+
+// Weather is the weather of a city, the same as the JSON API of keli
+// answers with: the weather of the sources as package keli merges it, and
+// what the server adds to it.
+type Weather struct {
+	keli.Weather
+	// Finnish Beaufort scale description of the wind, e.g. "navakka tuuli"
+	WindDescription string `json:"windDescription"`
+	// What to wear, e.g. "pipo ja hanskat, sadetakki mukaan"
+	Recommendation string `json:"recommendation"`
+	// How well laundry hung out now dries, from 0 to 100
+	DryingIndex int `json:"dryingIndex"`
+	// Heat and cold advisories of the thresholds the server has for the city
+	Advisories []Advisory `json:"advisories,omitempty"`
+	// Air pressure at sea level (hPa) at the nearest station and how it
+	// changed in 3 hours, when the server keeps history
+	Pressure      *float64       `json:"pressure,omitempty"`
+	PressureTrend *PressureTrend `json:"pressureTrend,omitempty"`
+	// Lightning strikes near the city in the last hour
+	Lightning *Lightning `json:"lightning,omitempty"`
+	// The weather station nearest to the city and its distance from it
+	Station *Station `json:"station,omitempty"`
+	// Ice of the lakes and the coast, with Options.Ice
+	Ice *IceReport `json:"ice,omitempty"`
+	// Electricity spot prices, with Options.Electricity
+	Electricity *Electricity `json:"electricity,omitempty"`
+	// The last time each group of fields was updated by a source: "current",
+	// "today", "tomorrow", "sun", "hourly" and "daily"
+	Updated map[string]time.Time `json:"updated,omitempty"`
+	// The source of each field by its JSON name, with Options.Provenance
+	Provenance map[string]keli.FieldSource `json:"provenance,omitempty"`
+	// Which sources the weather came from and what is missing
+	Meta *Meta `json:"meta,omitempty"`
+}
+
+// Has tells whether some source had the field by its JSON name, so that its
+// zero value is a real one.
+func (w *Weather) Has(field string) bool {
+	return !slices.Contains(w.Missing, field)
+}
+
+// IceReport is the ice of the lakes and the coast of a city, the
+// measurements of the last week newest first, and a notice to be careful.
+type IceReport struct {
+	Measurements []IceMeasurement `json:"measurements"`
+	Notice       string           `json:"notice"`
+}
+
+// IceMeasurement is the ice measured at a place.
+type IceMeasurement struct {
+	Place       string  `json:"place"`
+	ThicknessCm float64 `json:"thicknessCm"`
+	// Kind of the ice, e.g. "teräsjää"
+	Cover    string    `json:"cover"`
+	Measured time.Time `json:"measured"`
+	Source   string    `json:"source"`
+}
+
+// PressureTrend is how the air pressure changed in the last 3 hours.
+type PressureTrend struct {
+	// "rising", "steady" or "falling"
+	Tendency string `json:"tendency"`
+	// Change of the pressure in 3 hours (hPa)
+	Change float64 `json:"change"`
+}
+
+// Lightning is the lightning strikes near the city in the last hour.
+type Lightning struct {
+	// Strikes within RadiusKm of the city and the distance to the nearest
+	Strikes       int      `json:"strikes"`
+	RadiusKm      float64  `json:"radiusKm"`
+	NearestKm     *float64 `json:"nearestKm,omitempty"`
+	ThunderNearby bool     `json:"thunderNearby"`
+}
+
+// Station is a weather station of the Finnish Meteorological Institute.
+type Station struct {
+	Name   string `json:"name"`
+	FMISID int    `json:"fmisid"`
+	// Distance from the city (km)
+	DistanceKm float64 `json:"distanceKm"`
+}
+
+// Electricity is the electricity spot price of each hour from the current
+// one on, in c/kWh.
+type Electricity struct {
+	Now      float64     `json:"now"`
+	Cheapest SpotPrice   `json:"cheapest"`
+	Prices   []SpotPrice `json:"prices"`
+}
+
+// SpotPrice is the price of the hour starting at Time.
+type SpotPrice struct {
+	Time  time.Time `json:"time"`
+	Price float64   `json:"price"`
+}
+
+// Advisory is a temperature beyond one the server has for the city in the
+// current weather or the hourly forecast.
+type Advisory struct {
+	// "cold" or "heat"
+	Type      string  `json:"type"`
+	Threshold float64 `json:"threshold"`
+	// The coldest or hottest temperature beyond the threshold and its hour
+	Temperature float64   `json:"temperature"`
+	At          time.Time `json:"at"`
+}
+
+// Meta tells how complete the weather is.
+type Meta struct {
+	// Names of the sources the weather was merged from
+	Sources []string `json:"sources"`
+	// Sources that failed
+	Failed []SourceFailure `json:"failed"`
+	// Share of the fields the sources have that were found, from 0 to 1
+	Completeness float64 `json:"completeness"`
+	// Fields no source had, by their JSON names
+	Missing  []string  `json:"missing"`
+	Warnings []Warning `json:"warnings"`
+}
+
+// SourceFailure is a source that failed to give weather data.
+type SourceFailure struct {
+	Source string `json:"source"`
+	// Where it failed, "fetch", "parse", "breaker" when the server skipped
+	// it after failing again and again or "ratelimit" when its site had been
+	// fetched too often
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// Warning is something off in the weather, e.g. sources disagreeing.
+type Warning struct {
+	// "stale", "disagreement", "anomaly", "suspect", "derived" or "flood"
+	Code string `json:"code"`
+	// The field or group of fields warned about
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// Severity and area of a flood warning
+	Severity string `json:"severity,omitempty"`
+	Area     string `json:"area,omitempty"`
+}
+
+// Error is an error answered by the server, e.g. for an unknown city.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("keli: %s (%d)", e.Message, e.StatusCode)
+}
+
+// New returns a client with the options.
+func New(options Options) *Client {
+	if options.HTTPClient == nil {
+		options.HTTPClient = http.DefaultClient
+	}
+	options.Server = strings.TrimSuffix(options.Server, "/")
+	return &Client{options: options, cache: make(map[string]*Weather)}
+}
+
+// Get returns the weather of the city.
+func (c *Client) Get(ctx context.Context, city string) (*Weather, error) {
+	if c.options.CacheDuration > 0 {
+		c.cacheMutex.Lock()
+		cached, found := c.cache[city]
+		c.cacheMutex.Unlock()
+		if found && time.Since(cached.LastUpdated) < c.options.CacheDuration {
+			return cached, nil
+		}
+	}
+
+	query := url.Values{"city": {city}, "format": {"json"}}
+	if c.options.Units != "" {
+		query.Set("units", c.options.Units)
+	}
+	if c.options.WindUnit != "" {
+		query.Set("wind_unit", c.options.WindUnit)
+	}
+	if c.options.Provenance {
+		query.Set("provenance", "true")
+	}
+	if c.options.Ice {
+		query.Set("ice", "true")
+	}
+	if c.options.Electricity {
+		query.Set("electricity", "true")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.options.Server+"/api?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.options.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return nil, &Error{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	weather := new(Weather)
+	if err := json.NewDecoder(res.Body).Decode(weather); err != nil {
+		return nil, err
+	}
+
+	if c.options.CacheDuration > 0 {
+		c.cacheMutex.Lock()
+		c.cache[city] = weather
+		c.cacheMutex.Unlock()
+	}
+	return weather, nil
+}
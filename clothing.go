@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// hours of the forecast looked at besides the current weather when
+// recommending what to wear
+const clothingHours = 3
+
+// ClothingRule recommends something to wear or take along when all its
+// conditions match the weather.
+type ClothingRule struct {
+	// The recommendation, e.g. "pipo ja hanskat"
+	Text       string              `json:"text"`
+	Conditions []ClothingCondition `json:"when"`
+}
+
+// ClothingCondition is a condition of the weather of the next few hours.
+// The values are metric.
+type ClothingCondition struct {
+	// feelsLikeBelow, feelsLikeAbove, windAbove, rainAbove or
+	// rainChanceAbove
+	Type string `json:"type"`
+	// Limit of the feels-like temperature (°C), wind (m/s), hourly rain
+	// (mm) or chance of rain (%)
+	Value float64 `json:"value"`
+}
+
+// defaultClothing are the rules used unless the clothing section of the
+// config has its own.
+var defaultClothing = []ClothingRule{
+	{"toppatakki ja kerrasto", []ClothingCondition{{"feelsLikeBelow", -15}}},
+	{"pipo ja hanskat", []ClothingCondition{{"feelsLikeBelow", 0}}},
+	{"välikausitakki", []ClothingCondition{{"feelsLikeAbove", 0}, {"feelsLikeBelow", 12}}},
+	{"shortsit ja aurinkolasit", []ClothingCondition{{"feelsLikeAbove", 22}}},
+	{"tuulenpitävä takki", []ClothingCondition{{"windAbove", 10}}},
+	{"sadetakki mukaan", []ClothingCondition{{"rainChanceAbove", 50}}},
+	{"sadetakki mukaan", []ClothingCondition{{"rainAbove", 0.3}}},
+}
+
+func (rule ClothingRule) check() error {
+	if rule.Text == "" {
+		return fmt.Errorf("Missing 'text'")
+	}
+	if len(rule.Conditions) == 0 {
+		return fmt.Errorf("Missing 'when' of \"%s\"", rule.Text)
+	}
+	for _, condition := range rule.Conditions {
+		switch condition.Type {
+		case "feelsLikeBelow", "feelsLikeAbove", "windAbove", "rainAbove", "rainChanceAbove":
+		default:
+			return fmt.Errorf("Unknown condition \"%s\" of \"%s\", expected feelsLikeBelow, feelsLikeAbove, windAbove, rainAbove or rainChanceAbove", condition.Type, rule.Text)
+		}
+	}
+	return nil
+}
+
+// recommendClothing returns what to wear in the weather of the next few
+// hours by the rules of the config, or the default ones: the texts of the
+// rules matching, e.g. "pipo ja hanskat, sadetakki mukaan".
+func recommendClothing(weather WeatherData) string {
+	rules := defaultClothing
+	if configured := config().Clothing; configured != nil {
+		rules = configured
+	}
+
+	// the coldest, windiest and wettest of the current weather and the
+	// coming hours
+	feelsLow, feelsHigh := weather.TemperatureFeelsLike, weather.TemperatureFeelsLike
+	wind, rain, rainChance := float64(weather.WindSpeed), weather.Rainfall, float64(weather.RainChance)
+	for _, h := range weather.HourlyForecast[:min(clothingHours, len(weather.HourlyForecast))] {
+		feelsLow, feelsHigh = math.Min(feelsLow, h.TemperatureFeelsLike), math.Max(feelsHigh, h.TemperatureFeelsLike)
+		wind = math.Max(wind, float64(h.WindSpeed))
+		rain = math.Max(rain, h.Rainfall)
+		rainChance = math.Max(rainChance, float64(h.RainChance))
+	}
+
+	var recommended []string
+	for _, rule := range rules {
+		matches := true
+		for _, condition := range rule.Conditions {
+			switch condition.Type {
+			case "feelsLikeBelow":
+				matches = matches && feelsLow < condition.Value
+			case "feelsLikeAbove":
+				matches = matches && feelsHigh > condition.Value
+			case "windAbove":
+				matches = matches && wind > condition.Value
+			case "rainAbove":
+				matches = matches && rain > condition.Value
+			case "rainChanceAbove":
+				matches = matches && rainChance > condition.Value
+			}
+		}
+		if matches && !slices.Contains(recommended, rule.Text) {
+			recommended = append(recommended, rule.Text)
+		}
+	}
+	return strings.Join(recommended, ", ")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commute is the weather of the commutes of today and tomorrow, all most
+// people look at the forecast for.
+type Commute struct {
+	City    string          `json:"city"`
+	Windows []CommuteWindow `json:"windows"`
+}
+
+// CommuteWindow is the weather of one commute, the worst of its hours. The
+// values are metric.
+type CommuteWindow struct {
+	// "morning" or "evening"
+	Name string `json:"name"`
+	// Date of the day, e.g. "2024-04-19"
+	Date string    `json:"date"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Lowest and highest temperature (C)
+	TemperatureMin float64 `json:"temperatureMin"`
+	TemperatureMax float64 `json:"temperatureMax"`
+	// Rain during the window (mm) and the highest chance of it (%)
+	Rainfall   float64 `json:"rainfall"`
+	RainChance int     `json:"rainChance"`
+	// Strongest wind (m/s)
+	WindSpeed int `json:"windSpeed"`
+	// From 0 to 3, how slippery the roads are likely to be, see scores.go
+	Slipperiness int `json:"slipperiness"`
+}
+
+// parseHours parses hours like "7-9", the window starting at 7 and ending
+// at 9.
+func parseHours(value string) (from, to int, err error) {
+	start, end, found := strings.Cut(value, "-")
+	from, err1 := strconv.Atoi(start)
+	to, err2 := strconv.Atoi(end)
+	if !found || err1 != nil || err2 != nil || from < 0 || to > 24 || from >= to {
+		return 0, 0, fmt.Errorf("Invalid hours \"%s\", expected e.g. 7-9", value)
+	}
+	return from, to, nil
+}
+
+// commuteWeather returns the weather of the morning and evening windows of
+// today and tomorrow the forecast has hours of.
+func commuteWeather(weather WeatherData, now time.Time, morning, evening [2]int) Commute {
+	commute := Commute{City: weather.City, Windows: []CommuteWindow{}}
+	now = now.In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	for _, day := range []time.Time{today, today.AddDate(0, 0, 1)} {
+		for _, window := range []struct {
+			name  string
+			hours [2]int
+		}{{"morning", morning}, {"evening", evening}} {
+			from := atHour(day, window.hours[0])
+			to := atHour(day, window.hours[1])
+			hours := forecastWindow(weather, from, to)
+			if len(hours) == 0 {
+				continue
+			}
+			w := worstWeather(hours)
+			commute.Windows = append(commute.Windows, CommuteWindow{
+				Name:           window.name,
+				Date:           day.Format("2006-01-02"),
+				From:           from,
+				To:             to,
+				TemperatureMin: w.low,
+				TemperatureMax: w.high,
+				Rainfall:       roundTo(w.rain, 1),
+				RainChance:     int(w.rainChance),
+				WindSpeed:      int(w.wind),
+				Slipperiness:   int(math.Round(slipperiness(weather, w))),
+			})
+		}
+	}
+	return commute
+}
+
+// commuteHandler serves /commute?city=&morning=7-9&evening=16-18, the
+// weather of the commutes of today and tomorrow.
+func commuteHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	windows := map[string][2]int{"morning": {7, 9}, "evening": {16, 18}}
+	for name := range windows {
+		if value := r.URL.Query().Get(name); value != "" {
+			from, to, err := parseHours(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			windows[name] = [2]int{from, to}
+		}
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+
+	jsonData, err := json.Marshal(commuteWeather(weather, time.Now(), windows["morning"], windows["evening"]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
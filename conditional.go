@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// errNotModified is returned by fetchSource when the page hasn't changed
+// since it was last parsed, so the page fetched then still holds.
+var errNotModified = errors.New("Not modified")
+
+// parsedPage is the last page fetched from a URL: the validators the site
+// gave for it, the page and whether weather data was parsed from it.
+type parsedPage struct {
+	etag, lastModified string
+	page               []byte
+	parsed             bool
+}
+
+var (
+	parsedPages      = make(map[string]*parsedPage)
+	parsedPagesMutex sync.Mutex
+)
+
+// setConditionalHeaders asks the site for the page only if it has changed
+// since the one the data was last parsed from.
+func setConditionalHeaders(req *http.Request) {
+	parsedPagesMutex.Lock()
+	defer parsedPagesMutex.Unlock()
+
+	page, found := parsedPages[req.URL.String()]
+	if !found || !page.parsed {
+		return
+	}
+	if page.etag != "" {
+		req.Header.Set("If-None-Match", page.etag)
+	}
+	if page.lastModified != "" {
+		req.Header.Set("If-Modified-Since", page.lastModified)
+	}
+}
+
+// rememberValidators keeps the validators of a fetched page until the data
+// is parsed from it, see rememberPage and rememberParsed.
+func rememberValidators(url string, res *http.Response) {
+	parsedPagesMutex.Lock()
+	defer parsedPagesMutex.Unlock()
+
+	etag, lastModified := res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		delete(parsedPages, url)
+		return
+	}
+	parsedPages[url] = &parsedPage{etag: etag, lastModified: lastModified}
+}
+
+// rememberPage keeps the page last fetched from the URL until the data is
+// parsed from it.
+func rememberPage(url string, body []byte) {
+	parsedPagesMutex.Lock()
+	defer parsedPagesMutex.Unlock()
+
+	if page, found := parsedPages[url]; found {
+		page.page = body
+	}
+}
+
+// rememberParsed marks the page last fetched from the URL parsed, to be
+// parsed again when the site answers that it hasn't changed.
+func rememberParsed(url string) {
+	parsedPagesMutex.Lock()
+	defer parsedPagesMutex.Unlock()
+
+	if page, found := parsedPages[url]; found && page.page != nil {
+		page.parsed = true
+	}
+}
+
+// lastParsed returns the page last fetched from the URL and parsed.
+func lastParsed(url string) ([]byte, bool) {
+	parsedPagesMutex.Lock()
+	defer parsedPagesMutex.Unlock()
+
+	page, found := parsedPages[url]
+	if !found || !page.parsed {
+		return nil, false
+	}
+	return page.page, true
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// Config is the configuration file given with -config. Everything in it is
+// optional, keli runs without one.
+type Config struct {
+	// How long the weather of a city is served from the cache, e.g. "10m",
+	// 5 minutes by default
+	CacheTTL string `json:"cacheTTL"`
+	// File of the known places, one a line, data/places.txt by default
+	PlacesFile string `json:"placesFile"`
+	// Webhooks notified when their rules match the weather, see webhooks.go
+	Webhooks []Webhook `json:"webhooks"`
+	// Registering webhooks through /webhooks, see webhooks.go
+	WebhookAPI *WebhookAPIConfig `json:"webhookApi"`
+	// Broker the weather is published to, see mqtt.go
+	MQTT *MQTTConfig `json:"mqtt"`
+	// Discord application answering /weather, see discord.go
+	Discord *DiscordConfig `json:"discord"`
+	// Slack app whose slash command keli answers, see slack.go
+	Slack *SlackConfig `json:"slack"`
+	// Mastodon account the morning forecasts are posted to, see mastodon.go
+	Mastodon *MastodonConfig `json:"mastodon"`
+	// SMTP server the daily digests are emailed through, see digest.go
+	Email *EmailConfig `json:"email"`
+	// How each field is merged from the sources, see pkg/keli/merge.go
+	Merge map[string]keli.FieldMerge `json:"merge"`
+	// The sources by name, see sources.go
+	Sources map[string]SourceConfig `json:"sources"`
+	// Where alerts about broken sources are posted, see breakage.go
+	Alerts *AlertConfig `json:"alerts"`
+	// More selectors of the fields by source, see pkg/keli/selectors.go
+	Selectors map[string]map[string][]string `json:"selectors"`
+	// How the sources are fetched, see fetch.go
+	Fetch *FetchConfig `json:"fetch"`
+	// Who may refresh the weather with ?refresh=1 and how often, see
+	// refresh.go
+	Refresh *RefreshConfig `json:"refresh"`
+	// Rules recommending what to wear, replacing the default ones, see
+	// clothing.go
+	Clothing []ClothingRule `json:"clothing"`
+	// Alerts of frosty nights during the growing season, see frost.go
+	Frost []FrostAlert `json:"frost"`
+	// Heat and cold advisories of the cities, see advisories.go
+	Advisories []AdvisoryConfig `json:"advisories"`
+	// Feeds of the ski tracks of the cities, see skitracks.go
+	SkiTracks []SkiTrackFeed `json:"skiTracks"`
+	// Ice measurements of the lakes and the coast of the cities, see ice.go
+	Ice []IceConfig `json:"ice"`
+	// Electricity spot prices shown on the weather page, see electricity.go
+	Electricity *ElectricityConfig `json:"electricity"`
+	// Where the observed weather is kept for statistics, see history.go
+	History *HistoryConfig `json:"history"`
+	// Weather radar shown on the weather page, see radar.go
+	Radar *RadarConfig `json:"radar"`
+	// Flood warnings added to the warnings of the weather, see floods.go
+	Floods *FloodConfig `json:"floods"`
+	// Counters of the time since things, served at /streaks, see streaks.go
+	Streaks *StreaksConfig `json:"streaks"`
+	// Page for looking after the running server, see admin.go
+	Admin *AdminConfig `json:"admin"`
+	// Who may use each route, see access.go
+	Access *AccessConfig `json:"access"`
+
+	cacheTTL time.Duration
+}
+
+const (
+	// how long the weather of a city is served from the cache by default
+	defaultCacheTTL = 5 * time.Minute
+	// the known places by default
+	defaultPlacesFile = "data/places.txt"
+)
+
+var (
+	// the configuration in use, replaced whole when reloaded, see reload.go
+	loadedConfig atomic.Pointer[Config]
+	// the file the configuration was loaded from, if any
+	configPath string
+)
+
+// config returns the configuration in use. Its sections are not changed
+// after loading, a reload puts a new one in use instead.
+func config() *Config {
+	if c := loadedConfig.Load(); c != nil {
+		return c
+	}
+	return &Config{}
+}
+
+// cacheTTL returns how long the weather of a city is served from the cache.
+func cacheTTL() time.Duration {
+	if ttl := config().cacheTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultCacheTTL
+}
+
+// LoadConfig reads the JSON configuration file and checks it.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("Error in %s: %v", path, err)
+	}
+	if c.CacheTTL != "" {
+		ttl, err := time.ParseDuration(c.CacheTTL)
+		if err != nil || ttl <= 0 {
+			return c, fmt.Errorf("Error in cacheTTL of %s: Invalid duration \"%s\", expected e.g. 10m", path, c.CacheTTL)
+		}
+		c.cacheTTL = ttl
+	}
+	if c.PlacesFile != "" {
+		if _, err := os.Stat(c.PlacesFile); err != nil {
+			return c, fmt.Errorf("Error in placesFile of %s: %v", path, err)
+		}
+	}
+	for i := range c.Webhooks {
+		if err := c.Webhooks[i].check(); err != nil {
+			return c, fmt.Errorf("Error in webhook %d of %s: %v", i+1, path, err)
+		}
+	}
+	if c.WebhookAPI != nil {
+		if err := c.WebhookAPI.check(); err != nil {
+			return c, fmt.Errorf("Error in webhookApi of %s: %v", path, err)
+		}
+	}
+	if c.MQTT != nil {
+		if err := c.MQTT.check(); err != nil {
+			return c, fmt.Errorf("Error in mqtt of %s: %v", path, err)
+		}
+	}
+	if c.Discord != nil {
+		if err := c.Discord.check(); err != nil {
+			return c, fmt.Errorf("Error in discord of %s: %v", path, err)
+		}
+	}
+	if c.Slack != nil {
+		if err := c.Slack.check(); err != nil {
+			return c, fmt.Errorf("Error in slack of %s: %v", path, err)
+		}
+	}
+	if c.Mastodon != nil {
+		if err := c.Mastodon.check(); err != nil {
+			return c, fmt.Errorf("Error in mastodon of %s: %v", path, err)
+		}
+	}
+	if c.Email != nil {
+		if err := c.Email.check(); err != nil {
+			return c, fmt.Errorf("Error in email of %s: %v", path, err)
+		}
+	}
+	if c.Alerts != nil {
+		if err := c.Alerts.check(); err != nil {
+			return c, fmt.Errorf("Error in alerts of %s: %v", path, err)
+		}
+	}
+	if c.Fetch != nil {
+		if err := c.Fetch.check(); err != nil {
+			return c, fmt.Errorf("Error in fetch of %s: %v", path, err)
+		}
+	}
+	for i, rule := range c.Clothing {
+		if err := rule.check(); err != nil {
+			return c, fmt.Errorf("Error in clothing rule %d of %s: %v", i+1, path, err)
+		}
+	}
+	for i := range c.Frost {
+		if err := c.Frost[i].check(c.Email); err != nil {
+			return c, fmt.Errorf("Error in frost alert %d of %s: %v", i+1, path, err)
+		}
+	}
+	for i := range c.Advisories {
+		if err := c.Advisories[i].check(c.Email); err != nil {
+			return c, fmt.Errorf("Error in advisory %d of %s: %v", i+1, path, err)
+		}
+	}
+	for i := range c.SkiTracks {
+		if err := c.SkiTracks[i].check(); err != nil {
+			return c, fmt.Errorf("Error in ski track feed %d of %s: %v", i+1, path, err)
+		}
+	}
+	for i := range c.Ice {
+		if err := c.Ice[i].check(); err != nil {
+			return c, fmt.Errorf("Error in ice %d of %s: %v", i+1, path, err)
+		}
+	}
+	if c.Electricity != nil {
+		if err := c.Electricity.check(); err != nil {
+			return c, fmt.Errorf("Error in electricity of %s: %v", path, err)
+		}
+	}
+	if c.History != nil {
+		if err := c.History.check(); err != nil {
+			return c, fmt.Errorf("Error in history of %s: %v", path, err)
+		}
+	}
+	if c.Radar != nil {
+		if err := c.Radar.check(); err != nil {
+			return c, fmt.Errorf("Error in radar of %s: %v", path, err)
+		}
+	}
+	if c.Floods != nil {
+		if err := c.Floods.check(); err != nil {
+			return c, fmt.Errorf("Error in floods of %s: %v", path, err)
+		}
+	}
+	if c.Streaks != nil {
+		if err := c.Streaks.check(c.Email); err != nil {
+			return c, fmt.Errorf("Error in streaks of %s: %v", path, err)
+		}
+	}
+	if c.Admin != nil {
+		if err := c.Admin.check(); err != nil {
+			return c, fmt.Errorf("Error in admin of %s: %v", path, err)
+		}
+	}
+	if c.Access != nil {
+		if err := c.Access.check(); err != nil {
+			return c, fmt.Errorf("Error in access of %s: %v", path, err)
+		}
+	}
+	if c.Refresh != nil {
+		if err := c.Refresh.check(); err != nil {
+			return c, fmt.Errorf("Error in refresh of %s: %v", path, err)
+		}
+	}
+	if err := keli.CheckSelectors(c.Selectors); err != nil {
+		return c, fmt.Errorf("Error in selectors of %s: %v", path, err)
+	}
+	for name := range c.Sources {
+		if keli.SourceIndex(name) < 0 {
+			return c, fmt.Errorf("Error in sources of %s: Unknown source \"%s\"", path, name)
+		}
+	}
+	for field, merge := range c.Merge {
+		if err := merge.Check(field); err != nil {
+			return c, fmt.Errorf("Error in merge of %s: %v", path, err)
+		}
+	}
+	return c, nil
+}
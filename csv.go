@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// hourlyCSVHeader is the header row of the hourly forecast CSV
+var hourlyCSVHeader = []string{
+	"city", "hour", "symbolCode", "temperature", "temperatureFeelsLike",
+	"windSpeed", "windSpeedUnit", "rainfall", "rainChance", "units",
+	"precipitationType",
+}
+
+// HourlyCSV returns the hourly forecast as CSV rows, header first. Numbers
+// are not localized so spreadsheets and pandas can read them as is.
+func HourlyCSV(weather WeatherData) [][]string {
+	rows := [][]string{hourlyCSVHeader}
+	for _, h := range weather.HourlyForecast {
+		rows = append(rows, []string{
+			weather.City,
+			h.Hour,
+			h.SymbolCode,
+			formatCSVFloat(h.Temperature),
+			formatCSVFloat(h.TemperatureFeelsLike),
+			strconv.Itoa(h.WindSpeed),
+			string(weather.WindSpeedUnit),
+			formatCSVFloat(h.Rainfall),
+			strconv.Itoa(h.RainChance),
+			string(weather.Units),
+			string(h.PrecipitationType),
+		})
+	}
+	return rows
+}
+
+// historyCSVHeader is the header row of the history CSV
+var historyCSVHeader = []string{
+	"city", "date", "temperatureMin", "temperatureMax", "temperatureMean",
+	"rainfall", "hours",
+}
+
+// HistoryCSV returns the days of the history as CSV rows, header first, in
+// metric units like the history is kept.
+func HistoryCSV(h HistoryDays) [][]string {
+	rows := [][]string{historyCSVHeader}
+	for _, day := range h.Days {
+		rows = append(rows, []string{
+			h.City,
+			day.Date,
+			formatCSVFloat(day.TemperatureMin),
+			formatCSVFloat(day.TemperatureMax),
+			formatCSVFloat(day.TemperatureMean),
+			formatCSVFloat(day.Rainfall),
+			strconv.Itoa(day.Hours),
+		})
+	}
+	return rows
+}
+
+func formatCSVFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// writeCSV writes the rows as a CSV response, offered as a download named
+// filename.
+func writeCSV(w http.ResponseWriter, filename string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "inline; filename=\""+filename+"\"")
+
+	writer := csv.NewWriter(w)
+	err := writer.WriteAll(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func weatherCSVHandler(w http.ResponseWriter, weather WeatherData) {
+	writeCSV(w, keli.SanitizeCityName(weather.City)+"-hourly.csv", HourlyCSV(weather))
+}
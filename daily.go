@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// DayDetail is a day of the daily forecast opened on the weather page, with
+// the hours of the hourly forecast that fall on it.
+type DayDetail struct {
+	keli.DailyForecast
+	Hours []keli.HourlyForecast
+}
+
+// ParseDay parses the day query parameter of the weather page, a date like
+// 2024-04-19. An empty value means no day is open.
+func ParseDay(day string) (string, error) {
+	if day == "" {
+		return "", nil
+	}
+	if _, err := time.Parse(time.DateOnly, day); err != nil {
+		return "", fmt.Errorf("Unknown day \"%s\", expected a date like 2024-04-19", day)
+	}
+	return day, nil
+}
+
+// ForecastDay returns the detail of the day with the given date, or nil if
+// the daily forecast doesn't have it.
+func ForecastDay(weather WeatherData, date string) *DayDetail {
+	for _, d := range weather.DailyForecast {
+		if d.Date != date {
+			continue
+		}
+		detail := &DayDetail{DailyForecast: d}
+		for i, t := range ForecastTimes(weather) {
+			if t.Format(time.DateOnly) == date {
+				detail.Hours = append(detail.Hours, weather.HourlyForecast[i])
+			}
+		}
+		return detail
+	}
+	return nil
+}
+
+// weekday returns the name of the weekday of a date of the daily forecast.
+func weekday(lang Language, date string) string {
+	day, err := time.ParseInLocation(time.DateOnly, date, location)
+	if err != nil {
+		return date
+	}
+	return lang.Weekday(day.Weekday())
+}
+
+// ParseTimeOfDay parses a time of day like 07:00 from the configuration. An
+// empty value means the given default.
+func ParseTimeOfDay(value, def string) (time.Time, error) {
+	if value == "" {
+		value = def
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return t, fmt.Errorf("Invalid time \"%s\", expected e.g. 07:00", value)
+	}
+	return t, nil
+}
+
+// EveryDay calls run at the time of day, Finnish time, every day. Runs
+// forever.
+func EveryDay(at time.Time, run func()) {
+	for {
+		now := time.Now().In(location)
+		next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, location)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		time.Sleep(time.Until(next))
+		run()
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// least hours of today in the hourly forecast to derive today's weather from
+const minDeriveHours = 3
+
+// deriveFromHourly fills today's min and max temperature and, when no
+// source had a daily forecast, today's forecast from the hours of today in
+// the hourly forecast, so they aren't zero just because a selector broke.
+// Returns the fields derived.
+func deriveFromHourly(ctx context.Context, weather *WeatherData) (derived []string) {
+	from, found := weather.Provenance["hourlyForecast"]
+	if !found {
+		return nil
+	}
+
+	now := time.Now().In(location)
+	withTimes := *weather
+	withTimes.LastUpdated = now
+	var today []keli.HourlyForecast
+	for i, t := range ForecastTimes(withTimes) {
+		if t.YearDay() == now.YearDay() && t.Year() == now.Year() {
+			today = append(today, weather.HourlyForecast[i])
+		}
+	}
+	if len(today) < minDeriveHours {
+		return nil
+	}
+
+	day := keli.DailyForecast{Date: now.Format("2006-01-02"), TemperatureMax: math.Inf(-1), TemperatureMin: math.Inf(1)}
+	for _, h := range today {
+		day.TemperatureMax = math.Max(day.TemperatureMax, h.Temperature)
+		day.TemperatureMin = math.Min(day.TemperatureMin, h.Temperature)
+		day.Rainfall += h.Rainfall
+	}
+	day.Rainfall = roundTo(day.Rainfall, 2)
+	// the weather of the middle of the rest of the day
+	middle := today[len(today)/2]
+	day.SymbolCode, day.WeatherSymbol = middle.SymbolCode, middle.WeatherSymbol
+
+	for _, name := range []string{"temperatureMax", "temperatureMin"} {
+		if _, found := weather.Provenance[name]; found {
+			continue
+		}
+		field := keli.NumericFields[name]
+		if name == "temperatureMax" {
+			field.Set(&weather.Weather, day.TemperatureMax)
+		} else {
+			field.Set(&weather.Weather, day.TemperatureMin)
+		}
+		weather.Provenance[name] = from
+		derived = append(derived, name)
+	}
+	if _, found := weather.Provenance["dailyForecast"]; !found {
+		weather.DailyForecast = []keli.DailyForecast{day}
+		weather.Provenance["dailyForecast"] = from
+		derived = append(derived, "dailyForecast")
+	}
+
+	if len(derived) > 0 {
+		logf(ctx, "Derived %v of %s from the hourly forecast", derived, weather.City)
+	}
+	return derived
+}
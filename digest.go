@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	// most cities in one digest
+	maxDigestCities = 5
+	// time to confirm a subscription before it is forgotten
+	digestConfirmTime = 48 * time.Hour
+	// time before a confirmation is sent again to the same address
+	digestResendTime = time.Hour
+)
+
+// EmailConfig is the SMTP server digests are sent through, and where the
+// subscriptions are kept.
+type EmailConfig struct {
+	// SMTP server as host:port, e.g. smtp.example.com:587. STARTTLS is used
+	// when the server has it.
+	SMTP     string `json:"smtp"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Sender of the emails, e.g. "keli <keli@example.com>"
+	From string `json:"from"`
+	// Address keli is reached at, e.g. https://keli.example.com, for the
+	// confirm and unsubscribe links
+	BaseURL string `json:"baseUrl"`
+	// JSON file the subscriptions are kept in, digests.json by default
+	Subscriptions string `json:"subscriptions"`
+}
+
+// Subscription is an address getting the digest of its cities every day at
+// the hour.
+type Subscription struct {
+	Email  string          `json:"email"`
+	Cities []string        `json:"cities"`
+	Hour   int             `json:"hour"`
+	Lang   Language        `json:"lang"`
+	Units  keli.UnitSystem `json:"units"`
+	// Secret of the confirm and unsubscribe links
+	Token     string    `json:"token"`
+	Confirmed bool      `json:"confirmed"`
+	Created   time.Time `json:"created"`
+}
+
+// Digest is the data of the digest email template.
+type Digest struct {
+	Cities      []DigestCity
+	Unsubscribe string
+}
+
+// DigestCity is the weather of one city of a digest with a link to its page.
+type DigestCity struct {
+	Weather WeatherData
+	Page    string
+}
+
+var (
+	subscriptions      []Subscription
+	subscriptionsMutex sync.Mutex
+)
+
+func (e *EmailConfig) check() error {
+	if _, _, err := net.SplitHostPort(e.SMTP); err != nil {
+		return fmt.Errorf("Invalid smtp \"%s\", expected host:port", e.SMTP)
+	}
+	if _, err := mail.ParseAddress(e.From); err != nil {
+		return fmt.Errorf("Invalid from \"%s\", expected an email address", e.From)
+	}
+	if u, err := url.Parse(e.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid baseUrl \"%s\", expected an http or https URL", e.BaseURL)
+	}
+	e.BaseURL = strings.TrimSuffix(e.BaseURL, "/")
+	if e.Subscriptions == "" {
+		e.Subscriptions = "digests.json"
+	}
+	return nil
+}
+
+// StartDigests loads the subscriptions and sends the digests due every
+// hour.
+func StartDigests(e EmailConfig) error {
+	data, err := os.ReadFile(e.Subscriptions)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &subscriptions); err != nil {
+			return fmt.Errorf("Error in %s: %v", e.Subscriptions, err)
+		}
+	}
+
+	go func() {
+		for {
+			now := time.Now().In(location)
+			time.Sleep(time.Until(now.Truncate(time.Hour).Add(time.Hour)))
+			sendDigests(e, time.Now().In(location).Hour())
+		}
+	}()
+	return nil
+}
+
+// sendDigests sends the digests of the hour and forgets the subscriptions
+// left unconfirmed.
+func sendDigests(e EmailConfig, hour int) {
+	subscriptionsMutex.Lock()
+	var due []Subscription
+	expired := func(s Subscription) bool {
+		return !s.Confirmed && time.Since(s.Created) > digestConfirmTime
+	}
+	if slices.ContainsFunc(subscriptions, expired) {
+		subscriptions = slices.DeleteFunc(subscriptions, expired)
+		saveSubscriptions(e)
+	}
+	for _, s := range subscriptions {
+		if s.Confirmed && s.Hour == hour {
+			due = append(due, s)
+		}
+	}
+	subscriptionsMutex.Unlock()
+
+	for _, s := range due {
+		if err := sendDigest(e, s); err != nil {
+			log.Printf("Error sending digest to %s: %v", s.Email, err)
+		}
+	}
+}
+
+// sendDigest emails the weather of the cities of the subscription as HTML
+// and plain text.
+func sendDigest(e EmailConfig, s Subscription) error {
+	unsubscribe := e.BaseURL + "/digest/unsubscribe?token=" + s.Token
+	digest := Digest{Unsubscribe: unsubscribe}
+	var text strings.Builder
+	for _, city := range s.Cities {
+		weather, err := GetWeatherData(context.Background(), city)
+		if err != nil {
+			log.Printf("Error getting weather of %s for digest: %v", city, err)
+			continue
+		}
+		weather = ConvertUnits(weather, s.Units, "")
+		digest.Cities = append(digest.Cities, DigestCity{
+			Weather: weather,
+			Page:    e.BaseURL + "/" + url.PathEscape(weather.City) + "?lang=" + string(s.Lang),
+		})
+		text.WriteString(Text(weather, s.Lang) + "\n")
+	}
+	if len(digest.Cities) == 0 {
+		return fmt.Errorf("No weather for %s", strings.Join(s.Cities, ", "))
+	}
+	text.WriteString(s.Lang.T("digestUnsubscribeText", unsubscribe) + "\n")
+
+	var html strings.Builder
+	c := templateContext{Lang: s.Lang, Weather: digest.Cities[0].Weather}
+	if err := renderTemplate(&html, "digest.html", c, digest); err != nil {
+		return err
+	}
+
+	subject := s.Lang.T("digestSubject", s.Lang.FormatDate(time.Now().In(location)))
+	return sendEmail(e, s.Email, subject, text.String(), html.String(), unsubscribe)
+}
+
+// digestHandler serves POST /digest/subscribe and the confirm and
+// unsubscribe links of the emails, /digest/confirm?token= and
+// /digest/unsubscribe?token=.
+func digestHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	e := config().Email
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	lang, err := ParseLanguage(r.FormValue("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var message string
+	switch strings.TrimPrefix(r.URL.Path, "/digest/") {
+	case "subscribe":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s, err := parseSubscription(r, lang)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := subscribe(*e, s); err != nil {
+			logf(r.Context(), "Error subscribing %s: %v", s.Email, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		message = lang.T("digestCheckEmail")
+	case "confirm":
+		s, found := changeSubscription(*e, r.FormValue("token"), func(s *Subscription) bool {
+			s.Confirmed = true
+			return true
+		})
+		if !found {
+			http.Error(w, "Unknown or expired token", http.StatusNotFound)
+			return
+		}
+		message = s.Lang.T("digestConfirmed", strings.Join(s.Cities, ", "), s.Hour)
+	case "unsubscribe":
+		// GET from the link, POST from one-click unsubscribe of mail apps
+		s, found := changeSubscription(*e, r.FormValue("token"), func(s *Subscription) bool {
+			return false
+		})
+		if found {
+			lang = s.Lang
+		}
+		message = lang.T("digestUnsubscribed")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(message + "\n"))
+}
+
+// parseSubscription parses the email, city, hour and units of a subscribe
+// form. The cities are checked to have weather and get their proper names.
+func parseSubscription(r *http.Request, lang Language) (Subscription, error) {
+	s := Subscription{Lang: lang, Hour: 7, Created: time.Now()}
+
+	address, err := mail.ParseAddress(r.FormValue("email"))
+	if err != nil {
+		return s, fmt.Errorf("Invalid email \"%s\"", r.FormValue("email"))
+	}
+	s.Email = address.Address
+
+	if hour := r.FormValue("hour"); hour != "" {
+		s.Hour, err = strconv.Atoi(hour)
+		if err != nil || s.Hour < 0 || s.Hour > 23 {
+			return s, fmt.Errorf("Invalid hour \"%s\", expected 0 to 23", hour)
+		}
+	}
+
+	if s.Units, err = keli.ParseUnitSystem(r.FormValue("units")); err != nil {
+		return s, err
+	}
+
+	cities := r.Form["city"]
+	if len(cities) == 0 {
+		return s, fmt.Errorf("Missing 'city' parameter")
+	}
+	if len(cities) > maxDigestCities {
+		return s, fmt.Errorf("Too many cities, at most %d", maxDigestCities)
+	}
+	for _, city := range cities {
+		weather, err := GetWeatherData(r.Context(), city)
+		if err != nil {
+			return s, err
+		}
+		if !slices.Contains(s.Cities, weather.City) {
+			s.Cities = append(s.Cities, weather.City)
+		}
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return s, err
+	}
+	s.Token = hex.EncodeToString(token)
+	return s, nil
+}
+
+// subscribe adds the unconfirmed subscription, replacing an earlier one of
+// the address, and sends the link confirming it. The link isn't sent again
+// soon after, so the form can't be used to flood an inbox.
+func subscribe(e EmailConfig, s Subscription) error {
+	subscriptionsMutex.Lock()
+	i := slices.IndexFunc(subscriptions, func(old Subscription) bool { return old.Email == s.Email })
+	if i >= 0 && !subscriptions[i].Confirmed && time.Since(subscriptions[i].Created) < digestResendTime {
+		subscriptionsMutex.Unlock()
+		return nil
+	}
+	if i >= 0 {
+		subscriptions = slices.Delete(subscriptions, i, i+1)
+	}
+	subscriptions = append(subscriptions, s)
+	saveSubscriptions(e)
+	subscriptionsMutex.Unlock()
+
+	confirm := e.BaseURL + "/digest/confirm?token=" + s.Token
+	text := s.Lang.T("digestConfirmText", strings.Join(s.Cities, ", "), s.Hour, confirm) + "\n"
+	return sendEmail(e, s.Email, s.Lang.T("digestConfirmSubject"), text, "", "")
+}
+
+// changeSubscription runs change on the subscription with the token,
+// removing it when change returns false.
+func changeSubscription(e EmailConfig, token string, change func(s *Subscription) bool) (Subscription, bool) {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+
+	i := slices.IndexFunc(subscriptions, func(s Subscription) bool { return token != "" && s.Token == token })
+	if i < 0 {
+		return Subscription{}, false
+	}
+	s := subscriptions[i]
+	if change(&subscriptions[i]) {
+		s = subscriptions[i]
+	} else {
+		subscriptions = slices.Delete(subscriptions, i, i+1)
+	}
+	saveSubscriptions(e)
+	return s, true
+}
+
+// saveSubscriptions writes the subscriptions to their file. The caller
+// holds subscriptionsMutex.
+func saveSubscriptions(e EmailConfig) {
+	data, err := json.MarshalIndent(subscriptions, "", "  ")
+	if err == nil {
+		// write a temporary file first so a crash can't leave half a file
+		err = os.WriteFile(e.Subscriptions+".tmp", data, 0o600)
+	}
+	if err == nil {
+		err = os.Rename(e.Subscriptions+".tmp", e.Subscriptions)
+	}
+	if err != nil {
+		log.Printf("Error saving subscriptions to %s: %v", e.Subscriptions, err)
+	}
+}
+
+// sendEmail sends a plain text email, or a multipart one when there is
+// HTML too. An unsubscribe link is added as the List-Unsubscribe header.
+func sendEmail(e EmailConfig, to, subject, text, html, unsubscribe string) error {
+	from, err := mail.ParseAddress(e.From)
+	if err != nil {
+		return err
+	}
+
+	var message bytes.Buffer
+	header := textproto.MIMEHeader{}
+	header.Set("From", from.String())
+	header.Set("To", to)
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("MIME-Version", "1.0")
+	if unsubscribe != "" {
+		header.Set("List-Unsubscribe", "<"+unsubscribe+">")
+		header.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	var body bytes.Buffer
+	if html == "" {
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		if err := writeQuotedPrintable(&body, text); err != nil {
+			return err
+		}
+	} else {
+		parts := multipart.NewWriter(&body)
+		header.Set("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+		for _, part := range []struct{ contentType, content string }{
+			{"text/plain; charset=utf-8", text},
+			{"text/html; charset=utf-8", html},
+		} {
+			w, err := parts.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return err
+			}
+			if err := writeQuotedPrintable(w, part.content); err != nil {
+				return err
+			}
+		}
+		parts.Close()
+	}
+
+	for key, values := range header {
+		for _, value := range values {
+			fmt.Fprintf(&message, "%s: %s\r\n", key, value)
+		}
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+
+	host, _, _ := net.SplitHostPort(e.SMTP)
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	return smtp.SendMail(e.SMTP, auth, from.Address, []string{to}, message.Bytes())
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const discordAPI = "https://discord.com/api/v10"
+
+var discordClient = &http.Client{Timeout: 10 * time.Second}
+
+// DiscordConfig is the Discord application answering the /weather slash
+// command. Discord posts the commands to /discord, set as the interactions
+// endpoint URL of the application.
+type DiscordConfig struct {
+	// Application ID and public key from the Discord developer portal
+	ApplicationID string `json:"applicationId"`
+	PublicKey     string `json:"publicKey"`
+	// Bot token, for registering the command and the morning post
+	Token string `json:"token"`
+	// Address keli is reached at, e.g. https://keli.example.com, for the
+	// links and icons of the embeds
+	BaseURL string `json:"baseUrl"`
+	// Language when keli doesn't speak the user's, Finnish by default
+	Lang  string `json:"lang"`
+	Units string `json:"units"`
+	// Default city of the command, and the city of the morning post
+	City string `json:"city"`
+	// Channel ID to post the weather of the city to every morning, optional
+	Channel string `json:"channel"`
+	// Time of the morning post, 07:00 by default
+	Time string `json:"time"`
+
+	publicKey ed25519.PublicKey
+	lang      Language
+	units     keli.UnitSystem
+	postAt    time.Time
+}
+
+// discordInteraction is the part of a Discord interaction keli looks at.
+type discordInteraction struct {
+	Type   int    `json:"type"`
+	Token  string `json:"token"`
+	Locale string `json:"locale"`
+	Data   struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+// interaction and response types of the Discord API
+const (
+	discordPing            = 1
+	discordCommand         = 2
+	discordPong            = 1
+	discordMessage         = 4
+	discordDeferredMessage = 5
+	// message flag shown only to the user who ran the command
+	discordEphemeral = 64
+)
+
+type discordResponse struct {
+	Type int                   `json:"type"`
+	Data *discordMessageParams `json:"data,omitempty"`
+}
+
+type discordMessageParams struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []discordEmbed `json:"embeds,omitempty"`
+	Flags   int            `json:"flags,omitempty"`
+}
+
+type discordEmbed struct {
+	Title       string              `json:"title"`
+	URL         string              `json:"url,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color"`
+	Thumbnail   *discordEmbedImage  `json:"thumbnail,omitempty"`
+	Fields      []discordEmbedField `json:"fields"`
+	Timestamp   string              `json:"timestamp"`
+}
+
+type discordEmbedImage struct {
+	URL string `json:"url"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+func (d *DiscordConfig) check() error {
+	if d.ApplicationID == "" {
+		return fmt.Errorf("Missing 'applicationId'")
+	}
+	key, err := hex.DecodeString(d.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("Invalid publicKey \"%s\", expected the hex public key of the application", d.PublicKey)
+	}
+	d.publicKey = key
+	if u, err := url.Parse(d.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid baseUrl \"%s\", expected an http or https URL", d.BaseURL)
+	}
+	d.BaseURL = strings.TrimSuffix(d.BaseURL, "/")
+	if d.lang, err = ParseLanguage(d.Lang); err != nil {
+		return err
+	}
+	if d.units, err = keli.ParseUnitSystem(d.Units); err != nil {
+		return err
+	}
+	if d.Channel != "" {
+		if d.Token == "" || d.City == "" {
+			return fmt.Errorf("The morning post needs 'token' and 'city'")
+		}
+		if d.postAt, err = ParseTimeOfDay(d.Time, "07:00"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartDiscord registers the /weather command and starts the morning post
+// when they are configured.
+func StartDiscord(d DiscordConfig) {
+	if d.Token != "" {
+		go func() {
+			if err := registerDiscordCommand(d); err != nil {
+				log.Printf("Error registering Discord command: %v", err)
+			}
+		}()
+	}
+	if d.Channel != "" {
+		go EveryDay(d.postAt, func() { postDiscordMorning(d) })
+	}
+}
+
+// registerDiscordCommand creates the /weather command, replacing the
+// earlier commands of the application.
+func registerDiscordCommand(d DiscordConfig) error {
+	commands := []map[string]any{{
+		"name":        "weather",
+		"description": "Weather of a city",
+		"name_localizations": map[string]string{
+			"fi":    "sää",
+			"sv-SE": "väder",
+		},
+		"options": []map[string]any{{
+			// string option
+			"type":        3,
+			"name":        "city",
+			"description": "City or place in Finland",
+			"required":    d.City == "",
+		}},
+	}}
+	return discordRequest(http.MethodPut, "/applications/"+d.ApplicationID+"/commands", d.Token, commands)
+}
+
+// postDiscordMorning posts the weather of the city to the channel.
+func postDiscordMorning(d DiscordConfig) {
+	weather, err := GetWeatherData(context.Background(), d.City)
+	if err != nil {
+		log.Printf("Error getting weather for the Discord morning post: %v", err)
+		return
+	}
+	message := discordMessageParams{Embeds: []discordEmbed{d.embed(weather, d.lang)}}
+	if err := discordRequest(http.MethodPost, "/channels/"+d.Channel+"/messages", d.Token, message); err != nil {
+		log.Printf("Error posting the Discord morning post: %v", err)
+	}
+}
+
+// discordHandler serves /discord, the interactions endpoint of the Discord
+// application.
+func discordHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	d := config().Discord
+	if d == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !d.verify(r.Header, body) {
+		http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var interaction discordInteraction
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch interaction.Type {
+	case discordPing:
+		writeDiscordResponse(w, discordResponse{Type: discordPong})
+	case discordCommand:
+		d.answer(w, r, interaction)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown interaction type %d", interaction.Type), http.StatusBadRequest)
+	}
+}
+
+// verify checks that Discord signed the request, as it requires of
+// interactions endpoints.
+func (d *DiscordConfig) verify(header http.Header, body []byte) bool {
+	signature, err := hex.DecodeString(header.Get("X-Signature-Ed25519"))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	message := append([]byte(header.Get("X-Signature-Timestamp")), body...)
+	return ed25519.Verify(d.publicKey, message, signature)
+}
+
+// answer responds to the /weather command. Discord waits for 3 seconds,
+// so when the weather isn't cached the answer is deferred and sent as an
+// edit of the response once the weather is there.
+func (d *DiscordConfig) answer(w http.ResponseWriter, r *http.Request, interaction discordInteraction) {
+	city := d.City
+	for _, option := range interaction.Data.Options {
+		if option.Name == "city" {
+			city = option.Value
+		}
+	}
+	// Discord locales are like "fi" or "sv-SE"
+	lang, err := ParseLanguage(strings.Split(interaction.Locale, "-")[0])
+	if err != nil || interaction.Locale == "" {
+		lang = d.lang
+	}
+
+	message := make(chan discordMessageParams, 1)
+	go func() {
+		weather, err := GetWeatherData(r.Context(), city)
+		if err != nil {
+			message <- discordMessageParams{Content: err.Error(), Flags: discordEphemeral}
+			return
+		}
+		message <- discordMessageParams{Embeds: []discordEmbed{d.embed(weather, lang)}}
+	}()
+
+	select {
+	case m := <-message:
+		writeDiscordResponse(w, discordResponse{Type: discordMessage, Data: &m})
+	case <-time.After(2 * time.Second):
+		writeDiscordResponse(w, discordResponse{Type: discordDeferredMessage})
+		go func() {
+			m := <-message
+			path := "/webhooks/" + d.ApplicationID + "/" + interaction.Token + "/messages/@original"
+			if err := discordRequest(http.MethodPatch, path, "", m); err != nil {
+				logf(r.Context(), "Error answering Discord command: %v", err)
+			}
+		}()
+	}
+}
+
+// embed shows the weather with its icon and the temperature, wind and rain
+// as fields.
+func (d *DiscordConfig) embed(weather WeatherData, lang Language) discordEmbed {
+	weather = ConvertUnits(weather, d.units, "")
+
+	embed := discordEmbed{
+		Title:       lang.T("title", weather.City, weather.ObservationHour),
+		URL:         d.BaseURL + "/" + url.PathEscape(weather.City) + "?lang=" + string(lang),
+		Description: lang.Summary(weather),
+		Color:       0x4299e1,
+		Timestamp:   weather.LastUpdated.Format(time.RFC3339),
+	}
+	for _, field := range Fields(weather, lang) {
+		embed.Fields = append(embed.Fields, discordEmbedField{Name: field.Name, Value: field.Value, Inline: true})
+	}
+	if weather.SymbolCode != "" {
+		embed.Thumbnail = &discordEmbedImage{URL: d.BaseURL + "/icons/" + weather.SymbolCode + ".png"}
+	}
+	return embed
+}
+
+func writeDiscordResponse(w http.ResponseWriter, response discordResponse) {
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// discordRequest sends the body as JSON to the Discord API, authorized with
+// the bot token when there is one.
+func discordRequest(method, path, token string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, discordAPI+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bot "+token)
+	}
+
+	res, err := discordClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s: %s", res.Status, message)
+	}
+	return nil
+}
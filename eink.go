@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/fogleman/gg"
+	"github.com/itsnibsi/keli/pkg/keli"
+	"golang.org/x/image/bmp"
+)
+
+const (
+	// default size of the e-ink image, a common 7.5" panel
+	einkWidth  = 800
+	einkHeight = 480
+	// hours in the hourly strip
+	einkHours = 6
+)
+
+// einkPalette is the palette of 1-bit e-ink images.
+var einkPalette = color.Palette{color.Black, color.White}
+
+// EInk draws the weather for an e-paper display of the given size: a big
+// temperature with the icon, today's low, high and wind and a strip of the
+// next hours. The image is in greyscale, or dithered to black and white if
+// mono is set.
+func EInk(weather WeatherData, lang Language, width, height int, mono bool) image.Image {
+	units := weather.Labels()
+	// the layout is made for 800×480 and scaled to the size
+	s := math.Min(float64(width)/einkWidth, float64(height)/einkHeight)
+	px := func(v float64) float64 { return v * s }
+
+	dc := gg.NewContext(width, height)
+	dc.SetColor(color.White)
+	dc.Clear()
+	dc.SetColor(color.Black)
+
+	dc.SetFontFace(fontFace(fontBold, px(36)))
+	dc.DrawString(weather.City, px(24), px(52))
+	dc.SetFontFace(fontFace(fontRegular, px(22)))
+	dc.DrawStringAnchored(lang.FormatDateTime(weather.LastUpdated.In(location)), px(776), px(52), 1, 0)
+	dc.SetLineWidth(px(2))
+	dc.DrawLine(px(24), px(70), px(776), px(70))
+	dc.Stroke()
+
+	drawEInkIcon(dc, weather.SymbolCode, px(24), px(86), int(px(180)))
+
+	dc.SetFontFace(fontFace(fontBold, px(110)))
+	dc.DrawString(lang.Temperature(weather.Temperature, units.Temperature), px(220), px(190))
+	dc.SetFontFace(fontFace(fontRegular, px(28)))
+	dc.DrawString(lang.Summary(weather), px(226), px(238))
+	dc.SetFontFace(fontFace(fontRegular, px(24)))
+	dc.DrawString(fmt.Sprintf("%s %s   %s %s",
+		lang.T("min"), lang.Temperature(weather.TemperatureMin, units.Temperature),
+		lang.T("max"), lang.Temperature(weather.TemperatureMax, units.Temperature)), px(226), px(280))
+	dc.DrawString(lang.T("wind", weather.WindSpeed, units.WindSpeed, lang.WindDescription(weather.Beaufort)), px(226), px(314))
+
+	dc.DrawLine(px(24), px(334), px(776), px(334))
+	dc.Stroke()
+
+	hours := weather.HourlyForecast
+	if len(hours) > einkHours {
+		hours = hours[:einkHours]
+	}
+	column := px(752) / einkHours
+	for i, h := range hours {
+		x := px(24) + (float64(i)+0.5)*column
+		dc.SetFontFace(fontFace(fontRegular, px(22)))
+		dc.DrawStringAnchored(h.Hour, x, px(358), 0.5, 0)
+		drawEInkIcon(dc, h.SymbolCode, x-px(24), px(364), int(px(48)))
+		dc.SetFontFace(fontFace(fontBold, px(26)))
+		dc.DrawStringAnchored(lang.Decimal(h.Temperature, 0)+"°", x, px(436), 0.5, 0)
+		dc.SetFontFace(fontFace(fontRegular, px(18)))
+		dc.DrawStringAnchored(lang.Precipitation(h.Rainfall, weather.Units)+" "+units.Precipitation, x, px(456), 0.5, 0)
+		// snow and sleet told apart from rain, as the amounts look alike
+		if h.Rainfall > 0 {
+			dc.SetFontFace(fontFace(fontRegular, px(16)))
+			dc.DrawStringAnchored(lang.PrecipitationType(h.PrecipitationType), x, px(474), 0.5, 0)
+		}
+	}
+
+	img := dc.Image()
+	if mono {
+		paletted := image.NewPaletted(img.Bounds(), einkPalette)
+		draw.FloydSteinberg.Draw(paletted, img.Bounds(), img, image.Point{})
+		return paletted
+	}
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, img.Bounds(), img, image.Point{}, draw.Src)
+	return gray
+}
+
+func drawEInkIcon(dc *gg.Context, code string, x, y float64, size int) {
+	icon, err := IconImage(code, size)
+	if err != nil {
+		log.Printf("Error drawing icon for %s: %v", code, err)
+		return
+	}
+	dc.DrawImage(icon, int(x), int(y))
+}
+
+// writeMonoBMP writes a black and white image as a 1-bit BMP, which small
+// e-ink controllers read directly. x/image/bmp only writes 8-bit palettes.
+func writeMonoBMP(w io.Writer, img *image.Paletted) error {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	// rows are padded to four bytes
+	rowSize := (width + 31) / 32 * 4
+	const headerSize = 14 + 40 + 2*4
+
+	header := []any{
+		// file header
+		[2]byte{'B', 'M'}, uint32(headerSize + rowSize*height), uint32(0), uint32(headerSize),
+		// info header
+		uint32(40), int32(width), int32(height), uint16(1), uint16(1), uint32(0),
+		uint32(rowSize * height), int32(2835), int32(2835), uint32(2), uint32(0),
+	}
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	for _, c := range einkPalette {
+		r, g, b, _ := c.RGBA()
+		if _, err := w.Write([]byte{byte(b >> 8), byte(g >> 8), byte(r >> 8), 0}); err != nil {
+			return err
+		}
+	}
+
+	// bottom-up rows, most significant bit first
+	row := make([]byte, rowSize)
+	for y := img.Bounds().Max.Y - 1; y >= img.Bounds().Min.Y; y-- {
+		clear(row)
+		for x := 0; x < width; x++ {
+			if img.ColorIndexAt(img.Bounds().Min.X+x, y) == 1 {
+				row[x/8] |= 0x80 >> (x % 8)
+			}
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// einkSize parses the w or h parameter.
+func einkSize(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 100 || size > 2000 {
+		return 0, fmt.Errorf("Invalid size \"%s\", expected 100-2000 pixels", value)
+	}
+	return size, nil
+}
+
+// einkHandler serves /eink?city=X&w=800&h=480 as a PNG or BMP for e-paper
+// displays. bits=8 gives greyscale instead of black and white.
+func einkHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	query := r.URL.Query()
+	city := query.Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	width, err := einkSize(query.Get("w"), einkWidth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	height, err := einkSize(query.Get("h"), einkHeight)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var mono bool
+	switch query.Get("bits") {
+	case "", "1":
+		mono = true
+	case "8":
+		mono = false
+	default:
+		http.Error(w, fmt.Sprintf("Unknown bits \"%s\", expected 1 or 8", query.Get("bits")), http.StatusBadRequest)
+		return
+	}
+
+	format := query.Get("format")
+	if format != "" && format != "png" && format != "bmp" {
+		http.Error(w, fmt.Sprintf("Unknown format \"%s\", expected png or bmp", format), http.StatusBadRequest)
+		return
+	}
+
+	units, err := keli.ParseUnitSystem(query.Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lang, err := ParseLanguage(query.Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+	weather = ConvertUnits(weather, units, "")
+
+	img := EInk(weather, lang, width, height, mono)
+
+	switch {
+	case format == "bmp" && mono:
+		w.Header().Set("Content-Type", "image/bmp")
+		err = writeMonoBMP(w, img.(*image.Paletted))
+	case format == "bmp":
+		w.Header().Set("Content-Type", "image/bmp")
+		err = bmp.Encode(w, img)
+	default:
+		w.Header().Set("Content-Type", "image/png")
+		err = png.Encode(w, img)
+	}
+	if err != nil {
+		logf(r.Context(), "Error writing e-ink image for %s: %v", city, err)
+	}
+}
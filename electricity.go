@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// hourly spot prices of Finland by default, in €/kWh
+	defaultSpotPriceURL = "https://api.spot-hinta.fi/TodayAndDayForward"
+	// how long the prices are kept before fetching them again. Tomorrow's
+	// come out in the afternoon.
+	spotPriceCacheDuration = time.Hour
+)
+
+var spotPriceClient = &http.Client{Timeout: 10 * time.Second}
+
+// ElectricityConfig shows the electricity spot prices on the weather page,
+// as a cold snap is when they spike.
+type ElectricityConfig struct {
+	// JSON of the prices in the format of api.spot-hinta.fi, which is used
+	// by default
+	URL string `json:"url"`
+	// Prices without VAT instead of with it
+	WithoutTax bool `json:"withoutTax"`
+}
+
+// Electricity is the electricity spot price of each hour from the current
+// one on, in c/kWh.
+type Electricity struct {
+	// Price of the current hour
+	Now      float64     `json:"now"`
+	Cheapest SpotPrice   `json:"cheapest"`
+	Prices   []SpotPrice `json:"prices"`
+}
+
+// SpotPrice is the price of the hour starting at Time.
+type SpotPrice struct {
+	Time  time.Time `json:"time"`
+	Price float64   `json:"price"`
+}
+
+// spotPrice is a price of api.spot-hinta.fi, in €/kWh.
+type spotPrice struct {
+	DateTime     time.Time `json:"DateTime"`
+	PriceNoTax   float64   `json:"PriceNoTax"`
+	PriceWithTax float64   `json:"PriceWithTax"`
+}
+
+var (
+	spotPrices      []spotPrice
+	spotPricesTime  time.Time
+	spotPricesMutex sync.Mutex
+)
+
+func (e *ElectricityConfig) check() error {
+	if e.URL == "" {
+		e.URL = defaultSpotPriceURL
+	}
+	if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid url \"%s\", expected an http or https URL", e.URL)
+	}
+	return nil
+}
+
+// fetchSpotPrices returns the prices, fetching them again when the cached
+// ones are older than spotPriceCacheDuration or run out.
+func fetchSpotPrices(e ElectricityConfig, now time.Time) ([]spotPrice, error) {
+	spotPricesMutex.Lock()
+	defer spotPricesMutex.Unlock()
+	if time.Since(spotPricesTime) < spotPriceCacheDuration && len(spotPrices) > 0 && spotPrices[len(spotPrices)-1].DateTime.After(now) {
+		return spotPrices, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, e.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Accept", "application/json")
+	res, err := spotPriceClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var prices []spotPrice
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(prices, func(a, b spotPrice) int { return a.DateTime.Compare(b.DateTime) })
+
+	spotPrices, spotPricesTime = prices, time.Now()
+	return prices, nil
+}
+
+// ElectricityPrices returns the prices of the hours from the one of now on.
+// Prices of shorter periods than an hour are averaged over the hour.
+func ElectricityPrices(e ElectricityConfig, now time.Time) (*Electricity, error) {
+	prices, err := fetchSpotPrices(e, now)
+	if err != nil {
+		return nil, err
+	}
+
+	electricity := &Electricity{Prices: []SpotPrice{}}
+	current := now.In(location).Truncate(time.Hour)
+	count := 0
+	for _, p := range prices {
+		hour := p.DateTime.In(location).Truncate(time.Hour)
+		if hour.Before(current) {
+			continue
+		}
+		price := p.PriceWithTax
+		if e.WithoutTax {
+			price = p.PriceNoTax
+		}
+		last := len(electricity.Prices) - 1
+		if last < 0 || !electricity.Prices[last].Time.Equal(hour) {
+			electricity.Prices = append(electricity.Prices, SpotPrice{Time: hour})
+			last, count = last+1, 0
+		}
+		// a running average in c/kWh
+		count++
+		electricity.Prices[last].Price += (price*100 - electricity.Prices[last].Price) / float64(count)
+	}
+	if len(electricity.Prices) == 0 {
+		return nil, fmt.Errorf("No electricity prices from %s on", current.Format("2006-01-02 15:04"))
+	}
+
+	for i := range electricity.Prices {
+		electricity.Prices[i].Price = roundTo(electricity.Prices[i].Price, 2)
+	}
+	electricity.Now = electricity.Prices[0].Price
+	electricity.Cheapest = slices.MinFunc(electricity.Prices, func(a, b SpotPrice) int {
+		switch {
+		case a.Price < b.Price:
+			return -1
+		case a.Price > b.Price:
+			return 1
+		}
+		return a.Time.Compare(b.Time)
+	})
+	return electricity, nil
+}
+
+// currentElectricity returns the prices from now on by the config, or the
+// default one without, logging the error and returning nil if there are
+// none, so that the weather is served without them.
+func currentElectricity() *Electricity {
+	e := ElectricityConfig{URL: defaultSpotPriceURL}
+	if configured := config().Electricity; configured != nil {
+		e = *configured
+	}
+	electricity, err := ElectricityPrices(e, time.Now())
+	if err != nil {
+		log.Printf("Error getting electricity prices: %v", err)
+		return nil
+	}
+	return electricity
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// eventsHandler serves /events?city=X, a Server-Sent Events stream with a
+// weather event of the JSON weather data, first right away and then whenever
+// the weather of the city is refreshed.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	units, err := keli.ParseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	windUnit, err := keli.ParseWindUnit(r.URL.Query().Get("wind_unit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	updates, unsubscribe := Subscribe(city)
+	defer unsubscribe()
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+	// the weather may have been refreshed just now, don't send it twice
+	select {
+	case <-updates:
+	default:
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// keep proxies like nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	if err := writeEvent(w, ConvertUnits(weather, units, windUnit)); err != nil {
+		logf(r.Context(), "Error writing event for %s: %v", city, err)
+		return
+	}
+	flusher.Flush()
+
+	// the cache only refreshes when asked for the weather, so ask for it
+	// whenever it may have expired. A refresh arrives as an update.
+	ticker := time.NewTicker(cacheTTL())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case weather := <-updates:
+			if err := writeEvent(w, ConvertUnits(weather, units, windUnit)); err != nil {
+				logf(r.Context(), "Error writing event for %s: %v", city, err)
+				return
+			}
+		case <-ticker.C:
+			if _, err := GetWeatherData(r.Context(), city); err != nil {
+				logf(r.Context(), "Error refreshing weather for %s: %v", city, err)
+			}
+			// a comment keeps the connection open through proxies
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes the weather as a Server-Sent Event. The id is the time
+// of the update, so clients can tell the same weather from a new one.
+func writeEvent(w http.ResponseWriter, weather WeatherData) error {
+	data, err := json.Marshal(weather)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: weather\ndata: %s\n\n", weather.LastUpdated.Unix(), data)
+	return err
+}
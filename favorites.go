@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	favoritesCookie = "favorites"
+	recentCookie    = "recent"
+	// most cities remembered in each list
+	maxFavorites = 10
+	maxRecent    = 5
+)
+
+// Favorites is the data of templates/favorites.html: the starred and
+// recently viewed cities of the visitor, remembered in cookies.
+type Favorites struct {
+	// City of the page the strip is on, if any
+	City      string
+	Starred   bool
+	Favorites []string
+	Recent    []string
+}
+
+// readCities reads a list of cities from a cookie.
+func readCities(r *http.Request, name string) []string {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil
+	}
+	value, err := url.QueryUnescape(cookie.Value)
+	if err != nil || value == "" {
+		return nil
+	}
+	return strings.Split(value, "|")
+}
+
+// writeCities stores a list of cities in a cookie for a year.
+func writeCities(w http.ResponseWriter, name string, cities []string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    url.QueryEscape(strings.Join(cities, "|")),
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// prependCity moves city to the front of the list, keeping at most max
+// cities.
+func prependCity(cities []string, city string, max int) []string {
+	cities = slices.DeleteFunc(slices.Clone(cities), func(c string) bool { return c == city })
+	cities = append([]string{city}, cities...)
+	if len(cities) > max {
+		cities = cities[:max]
+	}
+	return cities
+}
+
+// rememberCity adds city to the recently viewed cities of the request, and
+// returns the favorites for rendering the page.
+func rememberCity(w http.ResponseWriter, r *http.Request, city string) Favorites {
+	recent := prependCity(readCities(r, recentCookie), city, maxRecent)
+	writeCities(w, recentCookie, recent)
+	return requestFavorites(r, city, recent)
+}
+
+// requestFavorites returns the favorites of the request. The current city
+// is left out of the recent cities, it is on the page already.
+func requestFavorites(r *http.Request, city string, recent []string) Favorites {
+	favorites := Favorites{
+		City:      city,
+		Favorites: readCities(r, favoritesCookie),
+		Recent:    slices.DeleteFunc(slices.Clone(recent), func(c string) bool { return c == city }),
+	}
+	favorites.Starred = slices.Contains(favorites.Favorites, city)
+	return favorites
+}
+
+// favoritesHandler serves /favorites, the favorites strip on its own.
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	city := r.URL.Query().Get("city")
+	favorites := requestFavorites(r, city, readCities(r, recentCookie))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = renderTemplate(w, "favorites.html", templateContext{Request: r, Lang: lang}, favorites)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// favoriteChangeHandler serves POST /favorites/add and /favorites/remove,
+// which star or unstar the city in the form and go back to the page.
+func favoriteChangeHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "Favorites can only be changed from the pages of keli", http.StatusForbidden)
+		return
+	}
+
+	city := r.FormValue("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	favorites := readCities(r, favoritesCookie)
+	switch strings.TrimPrefix(r.URL.Path, "/favorites/") {
+	case "add":
+		// use the proper name of the city, and only remember real ones
+		weather, err := GetWeatherData(r.Context(), city)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		city = weather.City
+		if !slices.Contains(favorites, city) {
+			if len(favorites) >= maxFavorites {
+				favorites = favorites[:maxFavorites-1]
+			}
+			favorites = append(favorites, city)
+		}
+	case "remove":
+		favorites = slices.DeleteFunc(favorites, func(c string) bool { return c == city })
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	writeCities(w, favoritesCookie, favorites)
+
+	back := "/" + url.PathEscape(city)
+	if referer, err := url.Parse(r.Referer()); err == nil && referer.Host == r.Host {
+		back = referer.RequestURI()
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// DailyFeed returns an Atom feed with one entry per forecast day. Entry IDs
+// stay the same for the day, so feed readers update the entry when the
+// forecast changes instead of adding a new one. Today's entry has the
+// current weather too, and each warning of the weather, like a flood
+// warning, has an entry of its own before the days.
+func DailyFeed(weather WeatherData, lang Language, base string) atomFeed {
+	units := weather.Labels()
+	temperature := func(t float64) string {
+		return lang.Temperature(t, units.Temperature)
+	}
+
+	page := base + "/" + url.PathEscape(weather.City)
+	updated := weather.LastUpdated.UTC().Format(time.RFC3339)
+	today := weather.LastUpdated.In(location)
+
+	entryID := func(date string) string {
+		return "tag:keli," + date + ":" + url.PathEscape(weather.City)
+	}
+	todayEntry := atomEntry{
+		Title: lang.T("feedToday", lang.FormatDate(today), lang.Summary(weather)),
+		ID:    entryID(today.Format(time.DateOnly)),
+		Link:  atomLink{Href: page},
+		Content: atomContent{
+			Type: "text",
+			Body: Text(weather, lang),
+		},
+		Updated: updated,
+	}
+
+	var entries []atomEntry
+	for _, warning := range weatherWarnings(weather) {
+		body := warning.Message
+		if warning.Area != "" {
+			body += "\n" + warning.Area
+		}
+		entries = append(entries, atomEntry{
+			Title:   lang.T("weatherWarning", weather.City, warning.Message),
+			ID:      "tag:keli:" + url.PathEscape(weather.City) + ":warning:" + url.PathEscape(warningKey(warning)),
+			Link:    atomLink{Href: page},
+			Content: atomContent{Type: "text", Body: body},
+			Updated: updated,
+		})
+	}
+
+	var days []atomEntry
+	for _, d := range weather.DailyForecast {
+		if d.Date == today.Format(time.DateOnly) {
+			days = append(days, todayEntry)
+			continue
+		}
+		day, err := time.ParseInLocation(time.DateOnly, d.Date, location)
+		if err != nil || day.Before(today) {
+			continue
+		}
+		days = append(days, atomEntry{
+			Title: lang.T("feedDay", lang.FormatDate(day), lang.SymbolDescription(d.SymbolCode)),
+			ID:    entryID(d.Date),
+			Link:  atomLink{Href: page},
+			Content: atomContent{
+				Type: "text",
+				Body: lang.T("feedRange", temperature(d.TemperatureMin), temperature(d.TemperatureMax)) + "\n" +
+					lang.T("rainfall", lang.Precipitation(d.Rainfall, weather.Units), units.Precipitation),
+			},
+			Updated: updated,
+		})
+	}
+	// the current weather even when the daily forecast starts tomorrow or
+	// is missing
+	if len(days) == 0 || days[0].ID != todayEntry.ID {
+		days = append([]atomEntry{todayEntry}, days...)
+	}
+	entries = append(entries, days...)
+
+	return atomFeed{
+		Title:   lang.T("feedTitle", weather.City),
+		ID:      "tag:keli:" + url.PathEscape(weather.City),
+		Updated: updated,
+		Author:  atomAuthor{Name: "keli"},
+		Links:   []atomLink{{Rel: "alternate", Href: page}},
+		Entries: entries,
+	}
+}
+
+// feedHandler serves /feed?city=X as an Atom feed
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	units, err := keli.ParseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+	weather = ConvertUnits(weather, units, "")
+
+	feed := DailyFeed(weather, lang, baseURL(r))
+	feed.Links = append(feed.Links, atomLink{Rel: "self", Href: baseURL(r) + r.URL.RequestURI()})
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		logf(r.Context(), "Error writing feed for %s: %v", city, err)
+	}
+}
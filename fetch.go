@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	// fetches of the sources at once across all cities by default
+	defaultMaxFetches = 8
+	// how keli introduces itself to the sites by default
+	defaultUserAgent = "keli (+https://github.com/itsnibsi/keli)"
+)
+
+// FetchConfig configures how the sources are fetched, see fetch.go.
+type FetchConfig struct {
+	// Fetches of the sources at once across all cities, 8 by default
+	MaxConcurrent int `json:"maxConcurrent"`
+	// Limits of the hosts of the sources by host name, see politeness.go
+	Hosts map[string]HostLimit `json:"hosts"`
+	// Longest a fetch queues for the limits of its host, 10000 ms by default
+	MaxWaitMs int `json:"maxWaitMs"`
+	// User-Agent header of the fetches, and more headers sent to every
+	// source. Headers of a single source go in the sources section.
+	UserAgent string            `json:"userAgent"`
+	Headers   map[string]string `json:"headers"`
+	// Proxy the sources are fetched through, e.g. "http://proxy:3128",
+	// instead of the one in HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	Proxy string `json:"proxy"`
+}
+
+func (f *FetchConfig) check() error {
+	if f.MaxConcurrent < 0 {
+		return fmt.Errorf("Invalid maxConcurrent %d, expected a positive number", f.MaxConcurrent)
+	}
+	if f.MaxConcurrent == 0 {
+		f.MaxConcurrent = defaultMaxFetches
+	}
+	if f.MaxWaitMs < 0 {
+		return fmt.Errorf("Invalid maxWaitMs %d, expected a positive number", f.MaxWaitMs)
+	}
+	if f.MaxWaitMs == 0 {
+		f.MaxWaitMs = defaultMaxWaitMs
+	}
+	if f.Proxy != "" {
+		u, err := url.Parse(f.Proxy)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			return fmt.Errorf("Invalid proxy \"%s\", expected an http, https or socks5 URL", f.Proxy)
+		}
+	}
+	for host, limit := range f.Hosts {
+		if err := limit.check(host); err != nil {
+			return err
+		}
+		f.Hosts[host] = limit
+	}
+	return nil
+}
+
+var (
+	// KELI_RECORD=dir saves the pages of the sources to dir as they are
+	// fetched, and KELI_REPLAY=dir serves them from there instead of
+	// fetching, for developing offline and debugging the parsers
+	recordDir = os.Getenv("KELI_RECORD")
+	replayDir = os.Getenv("KELI_REPLAY")
+
+	// a slot is taken for each fetch, so that a burst of requests for
+	// different cities doesn't open hundreds of connections to the sites
+	fetchSlots     chan struct{}
+	fetchSlotsOnce sync.Once
+
+	// client the sources are fetched with, see sourceClient
+	fetchClient     *http.Client
+	fetchClientOnce sync.Once
+)
+
+// sourceClient returns the client the sources are fetched with, going
+// through the configured proxy or else the one in the environment.
+func sourceClient() *http.Client {
+	fetchClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyFromEnvironment
+		if fetch := config().Fetch; fetch != nil && fetch.Proxy != "" {
+			// checked when loading the config
+			proxy, _ := url.Parse(fetch.Proxy)
+			transport.Proxy = http.ProxyURL(proxy)
+		}
+		fetchClient = &http.Client{Transport: transport}
+	})
+	return fetchClient
+}
+
+// acquireFetch waits until fewer than the maximum fetches are running and
+// returns the function to call when the fetch is done.
+func acquireFetch() (release func()) {
+	fetchSlotsOnce.Do(func() {
+		slots := defaultMaxFetches
+		if fetch := config().Fetch; fetch != nil {
+			slots = fetch.MaxConcurrent
+		}
+		fetchSlots = make(chan struct{}, slots)
+	})
+	fetchSlots <- struct{}{}
+	var once sync.Once
+	return func() { once.Do(func() { <-fetchSlots }) }
+}
+
+// fetchPage is the Fetch hook of the aggregator: it skips the sources that
+// are turned off or whose breaker is open, keeps within the limits of the
+// site and answers with the page last parsed when the site says it hasn't
+// changed.
+func fetchPage(ctx context.Context, source keli.Source, city string) (io.ReadCloser, error) {
+	allowed, err := allowSource(source)
+	if err != nil {
+		return nil, &keli.SourceError{Stage: "breaker", Err: err}
+	}
+	if !allowed {
+		return nil, keli.ErrSkipped
+	}
+
+	// stay within the limits of the site, see politeness.go
+	if err := waitForHost(source); err != nil {
+		logf(ctx, "Not fetching %s for %s: %v", source.Name, city, err)
+		return nil, &keli.SourceError{Stage: "ratelimit", Err: err}
+	}
+
+	// wait for a free fetch slot, held until the page is read
+	release := acquireFetch()
+	defer release()
+
+	url := source.URL + city
+	fetched := time.Now()
+	page, err := fetchSource(ctx, source, city)
+	if errors.Is(err, errNotModified) {
+		if body, found := lastParsed(url); found {
+			recordFetch(ctx, source, time.Since(fetched), nil)
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	if err != nil {
+		recordFetch(ctx, source, time.Since(fetched), err)
+		return nil, err
+	}
+	defer page.Close()
+
+	// read the whole page before timing the fetch
+	body, err := io.ReadAll(page)
+	recordFetch(ctx, source, time.Since(fetched), err)
+	if err != nil {
+		return nil, err
+	}
+	rememberPage(url, body)
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+// sourceFetched is the Fetched hook of the aggregator: it tracks how the
+// source parses, see breakage.go, and marks the page parsed to be fetched
+// conditionally next time.
+func sourceFetched(ctx context.Context, source keli.Source, city string, result keli.SourceData) {
+	var sourceErr *keli.SourceError
+	if errors.As(result.Err, &sourceErr) && sourceErr.Stage != "parse" {
+		return
+	}
+	recordParse(source, result.Data, result.Err)
+	if result.Err != nil {
+		return
+	}
+	rememberParsed(source.URL + city)
+	logf(ctx, "Found weather data for %s from %s", city, source.Name)
+	logf(ctx, "Data: %+v", result.Data)
+}
+
+// fetchSource fetches the page of the source for the city, passing on the
+// ID and trace of the request the context belongs to. The fetch isn't
+// cancelled with the request: the page fills the cache and the health of
+// the source either way.
+func fetchSource(ctx context.Context, source keli.Source, city string) (io.ReadCloser, error) {
+	if replayDir != "" {
+		return os.Open(recordingPath(replayDir, source, city))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, source.URL+city, nil)
+	if err != nil {
+		return nil, err
+	}
+	setFetchHeaders(req, source)
+	setConditionalHeaders(req)
+	setTraceHeaders(req, ctx)
+	res, err := sourceClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		return nil, errNotModified
+	}
+	rememberValidators(source.URL+city, res)
+	if recordDir == "" {
+		return res.Body, nil
+	}
+
+	defer res.Body.Close()
+	page, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	path := recordingPath(recordDir, source, city)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, page, 0o644); err != nil {
+		return nil, fmt.Errorf("Error recording %s: %v", source.URL+city, err)
+	}
+	logf(ctx, "Recorded %s to %s", source.URL+city, path)
+	return io.NopCloser(bytes.NewReader(page)), nil
+}
+
+// setFetchHeaders sets the User-Agent and the configured headers of the
+// fetch of the source, those of the source last.
+func setFetchHeaders(req *http.Request, source keli.Source) {
+	c := config()
+	req.Header.Set("User-Agent", defaultUserAgent)
+	if c.Fetch != nil {
+		if c.Fetch.UserAgent != "" {
+			req.Header.Set("User-Agent", c.Fetch.UserAgent)
+		}
+		for name, value := range c.Fetch.Headers {
+			req.Header.Set(name, value)
+		}
+	}
+	for name, value := range c.Sources[source.Name].Headers {
+		req.Header.Set(name, value)
+	}
+}
+
+// recordingPath returns the file the page of the source for the city is
+// recorded to in dir, e.g. dir/ampparit/Oulu.html.
+func recordingPath(dir string, source keli.Source, city string) string {
+	return filepath.Join(dir, source.Name, url.PathEscape(city)+".html")
+}
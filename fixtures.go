@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// fixture is the golden output of a parser for a recorded page.
+type fixture struct {
+	// The time the page is parsed at, that of the recording
+	Parsed time.Time    `json:"parsed"`
+	Data   keli.Weather `json:"data"`
+}
+
+// runFixtures runs `keli fixtures [-update] [dir]`, which parses each page
+// recorded in dir as <source>/<city>.html, see KELI_RECORD, and compares
+// the weather data with the golden <source>/<city>.json next to it. -update
+// writes the golden files instead. Returns the exit code.
+func runFixtures(args []string) int {
+	flags := flag.NewFlagSet("fixtures", flag.ContinueOnError)
+	update := flags.Bool("update", false, "write the golden files from what the parsers give now")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: keli fixtures [-update] [dir]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		return 2
+	}
+	dir := filepath.Join("testdata", "fixtures")
+	if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+	log.SetOutput(io.Discard)
+
+	pages, err := filepath.Glob(filepath.Join(dir, "*", "*.html"))
+	if err != nil || len(pages) == 0 {
+		fmt.Fprintf(os.Stderr, "keli: No fixtures in %s, record some with KELI_RECORD=%s\n", dir, dir)
+		return 1
+	}
+
+	failed := 0
+	for _, page := range pages {
+		name := strings.TrimSuffix(page, ".html")
+		if err := checkFixture(page, name+".json", *update); err != nil {
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d fixtures failed\n", failed, len(pages))
+		return 1
+	}
+	return 0
+}
+
+// checkFixture parses the page with the source of its directory and
+// compares the result with the golden file, or writes it with update.
+func checkFixture(page, golden string, update bool) error {
+	sourceName := filepath.Base(filepath.Dir(page))
+	i := keli.SourceIndex(sourceName)
+	if i < 0 {
+		return fmt.Errorf("Unknown source \"%s\"", sourceName)
+	}
+	source := keli.Sources[i]
+
+	var want fixture
+	data, err := os.ReadFile(golden)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &want); err != nil {
+			return fmt.Errorf("Error in %s: %v", golden, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && update:
+		info, err := os.Stat(page)
+		if err != nil {
+			return err
+		}
+		want.Parsed = info.ModTime()
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("No golden file %s, write it with -update", golden)
+	default:
+		return err
+	}
+
+	f, err := os.Open(page)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	got, err := keli.ParsePage(context.Background(), source, f, keli.ParseOptions{Now: want.Parsed})
+	if err != nil {
+		return err
+	}
+
+	if update {
+		data, err := json.MarshalIndent(fixture{Parsed: want.Parsed, Data: got}, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(golden, append(data, '\n'), 0o644)
+	}
+
+	wantJSON, _ := json.MarshalIndent(want.Data, "", "  ")
+	gotJSON, _ := json.MarshalIndent(got, "", "  ")
+	if diff := lineDiff(string(wantJSON), string(gotJSON)); diff != "" {
+		return fmt.Errorf("Weather data differs from %s:\n%s", golden, diff)
+	}
+	return nil
+}
+
+// lineDiff returns the lines that differ between want and got, or "" if
+// there are none. It compares line by line, which is enough for JSON of
+// the same struct.
+func lineDiff(want, got string) string {
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var b strings.Builder
+	for i := 0; i < max(len(wantLines), len(gotLines)); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			fmt.Fprintf(&b, "  - %s\n  + %s\n", strings.TrimSpace(w), strings.TrimSpace(g))
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// warnings of FMI as CAP, which has the flood warnings of the Finnish
+	// Flood Centre among them
+	defaultFloodURL = "https://alerts.fmi.fi/cap/feed/atom_fi-FI.xml"
+	// how long the warnings are kept before fetching them again
+	floodCacheDuration = 15 * time.Minute
+)
+
+var floodClient = &http.Client{Timeout: 10 * time.Second}
+
+// FloodConfig adds the flood warnings of the city to the warnings of the
+// weather, see floods.go.
+type FloodConfig struct {
+	// CAP alerts, a single alert or an Atom feed with the alerts in it, FMI's
+	// by default
+	URL string `json:"url"`
+}
+
+// FloodAlert is a flood warning of a CAP alert.
+type FloodAlert struct {
+	Event string
+	// "Minor", "Moderate", "Severe" or "Extreme"
+	Severity string
+	Headline string
+	// Municipalities, rivers or stretches of coast the warning is for
+	Areas   []string
+	Expires time.Time
+}
+
+var (
+	floodAlerts     []FloodAlert
+	floodAlertsTime time.Time
+	// when fetching the warnings last failed, so that the feed isn't asked
+	// again on every request while it's down
+	floodAlertsFailed time.Time
+	floodAlertsMutex  sync.Mutex
+)
+
+func (f *FloodConfig) check() error {
+	if f.URL == "" {
+		f.URL = defaultFloodURL
+	}
+	if u, err := url.Parse(f.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid url \"%s\", expected an http or https URL", f.URL)
+	}
+	return nil
+}
+
+// isFlood tells whether the event of a CAP alert is a flood, in English or
+// in Finnish.
+func isFlood(event string) bool {
+	event = strings.ToLower(event)
+	return strings.Contains(event, "flood") || strings.Contains(event, "tulva")
+}
+
+// parseFloodAlerts parses the flood warnings of the CAP alerts. Each info
+// of an alert is one, in Finnish if the alert has the language. The elements
+// are looked up by their local names, so the namespaces don't matter.
+func parseFloodAlerts(r io.Reader) ([]FloodAlert, error) {
+	var alerts []FloodAlert
+	decoder := xml.NewDecoder(r)
+
+	var path []string
+	var info *FloodAlert
+	var language string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			if t.Name.Local == "info" {
+				info, language = &FloodAlert{}, ""
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+			if t.Name.Local == "info" && info != nil {
+				if isFlood(info.Event) && (language == "" || strings.HasPrefix(language, "fi")) {
+					alerts = append(alerts, *info)
+				}
+				info = nil
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if info == nil || text == "" {
+				continue
+			}
+			switch path[len(path)-1] {
+			case "language":
+				language = strings.ToLower(text)
+			case "event":
+				info.Event = text
+			case "severity":
+				info.Severity = text
+			case "headline":
+				info.Headline = text
+			case "areaDesc":
+				info.Areas = append(info.Areas, text)
+			case "expires":
+				info.Expires, _ = time.Parse(time.RFC3339, text)
+			}
+		}
+	}
+	return alerts, nil
+}
+
+// fetchFloodAlerts returns the flood warnings, fetching them again when the
+// cached ones are older than floodCacheDuration. After a failed fetch there
+// are none until the cache TTL has passed.
+func fetchFloodAlerts(f FloodConfig) ([]FloodAlert, error) {
+	floodAlertsMutex.Lock()
+	alerts, fetched, failed := floodAlerts, floodAlertsTime, floodAlertsFailed
+	floodAlertsMutex.Unlock()
+	if time.Since(fetched) < floodCacheDuration {
+		return alerts, nil
+	}
+	if time.Since(failed) < cacheTTL() {
+		return nil, nil
+	}
+
+	// fetched without holding the lock, so that the weather of other cities
+	// doesn't wait for a slow feed
+	alerts, err := downloadFloodAlerts(f.URL)
+	floodAlertsMutex.Lock()
+	defer floodAlertsMutex.Unlock()
+	if err != nil {
+		floodAlertsFailed = time.Now()
+		return nil, err
+	}
+	floodAlerts, floodAlertsTime = alerts, time.Now()
+	return alerts, nil
+}
+
+// downloadFloodAlerts fetches and parses the flood warnings of the CAP
+// alerts at the URL.
+func downloadFloodAlerts(target string) ([]FloodAlert, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	res, err := floodClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+	return parseFloodAlerts(res.Body)
+}
+
+// currentFloodAlerts returns the flood warnings by the config, logging the
+// error and returning none if they can't be had or there's no floods
+// section, so that the weather is served without them.
+func currentFloodAlerts(ctx context.Context) []FloodAlert {
+	floods := config().Floods
+	if floods == nil || offline() {
+		return nil
+	}
+	alerts, err := fetchFloodAlerts(*floods)
+	if err != nil {
+		logf(ctx, "Error getting flood warnings: %v", err)
+		return nil
+	}
+	return alerts
+}
+
+// namesPlace tells whether a word of the area is the place, also inflected
+// as in "Porin kohdalla".
+func namesPlace(area, place string) bool {
+	place = foldPlace(place)
+	words := strings.FieldsFunc(foldPlace(area), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '-' || r == '/' || r == '(' || r == ')'
+	})
+	for _, word := range words {
+		if strings.HasPrefix(word, place) && len(word)-len(place) <= 3 {
+			return true
+		}
+	}
+	return false
+}
+
+// floodWarnings returns the warnings of the flood alerts in force whose
+// areas name the city.
+func floodWarnings(alerts []FloodAlert, city string, now time.Time) []Warning {
+	var warnings []Warning
+	if city == "" {
+		return nil
+	}
+	for _, alert := range alerts {
+		if !alert.Expires.IsZero() && alert.Expires.Before(now) {
+			continue
+		}
+		for _, area := range alert.Areas {
+			if !namesPlace(area, city) {
+				continue
+			}
+			message := alert.Headline
+			if message == "" {
+				message = alert.Event
+			}
+			warnings = append(warnings, Warning{
+				Code:     "flood",
+				Field:    "flood",
+				Message:  message,
+				Severity: strings.ToLower(alert.Severity),
+				Area:     area,
+			})
+			break
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// maxShortLength is the length of a single SMS
+const maxShortLength = 160
+
+// cityAbbreviations holds the commonly used abbreviations of city names,
+// used to keep the short format short.
+var cityAbbreviations = map[string]string{
+	"Helsinki":     "Hki",
+	"Hämeenlinna":  "Hml",
+	"Jyväskylä":    "Jkl",
+	"Lappeenranta": "Lpr",
+	"Tampere":      "Tre",
+	"Turku":        "Tku",
+}
+
+// ShortText returns the weather as a single line of at most 160 characters,
+// e.g. "Hki +2,1°C (tuntuu −3°), tuulta 7 m/s, sadetta 0,2mm".
+func ShortText(weather WeatherData, lang Language) string {
+	units := weather.Labels()
+
+	city := weather.City
+	if abbreviation, found := cityAbbreviations[city]; found {
+		city = abbreviation
+	}
+
+	output := lang.T("short",
+		city,
+		lang.Temperature(weather.Temperature, units.Temperature),
+		lang.Decimal(weather.TemperatureFeelsLike, 0),
+		weather.WindSpeed, units.WindSpeed,
+		lang.Precipitation(weather.Rainfall, weather.Units), units.Precipitation,
+	)
+
+	return truncate(output, maxShortLength)
+}
+
+// truncate cuts s to at most max characters, marking the cut with an ellipsis.
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:max-1]) + "…"
+}
+
+func weatherShortHandler(w http.ResponseWriter, weather WeatherData, lang Language) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	_, err := w.Write([]byte(ShortText(weather, lang) + "\n"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// speechUnits maps unit labels to the message keys of their spoken names
+var speechUnits = map[string]string{
+	"m/s":  "speechMetersPerSecond",
+	"km/h": "speechKilometersPerHour",
+	"mph":  "speechMilesPerHour",
+	"kn":   "speechKnots",
+	"Bft":  "speechBeaufort",
+	"mm":   "speechMillimeters",
+	"in":   "speechInches",
+}
+
+// SpeechText returns the weather as full sentences without symbols or
+// abbreviations, suitable for text-to-speech.
+func SpeechText(weather WeatherData, lang Language) string {
+	units := weather.Labels()
+
+	temperature := func(t float64) string {
+		switch {
+		case weather.Units == keli.UnitsImperial:
+			return lang.T("speechFahrenheit", lang.Number(t))
+		case t > 0:
+			return lang.T("speechWarm", lang.Number(t))
+		case t < 0:
+			return lang.T("speechFrost", lang.Number(math.Abs(t)))
+		}
+		return lang.T("speechZero")
+	}
+
+	sentences := []string{
+		lang.T("speechIntro", weather.City, weather.ObservationHour),
+	}
+	if summary := lang.Summary(weather); summary != "" {
+		sentences = append(sentences, strings.TrimSuffix(summary, ".")+".")
+	}
+	sentences = append(sentences,
+		lang.T("speechTemperature", temperature(weather.Temperature), temperature(weather.TemperatureFeelsLike)),
+		lang.T("speechMinMax", temperature(weather.TemperatureMin), temperature(weather.TemperatureMax)),
+		lang.T("speechWind", weather.WindSpeed, lang.T(speechUnits[units.WindSpeed]), lang.WindDescription(weather.Beaufort)),
+	)
+	if weather.Rainfall > 0 {
+		sentences = append(sentences, lang.T("speechRain", lang.Precipitation(weather.Rainfall, weather.Units), lang.T(speechUnits[units.Precipitation])))
+	} else {
+		sentences = append(sentences, lang.T("speechNoRain"))
+	}
+	sentences = append(sentences,
+		lang.T("speechTomorrow", temperature(weather.TemperatureTomorrow), temperature(weather.TemperatureMinTomorrow)),
+	)
+	if weather.Sunrise != "" && weather.Sunset != "" {
+		sentences = append(sentences, lang.T("speechSun", speechTime(weather.Sunrise, lang), speechTime(weather.Sunset, lang)))
+	}
+
+	return strings.Join(sentences, " ")
+}
+
+// speechTime reformats a "H:MM" time the way the language reads it.
+func speechTime(hhmm string, lang Language) string {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return hhmm
+	}
+	return lang.FormatTime(t)
+}
+
+func weatherSpeechHandler(w http.ResponseWriter, weather WeatherData, lang Language) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	_, err := w.Write([]byte(SpeechText(weather, lang) + "\n"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// BarOutput is the JSON shape status bars like Waybar and Polybar read.
+type BarOutput struct {
+	// Text shown in the bar
+	Text string `json:"text"`
+	// Text shown on hover
+	Tooltip string `json:"tooltip"`
+	// CSS class, the name of the weather icon, e.g. "partly-cloudy"
+	Class string `json:"class"`
+	// Alternative value for icon lookups, the weather symbol code
+	Alt string `json:"alt"`
+}
+
+// Bar returns the weather in the status bar JSON shape.
+func Bar(weather WeatherData, lang Language) BarOutput {
+	units := weather.Labels()
+	return BarOutput{
+		Text:    strings.TrimSpace(weather.WeatherSymbol + " " + lang.Temperature(weather.Temperature, units.Temperature)),
+		Tooltip: strings.TrimSpace(Text(weather, lang)),
+		Class:   WeatherIconName(weather.SymbolCode),
+		Alt:     weather.SymbolCode,
+	}
+}
+
+func weatherBarHandler(w http.ResponseWriter, weather WeatherData, lang Language) {
+	jsonData, err := json.Marshal(Bar(weather, lang))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// minimalRainHours are the hours of the forecast summed in rain_next_3h
+const minimalRainHours = 3
+
+// MinimalOutput is a flat JSON object of the few fields scripts and Apple
+// Shortcuts usually want.
+type MinimalOutput struct {
+	City       string  `json:"city"`
+	Temp       float64 `json:"temp"`
+	FeelsLike  float64 `json:"feels_like"`
+	SymbolText string  `json:"symbol_text"`
+	Wind       int     `json:"wind"`
+	RainNext3h float64 `json:"rain_next_3h"`
+}
+
+// Minimal returns the weather in the minimal JSON shape. The symbol text is
+// the description of the current weather symbol in the language.
+func Minimal(weather WeatherData, lang Language) MinimalOutput {
+	return MinimalOutput{
+		City:       weather.City,
+		Temp:       weather.Temperature,
+		FeelsLike:  weather.TemperatureFeelsLike,
+		SymbolText: lang.SymbolDescription(weather.SymbolCode),
+		Wind:       weather.WindSpeed,
+		RainNext3h: rainNextHours(weather, minimalRainHours),
+	}
+}
+
+// rainNextHours sums the rain of the next hours of the forecast.
+func rainNextHours(weather WeatherData, hours int) float64 {
+	rain := 0.0
+	for i, h := range weather.HourlyForecast {
+		if i >= hours {
+			break
+		}
+		rain += h.Rainfall
+	}
+	// keep the sum from showing floating point noise like 0.30000000000000004
+	return math.Round(rain*100) / 100
+}
+
+func weatherMinimalHandler(w http.ResponseWriter, weather WeatherData, lang Language) {
+	jsonData, err := json.Marshal(Minimal(weather, lang))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Field is a labeled part of the weather, like the fields of the messages
+// of chat apps.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Fields returns the temperature, wind and rain of the weather as fields
+// of two lines each.
+func Fields(weather WeatherData, lang Language) []Field {
+	units := weather.Labels()
+	temperature := func(t float64) string {
+		return lang.Temperature(t, units.Temperature)
+	}
+
+	return []Field{
+		{
+			Name:  lang.T("labelTemperature"),
+			Value: temperature(weather.Temperature) + "\n" + lang.T("feelsLike", temperature(weather.TemperatureFeelsLike)),
+		},
+		{
+			Name:  lang.T("labelWind"),
+			Value: fmt.Sprintf("%d %s\n%s", weather.WindSpeed, units.WindSpeed, lang.WindDescription(weather.Beaufort)),
+		},
+		{
+			Name:  lang.T("labelRain"),
+			Value: fmt.Sprintf("%s %s\n%d%%", lang.Precipitation(weather.Rainfall, weather.Units), units.Precipitation, weather.RainChance),
+		},
+	}
+}
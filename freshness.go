@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// how long the fields of a source that failed are kept from the previous
+// fetch
+const keepStale = 3 * time.Hour
+
+// groupTimes returns the time each field group was last updated, the
+// latest fetch of the sources its fields came from.
+func groupTimes(provenance map[string]keli.FieldSource) map[string]time.Time {
+	times := make(map[string]time.Time)
+	for group, fields := range keli.FieldGroups {
+		for _, field := range fields {
+			if from, found := provenance[field]; found && from.Fetched.After(times[group]) {
+				times[group] = from.Fetched
+			}
+		}
+	}
+	return times
+}
+
+// keepStaleGroups fills the field groups no source updated this time from
+// the previous weather data, unless that is older than keepStale too.
+// Returns the groups kept.
+func keepStaleGroups(ctx context.Context, weather *WeatherData, previous WeatherData) (kept []string) {
+	for group, fields := range keli.FieldGroups {
+		updated, found := previous.Updated[group]
+		if !found || time.Since(updated) > keepStale {
+			continue
+		}
+		missing := true
+		for _, field := range fields {
+			if _, found := weather.Provenance[field]; found {
+				missing = false
+				break
+			}
+		}
+		if !missing {
+			continue
+		}
+
+		logf(ctx, "Keeping %s of %s from %s", group, weather.City, updated)
+		kept = append(kept, group)
+		copyGroup(group, weather, previous)
+		for _, field := range fields {
+			if from, found := previous.Provenance[field]; found {
+				weather.Provenance[field] = from
+			}
+		}
+	}
+	slices.Sort(kept)
+	return kept
+}
+
+func copyGroup(group string, dst *WeatherData, src WeatherData) {
+	switch group {
+	case "current":
+		dst.Temperature = src.Temperature
+		dst.TemperatureFeelsLike = src.TemperatureFeelsLike
+		dst.ObservationHour = src.ObservationHour
+		dst.SymbolCode = src.SymbolCode
+		dst.WeatherSymbol = src.WeatherSymbol
+		dst.Rainfall = src.Rainfall
+		dst.RainChance = src.RainChance
+		dst.Snowfall = src.Snowfall
+		dst.WindSpeed = src.WindSpeed
+	case "today":
+		dst.TemperatureMin = src.TemperatureMin
+		dst.TemperatureMax = src.TemperatureMax
+		dst.WeatherSummary = src.WeatherSummary
+	case "tomorrow":
+		dst.TemperatureTomorrow = src.TemperatureTomorrow
+		dst.TemperatureMinTomorrow = src.TemperatureMinTomorrow
+	case "sun":
+		dst.Sunrise = src.Sunrise
+		dst.Sunset = src.Sunset
+		dst.DayLength = src.DayLength
+	case "hourly":
+		dst.HourlyForecast = src.HourlyForecast
+	case "daily":
+		dst.DailyForecast = src.DailyForecast
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// lowest temperature of the night not alerted of by default: the ground
+	// freezes before the air two meters up, where temperatures are measured
+	defaultFrostThreshold = 2.0
+	// the growing season by default
+	defaultFrostFrom = "05-01"
+	defaultFrostTo   = "09-30"
+	// the hours of a night looked at, from the evening until the morning
+	nightStartHour = 18
+	nightEndHour   = 9
+)
+
+var (
+	// the night each frost alert last notified of, by its name and city
+	frostAlerted      = make(map[string]time.Time)
+	frostAlertedMutex sync.Mutex
+)
+
+// FrostAlert notifies when the night is forecast to get colder than the
+// threshold in its city during the growing season, so that the plants can
+// be covered in time.
+type FrostAlert struct {
+	// Name of the alert in the logs and the notifications
+	Name string `json:"name"`
+	City string `json:"city"`
+	// Lowest temperature of the night not alerted of (°C), 2 by default
+	Threshold *float64 `json:"threshold"`
+	// First and last day of the season as MM-DD, 05-01 and 09-30 by default
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Where the alert goes, see notify.go
+	Notify
+	// Language of the notification text, Finnish by default
+	Lang string `json:"lang"`
+
+	lang Language
+}
+
+// FrostNotification is the JSON body posted to the webhook of a frost
+// alert.
+type FrostNotification struct {
+	Alert string `json:"alert"`
+	City  string `json:"city"`
+	// The lowest temperature forecast for the night and its hour
+	Lowest    float64   `json:"lowest"`
+	At        time.Time `json:"at"`
+	Threshold float64   `json:"threshold"`
+	// Text describing the notification, e.g. "Frost risk in Espoo tonight:
+	// down to -0.5°C at 04:00"
+	Text string `json:"text"`
+}
+
+func (a *FrostAlert) check(email *EmailConfig) error {
+	if a.City == "" {
+		return fmt.Errorf("Missing 'city'")
+	}
+	if a.Threshold == nil {
+		threshold := defaultFrostThreshold
+		a.Threshold = &threshold
+	}
+	if a.From == "" {
+		a.From = defaultFrostFrom
+	}
+	if a.To == "" {
+		a.To = defaultFrostTo
+	}
+	for _, day := range []string{a.From, a.To} {
+		if _, err := time.Parse("01-02", day); err != nil {
+			return fmt.Errorf("Invalid day \"%s\", expected MM-DD", day)
+		}
+	}
+	if err := a.Notify.check(email); err != nil {
+		return err
+	}
+	lang, err := ParseLanguage(a.Lang)
+	if err != nil {
+		return err
+	}
+	a.lang = lang
+	if a.Name == "" {
+		a.Name = "frost " + a.City
+	}
+	return nil
+}
+
+// inSeason tells whether the day is within the season of the alert, which
+// may go over the new year.
+func (a FrostAlert) inSeason(day time.Time) bool {
+	today := day.Format("01-02")
+	if a.From <= a.To {
+		return a.From <= today && today <= a.To
+	}
+	return today >= a.From || today <= a.To
+}
+
+// nightOf returns the night coming at the time now, or going on, from the
+// evening until the morning.
+func nightOf(now time.Time) (from, to time.Time) {
+	now = now.In(location)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	if now.Hour() < nightEndHour {
+		day = day.AddDate(0, 0, -1)
+	}
+	return atHour(day, nightStartHour), atHour(day.AddDate(0, 0, 1), nightEndHour)
+}
+
+// coldestHour returns the lowest temperature forecast between from and to
+// and its hour, or false if the forecast has none of the hours.
+func coldestHour(weather WeatherData, from, to time.Time) (lowest float64, at time.Time, found bool) {
+	lowest = math.Inf(1)
+	for i, t := range ForecastTimes(weather) {
+		if t.Before(from) || !t.Before(to) {
+			continue
+		}
+		if h := weather.HourlyForecast[i]; h.Temperature < lowest {
+			lowest, at, found = h.Temperature, t, true
+		}
+	}
+	return lowest, at, found
+}
+
+// StartFrostAlerts starts watching the weather of the cities of the frost
+// alerts.
+func StartFrostAlerts(alerts []FrostAlert) {
+	for _, alert := range alerts {
+		watchCity("frost", alert.City, notifyFrost)
+	}
+}
+
+// notifyFrost checks the forecast of the night for the frost alerts of the
+// city in the config in use whenever its weather is refreshed, notifying
+// once a night at most.
+func notifyFrost(city string, weather WeatherData) {
+	frostAlertedMutex.Lock()
+	defer frostAlertedMutex.Unlock()
+
+	from, to := nightOf(time.Now())
+	for _, alert := range config().Frost {
+		key := alert.Name + "|" + city
+		if cityKey(alert.City) != city || from.Equal(frostAlerted[key]) || !alert.inSeason(from) {
+			continue
+		}
+		lowest, at, found := coldestHour(weather, from, to)
+		if !found || lowest >= *alert.Threshold {
+			continue
+		}
+		frostAlerted[key] = from
+		text := alert.lang.T("frostAlert", weather.City, alert.lang.Temperature(lowest, "°C"), at.Format("15:04"))
+		go alert.send(alert.Name, text, FrostNotification{
+			Alert:     alert.Name,
+			City:      weather.City,
+			Lowest:    lowest,
+			At:        at,
+			Threshold: *alert.Threshold,
+			Text:      text,
+		})
+	}
+}
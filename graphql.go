@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// graphqlObject builds a GraphQL object type from the JSON fields of a
+// struct, so the schema follows WeatherData without listing every field
+// twice.
+func graphqlObject(name string, t reflect.Type, objects map[reflect.Type]*graphql.Object) *graphql.Object {
+	if object, found := objects[t]; found {
+		return object
+	}
+
+	fields := graphql.Fields{}
+	graphqlFields(fields, t, nil, objects)
+
+	object := graphql.NewObject(graphql.ObjectConfig{Name: name, Fields: fields})
+	objects[t] = object
+	return object
+}
+
+// graphqlFields adds the JSON fields of the struct to fields, those of the
+// structs it embeds too, like keli.Weather of WeatherData. The default
+// resolver doesn't look into embedded structs, so each field is resolved by
+// its index.
+func graphqlFields(fields graphql.Fields, t reflect.Type, index []int, objects map[reflect.Type]*graphql.Object) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldIndex := append(slices.Clone(index), i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			graphqlFields(fields, field.Type, fieldIndex, objects)
+			continue
+		}
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if jsonName == "" || jsonName == "-" {
+			continue
+		}
+		// GraphQL has no maps, they are only in the JSON API
+		if field.Type.Kind() == reflect.Map {
+			continue
+		}
+		fields[jsonName] = &graphql.Field{
+			Type: graphqlType(field.Type, objects),
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				return reflect.Indirect(reflect.ValueOf(p.Source)).FieldByIndex(fieldIndex).Interface(), nil
+			},
+		}
+	}
+}
+
+func graphqlType(t reflect.Type, objects map[reflect.Type]*graphql.Object) graphql.Output {
+	if t == reflect.TypeOf(time.Time{}) {
+		return graphql.DateTime
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return graphql.String
+	case reflect.Int:
+		return graphql.Int
+	case reflect.Float64:
+		return graphql.Float
+	case reflect.Bool:
+		return graphql.Boolean
+	case reflect.Slice:
+		return graphql.NewList(graphqlType(t.Elem(), objects))
+	case reflect.Struct:
+		return graphqlObject(t.Name(), t, objects)
+	case reflect.Pointer:
+		return graphqlType(t.Elem(), objects)
+	}
+	panic(fmt.Sprintf("graphql: no type for %s", t))
+}
+
+// graphqlWeather fetches the weather for the city, units and windUnit
+// arguments of a query.
+func graphqlWeather(p graphql.ResolveParams) (WeatherData, error) {
+	units, err := keli.ParseUnitSystem(stringArg(p, "units"))
+	if err != nil {
+		return WeatherData{}, err
+	}
+	windUnit, err := keli.ParseWindUnit(stringArg(p, "windUnit"))
+	if err != nil {
+		return WeatherData{}, err
+	}
+
+	weather, err := GetWeatherData(p.Context, stringArg(p, "city"))
+	if err != nil {
+		return WeatherData{}, err
+	}
+	return ConvertUnits(weather, units, windUnit), nil
+}
+
+func stringArg(p graphql.ResolveParams, name string) string {
+	value, _ := p.Args[name].(string)
+	return value
+}
+
+func newGraphQLSchema() (graphql.Schema, error) {
+	objects := make(map[reflect.Type]*graphql.Object)
+	weatherType := graphqlObject("Weather", reflect.TypeOf(WeatherData{}), objects)
+	hourlyType := graphqlObject("HourlyForecast", reflect.TypeOf(keli.HourlyForecast{}), objects)
+	historyType := graphqlObject("History", reflect.TypeOf(HistoryDays{}), objects)
+
+	weatherArgs := graphql.FieldConfigArgument{
+		"city":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+		"units":    &graphql.ArgumentConfig{Type: graphql.String, Description: "metric or imperial"},
+		"windUnit": &graphql.ArgumentConfig{Type: graphql.String, Description: "m/s, km/h, mph, kn or beaufort"},
+	}
+
+	forecastArgs := graphql.FieldConfigArgument{
+		"hours": &graphql.ArgumentConfig{Type: graphql.Int, Description: "Number of hours, all if not given"},
+	}
+	for name, arg := range weatherArgs {
+		forecastArgs[name] = arg
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"weather": &graphql.Field{
+				Type:        weatherType,
+				Description: "Current weather of a city",
+				Args:        weatherArgs,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					weather, err := graphqlWeather(p)
+					if err != nil {
+						return nil, err
+					}
+					return weather, nil
+				},
+			},
+			"forecast": &graphql.Field{
+				Type:        graphql.NewList(hourlyType),
+				Description: "Hourly forecast of a city",
+				Args:        forecastArgs,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					weather, err := graphqlWeather(p)
+					if err != nil {
+						return nil, err
+					}
+					hourly := weather.HourlyForecast
+					if hours, ok := p.Args["hours"].(int); ok && hours >= 0 && hours < len(hourly) {
+						hourly = hourly[:hours]
+					}
+					return hourly, nil
+				},
+			},
+			"history": &graphql.Field{
+				Type:        historyType,
+				Description: "Observed weather of a city by day, metric, the last 30 days if from isn't given",
+				Args: graphql.FieldConfigArgument{
+					"city": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"from": &graphql.ArgumentConfig{Type: graphql.String, Description: "First day, e.g. 2024-03-01"},
+					"to":   &graphql.ArgumentConfig{Type: graphql.String, Description: "Last day, today if not given"},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if config().History == nil {
+						return nil, fmt.Errorf("No history is kept, see history in the configuration")
+					}
+					return cityHistory(stringArg(p, "city"), stringArg(p, "from"), stringArg(p, "to"))
+				},
+			},
+			"places": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "Known places, optionally only those starting with prefix",
+				Args: graphql.FieldConfigArgument{
+					"prefix": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					places, err := GetPlaces()
+					if err != nil {
+						return nil, err
+					}
+					prefix := strings.ToLower(stringArg(p, "prefix"))
+					var matches []string
+					for _, place := range places {
+						if strings.HasPrefix(strings.ToLower(place), prefix) {
+							matches = append(matches, place)
+						}
+					}
+					return matches, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+var graphqlSchema = func() graphql.Schema {
+	schema, err := newGraphQLSchema()
+	if err != nil {
+		log.Fatalf("Error building GraphQL schema: %v", err)
+	}
+	return schema
+}()
+
+// graphqlHandler serves GraphQL queries, both as GET ?query= and as POSTed
+// JSON {"query", "variables", "operationName"}.
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	var request struct {
+		Query         string         `json:"query"`
+		Variables     map[string]any `json:"variables"`
+		OperationName string         `json:"operationName"`
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		request.Query = r.URL.Query().Get("query")
+		request.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if request.Query == "" {
+		http.Error(w, "Missing 'query'", http.StatusBadRequest)
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        r.Context(),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/itsnibsi/keli/pkg/keli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+//go:embed proto/keli.proto
+var protoFiles embed.FS
+
+// weatherService implements keli.v1.WeatherService from proto/keli.proto.
+//
+// There is no generated code: the proto file is compiled at startup and the
+// messages are dynamic. WeatherData is converted through its JSON form,
+// which uses the same field names as the proto messages.
+type weatherService struct {
+	file protoreflect.FileDescriptor
+}
+
+// loadWeatherService compiles proto/keli.proto and registers it globally so
+// the reflection service can describe it.
+func loadWeatherService() (*weatherService, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: func(path string) (io.ReadCloser, error) {
+				return protoFiles.Open("proto/" + path)
+			},
+		}),
+	}
+
+	files, err := compiler.Compile(context.Background(), "keli.proto")
+	if err != nil {
+		return nil, err
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(files[0]); err != nil {
+		return nil, err
+	}
+
+	return &weatherService{file: files[0]}, nil
+}
+
+func (s *weatherService) message(name string) *dynamicpb.Message {
+	return dynamicpb.NewMessage(s.file.Messages().ByName(protoreflect.Name(name)))
+}
+
+// toMessage converts v to the named message through its JSON form.
+func (s *weatherService) toMessage(name string, v any) (*dynamicpb.Message, error) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	msg := s.message(name)
+	err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(jsonData, msg)
+	return msg, err
+}
+
+func stringField(msg *dynamicpb.Message, name string) string {
+	return msg.Get(msg.Descriptor().Fields().ByName(protoreflect.Name(name))).String()
+}
+
+// weather fetches the weather for a GetWeatherRequest or GetForecastRequest
+func (s *weatherService) weather(req *dynamicpb.Message) (WeatherData, error) {
+	city := stringField(req, "city")
+	if city == "" {
+		return WeatherData{}, status.Error(codes.InvalidArgument, "Missing 'city'")
+	}
+	units, err := keli.ParseUnitSystem(stringField(req, "units"))
+	if err != nil {
+		return WeatherData{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	windUnit, err := keli.ParseWindUnit(stringField(req, "wind_unit"))
+	if err != nil {
+		return WeatherData{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	weather, err := GetWeatherData(context.Background(), city)
+	if err != nil {
+		return WeatherData{}, status.Error(codes.NotFound, err.Error())
+	}
+	return ConvertUnits(weather, units, windUnit), nil
+}
+
+func (s *weatherService) getWeather(req *dynamicpb.Message) (proto.Message, error) {
+	weather, err := s.weather(req)
+	if err != nil {
+		return nil, err
+	}
+	return s.toMessage("WeatherData", weather)
+}
+
+func (s *weatherService) getForecast(req *dynamicpb.Message) (proto.Message, error) {
+	weather, err := s.weather(req)
+	if err != nil {
+		return nil, err
+	}
+
+	hourly := weather.HourlyForecast
+	hours := int(req.Get(req.Descriptor().Fields().ByName("hours")).Int())
+	if hours > 0 && hours < len(hourly) {
+		hourly = hourly[:hours]
+	}
+
+	return s.toMessage("GetForecastResponse", struct {
+		City           string                `json:"city"`
+		HourlyForecast []keli.HourlyForecast `json:"hourlyForecast"`
+	}{weather.City, hourly})
+}
+
+// watchWeather sends the weather and then again whenever the cached data has
+// been refreshed, until the client goes away.
+func (s *weatherService) watchWeather(req *dynamicpb.Message, stream grpc.ServerStream) error {
+	var lastUpdated time.Time
+	ticker := time.NewTicker(cacheTTL())
+	defer ticker.Stop()
+
+	for {
+		weather, err := s.weather(req)
+		if err != nil {
+			return err
+		}
+
+		if !weather.LastUpdated.Equal(lastUpdated) {
+			lastUpdated = weather.LastUpdated
+			msg, err := s.toMessage("WeatherData", weather)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// unaryHandler adapts a method taking the named request message to gRPC.
+func (s *weatherService) unaryHandler(name, request string, method func(*dynamicpb.Message) (proto.Message, error)) func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := s.message(request)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return method(req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/keli.v1.WeatherService/" + name}
+		return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			return method(req.(*dynamicpb.Message))
+		})
+	}
+}
+
+func (s *weatherService) serviceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: "keli.v1.WeatherService",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "GetWeather", Handler: s.unaryHandler("GetWeather", "GetWeatherRequest", s.getWeather)},
+			{MethodName: "GetForecast", Handler: s.unaryHandler("GetForecast", "GetForecastRequest", s.getForecast)},
+		},
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "WatchWeather",
+				ServerStreams: true,
+				Handler: func(_ any, stream grpc.ServerStream) error {
+					req := s.message("GetWeatherRequest")
+					if err := stream.RecvMsg(req); err != nil {
+						return err
+					}
+					return s.watchWeather(req, stream)
+				},
+			},
+		},
+		Metadata: "keli.proto",
+	}
+}
+
+// ServeGRPC serves the gRPC API on addr.
+func ServeGRPC(addr string) error {
+	service, err := loadWeatherService()
+	if err != nil {
+		return fmt.Errorf("loading proto/keli.proto: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer()
+	server.RegisterService(service.serviceDesc(), service)
+	reflection.Register(server)
+
+	log.Printf("gRPC weather balloon spying on %s", addr)
+	return server.Serve(listener)
+}
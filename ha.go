@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// haHandler serves /ha?city=X, the weather flattened for the Home Assistant
+// REST sensor: every sensor field is a number at the top level, so each
+// maps to one entity with value_template "{{ value_json.temperature }}",
+// and "units" has the unit of each. The keys are the same as in the JSON
+// API and don't change, a missing value is 0.
+func haHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	units, err := keli.ParseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	windUnit, err := keli.ParseWindUnit(r.URL.Query().Get("wind_unit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+	weather = ConvertUnits(weather, units, windUnit)
+
+	jsonData, err := json.Marshal(HAState(weather))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HAState flattens the weather into the sensor values, the city, the time
+// of the update in Unix seconds and the units of the values.
+func HAState(weather WeatherData) map[string]any {
+	labels := weather.Labels()
+	state := map[string]any{
+		"city":        weather.City,
+		"lastUpdated": weather.LastUpdated.Unix(),
+	}
+	units := map[string]string{}
+	for _, sensor := range haSensors {
+		state[sensor.Key] = sensor.Value(weather)
+		if unit := sensor.Unit(labels); unit != "" {
+			units[sensor.Key] = unit
+		}
+	}
+	state["units"] = units
+	return state
+}
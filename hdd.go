@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// days of history /hdd covers without a period
+const defaultHDDDays = 30
+
+// DegreeDays are the heating degree days of a city by day, week or month.
+type DegreeDays struct {
+	City string `json:"city"`
+	// "day", "week" or "month"
+	By      string            `json:"by"`
+	Base    float64           `json:"base"`
+	Periods []DegreeDayPeriod `json:"periods"`
+	Total   float64           `json:"total"`
+}
+
+// DegreeDayPeriod is the heating degree days of a day, week or month.
+type DegreeDayPeriod struct {
+	// e.g. "2024-12-01", "2024-W48" or "2024-12"
+	Period            string  `json:"period"`
+	HeatingDegreeDays float64 `json:"heatingDegreeDays"`
+	// Mean temperature (C) of the days observed
+	TemperatureMean float64 `json:"temperatureMean"`
+	Days            int     `json:"days"`
+}
+
+// degreeDayPeriod returns the name of the day, week or month the date is
+// in.
+func degreeDayPeriod(date, by string) string {
+	switch by {
+	case "week":
+		t, _ := time.Parse(time.DateOnly, date)
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "month":
+		return date[:7]
+	}
+	return date
+}
+
+// degreeDays sums the heating degree days of the days by day, week or
+// month.
+func degreeDays(days []HistoryDay, by string, base float64) DegreeDays {
+	dd := DegreeDays{By: by, Base: base, Periods: []DegreeDayPeriod{}}
+	for _, day := range days {
+		period := degreeDayPeriod(day.Date, by)
+		if len(dd.Periods) == 0 || dd.Periods[len(dd.Periods)-1].Period != period {
+			dd.Periods = append(dd.Periods, DegreeDayPeriod{Period: period})
+		}
+		p := &dd.Periods[len(dd.Periods)-1]
+		p.HeatingDegreeDays += heatingDegreeDays(day, base)
+		p.Days++
+		p.TemperatureMean += (day.TemperatureMean - p.TemperatureMean) / float64(p.Days)
+		dd.Total += heatingDegreeDays(day, base)
+	}
+	for i := range dd.Periods {
+		dd.Periods[i].HeatingDegreeDays = roundTo(dd.Periods[i].HeatingDegreeDays, 1)
+		dd.Periods[i].TemperatureMean = roundTo(dd.Periods[i].TemperatureMean, 1)
+	}
+	dd.Total = roundTo(dd.Total, 1)
+	return dd
+}
+
+// DegreeDaysCSV returns the heating degree days as CSV rows, header first.
+func DegreeDaysCSV(dd DegreeDays) [][]string {
+	rows := [][]string{{"city", "period", "heatingDegreeDays", "temperatureMean", "days", "base"}}
+	for _, p := range dd.Periods {
+		rows = append(rows, []string{
+			dd.City,
+			p.Period,
+			formatCSVFloat(p.HeatingDegreeDays),
+			formatCSVFloat(p.TemperatureMean),
+			strconv.Itoa(p.Days),
+			formatCSVFloat(dd.Base),
+		})
+	}
+	return rows
+}
+
+// hddHandler serves /hdd?city=&base=&by=&period=, the heating degree days
+// of the city from its history by day, week or month, of the period or the
+// last 30 days, as JSON or with format=csv as CSV.
+func hddHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	if config().History == nil {
+		http.Error(w, "No history is kept, see history in the configuration", http.StatusNotFound)
+		return
+	}
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	base, err := parseBase(r.URL.Query().Get("base"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	by := r.URL.Query().Get("by")
+	switch by {
+	case "":
+		by = "day"
+	case "day", "week", "month":
+	default:
+		http.Error(w, fmt.Sprintf("Invalid by \"%s\", expected day, week or month", by), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().In(location)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location).AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -defaultHDDDays)
+	if period := r.URL.Query().Get("period"); period != "" {
+		from, to, err = ParsePeriod(period)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	days, name := historyDays(city, from, to)
+	if name == "" {
+		name = city
+	}
+	dd := degreeDays(days, by, base)
+	dd.City = name
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeCSV(w, keli.SanitizeCityName(name)+"-hdd.csv", DegreeDaysCSV(dd))
+		return
+	}
+
+	jsonData, err := json.Marshal(dd)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
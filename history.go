@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// days of history given when no range is asked for
+const defaultHistoryDays = 30
+
+// HistoryConfig keeps the observed weather of the cities for statistics,
+// see history.go.
+type HistoryConfig struct {
+	// JSON lines file the observations are kept in, history.jsonl by
+	// default
+	File string `json:"file"`
+	// Cities whose weather is kept refreshed so that their history has
+	// every hour. Other cities have the hours they were asked for.
+	Cities []string `json:"cities"`
+}
+
+// Observation is the observed weather of a city at an hour.
+type Observation struct {
+	City        string    `json:"city"`
+	Time        time.Time `json:"time"`
+	Temperature float64   `json:"temperature"`
+	Rainfall    float64   `json:"rainfall"`
+	// Air pressure at sea level (hPa) at the nearest station, if it had it
+	Pressure *float64 `json:"pressure,omitempty"`
+}
+
+// HistoryDay is the weather of a day from the observations of its hours.
+type HistoryDay struct {
+	// Date of the day, e.g. "2024-04-19"
+	Date string `json:"date"`
+	// Lowest, highest and mean temperature (C) and the rain (mm)
+	TemperatureMin  float64 `json:"temperatureMin"`
+	TemperatureMax  float64 `json:"temperatureMax"`
+	TemperatureMean float64 `json:"temperatureMean"`
+	Rainfall        float64 `json:"rainfall"`
+	// Hours of the day observed
+	Hours int `json:"hours"`
+}
+
+var (
+	// observations by the folded city name, oldest first
+	history      = make(map[string][]Observation)
+	historyFile  *os.File
+	historyMutex sync.Mutex
+)
+
+func (h *HistoryConfig) check() error {
+	if h.File == "" {
+		h.File = "history.jsonl"
+	}
+	return nil
+}
+
+// StartHistory loads the observations kept so far, opens the file for the
+// new ones and starts keeping the weather of the cities refreshed.
+func StartHistory(h HistoryConfig) error {
+	f, err := os.OpenFile(h.File, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var o Observation
+		if err := json.Unmarshal(scanner.Bytes(), &o); err != nil {
+			f.Close()
+			return fmt.Errorf("Error in line %d of %s: %v", line, h.File, err)
+		}
+		key := foldPlace(o.City)
+		history[key] = append(history[key], o)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return err
+	}
+
+	historyMutex.Lock()
+	historyFile = f
+	historyMutex.Unlock()
+
+	for _, city := range h.Cities {
+		go WatchWeather(city, func(WeatherData) {})
+	}
+	return nil
+}
+
+// observationTime returns the time of the hour the observation of the
+// weather is from.
+func observationTime(weather WeatherData) time.Time {
+	updated := weather.LastUpdated.In(location)
+	t := time.Date(updated.Year(), updated.Month(), updated.Day(), weather.ObservationHour, 0, 0, 0, location)
+	if t.After(updated) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// recordHistory keeps the observation of the weather if history is kept
+// and the hour isn't already. Weather no source had the temperature of, whose
+// zeros aren't readings, and made up weather are not kept.
+func recordHistory(ctx context.Context, weather WeatherData) {
+	if _, found := weather.Provenance["temperature"]; !found || mockMode {
+		return
+	}
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if historyFile == nil {
+		return
+	}
+
+	o := Observation{City: weather.City, Time: observationTime(weather), Temperature: weather.Temperature, Rainfall: weather.Rainfall, Pressure: weather.Pressure}
+	key := foldPlace(o.City)
+	observations := history[key]
+	if len(observations) > 0 && !observations[len(observations)-1].Time.Before(o.Time) {
+		return
+	}
+	history[key] = append(observations, o)
+
+	data, err := json.Marshal(o)
+	if err == nil {
+		_, err = historyFile.Write(append(data, '\n'))
+	}
+	if err != nil {
+		logf(ctx, "Error keeping the history of %s: %v", o.City, err)
+	}
+}
+
+// historyDays returns the days from from until to that the city has
+// observations of, oldest first, and the name of the city as observed.
+func historyDays(city string, from, to time.Time) (days []HistoryDay, name string) {
+	historyMutex.Lock()
+	observations := history[foldPlace(city)]
+	historyMutex.Unlock()
+
+	days = []HistoryDay{}
+	for _, o := range observations {
+		t := o.Time.In(location)
+		if t.Before(from) || !t.Before(to) {
+			continue
+		}
+		name = o.City
+		date := t.Format(time.DateOnly)
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, HistoryDay{Date: date, TemperatureMin: o.Temperature, TemperatureMax: o.Temperature})
+		}
+		day := &days[len(days)-1]
+		day.TemperatureMin = min(day.TemperatureMin, o.Temperature)
+		day.TemperatureMax = max(day.TemperatureMax, o.Temperature)
+		day.Rainfall += o.Rainfall
+		// a running mean
+		day.Hours++
+		day.TemperatureMean += (o.Temperature - day.TemperatureMean) / float64(day.Hours)
+	}
+	for i := range days {
+		days[i].TemperatureMean = roundTo(days[i].TemperatureMean, 1)
+		days[i].Rainfall = roundTo(days[i].Rainfall, 1)
+	}
+	return days, name
+}
+
+// HistoryDays is the history of a city by day over a range of days.
+type HistoryDays struct {
+	City string `json:"city"`
+	// First and last day of the range
+	From string       `json:"from"`
+	To   string       `json:"to"`
+	Days []HistoryDay `json:"days"`
+}
+
+// parseHistoryRange parses the from and to days of a history query, like
+// 2024-03-01, returning the first day and the day after the last. Without
+// from the range is the defaultHistoryDays days up to to, and without to up
+// to today.
+func parseHistoryRange(fromParam, toParam string) (from, to time.Time, err error) {
+	now := time.Now().In(location)
+	to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location).AddDate(0, 0, 1)
+	if toParam != "" {
+		day, err := time.ParseInLocation(time.DateOnly, toParam, location)
+		if err != nil {
+			return from, to, fmt.Errorf("Invalid to \"%s\", expected a day like 2024-03-31", toParam)
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+	from = to.AddDate(0, 0, -defaultHistoryDays)
+	if fromParam != "" {
+		from, err = time.ParseInLocation(time.DateOnly, fromParam, location)
+		if err != nil {
+			return from, to, fmt.Errorf("Invalid from \"%s\", expected a day like 2024-03-01", fromParam)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("Invalid range, from %s is after to", fromParam)
+	}
+	return from, to, nil
+}
+
+// cityHistory returns the history of the city over the range of a query,
+// see parseHistoryRange.
+func cityHistory(city, fromParam, toParam string) (HistoryDays, error) {
+	from, to, err := parseHistoryRange(fromParam, toParam)
+	if err != nil {
+		return HistoryDays{}, err
+	}
+	days, name := historyDays(city, from, to)
+	if name == "" {
+		name = city
+	}
+	return HistoryDays{
+		City: name,
+		From: from.Format(time.DateOnly),
+		To:   to.AddDate(0, 0, -1).Format(time.DateOnly),
+		Days: days,
+	}, nil
+}
+
+// historyHandler serves /history?city=&from=&to=, the observed weather of
+// the city by day, as JSON or with format=csv as CSV.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	if config().History == nil {
+		http.Error(w, "No history is kept, see history in the configuration", http.StatusNotFound)
+		return
+	}
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	h, err := cityHistory(city, r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeCSV(w, keli.SanitizeCityName(h.City)+"-history.csv", HistoryCSV(h))
+		return
+	}
+
+	jsonData, err := json.Marshal(h)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
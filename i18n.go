@@ -0,0 +1,258 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+	"golang.org/x/text/number"
+)
+
+// Language is a language user-facing output can be translated to. Every
+// catalog file in i18n/ adds a language, named after the file.
+type Language string
+
+const (
+	LangFinnish Language = "fi"
+	LangEnglish Language = "en"
+	LangSwedish Language = "sv"
+)
+
+//go:embed i18n/*.json
+var catalogFiles embed.FS
+
+// catalogFile is the format of the message catalogs in i18n/.
+type catalogFile struct {
+	// Messages by key. Messages are fmt-style format strings, numbers in
+	// the arguments are formatted for the language.
+	Messages map[string]string `json:"messages"`
+	// Translations of the phrases in Finnish weather summaries, keyed by
+	// the lowercase Finnish phrase. Time of day phrases like "illalla" are
+	// format strings taking the rest of the phrase.
+	Summaries map[string]string `json:"summaries"`
+}
+
+var (
+	languages []Language
+	printers  = make(map[Language]*message.Printer)
+	summaries = make(map[Language]map[string]string)
+	// keys of the Finnish catalog, which every other catalog falls back to
+	messageKeys = make(map[string]bool)
+	// keys of each catalog, for messages only some languages have
+	catalogKeys = make(map[Language]map[string]bool)
+)
+
+func init() {
+	if err := loadCatalogs(); err != nil {
+		log.Fatalf("Error loading message catalogs: %v", err)
+	}
+}
+
+func loadCatalogs() error {
+	builder := catalog.NewBuilder(catalog.Fallback(language.Finnish))
+
+	files, err := catalogFiles.ReadDir("i18n")
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		lang := Language(strings.TrimSuffix(file.Name(), path.Ext(file.Name())))
+		tag, err := language.Parse(string(lang))
+		if err != nil {
+			return fmt.Errorf("%s: %v", file.Name(), err)
+		}
+
+		content, err := catalogFiles.ReadFile("i18n/" + file.Name())
+		if err != nil {
+			return err
+		}
+		var cat catalogFile
+		if err := json.Unmarshal(content, &cat); err != nil {
+			return fmt.Errorf("%s: %v", file.Name(), err)
+		}
+
+		catalogKeys[lang] = make(map[string]bool)
+		for key, msg := range cat.Messages {
+			catalogKeys[lang][key] = true
+			if err := builder.SetString(tag, key, msg); err != nil {
+				return fmt.Errorf("%s: %s: %v", file.Name(), key, err)
+			}
+			if lang == LangFinnish {
+				messageKeys[key] = true
+			}
+		}
+
+		languages = append(languages, lang)
+		summaries[lang] = cat.Summaries
+	}
+
+	for _, lang := range languages {
+		printers[lang] = message.NewPrinter(language.MustParse(string(lang)), message.Catalog(builder))
+	}
+	sort.Slice(languages, func(i, j int) bool { return languages[i] < languages[j] })
+
+	return nil
+}
+
+// ParseLanguage parses the lang query parameter. An empty value means Finnish.
+func ParseLanguage(lang string) (Language, error) {
+	if lang == "" {
+		return LangFinnish, nil
+	}
+	if _, found := printers[Language(strings.ToLower(lang))]; found {
+		return Language(strings.ToLower(lang)), nil
+	}
+
+	names := make([]string, len(languages))
+	for i, l := range languages {
+		names[i] = string(l)
+	}
+	return "", fmt.Errorf("Unknown language \"%s\", expected one of %s", lang, strings.Join(names, ", "))
+}
+
+func (l Language) printer() *message.Printer {
+	if p, found := printers[l]; found {
+		return p
+	}
+	return printers[LangFinnish]
+}
+
+// T returns the translation of key, formatted with args. Missing
+// translations fall back to Finnish.
+func (l Language) T(key string, args ...any) string {
+	if !messageKeys[key] {
+		log.Printf("Missing translation for %q", key)
+		return key
+	}
+	return l.printer().Sprintf(key, args...)
+}
+
+// Decimal formats a number with the given amount of decimals using the
+// decimal separator of the language.
+func (l Language) Decimal(value float64, decimals int) string {
+	return l.printer().Sprint(number.Decimal(value, number.Scale(decimals)))
+}
+
+// Number formats a number with at most one decimal, e.g. "2,1" or "-3".
+func (l Language) Number(value float64) string {
+	return l.printer().Sprint(number.Decimal(value, number.MaxFractionDigits(1)))
+}
+
+// Temperature formats a temperature with an explicit plus sign for
+// temperatures above zero, e.g. "+2,1°C".
+func (l Language) Temperature(temperature float64, unit string) string {
+	if temperature > 0 {
+		return "+" + l.Decimal(temperature, 1) + unit
+	}
+	return l.Decimal(temperature, 1) + unit
+}
+
+// Precipitation formats a precipitation amount, inches need more decimals
+// than millimeters to be useful.
+func (l Language) Precipitation(amount float64, units keli.UnitSystem) string {
+	if units == keli.UnitsImperial {
+		return l.Decimal(amount, 2)
+	}
+	return l.Decimal(amount, 1)
+}
+
+// Weekday returns the name of the weekday.
+func (l Language) Weekday(day time.Weekday) string {
+	return l.T(fmt.Sprintf("weekday%d", day))
+}
+
+// FormatDate formats the date part of t, e.g. "maanantai 1.4."
+func (l Language) FormatDate(t time.Time) string {
+	return l.T("date", l.Weekday(t.Weekday()), t.Day(), int(t.Month()), l.T(fmt.Sprintf("month%d", t.Month())))
+}
+
+// FormatTime formats the time of day part of t, e.g. "14.05"
+func (l Language) FormatTime(t time.Time) string {
+	return l.T("time", t.Hour(), t.Minute())
+}
+
+// FormatDateTime formats t as a date and time of day.
+func (l Language) FormatDateTime(t time.Time) string {
+	return l.T("dateTime", l.FormatDate(t), l.FormatTime(t))
+}
+
+// WindDescription returns the Beaufort scale description of the wind.
+func (l Language) WindDescription(beaufort int) string {
+	return l.T(fmt.Sprintf("beaufort%d", beaufort))
+}
+
+// SymbolDescription returns the description of the weather symbol code.
+func (l Language) SymbolDescription(code string) string {
+	symbol, known := keli.LookupWeatherSymbol(code)
+	key := "symbol" + strings.TrimLeft(code, "dn")
+	// the Finnish descriptions are in the symbol table, not the catalog
+	if !known || !catalogKeys[l][key] {
+		return symbol.Description
+	}
+	return l.printer().Sprintf(key)
+}
+
+// TranslateSummary translates a Finnish weather summary like "Heikkoa
+// lumisadetta, illalla pilvistä" phrase by phrase. If some phrase is not in
+// the catalog the description of symbolCode is used instead.
+func (l Language) TranslateSummary(summary, symbolCode string) string {
+	if l == LangFinnish || summary == "" {
+		return summary
+	}
+	if translated, ok := l.translateSummary(summary); ok {
+		return translated
+	}
+	if symbolCode == "" {
+		return summary
+	}
+	return l.SymbolDescription(symbolCode)
+}
+
+// translateSummary translates a Finnish weather summary phrase by phrase,
+// telling whether every phrase was in the catalog.
+func (l Language) translateSummary(summary string) (string, bool) {
+	phrases := summaries[l]
+	translate := func(phrase string) (string, bool) {
+		phrase = strings.ToLower(strings.TrimSpace(phrase))
+		if translated, found := phrases[phrase]; found {
+			return translated, true
+		}
+		// time of day, e.g. "illalla pilvistä"
+		when, rest, _ := strings.Cut(phrase, " ")
+		format, found := phrases[when]
+		translated, restFound := phrases[rest]
+		if !found || !restFound {
+			return "", false
+		}
+		return fmt.Sprintf(format, translated), true
+	}
+
+	var translated []string
+	for _, phrase := range strings.Split(summary, ",") {
+		t, ok := translate(phrase)
+		if !ok {
+			return "", false
+		}
+		translated = append(translated, t)
+	}
+
+	return capitalize(strings.Join(translated, ", ")), true
+}
+
+func capitalize(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// measurements older than this are left out of the ice report, the ice
+	// changes too much to trust them
+	iceMaxAge = 7 * 24 * time.Hour
+	// how long the measurements of a feed are kept before fetching it again
+	iceCacheDuration = time.Hour
+)
+
+var iceClient = &http.Client{Timeout: 10 * time.Second}
+
+// IceConfig is where the ice measurements of a city come from: a JSON feed
+// of them, like one kept from the ice charts of the Finnish Meteorological
+// Institute, or measurements entered by hand, e.g. by the local club.
+type IceConfig struct {
+	City string `json:"city"`
+	// JSON feed of a list of measurements like those below
+	URL          string           `json:"url"`
+	Measurements []IceMeasurement `json:"measurements"`
+}
+
+// IceMeasurement is the ice measured at a place of a lake or the coast.
+type IceMeasurement struct {
+	// e.g. "Näsijärvi, Siilinkari"
+	Place       string  `json:"place"`
+	ThicknessCm float64 `json:"thicknessCm"`
+	// Kind of the ice, e.g. "teräsjää" or "kohvajää"
+	Cover    string    `json:"cover"`
+	Measured time.Time `json:"measured"`
+	// Who measured it, e.g. "FMI"
+	Source string `json:"source"`
+}
+
+// IceReport is the ice of the lakes and the coast of a city, the
+// measurements of the last week newest first. Without any, the notice says
+// there is no official data and to be careful.
+type IceReport struct {
+	Measurements []IceMeasurement `json:"measurements"`
+	Notice       string           `json:"notice"`
+}
+
+type iceCache struct {
+	measurements []IceMeasurement
+	fetched      time.Time
+}
+
+var (
+	iceCaches      = make(map[string]iceCache)
+	iceCachesMutex sync.Mutex
+)
+
+func (c *IceConfig) check() error {
+	if c.City == "" {
+		return fmt.Errorf("Missing 'city'")
+	}
+	if c.URL == "" && len(c.Measurements) == 0 {
+		return fmt.Errorf("Missing 'url' or 'measurements'")
+	}
+	if c.URL != "" {
+		if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("Invalid url \"%s\", expected an http or https URL", c.URL)
+		}
+	}
+	for _, m := range c.Measurements {
+		if m.Place == "" || m.Measured.IsZero() {
+			return fmt.Errorf("Missing 'place' or 'measured' of a measurement")
+		}
+	}
+	return nil
+}
+
+// fetchIce returns the measurements of the feed, fetching them again when
+// the cached ones are older than iceCacheDuration. When the feed fails, the
+// last ones fetched are returned with the error.
+func fetchIce(feed string) ([]IceMeasurement, error) {
+	iceCachesMutex.Lock()
+	cached, found := iceCaches[feed]
+	iceCachesMutex.Unlock()
+	if found && time.Since(cached.fetched) < iceCacheDuration {
+		return cached.measurements, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, feed, nil)
+	if err != nil {
+		return cached.measurements, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Accept", "application/json")
+	res, err := iceClient.Do(req)
+	if err != nil {
+		return cached.measurements, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return cached.measurements, fmt.Errorf("%s", res.Status)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return cached.measurements, err
+	}
+	var measurements []IceMeasurement
+	if err := json.Unmarshal(data, &measurements); err != nil {
+		return cached.measurements, err
+	}
+
+	iceCachesMutex.Lock()
+	iceCaches[feed] = iceCache{measurements: measurements, fetched: time.Now()}
+	iceCachesMutex.Unlock()
+	return measurements, nil
+}
+
+// IceReportOf returns the ice report of the city, its notice in the
+// language.
+func IceReportOf(city string, lang Language) *IceReport {
+	report := &IceReport{Measurements: []IceMeasurement{}}
+	for _, c := range config().Ice {
+		if foldPlace(c.City) != foldPlace(city) {
+			continue
+		}
+		measurements := c.Measurements
+		if c.URL != "" {
+			fetched, err := fetchIce(c.URL)
+			if err != nil {
+				log.Printf("Error fetching ice of %s from %s: %v", city, c.URL, err)
+			}
+			measurements = slices.Concat(measurements, fetched)
+		}
+		for _, m := range measurements {
+			if time.Since(m.Measured) <= iceMaxAge {
+				report.Measurements = append(report.Measurements, m)
+			}
+		}
+	}
+	slices.SortStableFunc(report.Measurements, func(a, b IceMeasurement) int { return b.Measured.Compare(a.Measured) })
+
+	report.Notice = lang.T("iceNoData")
+	if len(report.Measurements) > 0 {
+		report.Notice = lang.T("iceCheck")
+	}
+	return report
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"html/template"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+//go:embed static/icons/*.svg
+var iconFiles embed.FS
+
+// WeatherIconName returns the name of the bundled icon for the given symbol
+// code. Night codes use the "-night" variant of the icon when there is one.
+func WeatherIconName(code string) string {
+	symbol, _ := keli.LookupWeatherSymbol(code)
+	if strings.HasPrefix(code, "n") {
+		night := symbol.Icon + "-night"
+		if _, err := iconFiles.Open(iconPath(night)); err == nil {
+			return night
+		}
+	}
+	return symbol.Icon
+}
+
+func iconPath(name string) string {
+	return "static/icons/" + name + ".svg"
+}
+
+// iconDataURI returns the icon of the symbol code as a data URI, for
+// images and snippets that have to work on their own. It is made of the
+// bundled icons only, so the templates can take it as a safe URL.
+func iconDataURI(code string) template.URL {
+	icon, err := iconFiles.ReadFile(iconPath(WeatherIconName(code)))
+	if err != nil {
+		log.Printf("Error reading icon for %s: %v", code, err)
+	}
+	return template.URL("data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(icon))
+}
+
+// IconImage renders the icon of the symbol code as a size×size image, for
+// the endpoints that draw images instead of linking to /icons.
+func IconImage(code string, size int) (image.Image, error) {
+	data, err := iconFiles.ReadFile(iconPath(WeatherIconName(code)))
+	if err != nil {
+		return nil, err
+	}
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	icon.SetTarget(0, 0, float64(size), float64(size))
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	scanner := rasterx.NewScannerGV(size, size, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(size, size, scanner), 1)
+	return img, nil
+}
+
+// size of the PNG icons, for chat apps and others that don't show SVG
+const iconPNGSize = 128
+
+// iconHandler serves /icons/{code}.svg and /icons/{code}.png
+func iconHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/icons/")
+	if size, found := appIconSizes[name]; found {
+		appIconHandler(w, size)
+		return
+	}
+	if code, ok := strings.CutSuffix(name, ".png"); ok && keli.IsWeatherSymbolCode(code) {
+		iconPNGHandler(w, code)
+		return
+	}
+	code, ok := strings.CutSuffix(name, ".svg")
+	if !ok || !keli.IsWeatherSymbolCode(code) {
+		http.NotFound(w, r)
+		return
+	}
+
+	icon, err := iconFiles.ReadFile(iconPath(WeatherIconName(code)))
+	if err != nil {
+		logf(r.Context(), "Error reading icon for %s: %v", code, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(icon)
+}
+
+func iconPNGHandler(w http.ResponseWriter, code string) {
+	img, err := IconImage(code, iconPNGSize)
+	if err != nil {
+		log.Printf("Error rendering icon for %s: %v", code, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if err := png.Encode(w, img); err != nil {
+		log.Printf("Error writing icon for %s: %v", code, err)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,151 +13,165 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
+	_ "time/tzdata"
 
-	"github.com/PuerkitoBio/goquery"
+	"github.com/itsnibsi/keli/pkg/keli"
 )
 
-type HourlyForecast struct {
-	Hour                 string  `json:"hour"`
-	WeatherSymbol        string  `json:"weather"`
-	Temperature          float64 `json:"temperature"`
-	TemperatureFeelsLike float64 `json:"temperatureFeelsLike"`
-	WindSpeed            int     `json:"windSpeed"`
-	Rainfall             float64 `json:"rainfall"`
-	RainChance           int     `json:"rainChance"`
+// WeatherData represents the weather data for a given city: the weather
+// of the sources merged by package keli, and what keli knows from
+// elsewhere. The Weather of the Go client in client/ mirrors its JSON, keep
+// the two in sync.
+type WeatherData struct {
+	keli.Weather
+	// Finnish Beaufort scale description of the wind, e.g. "navakka tuuli"
+	WindDescription string `json:"windDescription"`
+	// What to wear, e.g. "pipo ja hanskat, sadetakki mukaan", see
+	// clothing.go
+	Recommendation string `json:"recommendation"`
+	// How well laundry hung out now dries, from 0 to 100, see scores.go
+	DryingIndex int `json:"dryingIndex"`
+	// Heat and cold advisories of the thresholds configured for the city,
+	// see advisories.go
+	Advisories []Advisory `json:"advisories,omitempty"`
+	// Air pressure at sea level (hPa) at the nearest station and how it
+	// changed in 3 hours, when history is kept, see pressure.go
+	Pressure      *float64       `json:"pressure,omitempty"`
+	PressureTrend *PressureTrend `json:"pressureTrend,omitempty"`
+	// Lightning strikes near the city in the last hour, see lightning.go
+	Lightning *Lightning `json:"lightning,omitempty"`
+	// The weather station of FMI nearest to the city, see stations.go
+	Station *NearestStation `json:"station,omitempty"`
+	// Ice of the lakes and the coast with ?ice=true, see ice.go
+	Ice *IceReport `json:"ice,omitempty"`
+	// Electricity spot prices with ?electricity=true, see electricity.go
+	Electricity *Electricity `json:"electricity,omitempty"`
+	// The last time each group of fields was updated by a source, e.g.
+	// "current" or "sun", see keli.FieldGroups
+	Updated map[string]time.Time `json:"updated,omitempty"`
+	// Which sources the data came from and what is missing, see meta.go
+	Meta *WeatherMeta `json:"meta,omitempty"`
 }
 
-// WeatherData represents the weather data for a given city.
-type WeatherData struct {
-	// Human-readable name of the city we're looking at
-	City string `json:"city"`
-	// The hour the last observation update is from
-	ObservationHour int `json:"observationHour"`
-	// Text description of the weather
-	WeatherSummary string `json:"weatherSummary"`
-	// Current temperature (C)
-	Temperature float64 `json:"temperature"`
-	// How current temperature feels (C)
-	TemperatureFeelsLike float64 `json:"temperatureFeelsLike"`
-	// Today's min temperature (C)
-	TemperatureMin float64 `json:"temperatureMin"`
-	// Today's max temperature (C)
-	TemperatureMax float64 `json:"temperatureMax"`
-	// Amount of rain (mm)
-	Rainfall float64 `json:"rainfall"`
-	// Amount of snow (mm)
-	Snowfall float64 `json:"snowfall"`
-	// Wind speed (m/s)
-	WindSpeed int `json:"windSpeed"`
-	// Rain chance (%)
-	RainChance int `json:"rainChance"`
-	// Tomorrow's temperature (C)
-	TemperatureTomorrow float64 `json:"temperatureTomorrow"`
-	// Tomorrow's min temperature (C)
-	TemperatureMinTomorrow float64 `json:"temperatureMinTomorrow"`
-	// The time the sun rises
-	Sunrise string `json:"sunrise"`
-	// The time the sun sets
-	Sunset string `json:"sunset"`
-	// The length of the day (HH:MM)
-	DayLength string `json:"dayLength"`
-	// The last time the weather data was updated in the cache
-	LastUpdated time.Time `json:"lastUpdated"`
-	// Hourly forecast
-	HourlyForecast []HourlyForecast `json:"hourlyForecast"`
+// sourceData is the weather data parsed from one source.
+type sourceData struct {
+	keli.SourceData
+	// Values dropped from the data as implausible jumps, see suspects.go
+	Suspects []Warning
 }
 
-// WeatherSource represents a source of weather data.
-type WeatherSource struct {
-	URL   string
-	Parse func(*goquery.Document) (WeatherData, error)
+// failure returns what went wrong when the source failed, nil if it didn't.
+func (sd sourceData) failure() *SourceFailure {
+	if sd.Err == nil {
+		return nil
+	}
+	stage := "fetch"
+	var sourceErr *keli.SourceError
+	if errors.As(sd.Err, &sourceErr) {
+		stage = sourceErr.Stage
+	}
+	return &SourceFailure{Source: sd.Source, Stage: stage, Error: sd.Err.Error()}
 }
 
 var (
-	cache         = make(map[string]WeatherData)
-	cacheMutex    sync.Mutex
-	cacheDuration = 5 * time.Minute
+	// Finnish time, which the times of the sources are in
+	location = mustLoadLocation("Europe/Helsinki")
 
-	weatherSources = []WeatherSource{
-		{URL: "https://www.foreca.fi/Finland/", Parse: parseForecaData},
-		{URL: "https://www.ampparit.com/saa/", Parse: parseAmpparitData},
-		{URL: "http://www.moisio.fi/taivas/aurinko.php?paikka=", Parse: parseMoisioData},
-	}
+	cache      = make(map[string]WeatherData)
+	cacheMutex sync.Mutex
 )
 
-// GetWeatherData returns the weather data for the given city
-func GetWeatherData(city string) (weather WeatherData, err error) {
+// GetWeatherData returns the weather data for the given city, fetched for
+// the request the context belongs to if any
+func GetWeatherData(ctx context.Context, city string) (weather WeatherData, err error) {
+	return getWeatherData(ctx, city, false)
+}
+
+// RefreshWeatherData fetches the weather data for the given city again
+// even if it is in the cache.
+func RefreshWeatherData(ctx context.Context, city string) (weather WeatherData, err error) {
+	return getWeatherData(ctx, city, true)
+}
+
+func getWeatherData(ctx context.Context, city string, refresh bool) (weather WeatherData, err error) {
 	// clean up the city name of special characters
-	city = sanitizeCityName(city)
+	city = keli.SanitizeCityName(city)
 
 	// cache check
 	cacheMutex.Lock()
 	cachedData, found := cache[city]
 	cacheMutex.Unlock()
-	if found && time.Since(cachedData.LastUpdated) < cacheDuration {
+	if found && !refresh && time.Since(cachedData.LastUpdated) < cacheTTL() {
 		return cachedData, nil
 	}
 
-	// channel for receiving partial weather data from sources
-	weatherDataChan := make(chan WeatherData, len(weatherSources))
-
-	// create a waitgroup to wait for all sources to finish parsing
-	var wg sync.WaitGroup
-	wg.Add(len(weatherSources))
-
-	// fetch weather data from all sources
-	for _, source := range weatherSources {
-		go func(source WeatherSource) {
-			defer wg.Done()
-
-			url := source.URL + city
-
-			// fetch the document
-			res, err := http.Get(url)
-			if err != nil {
-				log.Printf("Error fetching data from %s: %v", url, err)
-				return
-			}
-			defer res.Body.Close()
-
-			// feed the document to goquery
-			doc, err := goquery.NewDocumentFromReader(res.Body)
-			if err != nil {
-				log.Printf("Error parsing document from %s: %v", url, err)
-				return
-			}
-
-			// Parse weather data from the document
-			data, err := source.Parse(doc)
-			if err != nil {
-				log.Printf("Error parsing weather data from %s: %v", url, err)
-				return
-			}
-
-			weatherDataChan <- data
-		}(source)
-	}
-
-	// close channel after all sources have been parsed
-	go func() {
-		wg.Wait()
-		close(weatherDataChan)
-	}()
-
-	// Collect parsed weather data
-	var weatherData []WeatherData
-	for data := range weatherDataChan {
-		weatherData = append(weatherData, data)
-		log.Printf("Found weather data for %s", city)
-		log.Printf("Data: %+v", data)
+	// the lightning, the chance of thunder, the flood warnings and the air
+	// pressure come from FMI while the sources are fetched
+	lightningChan := make(chan *Lightning, 1)
+	go func() { lightningChan <- recentLightning(ctx, city) }()
+	thunderChan := make(chan map[time.Time]float64, 1)
+	go func() { thunderChan <- thunderChances(ctx, city) }()
+	floodChan := make(chan []FloodAlert, 1)
+	go func() { floodChan <- currentFloodAlerts(ctx) }()
+	pressureChan := make(chan *float64, 1)
+	go func() { pressureChan <- stationPressure(ctx, city) }()
+
+	// fetch weather data from all sources, or make it up with -mock
+	aggregator := weatherAggregator()
+	var fetched []keli.SourceData
+	if mockMode {
+		now := time.Now()
+		data, err := MockWeather(city, now)
+		if err != nil {
+			return WeatherData{}, err
+		}
+		fetched = []keli.SourceData{{Source: "mock", Fetched: now, Data: data}}
+	} else if fetched, err = aggregator.FetchSources(ctx, city); err != nil {
+		return WeatherData{}, err
 	}
 
-	finalWeatherData := mergeWeatherData(weatherData)
+	results := make([]sourceData, len(fetched))
+	for i := range fetched {
+		if found && fetched[i].Err == nil {
+			results[i].Suspects = dropSuspects(ctx, &fetched[i], cachedData)
+		}
+		results[i].SourceData = fetched[i]
+	}
+
+	finalWeatherData := WeatherData{Weather: aggregator.Merge(ctx, fetched)}
+	var stale, derived []string
+	if found && finalWeatherData.City != "" {
+		keepSuspected(&finalWeatherData, results, cachedData)
+		stale = keepStaleGroups(ctx, &finalWeatherData, cachedData)
+	}
+	if finalWeatherData.City != "" {
+		derived = deriveFromHourly(ctx, &finalWeatherData)
+	}
+	finalWeatherData.UpdateMissing()
+	finalWeatherData.Recommendation = recommendClothing(finalWeatherData)
+	finalWeatherData.Updated = groupTimes(finalWeatherData.Provenance)
+	finalWeatherData.Meta = buildMeta(finalWeatherData, results, stale, derived)
+	finalWeatherData.Meta.Warnings = append(finalWeatherData.Meta.Warnings, floodWarnings(<-floodChan, finalWeatherData.City, time.Now())...)
+	// the wind may have been kept from earlier
+	finalWeatherData.Beaufort = keli.BeaufortNumber(float64(finalWeatherData.WindSpeed))
+	finalWeatherData.WindDescription = BeaufortDescription(float64(finalWeatherData.WindSpeed))
 	finalWeatherData.LastUpdated = time.Now()
+	applyThunderChances(&finalWeatherData, <-thunderChan)
+	finalWeatherData.DryingIndex = laundryScore(finalWeatherData, finalWeatherData.LastUpdated).Score
+	finalWeatherData.Advisories = advisoriesFor(finalWeatherData, city)
+	finalWeatherData.Lightning = <-lightningChan
+	finalWeatherData.Pressure = <-pressureChan
+	if finalWeatherData.Pressure != nil {
+		finalWeatherData.PressureTrend = pressureTrend(finalWeatherData.City, observationTime(finalWeatherData), *finalWeatherData.Pressure)
+	}
 
 	if finalWeatherData.City == "" {
+		// rather the last data than none while the sites are spared
+		if found && rateLimited(results) {
+			logf(ctx, "Serving cached data for %s, the sources are rate limited", city)
+			return cachedData, nil
+		}
 		return WeatherData{}, fmt.Errorf("No weather data found for city \"%s\"", city)
 	}
 
@@ -163,309 +179,250 @@ func GetWeatherData(city string) (weather WeatherData, err error) {
 	cacheMutex.Lock()
 	cache[city] = finalWeatherData
 	cacheMutex.Unlock()
+	publish(city, finalWeatherData)
+	recordHistory(ctx, finalWeatherData)
 
 	return finalWeatherData, nil
 }
 
-func sanitizeCityName(city string) string {
-	replacer := strings.NewReplacer(
-		"ä", "a",
-		"ö", "o",
-	)
-	return replacer.Replace(city)
-}
-
-func mergeWeatherData(data []WeatherData) (md WeatherData) {
-	chooseNonEmptyString := func(existing, incoming string) string {
-		if existing != "" {
-			return existing
-		}
-		return incoming
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Fatalf("Error loading location %s: %v", name, err)
 	}
+	return loc
+}
 
-	chooseNonZeroFloat64 := func(existing, incoming float64) float64 {
-		if incoming != 0 {
-			return incoming
-		}
-		return existing
-	}
-
-	for _, d := range data {
-		// Foreca
-		md.City = chooseNonEmptyString(md.City, d.City)
-		md.TemperatureMax = chooseNonZeroFloat64(md.TemperatureMax, d.TemperatureMax)
-		md.TemperatureMin = chooseNonZeroFloat64(md.TemperatureMin, d.TemperatureMin)
-		md.Rainfall = chooseNonZeroFloat64(md.Rainfall, d.Rainfall)
-		md.Snowfall = chooseNonZeroFloat64(md.Snowfall, d.Snowfall)
-		if d.WindSpeed != 0 {
-			md.WindSpeed = d.WindSpeed
-		}
-		md.WeatherSummary = chooseNonEmptyString(md.WeatherSummary, d.WeatherSummary)
-		// Moisio
-		md.Sunrise = chooseNonEmptyString(md.Sunrise, d.Sunrise)
-		md.Sunset = chooseNonEmptyString(md.Sunset, d.Sunset)
-		md.DayLength = chooseNonEmptyString(md.DayLength, d.DayLength)
-		// Ampparit
-		md.Temperature = chooseNonZeroFloat64(md.Temperature, d.Temperature)
-		md.TemperatureFeelsLike = chooseNonZeroFloat64(md.TemperatureFeelsLike, d.TemperatureFeelsLike)
-		if d.ObservationHour != 0 {
-			md.ObservationHour = d.ObservationHour
+// ForecastTimes returns the start time of each hour in the hourly forecast.
+// The forecast only has the hour of day, so the date is counted from the
+// last update, moving to the next day when the hour wraps past midnight.
+func ForecastTimes(weather WeatherData) []time.Time {
+	updated := weather.LastUpdated.In(location)
+	day := time.Date(updated.Year(), updated.Month(), updated.Day(), 0, 0, 0, 0, location)
+
+	times := make([]time.Time, len(weather.HourlyForecast))
+	previous := -1
+	for i, h := range weather.HourlyForecast {
+		hour, err := strconv.Atoi(h.Hour)
+		if err != nil {
+			hour = previous + 1
 		}
-		md.TemperatureTomorrow = chooseNonZeroFloat64(md.TemperatureTomorrow, d.TemperatureTomorrow)
-		md.TemperatureMinTomorrow = chooseNonZeroFloat64(md.TemperatureMinTomorrow, d.TemperatureMinTomorrow)
-		if d.HourlyForecast != nil {
-			md.HourlyForecast = d.HourlyForecast
-			log.Printf("Hourly forecast: %v", d.HourlyForecast)
+		if hour < previous {
+			day = day.AddDate(0, 0, 1)
 		}
+		previous = hour
+		times[i] = atHour(day, hour)
 	}
-
-	return
+	return times
 }
 
-func parseForecaData(doc *goquery.Document) (data WeatherData, err error) {
-	// Temperature max
-	tempMaxText := doc.Find("#dailybox > div:nth-child(1) > a > div > p.tx > abbr").First().Text()
-	tempMax, err := cleanTemperatureString(tempMaxText)
-	if err != nil {
-		log.Printf("Foreca - Error parsing temperature: %v", err)
-		return WeatherData{}, err
-	}
-	data.TemperatureMax = tempMax
+// atHour returns the hour of the day by the clock. Adding the hours to
+// midnight would be an hour off after the change on the days of the DST
+// changes.
+func atHour(day time.Time, hour int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, location)
+}
 
-	// Temperature min
-	tempMinText := doc.Find("#dailybox > div:nth-child(1) > a > div > p.tn > abbr").First().Text()
-	tempMin, err := cleanTemperatureString(tempMinText)
+// clockTime returns the time of day like "7:49" on the given day.
+func clockTime(day time.Time, clock string) (time.Time, error) {
+	t, err := time.Parse("15:04", strings.Replace(clock, ".", ":", 1))
 	if err != nil {
-		log.Printf("Foreca - Error parsing temperature FL: %v", err)
-		return WeatherData{}, err
+		return time.Time{}, err
 	}
-	data.TemperatureMin = tempMin
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, location), nil
+}
 
-	// Wind speed
-	windSpeedText := doc.Find("#dailybox > div:nth-child(1) > a > div > p.w > span > em").First().Text()
-	windSpeed, err := strconv.Atoi(windSpeedText)
-	if err != nil {
-		log.Printf("Foreca - Error parsing wind speed: %v", err)
-		return WeatherData{}, err
+// baseURL returns the scheme and host the request was made to, for building
+// absolute links back to keli.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
 	}
-	data.WindSpeed = windSpeed
-
-	// // Snowfall
-	// snowfallText := doc.Find("#dailybox > div:nth-child(1) > a > div > div.p > em").First().Text()
-	// snowfall, err := strconv.ParseFloat(strings.Replace(snowfallText, ",", ".", -1), 64)
-	// if err != nil {
-	// 	log.Printf("Foreca - Error parsing snowfall: %v", err)
-	// 	return WeatherData{}, err
-	// }
-	// data.Snowfall = snowfall
-
-	// Weather summarized text
-	weatherSummary := doc.Find(".today .day .txt").First().Text()
-	data.WeatherSummary = strings.Split(weatherSummary, ".")[0]
+	return scheme + "://" + r.Host
+}
 
-	return
+// weatherAggregator returns the aggregator the sources are fetched and
+// merged with, as configured now. The server keeps its own cache of the
+// weather with what it adds from FMI, so the aggregator keeps none.
+func weatherAggregator() *keli.Aggregator {
+	c := config()
+	return keli.New(keli.Options{
+		CacheTTL:  -1,
+		Merge:     c.Merge,
+		Selectors: c.Selectors,
+		Hooks:     keli.Hooks{Fetch: fetchPage, Fetched: sourceFetched},
+	})
 }
 
-func parseAmpparitData(doc *goquery.Document) (data WeatherData, err error) {
-	// Parse the city name from the document title
-	city := doc.Find(".current-weather__location").Text()
+func weatherHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
 	if city == "" {
-		return WeatherData{}, errors.New("failed to parse city name")
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
 	}
-	data.City = city
 
-	temperatureText := doc.Find("span.current-weather__temperature").First().Text()
-	temperature, err := cleanTemperatureString(temperatureText)
-	if err != nil {
-		return WeatherData{}, err
+	format := r.URL.Query().Get("format")
+	if IsWttrFormat(format) {
+		wttrHandler(w, r, city)
+		return
 	}
-	data.Temperature = temperature
 
-	temperatureFeelsLikeText := doc.Find("span.weather-lighter.weather-temperature-feelslike").First().Text()
-	temperatureFeelsLike, err := cleanTemperatureString(temperatureFeelsLikeText)
+	units, err := keli.ParseUnitSystem(r.URL.Query().Get("units"))
 	if err != nil {
-		return WeatherData{}, err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	data.TemperatureFeelsLike = temperatureFeelsLike
 
-	// Rainfall amount
-	rainfallText := doc.Find(".current-weather__precipitation .weather-value").First().Text()
-	rainfallText = strings.Replace(rainfallText, " mm", "", -1)
-	rainfall, err := strconv.ParseFloat(rainfallText, 64)
+	windUnit, err := keli.ParseWindUnit(r.URL.Query().Get("wind_unit"))
 	if err != nil {
-		return WeatherData{}, err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	data.Rainfall = rainfall
 
-	// Updated hour
-	observationHour := doc.Find("ol > li:nth-child(1) > div.weather-time > time").First().Text()
-	observationHourInt, err := strconv.Atoi(observationHour)
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
 	if err != nil {
-		return WeatherData{}, err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	data.ObservationHour = observationHourInt
-
-	hours := doc.Find(".weather-hour-selector ol > li").Slice(0, 24)
-	hours.Each(func(i int, s *goquery.Selection) {
-		tempString := s.Find(".weather-temperature > span").First().Text()
-		temp, err := cleanTemperatureString(tempString)
-		if err != nil {
-			log.Printf("Ampparit - Error parsing hourly temperature: %v", err)
-			return
-		}
 
-		tempFLString := s.Find(".weather-temperature > span").First().Text()
-		tempFL, err := cleanTemperatureString(tempFLString)
-		if err != nil {
-			log.Printf("Ampparit - Error parsing hourly temperature FL: %v", err)
-			return
-		}
-
-		windSpeedStr := s.Find(".weather-wind > .weather-value").First().Text()
-		windSpeed, err := strconv.Atoi(windSpeedStr)
-		if err != nil {
-			log.Printf("Ampparit - Error parsing hourly wind speed: %v", err)
-			return
-		}
-
-		rainfallStr := s.Find(".weather-precipitation-amount").First().Text()
-		rainfallStr = strings.Replace(rainfallStr, " mm", "", -1)
-		rainfall, err := strconv.ParseFloat(rainfallStr, 64)
-		if err != nil {
-			log.Printf("Ampparit - Error parsing hourly rainfall: %v", err)
-			return
-		}
-
-		weatherSymbolText := s.Find(".weather-symbol > span").First().AttrOr("class", "invalid")
-		var weatherSymbol string
-
-		switch weatherSymbolText {
-		case "d000":
-			weatherSymbol = "☀️"
-		case "n000":
-			weatherSymbol = "🌜"
-		default:
-			weatherSymbol = "❓"
-		}
-
-		data.HourlyForecast = append(data.HourlyForecast, HourlyForecast{
-			Hour:                 s.Find("time").Text(),
-			WeatherSymbol:        weatherSymbol,
-			Temperature:          temp,
-			TemperatureFeelsLike: tempFL,
-			WindSpeed:            windSpeed,
-			Rainfall:             rainfall,
-			RainChance:           0,
-		})
-	})
-
-	// Tomorrow weather
-	temperatureTomorrowText := doc.Find(".weekly-weather-list-wrapper:nth-child(2) .weather-temperature").First().Text()
-	temperatureTomorrow, err := cleanTemperatureString(temperatureTomorrowText)
-	if err != nil {
-		return WeatherData{}, err
+	refresh, ok := checkRefresh(w, r, city)
+	if !ok {
+		return
 	}
-	data.TemperatureTomorrow = temperatureTomorrow
 
-	temperatureTomorrowMinText := doc.Find(".weekly-weather-list-wrapper:nth-child(2) .weather-min-temperature").First().Text()
-	temperatureTomorrowMinText = strings.Replace(temperatureTomorrowMinText, "alin ", "", -1)
-	temperatureTomorrowMin, err := cleanTemperatureString(temperatureTomorrowMinText)
-	if err != nil {
-		return WeatherData{}, err
+	getWeather := GetWeatherData
+	if refresh {
+		getWeather = RefreshWeatherData
 	}
-	data.TemperatureMinTomorrow = temperatureTomorrowMin
-
-	data.WeatherSummary = ""
-
-	return
-}
-
-func parseMoisioData(doc *goquery.Document) (data WeatherData, err error) {
-	data.Sunrise = doc.Find("td.tbl0:nth-child(4)").First().Text()
-	data.Sunset = doc.Find("td.tbl0:nth-child(5)").First().Text()
-	data.DayLength = doc.Find("td.tbl0:nth-child(6)").First().Text()
-	return
-}
-
-func cleanTemperatureString(temperature string) (temp float64, err error) {
-	parser := strings.NewReplacer(
-		"°", "",
-		"C", "",
-		"F", "",
-		",", ".",
-	)
-
-	temperature = parser.Replace(temperature)
-	temperature = strings.TrimSpace(temperature)
-
-	temperatureFloat, err := strconv.ParseFloat(temperature, 64)
+	weather, err := getWeather(r.Context(), city)
 	if err != nil {
-		log.Printf("Error parsing temperature: %v", err)
-		return 0, err
-	}
-	return temperatureFloat, nil
-}
-
-func weatherHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request for %s", r.URL.Path)
-
-	city := r.URL.Query().Get("city")
-	if city == "" {
-		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		http.Error(w, err.Error(), weatherErrorStatus(err))
 		return
 	}
-
-	weather, err := GetWeatherData(city)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	weather = ConvertUnits(weather, units, windUnit)
+	weather.Station = nearestStation(city)
+	if r.URL.Query().Get("ice") == "true" {
+		weather.Ice = IceReportOf(city, lang)
+	}
+	if r.URL.Query().Get("electricity") == "true" {
+		weather.Electricity = currentElectricity()
 	}
 
-	w.WriteHeader(http.StatusOK)
-
-	format := r.URL.Query().Get("format")
 	switch format {
 	case "text":
-		weatherTextHandler(w, weather)
+		weatherTextHandler(w, weather, lang)
+	case "short":
+		weatherShortHandler(w, weather, lang)
+	case "speech":
+		weatherSpeechHandler(w, weather, lang)
+	case "ansi":
+		weatherANSIHandler(w, weather, lang)
+	case "bar":
+		weatherBarHandler(w, weather, lang)
+	case "minimal":
+		weatherMinimalHandler(w, weather, lang)
+	case "csv":
+		weatherCSVHandler(w, weather)
+	case "cbor":
+		weatherBinaryHandler(w, weather, "application/cbor", MarshalCBOR)
+	case "msgpack":
+		weatherBinaryHandler(w, weather, "application/msgpack", MarshalMsgpack)
 	default:
-		weatherJSONHandler(w, weather)
+		weatherJSONHandler(w, weather, r.URL.Query().Get("provenance") == "true")
 	}
 }
 
-func weatherTextHandler(w http.ResponseWriter, weather WeatherData) {
-	w.Header().Set("Content-Type", "text/plain")
+func weatherTextHandler(w http.ResponseWriter, weather WeatherData, lang Language) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
-	output := fmt.Sprintf("Sää %s (Klo. %02d)\n", weather.City, weather.ObservationHour)
-	output += fmt.Sprintf("%s\n\n", weather.WeatherSummary)
-
-	output += fmt.Sprintf("Lämpötila: %s (Tuntuu kuin %s)\n", temperatureWithSign(weather.Temperature), temperatureWithSign(weather.TemperatureFeelsLike))
-	output += fmt.Sprintf("Päivän alin: %s\n", temperatureWithSign(weather.TemperatureMin))
-	output += fmt.Sprintf("Päivän ylin: %s\n", temperatureWithSign(weather.TemperatureMin))
+	_, err := w.Write([]byte(Text(weather, lang)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 
-	output += fmt.Sprintf("Sadetta: %.1f mm\n", weather.Rainfall)
-	output += fmt.Sprintf("Lunta: %.1f cm\n", weather.Snowfall)
-	output += fmt.Sprintf("Tuuli: %d m/s\n", weather.WindSpeed)
+// Text returns the weather as multiple lines of plain text.
+func Text(weather WeatherData, lang Language) string {
+	units := weather.Labels()
+	temperature := func(t float64) string {
+		return lang.Temperature(t, units.Temperature)
+	}
+
+	output := lang.T("title", weather.City, weather.ObservationHour) + "\n"
+	output += fmt.Sprintf("%s\n", lang.Summary(weather))
+	for _, advisory := range weather.Advisories {
+		output += advisory.Text(lang, weather.City, units.Temperature) + "\n"
+	}
+	if weather.Lightning != nil && weather.Lightning.ThunderNearby {
+		output += lang.T("lightning", weather.Lightning.Strikes, lang.Number(*weather.Lightning.NearestKm)) + "\n"
+	}
+	output += "\n"
+
+	output += lang.T("temperature", temperature(weather.Temperature), temperature(weather.TemperatureFeelsLike)) + "\n"
+	output += lang.T("dayMin", temperature(weather.TemperatureMin)) + "\n"
+	output += lang.T("dayMax", temperature(weather.TemperatureMax)) + "\n"
+
+	output += lang.T("rainfall", lang.Precipitation(weather.Rainfall, weather.Units), units.Precipitation) + "\n"
+	output += lang.T("rainChance", weather.RainChance) + "\n"
+	output += lang.T("snowfall", lang.Precipitation(weather.Snowfall, weather.Units), units.Snow) + "\n"
+	output += lang.T("wind", weather.WindSpeed, units.WindSpeed, lang.WindDescription(weather.Beaufort)) + "\n"
+	output += lang.T("dryingIndex", weather.DryingIndex) + "\n"
+	if weather.Pressure != nil {
+		pressure := fmt.Sprintf("%.0f hPa", *weather.Pressure)
+		if trend := weather.PressureTrend; trend != nil {
+			change := lang.Decimal(trend.Change, 1)
+			if trend.Change > 0 {
+				change = "+" + change
+			}
+			pressure += " " + trend.Arrow() + " " + lang.T("pressureChange", change)
+		}
+		output += lang.T("pressure", pressure) + "\n"
+	}
 
-	output += fmt.Sprintf("Huomenna: %s (Alin: %s)\n", temperatureWithSign(weather.TemperatureTomorrow), temperatureWithSign(weather.TemperatureMinTomorrow))
+	output += lang.T("tomorrowLine", temperature(weather.TemperatureTomorrow), temperature(weather.TemperatureMinTomorrow)) + "\n"
 
-	output += fmt.Sprintf("Auringonnousu: %s\nAuringonlasku: %s\n", weather.Sunrise, weather.Sunset)
-	output += fmt.Sprintf("Päivän pituus: %s\n", weather.DayLength)
+	if weather.Ice != nil {
+		for _, m := range weather.Ice.Measurements {
+			output += lang.T("iceMeasurement", m.Place, lang.Number(m.ThicknessCm), lang.FormatDate(m.Measured.In(location))) + "\n"
+		}
+		output += weather.Ice.Notice + "\n"
+	}
 
-	_, err := w.Write([]byte(output))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	output += lang.T("sunrise", weather.Sunrise) + "\n"
+	output += lang.T("sunset", weather.Sunset) + "\n"
+	output += lang.T("dayLength", weather.DayLength) + "\n"
+	output += lang.T("updated", lang.FormatDateTime(weather.LastUpdated.Local())) + "\n"
+	if weather.Station != nil {
+		output += lang.T("station", weather.Station.Name, lang.Number(weather.Station.DistanceKm)) + "\n"
 	}
+
+	return output
 }
 
-func temperatureWithSign(temperature float64) string {
-	if temperature > 0 {
-		return fmt.Sprintf("+%.1f°C", temperature)
+// weatherErrorStatus returns the status of a failure to get the weather of
+// a city, 404 when there is no such city.
+func weatherErrorStatus(err error) int {
+	if errors.Is(err, errUnknownCity) {
+		return http.StatusNotFound
 	}
-	return fmt.Sprintf("%.1f°C", temperature)
+	return http.StatusInternalServerError
 }
 
-func weatherJSONHandler(w http.ResponseWriter, weather WeatherData) {
-	jsonData, err := json.Marshal(weather)
+// provenanceOutput is the JSON output with the source of each field.
+type provenanceOutput struct {
+	WeatherData
+	Provenance map[string]keli.FieldSource `json:"provenance"`
+}
+
+func weatherJSONHandler(w http.ResponseWriter, weather WeatherData, provenance bool) {
+	var data any = weather
+	if provenance {
+		data = provenanceOutput{WeatherData: weather, Provenance: weather.Provenance}
+	}
+	jsonData, err := json.Marshal(data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -480,7 +437,7 @@ func weatherJSONHandler(w http.ResponseWriter, weather WeatherData) {
 }
 
 func weatherPageHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request for %s", r.URL.Path)
+	logf(r.Context(), "Received request for %s", r.URL.Path)
 
 	w.Header().Set("Content-Type", "text/html")
 
@@ -490,29 +447,57 @@ func weatherPageHandler(w http.ResponseWriter, r *http.Request) {
 		city = "Hyvinkää"
 	}
 
-	weather, err := GetWeatherData(city)
+	// scripts written for wttr.in, e.g. /Oulu?format=3
+	if IsWttrFormat(r.URL.Query().Get("format")) {
+		wttrHandler(w, r, city)
+		return
+	}
+
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	day, err := ParseDay(r.URL.Query().Get("day"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Similar template but using a weather-app type styling using tailwindcss
-	tmpl, err := template.ParseFiles("templates/weather.html")
+	theme, err := requestTheme(w, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
 		return
 	}
 
-	err = tmpl.Execute(w, weather)
+	if config().Electricity != nil {
+		weather.Electricity = currentElectricity()
+	}
+
+	favorites := rememberCity(w, r, weather.City)
+
+	w.WriteHeader(http.StatusOK)
+
+	// Similar template but using a weather-app type styling using tailwindcss
+	context := templateContext{Request: r, Lang: lang, Weather: weather, Theme: theme, Favorites: favorites}
+	if day != "" {
+		context.Day = ForecastDay(weather, day)
+	}
+	err = renderTemplate(w, "weather.html", context, weather)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 func placesHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request for %s", r.URL.Path)
+	logf(r.Context(), "Received request for %s", r.URL.Path)
 
 	w.Header().Set("Content-Type", "text/json")
 
@@ -526,9 +511,14 @@ func placesHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(places)
 }
 
-// GetPlaces returns a list of known places
+// GetPlaces returns a list of known places, read from placesFile of the
+// config or data/places.txt
 func GetPlaces() (places []string, err error) {
-	file, err := os.Open("data/places.txt")
+	path := defaultPlacesFile
+	if configured := config().PlacesFile; configured != "" {
+		path = configured
+	}
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -547,25 +537,129 @@ func GetPlaces() (places []string, err error) {
 }
 
 func main() {
+	// the parsers and the merge log with the request IDs too
+	keli.Logf = logf
+
+	if len(os.Args) > 1 && os.Args[1] == "get" {
+		os.Exit(runGet(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fixtures" {
+		os.Exit(runFixtures(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "loadgen" {
+		os.Exit(runLoadgen(os.Args[2:]))
+	}
+
+	grpcAddr := flag.String("grpc", "", "also serve the gRPC API on this address, e.g. :9090")
+	templateDir := flag.String("templates", "templates", "directory of templates overriding the built-in ones")
+	configFile := flag.String("config", "", "JSON configuration file, see the README")
+	mock := flag.Bool("mock", false, "serve made up weather instead of fetching it, for demos and development")
+	flag.Parse()
+
+	if *mock {
+		StartMock()
+	}
+
+	if *configFile != "" {
+		loaded, err := LoadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Error loading config: %v", err)
+		}
+		loadedConfig.Store(&loaded)
+		configPath = *configFile
+	}
+
+	if err := LoadTemplates(*templateDir); err != nil {
+		log.Fatalf("Error loading templates: %v", err)
+	}
+
+	if err := StartWebhooks(*config()); err != nil {
+		log.Fatalf("Error loading webhooks: %v", err)
+	}
+	StartFrostAlerts(config().Frost)
+	StartAdvisories(config().Advisories)
+	if config().MQTT != nil {
+		StartMQTT(*config().MQTT)
+	}
+	if config().Discord != nil {
+		StartDiscord(*config().Discord)
+	}
+	if config().Mastodon != nil {
+		StartMastodon(*config().Mastodon)
+	}
+	if config().Email != nil {
+		if err := StartDigests(*config().Email); err != nil {
+			log.Fatalf("Error loading digest subscriptions: %v", err)
+		}
+	}
+	if config().History != nil {
+		if err := StartHistory(*config().History); err != nil {
+			log.Fatalf("Error loading history: %v", err)
+		}
+	}
+	if config().Streaks != nil {
+		if err := StartStreaks(*config().Streaks); err != nil {
+			log.Fatalf("Error loading streaks: %v", err)
+		}
+	}
+	if config().Admin != nil {
+		StartAdmin()
+	}
+	go reloadOnHangup()
+
+	if *grpcAddr != "" {
+		go func() {
+			log.Fatal(ServeGRPC(*grpcAddr))
+		}()
+	}
+
 	http.HandleFunc("/", weatherPageHandler)
 	http.HandleFunc("/w", weatherHandler)
 	http.HandleFunc("/api", weatherHandler)
 	http.HandleFunc("/places", placesHandler)
+	http.HandleFunc("/places/suggest", suggestHandler)
+	http.HandleFunc("/search", searchHandler)
+	http.HandleFunc("/icons/", iconHandler)
+	http.HandleFunc("/static/", staticHandler)
+	http.HandleFunc("/graphql", graphqlHandler)
+	http.HandleFunc("/feed", feedHandler)
+	http.HandleFunc("/calendar.ics", calendarHandler)
+	http.HandleFunc("/badge", badgeHandler)
+	http.HandleFunc("/card", cardHandler)
+	http.HandleFunc("/chart", chartHandler)
+	http.HandleFunc("/eink", einkHandler)
+	http.HandleFunc("/widget", widgetHandler)
+	http.HandleFunc("/oembed", oembedHandler)
+	http.HandleFunc("/sw.js", serviceWorkerHandler)
+	http.HandleFunc("/manifest.webmanifest", manifestHandler)
+	http.HandleFunc("/favorites", favoritesHandler)
+	http.HandleFunc("/favorites/", favoriteChangeHandler)
+	http.HandleFunc("/partials/", partialHandler)
+	http.HandleFunc("/events", eventsHandler)
+	http.HandleFunc("/ws", wsHandler)
 	http.HandleFunc("/smoke", smokeHandler)
+	http.HandleFunc("/streaks", streaksHandler)
+	http.HandleFunc("/streaks/", streakHandler)
+	http.HandleFunc("/webhooks", webhooksHandler)
+	http.HandleFunc("/webhooks/", webhookHandler)
+	http.HandleFunc("/ha", haHandler)
+	http.HandleFunc("/discord", discordHandler)
+	http.HandleFunc("/integrations/slack", slackHandler)
+	http.HandleFunc("/digest/", digestHandler)
+	http.HandleFunc("/voice/alexa", alexaHandler)
+	http.HandleFunc("/voice/dialogflow", dialogflowHandler)
+	http.HandleFunc("/sources", sourcesHandler)
+	http.HandleFunc("/score/", scoreHandler)
+	http.HandleFunc("/commute", commuteHandler)
+	http.HandleFunc("/skitracks", skiTracksHandler)
+	http.HandleFunc("/stats", statsHandler)
+	http.HandleFunc("/hdd", hddHandler)
+	http.HandleFunc("/history", historyHandler)
+	http.HandleFunc("/observations", observationsHandler)
+	http.HandleFunc("/radar", radarHandler)
+	http.HandleFunc("/admin", adminHandler)
+	http.HandleFunc("/admin/", adminHandler)
 
 	log.Printf("weather balloon spying on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
-
-func smokeHandler(w http.ResponseWriter, r *http.Request) {
-	loc, _ := time.LoadLocation("Europe/Helsinki")
-	time.Local = loc
-	quitSmokingTime := time.Date(2024, time.April, 21, 18, 20, 0, 0, time.Local)
-	timeSinceQuitSmoking := time.Since(quitSmokingTime)
-	days := int(timeSinceQuitSmoking.Hours())/24 + int(timeSinceQuitSmoking.Minutes())/1440
-	hours := int(timeSinceQuitSmoking.Hours())%24 + int(timeSinceQuitSmoking.Minutes())%60/60
-	minutes := int(timeSinceQuitSmoking.Minutes()) % 60
-	seconds := int(timeSinceQuitSmoking.Seconds()) % 60
-
-	fmt.Fprintf(w, "%d days %d hours %d minutes %d seconds", days, hours, minutes, seconds)
+	log.Fatal(http.ListenAndServe(":8080", withRequestIDs(withAccess(http.DefaultServeMux))))
 }
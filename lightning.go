@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// distance from the city the strikes are counted in (km)
+	lightningRadiusKm = 30
+	// how far back the strikes are counted
+	lightningPeriod = time.Hour
+)
+
+// Lightning is the lightning strikes located by FMI near the city in the
+// last hour.
+type Lightning struct {
+	// Strikes within RadiusKm of the city
+	Strikes  int     `json:"strikes"`
+	RadiusKm float64 `json:"radiusKm"`
+	// Distance from the city to the nearest strike (km), if there was one
+	NearestKm *float64 `json:"nearestKm,omitempty"`
+	// Whether there were strikes near the city, for the thunder rule of the
+	// webhooks
+	ThunderNearby bool `json:"thunderNearby"`
+}
+
+// parseFMIStrikes parses the coordinates of the strikes from the simple
+// lightning observations of FMI, which have an element with the location
+// of the strike for every parameter asked for.
+func parseFMIStrikes(r io.Reader) ([]placeLocation, error) {
+	var strikes []placeLocation
+	decoder := xml.NewDecoder(r)
+	inPos := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			inPos = t.Name.Local == "pos"
+		case xml.EndElement:
+			inPos = false
+		case xml.CharData:
+			fields := strings.Fields(string(t))
+			if !inPos || len(fields) < 2 {
+				continue
+			}
+			lat, err1 := strconv.ParseFloat(fields[0], 64)
+			lon, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 == nil && err2 == nil {
+				strikes = append(strikes, placeLocation{lat, lon})
+			}
+		}
+	}
+	return strikes, nil
+}
+
+// lightningNear counts the strikes within the radius of the coordinates.
+func lightningNear(coordinates placeLocation, strikes []placeLocation) *Lightning {
+	lightning := &Lightning{RadiusKm: lightningRadiusKm}
+	for _, strike := range strikes {
+		distance := distanceKm(coordinates.latitude, coordinates.longitude, strike.latitude, strike.longitude)
+		if distance > lightningRadiusKm {
+			// the corners of the bounding box
+			continue
+		}
+		lightning.Strikes++
+		if lightning.NearestKm == nil || distance < *lightning.NearestKm {
+			lightning.NearestKm = &distance
+		}
+	}
+	if lightning.NearestKm != nil {
+		nearest := roundTo(*lightning.NearestKm, 1)
+		lightning.NearestKm = &nearest
+	}
+	lightning.ThunderNearby = lightning.Strikes > 0
+	return lightning
+}
+
+// FetchLightning returns the strikes near the place in the last hour.
+func FetchLightning(place string, now time.Time) (*Lightning, error) {
+	coordinates, err := locatePlace(place)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"service":        {"WFS"},
+		"version":        {"2.0.0"},
+		"request":        {"getFeature"},
+		"storedquery_id": {"fmi::observations::lightning::simple"},
+		"parameters":     {"peak_current"},
+		"bbox":           {bboxAround(coordinates.latitude, coordinates.longitude, lightningRadiusKm)},
+		"starttime":      {now.Add(-lightningPeriod).UTC().Format(time.RFC3339)},
+		"endtime":        {now.UTC().Format(time.RFC3339)},
+	}
+	req, err := http.NewRequest(http.MethodGet, fmiWFS+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	res, err := fmiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("No lightning for \"%s\": %s", place, res.Status)
+	}
+	strikes, err := parseFMIStrikes(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error in the lightning of \"%s\": %v", place, err)
+	}
+	return lightningNear(coordinates, strikes), nil
+}
+
+// recentLightning returns the strikes near the city, logging the error and
+// returning nil if they can't be had, so that the weather is served
+// without them.
+func recentLightning(ctx context.Context, city string) *Lightning {
+	if offline() {
+		// no FMI when replaying recorded pages or with -mock
+		return nil
+	}
+	lightning, err := FetchLightning(city, time.Now())
+	if err != nil {
+		logf(ctx, "Error getting the lightning near %s: %v", city, err)
+		return nil
+	}
+	return lightning
+}
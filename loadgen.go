@@ -0,0 +1,289 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default mix of formats of the load, about as the server sees them
+const defaultLoadFormats = "json:6,html:3,text:1"
+
+// the largest cities, asked for the most, by population
+var busiestPlaces = []string{"Helsinki", "Espoo", "Tampere", "Vantaa", "Oulu", "Turku", "Jyväskylä", "Kuopio", "Lahti", "Pori"}
+
+// weighted is a choice with its weight, e.g. a city asked for 5 times as
+// often as one of weight 1.
+type weighted struct {
+	Value  string
+	Weight float64
+}
+
+// parseWeighted parses choices like "Helsinki:5,Oulu:2,Kemi", whose
+// weights are 1 unless given.
+func parseWeighted(list string) ([]weighted, error) {
+	var choices []weighted
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		choice := weighted{Value: item, Weight: 1}
+		if i := strings.LastIndex(item, ":"); i >= 0 {
+			weight, err := strconv.ParseFloat(item[i+1:], 64)
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("Invalid weight in \"%s\", expected a positive number", item)
+			}
+			choice = weighted{Value: item[:i], Weight: weight}
+		}
+		choices = append(choices, choice)
+	}
+	if len(choices) == 0 {
+		return nil, fmt.Errorf("Nothing to choose from in \"%s\"", list)
+	}
+	return choices, nil
+}
+
+// zipfPlaces weighs the places like the traffic of a weather service: the
+// largest cities asked for far more often than the long tail of the rest.
+func zipfPlaces(places []string) []weighted {
+	ordered := slices.Clone(busiestPlaces)
+	for _, place := range places {
+		if !slices.Contains(ordered, place) {
+			ordered = append(ordered, place)
+		}
+	}
+	choices := make([]weighted, len(ordered))
+	for i, place := range ordered {
+		choices[i] = weighted{Value: place, Weight: 1 / float64(i+1)}
+	}
+	return choices
+}
+
+// pick returns a choice at random by the weights.
+func pick(random *rand.Rand, choices []weighted) string {
+	total := 0.0
+	for _, c := range choices {
+		total += c.Weight
+	}
+	n := random.Float64() * total
+	for _, c := range choices {
+		if n < c.Weight {
+			return c.Value
+		}
+		n -= c.Weight
+	}
+	return choices[len(choices)-1].Value
+}
+
+// loadPath returns the path asking for the weather of the city in the
+// format, html being the weather page.
+func loadPath(city, format string) string {
+	if format == "html" {
+		return "/" + url.PathEscape(city)
+	}
+	return "/w?city=" + url.QueryEscape(city) + "&format=" + url.QueryEscape(format)
+}
+
+// loadRequest is one request of the load.
+type loadRequest struct {
+	City   string
+	Format string
+}
+
+// loadResult is the outcome of one request of the load.
+type loadResult struct {
+	Format  string
+	Status  int
+	Latency time.Duration
+	Err     error
+}
+
+// percentile returns the latency under which the share p of the sorted
+// latencies are.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+// loadReport writes the outcome of the load: the statuses, the requests a
+// second and the latency percentiles in all and by format.
+func loadReport(w io.Writer, results []loadResult, elapsed time.Duration) {
+	statuses := make(map[string]int)
+	byFormat := make(map[string][]time.Duration)
+	var all []time.Duration
+	var firstErr error
+	for _, r := range results {
+		if r.Err != nil {
+			statuses["error"]++
+			if firstErr == nil {
+				firstErr = r.Err
+			}
+			continue
+		}
+		statuses[strconv.Itoa(r.Status)]++
+		all = append(all, r.Latency)
+		byFormat[r.Format] = append(byFormat[r.Format], r.Latency)
+	}
+
+	fmt.Fprintf(w, "%d requests in %s, %.1f/s\n", len(results), elapsed.Round(time.Millisecond), float64(len(results))/elapsed.Seconds())
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-6s %d\n", name, statuses[name])
+	}
+	if firstErr != nil {
+		fmt.Fprintf(w, "  first error: %v\n", firstErr)
+	}
+
+	fmt.Fprintf(w, "\n%-8s %7s %9s %9s %9s %9s %9s\n", "format", "count", "p50", "p90", "p95", "p99", "max")
+	row := func(name string, latencies []time.Duration) {
+		slices.Sort(latencies)
+		fmt.Fprintf(w, "%-8s %7d", name, len(latencies))
+		for _, p := range []float64{0.5, 0.9, 0.95, 0.99, 1} {
+			fmt.Fprintf(w, " %9s", percentile(latencies, p).Round(100*time.Microsecond))
+		}
+		fmt.Fprintln(w)
+	}
+	formats := make([]string, 0, len(byFormat))
+	for format := range byFormat {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	for _, format := range formats {
+		row(format, byFormat[format])
+	}
+	row("all", all)
+}
+
+// runLoadgen runs `keli loadgen [flags] <url>`, which sends requests for the
+// weather of cities in formats picked by their weights to a running keli
+// from concurrent clients, and reports the latencies. Returns the exit code.
+func runLoadgen(args []string) int {
+	flags := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	citiesFlag := flags.String("cities", "", "cities with weights, e.g. Helsinki:5,Oulu:2,Kemi; by default the places, the largest most often")
+	formatsFlag := flags.String("formats", defaultLoadFormats, "formats with weights, html for the weather page")
+	concurrency := flags.Int("c", 10, "concurrent clients")
+	requests := flags.Int("n", 1000, "requests in all, 0 for no limit")
+	duration := flags.Duration("d", 0, "how long to run, e.g. 30s, 0 for no limit")
+	seed := flags.Int64("seed", 1, "seed of the random choices, the same seed giving the same requests")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: keli loadgen [flags] <url>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		if err == nil {
+			flags.Usage()
+		}
+		return 2
+	}
+
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "keli: %v\n", err)
+		return 1
+	}
+
+	target := strings.TrimSuffix(flags.Arg(0), "/")
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fail(fmt.Errorf("Invalid url \"%s\", expected an http or https URL", target))
+	}
+	if *concurrency < 1 {
+		return fail(fmt.Errorf("Invalid concurrency %d, expected at least 1", *concurrency))
+	}
+	if *requests == 0 && *duration == 0 {
+		return fail(fmt.Errorf("No end to the load, give -n or -d"))
+	}
+
+	var cities []weighted
+	if *citiesFlag != "" {
+		parsed, err := parseWeighted(*citiesFlag)
+		if err != nil {
+			return fail(err)
+		}
+		cities = parsed
+	} else {
+		places, err := GetPlaces()
+		if err != nil {
+			return fail(fmt.Errorf("Error reading the places, give -cities: %v", err))
+		}
+		cities = zipfPlaces(places)
+	}
+	formats, err := parseWeighted(*formatsFlag)
+	if err != nil {
+		return fail(err)
+	}
+
+	// the requests are picked in turn by one goroutine, so that a seed always
+	// gives the same ones whatever the timing
+	random := rand.New(rand.NewSource(*seed))
+	next := make(chan loadRequest)
+	go func() {
+		defer close(next)
+		for i := 0; *requests == 0 || i < *requests; i++ {
+			next <- loadRequest{City: pick(random, cities), Format: pick(random, formats)}
+		}
+	}()
+
+	stop := make(chan struct{})
+	if *duration > 0 {
+		time.AfterFunc(*duration, func() { close(stop) })
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var results []loadResult
+	var resultsMutex sync.Mutex
+	var wg sync.WaitGroup
+	started := time.Now()
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var request loadRequest
+				var ok bool
+				select {
+				case <-stop:
+					return
+				case request, ok = <-next:
+					if !ok {
+						return
+					}
+				}
+				result := loadResult{Format: request.Format}
+				start := time.Now()
+				res, err := client.Get(target + loadPath(request.City, request.Format))
+				if err == nil {
+					_, err = io.Copy(io.Discard, res.Body)
+					res.Body.Close()
+					result.Status = res.StatusCode
+				}
+				result.Latency, result.Err = time.Since(start), err
+
+				resultsMutex.Lock()
+				results = append(results, result)
+				resultsMutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	loadReport(os.Stdout, results, time.Since(started))
+	return 0
+}
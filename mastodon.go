@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// longest Mastodon post by default
+const maxMastodonLength = 500
+
+var mastodonClient = &http.Client{Timeout: 30 * time.Second}
+
+// MastodonConfig is the Mastodon (or other Fediverse server with the
+// Mastodon API) account the morning forecasts of the cities are posted to.
+type MastodonConfig struct {
+	// Address of the server, e.g. https://mastodon.social
+	Server string `json:"server"`
+	// Access token of the account, with the write:statuses and write:media
+	// scopes
+	Token  string   `json:"token"`
+	Cities []string `json:"cities"`
+	// Time of the posts, 07:00 by default
+	Time  string `json:"time"`
+	Lang  string `json:"lang"`
+	Units string `json:"units"`
+	// public, unlisted or private, unlisted by default
+	Visibility string `json:"visibility"`
+	// Address of keli for links to the weather pages, optional
+	BaseURL string `json:"baseUrl"`
+
+	postAt time.Time
+	lang   Language
+	units  keli.UnitSystem
+}
+
+func (m *MastodonConfig) check() error {
+	if u, err := url.Parse(m.Server); err != nil || u.Scheme != "https" {
+		return fmt.Errorf("Invalid server \"%s\", expected an https URL", m.Server)
+	}
+	m.Server = strings.TrimSuffix(m.Server, "/")
+	if m.Token == "" {
+		return fmt.Errorf("Missing 'token'")
+	}
+	if len(m.Cities) == 0 {
+		return fmt.Errorf("Missing 'cities'")
+	}
+	switch m.Visibility {
+	case "":
+		m.Visibility = "unlisted"
+	case "public", "unlisted", "private":
+	default:
+		return fmt.Errorf("Unknown visibility \"%s\", expected public, unlisted or private", m.Visibility)
+	}
+	m.BaseURL = strings.TrimSuffix(m.BaseURL, "/")
+	var err error
+	if m.postAt, err = ParseTimeOfDay(m.Time, "07:00"); err != nil {
+		return err
+	}
+	if m.lang, err = ParseLanguage(m.Lang); err != nil {
+		return err
+	}
+	if m.units, err = keli.ParseUnitSystem(m.Units); err != nil {
+		return err
+	}
+	return nil
+}
+
+// StartMastodon posts the forecast of the cities every morning.
+func StartMastodon(m MastodonConfig) {
+	go EveryDay(m.postAt, func() {
+		for _, city := range m.Cities {
+			if err := postMastodonForecast(m, city); err != nil {
+				log.Printf("Error posting the forecast of %s to Mastodon: %v", city, err)
+			}
+		}
+	})
+}
+
+// MastodonText is the text of the morning post: the day's weather, low and
+// high, wind and rain, and a link to the weather page when keli knows its
+// address.
+func MastodonText(weather WeatherData, lang Language, base string) string {
+	units := weather.Labels()
+	temperature := func(t float64) string {
+		return lang.Temperature(t, units.Temperature)
+	}
+	today := weather.LastUpdated.In(location)
+
+	lines := []string{
+		lang.T("feedTitle", weather.City) + ", " + lang.FormatDate(today),
+		lang.Summary(weather),
+		"",
+		lang.T("temperature", temperature(weather.Temperature), temperature(weather.TemperatureFeelsLike)),
+		lang.T("dayMin", temperature(weather.TemperatureMin)),
+		lang.T("dayMax", temperature(weather.TemperatureMax)),
+		lang.T("wind", weather.WindSpeed, units.WindSpeed, lang.WindDescription(weather.Beaufort)),
+		lang.T("rainfall", lang.Precipitation(weather.Rainfall, weather.Units), units.Precipitation),
+	}
+	text := truncate(strings.Join(lines, "\n"), maxMastodonLength)
+
+	if base != "" {
+		// Mastodon counts every link as 23 characters
+		link := base + "/" + url.PathEscape(weather.City) + "?lang=" + string(lang)
+		text = truncate(text, maxMastodonLength-25) + "\n\n" + link
+	}
+	return text
+}
+
+// postMastodonForecast posts the text of the city's forecast with its share
+// card as the image.
+func postMastodonForecast(m MastodonConfig, city string) error {
+	weather, err := GetWeatherData(context.Background(), city)
+	if err != nil {
+		return err
+	}
+	weather = ConvertUnits(weather, m.units, "")
+	text := MastodonText(weather, m.lang, m.BaseURL)
+
+	var card bytes.Buffer
+	if err := png.Encode(&card, Card(weather, m.lang)); err != nil {
+		return err
+	}
+	media, err := m.uploadMedia(card.Bytes(), ShortText(weather, m.lang))
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"status":      {text},
+		"media_ids[]": {media},
+		"visibility":  {m.Visibility},
+		"language":    {string(m.lang)},
+	}
+	req, err := http.NewRequest(http.MethodPost, m.Server+"/api/v1/statuses", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// the same city isn't posted twice a day even if a request is retried
+	req.Header.Set("Idempotency-Key", "keli-"+keli.SanitizeCityName(city)+"-"+time.Now().In(location).Format(time.DateOnly))
+
+	if _, err := m.do(req); err != nil {
+		return err
+	}
+	log.Printf("Posted the forecast of %s to Mastodon", city)
+	return nil
+}
+
+// uploadMedia uploads the PNG image with its alt text, returning its ID
+// once the server has processed it.
+func (m MastodonConfig) uploadMedia(image []byte, description string) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "keli.png")
+	if err != nil {
+		return "", err
+	}
+	part.Write(image)
+	writer.WriteField("description", description)
+	writer.Close()
+
+	req, err := http.NewRequest(http.MethodPost, m.Server+"/api/v2/media", &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	var media struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	data, err := m.do(req)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(data, &media); err != nil {
+		return "", err
+	}
+
+	// the media is processed in the background while it has no URL
+	for attempt := 0; media.URL == "" && attempt < 10; attempt++ {
+		time.Sleep(time.Second)
+		req, err := http.NewRequest(http.MethodGet, m.Server+"/api/v1/media/"+media.ID, nil)
+		if err != nil {
+			return "", err
+		}
+		data, err := m.do(req)
+		if err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(data, &media); err != nil {
+			return "", err
+		}
+	}
+	return media.ID, nil
+}
+
+// do sends the request with the access token and returns the body of a
+// successful response.
+func (m MastodonConfig) do(req *http.Request) ([]byte, error) {
+	req.Header.Set("Authorization", "Bearer "+m.Token)
+	res, err := mastodonClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s: %s", res.Status, truncate(string(data), 200))
+	}
+	return data, nil
+}
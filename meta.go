@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// WeatherMeta tells how complete the weather data is: which sources it was
+// merged from, which failed and what is missing.
+type WeatherMeta struct {
+	// Names of the sources the data was merged from
+	Sources []string `json:"sources"`
+	// Sources that failed this time
+	Failed []SourceFailure `json:"failed"`
+	// Share of the fields the sources have that were found, from 0 to 1
+	Completeness float64 `json:"completeness"`
+	// Fields no source had, by their JSON names
+	Missing []string `json:"missing"`
+	// Anything else worth knowing about the data
+	Warnings []Warning `json:"warnings"`
+}
+
+// SourceFailure is a source that failed to give weather data.
+type SourceFailure struct {
+	Source string `json:"source"`
+	// Where it failed, "fetch", "parse", "breaker" when it was skipped
+	// after failing again and again or "ratelimit" when its site had been
+	// fetched too often
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// Warning is something off in the weather data.
+type Warning struct {
+	// "stale" when a group of fields is kept from earlier as its sources
+	// failed, "disagreement" when sources disagree on a field, "anomaly"
+	// when an unbelievable value was dropped, "suspect" when a value that
+	// jumped too much since the last fetch was ignored, "derived" when a
+	// field no source had is derived from the hourly forecast or "flood"
+	// for a flood warning of the city, see floods.go
+	Code string `json:"code"`
+	// The field or group of fields warned about
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// Severity of a flood warning, "minor", "moderate", "severe" or
+	// "extreme", and the area it is for
+	Severity string `json:"severity,omitempty"`
+	Area     string `json:"area,omitempty"`
+}
+
+// weatherWarnings returns the warnings of the weather itself, the flood
+// warnings, leaving out those about the data.
+func weatherWarnings(weather WeatherData) []Warning {
+	if weather.Meta == nil {
+		return nil
+	}
+	var warnings []Warning
+	for _, warning := range weather.Meta.Warnings {
+		if warning.Code == "flood" {
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings
+}
+
+// warningKey identifies a warning of the weather from one refresh to the
+// next.
+func warningKey(warning Warning) string {
+	return warning.Code + "|" + warning.Area + "|" + warning.Message
+}
+
+// buildMeta returns the meta of the weather data merged from the results
+// of the sources, with the groups kept from earlier and the fields derived
+// from the hourly forecast.
+func buildMeta(weather WeatherData, results []sourceData, stale, derived []string) *WeatherMeta {
+	meta := &WeatherMeta{Sources: []string{}, Failed: []SourceFailure{}, Missing: []string{}, Warnings: []Warning{}}
+
+	for _, result := range results {
+		if failure := result.failure(); failure != nil {
+			meta.Failed = append(meta.Failed, *failure)
+			continue
+		}
+		meta.Sources = append(meta.Sources, result.Source)
+		for _, field := range result.Anomalies {
+			meta.Warnings = append(meta.Warnings, Warning{
+				Code:    "anomaly",
+				Field:   field,
+				Message: fmt.Sprintf("Dropped an unbelievable %s from %s", field, result.Source),
+			})
+		}
+		meta.Warnings = append(meta.Warnings, result.Suspects...)
+	}
+
+	fields := keli.MergedFields()
+	meta.Missing = append(meta.Missing, weather.Missing...)
+	meta.Completeness = roundTo(float64(len(fields)-len(meta.Missing))/float64(len(fields)), 2)
+
+	for _, group := range stale {
+		meta.Warnings = append(meta.Warnings, Warning{
+			Code:    "stale",
+			Field:   group,
+			Message: fmt.Sprintf("Kept %s from %s as its sources failed", group, weather.Updated[group].In(location).Format("15:04")),
+		})
+	}
+	for _, field := range derived {
+		meta.Warnings = append(meta.Warnings, Warning{
+			Code:    "derived",
+			Field:   field,
+			Message: fmt.Sprintf("Derived %s from the hourly forecast as no source had it", field),
+		})
+	}
+	for _, field := range sortedKeys(weather.Disagreements) {
+		var values []string
+		for _, source := range sortedKeys(weather.Disagreements[field]) {
+			values = append(values, fmt.Sprintf("%s %v", source, weather.Disagreements[field][source]))
+		}
+		meta.Warnings = append(meta.Warnings, Warning{
+			Code:    "disagreement",
+			Field:   field,
+			Message: fmt.Sprintf("Sources disagree on %s: %s", field, strings.Join(values, ", ")),
+		})
+	}
+	return meta
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// how often the streaks are checked for milestones reached
+const milestoneCheckInterval = time.Minute
+
+// StreakMilestone is a time a streak reaches: 1 week, 30 days and each
+// full year from its start.
+type StreakMilestone struct {
+	// "1 week", "30 days", "1 year", "2 years" and so on
+	Name string    `json:"name"`
+	At   time.Time `json:"at"`
+	// Full years of the milestones of years
+	Years int `json:"years,omitempty"`
+}
+
+// StreakNotification is the JSON body posted to the webhook when a streak
+// reaches a milestone.
+type StreakNotification struct {
+	Streak    string    `json:"streak"`
+	Start     time.Time `json:"start"`
+	Milestone string    `json:"milestone"`
+	At        time.Time `json:"at"`
+	// Text describing the notification, e.g. "smoke: 30 days without a
+	// break"
+	Text string `json:"text"`
+}
+
+// milestoneAfter returns the first milestone of the streak after the time,
+// counted in the days of its timezone.
+func (s Streak) milestoneAfter(t time.Time) StreakMilestone {
+	start := s.start.In(s.zone)
+	if at := start.AddDate(0, 0, 7); at.After(t) {
+		return StreakMilestone{Name: "1 week", At: at}
+	}
+	if at := start.AddDate(0, 0, 30); at.After(t) {
+		return StreakMilestone{Name: "30 days", At: at}
+	}
+	years := max(t.In(s.zone).Year()-start.Year(), 1)
+	at := start.AddDate(years, 0, 0)
+	if !at.After(t) {
+		years++
+		at = start.AddDate(years, 0, 0)
+	}
+	if years == 1 {
+		return StreakMilestone{Name: "1 year", At: at, Years: 1}
+	}
+	return StreakMilestone{Name: fmt.Sprintf("%d years", years), At: at, Years: years}
+}
+
+// Milestone returns the name of the milestone, e.g. "30 päivää".
+func (l Language) Milestone(m StreakMilestone) string {
+	switch {
+	case m.Years > 1:
+		return l.T("milestoneYears", m.Years)
+	case m.Years == 1:
+		return l.T("milestoneYear")
+	case m.Name == "30 days":
+		return l.T("milestone30Days")
+	}
+	return l.T("milestoneWeek")
+}
+
+// watchMilestones notifies of the milestones the streaks reach while keli
+// runs, checking them every milestoneCheckInterval.
+func watchMilestones(c StreaksConfig) {
+	ticker := time.NewTicker(milestoneCheckInterval)
+	defer ticker.Stop()
+
+	checked := time.Now()
+	for now := range ticker.C {
+		streaksMutex.Lock()
+		current := slices.Clone(streaks)
+		streaksMutex.Unlock()
+
+		for _, s := range current {
+			if s.start.After(checked) {
+				// started again since, the milestones start over
+				continue
+			}
+			milestone := s.milestoneAfter(checked)
+			if milestone.At.After(now) {
+				continue
+			}
+			text := c.lang.T("streakMilestone", s.Name, c.lang.Milestone(milestone))
+			go c.Notify.send("streak "+s.Name, text, StreakNotification{
+				Streak:    s.Name,
+				Start:     s.start,
+				Milestone: milestone.Name,
+				At:        milestone.At,
+				Text:      text,
+			})
+		}
+		checked = now
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// mockMode is set by -mock: the weather is made up by MockWeather instead
+// of fetched, and nothing upstream is touched.
+var mockMode bool
+
+// mockHours and mockDays are the lengths of the made up forecasts.
+const (
+	mockHours = 24
+	mockDays  = 7
+)
+
+// errUnknownCity is returned for a city that is not in the places with
+// -mock, which has no site to ask whether it exists.
+var errUnknownCity = errors.New("Unknown city")
+
+// mockTransport refuses every request, so that nothing upstream is fetched
+// with -mock.
+type mockTransport struct{}
+
+func (mockTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("Not fetching %s with -mock", r.URL.Host)
+}
+
+// StartMock makes up the weather from now on instead of fetching it, and
+// keeps the clients of FMI and the other data from upstream from fetching
+// anything. Webhooks and the like still go out.
+func StartMock() {
+	mockMode = true
+	for _, client := range []*http.Client{fmiClient, floodClient, radarClient, iceClient, skiTrackClient, spotPriceClient} {
+		client.Transport = mockTransport{}
+	}
+}
+
+// offline tells whether the sites and FMI are left alone, when replaying
+// recorded pages or making up the weather.
+func offline() bool {
+	return replayDir != "" || mockMode
+}
+
+// mockNoise returns a number in [0, 1) fixed by the seed and n.
+func mockNoise(seed uint64, n int64) float64 {
+	// splitmix64
+	x := seed + uint64(n)*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+// mockWeatherAt describes the made up weather of the place at the time,
+// changing smoothly between the values fixed every six hours.
+type mockWeatherAt struct {
+	temperature float64
+	// 0 clear to 1 overcast
+	clouds float64
+	// 0 dry to 1 pouring
+	wetness float64
+	wind    float64
+}
+
+func mockAt(seed uint64, t time.Time) mockWeatherAt {
+	const period = 6 * 3600
+	unix := t.Unix()
+	knot, into := unix/period, float64(unix%period)/period
+	// smoothstep between the values of the knots around the time
+	into = into * into * (3 - 2*into)
+	smooth := func(salt int64) float64 {
+		a := mockNoise(seed+uint64(salt), knot)
+		b := mockNoise(seed+uint64(salt), knot+1)
+		return a + (b-a)*into
+	}
+
+	local := t.In(location)
+	year := 2 * math.Pi * float64(local.YearDay()-15) / 365
+	hour := float64(local.Hour()) + float64(local.Minute())/60
+	// from about -6 in January to 17 in July, warmest at 15, and further
+	// north or inland up to 3 degrees colder
+	mean := 5.5 - 11.5*math.Cos(year) - 3*mockNoise(seed, -1)
+	swing := 3 + 2*(1-math.Cos(year))/2
+
+	clouds := smooth(1)
+	wetness := math.Max(0, smooth(2)*1.6-0.6) * clouds
+	return mockWeatherAt{
+		temperature: roundTo(mean+swing*math.Cos(2*math.Pi*(hour-15)/24)*(1-clouds/2)+6*(smooth(3)-0.5), 1),
+		clouds:      clouds,
+		wetness:     wetness,
+		wind:        1 + 9*smooth(4),
+	}
+}
+
+// mockSun returns the made up sunrise and sunset of the day, about those of
+// southern Finland.
+func mockSun(day time.Time) (sunrise, sunset time.Time) {
+	local := day.In(location)
+	length := 12 + 6.5*math.Sin(2*math.Pi*float64(local.YearDay()-80)/365)
+	noon := time.Date(local.Year(), local.Month(), local.Day(), 12, 20, 0, 0, location)
+	if noon.IsDST() {
+		noon = noon.Add(time.Hour)
+	}
+	half := time.Duration(length / 2 * float64(time.Hour)).Round(time.Minute)
+	return noon.Add(-half), noon.Add(half)
+}
+
+// symbol returns the symbol code of the made up weather, day or night by
+// the sun.
+func (m mockWeatherAt) symbol(t time.Time) string {
+	code := "d"
+	if sunrise, sunset := mockSun(t); t.Before(sunrise) || !t.Before(sunset) {
+		code = "n"
+	}
+	clouds := min(int(m.clouds*5), 4)
+	intensity := 0
+	if m.wetness > 0.05 && clouds >= 2 {
+		intensity = min(1+int(m.wetness*3), 3)
+	}
+	kind := 0
+	switch {
+	case intensity == 0:
+	case m.temperature < keli.SnowTemperature:
+		kind = 2
+	case m.temperature < keli.SleetTemperature:
+		kind = 1
+	}
+	return fmt.Sprintf("%s%d%d%d", code, clouds, intensity, kind)
+}
+
+// rainfall returns the made up rainfall of an hour (mm).
+func (m mockWeatherAt) rainfall() float64 {
+	if m.wetness <= 0.05 || m.clouds < 0.4 {
+		return 0
+	}
+	return roundTo(m.wetness*3, 1)
+}
+
+// mockPlace returns the known place the city is, by its name in any case
+// and with or without the dots, see foldPlace.
+func mockPlace(city string) (string, error) {
+	places, err := GetPlaces()
+	if err != nil {
+		return "", err
+	}
+	for _, place := range places {
+		if foldPlace(place) == foldPlace(city) {
+			return place, nil
+		}
+	}
+	return "", fmt.Errorf("%w \"%s\"", errUnknownCity, city)
+}
+
+// MockWeather returns made up but believable weather of the city at the
+// time, always the same for the same city and time, for demos and
+// development without the sites. Only the known places have weather, see
+// GetPlaces.
+func MockWeather(city string, now time.Time) (keli.Weather, error) {
+	name, err := mockPlace(city)
+	if err != nil {
+		return keli.Weather{}, err
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(foldPlace(name)))
+	seed := hash.Sum64()
+
+	now = now.In(location)
+	hour := now.Truncate(time.Hour)
+	current := mockAt(seed, now)
+	code := current.symbol(now)
+
+	weather := keli.Weather{
+		City:                 name,
+		ObservationHour:      now.Hour(),
+		WeatherSummary:       LangFinnish.SymbolDescription(code),
+		SymbolCode:           code,
+		WeatherSymbol:        keli.WeatherSymbolEmoji(code),
+		Temperature:          current.temperature,
+		TemperatureFeelsLike: roundTo(current.temperature-current.wind/3, 1),
+		Rainfall:             current.rainfall(),
+		WindSpeed:            int(math.Round(current.wind)),
+		RainChance:           int(math.Round(math.Min(current.clouds*60+current.wetness*100, 100))),
+	}
+	if code[3] == '2' {
+		weather.Snowfall, weather.Rainfall = weather.Rainfall, 0
+	}
+
+	for i := 1; i <= mockHours; i++ {
+		at := hour.Add(time.Duration(i) * time.Hour)
+		m := mockAt(seed, at)
+		code := m.symbol(at)
+		weather.HourlyForecast = append(weather.HourlyForecast, keli.HourlyForecast{
+			Hour:                 fmt.Sprint(at.Hour()),
+			SymbolCode:           code,
+			WeatherSymbol:        keli.WeatherSymbolEmoji(code),
+			Temperature:          m.temperature,
+			TemperatureFeelsLike: roundTo(m.temperature-m.wind/3, 1),
+			WindSpeed:            int(math.Round(m.wind)),
+			Rainfall:             m.rainfall(),
+			RainChance:           int(math.Round(math.Min(m.clouds*60+m.wetness*100, 100))),
+		})
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	for i := range mockDays {
+		day := today.AddDate(0, 0, i)
+		forecast := keli.DailyForecast{Date: day.Format(time.DateOnly), TemperatureMin: math.Inf(1), TemperatureMax: math.Inf(-1)}
+		for h := 0; h < 24; h++ {
+			m := mockAt(seed, day.Add(time.Duration(h)*time.Hour))
+			forecast.TemperatureMin = math.Min(forecast.TemperatureMin, m.temperature)
+			forecast.TemperatureMax = math.Max(forecast.TemperatureMax, m.temperature)
+			forecast.Rainfall += m.rainfall()
+		}
+		forecast.Rainfall = roundTo(forecast.Rainfall, 1)
+		afternoon := day.Add(14 * time.Hour)
+		forecast.SymbolCode = mockAt(seed, afternoon).symbol(afternoon)
+		forecast.WeatherSymbol = keli.WeatherSymbolEmoji(forecast.SymbolCode)
+		weather.DailyForecast = append(weather.DailyForecast, forecast)
+	}
+	weather.TemperatureMin = weather.DailyForecast[0].TemperatureMin
+	weather.TemperatureMax = weather.DailyForecast[0].TemperatureMax
+	weather.TemperatureMinTomorrow = weather.DailyForecast[1].TemperatureMin
+	weather.TemperatureTomorrow = weather.DailyForecast[1].TemperatureMax
+
+	sunrise, sunset := mockSun(now)
+	weather.Sunrise = fmt.Sprintf("%d:%02d", sunrise.Hour(), sunrise.Minute())
+	weather.Sunset = fmt.Sprintf("%d:%02d", sunset.Hour(), sunset.Minute())
+	length := sunset.Sub(sunrise)
+	weather.DayLength = fmt.Sprintf("%02d:%02d", int(length.Hours()), int(length.Minutes())%60)
+
+	for field := range keli.NumericFields {
+		weather.SetPresent(field)
+	}
+	return weather, nil
+}
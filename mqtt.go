@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// MQTTConfig is the broker keli publishes the weather of its cities to.
+type MQTTConfig struct {
+	// Broker URL, e.g. tcp://localhost:1883 or ssl://broker:8883
+	Broker   string `json:"broker"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Client ID at the broker, "keli" by default
+	ClientID string `json:"clientId"`
+	// Prefix of the topics, "keli" by default
+	Topic string `json:"topic"`
+	// Cities whose weather is published
+	Cities []string `json:"cities"`
+	// Prefix of the Home Assistant discovery topics, "homeassistant" by
+	// default and "-" for no discovery
+	Discovery string `json:"discovery"`
+}
+
+// haSensor is a numeric field of the weather Home Assistant knows as a
+// sensor.
+type haSensor struct {
+	// JSON name of the field, also the last part of its topic
+	Key  string
+	Name string
+	// Unit of the field in the units of the weather
+	Unit        func(keli.UnitLabels) string
+	DeviceClass string
+	Value       func(WeatherData) float64
+}
+
+var (
+	unitOfTemperature = func(u keli.UnitLabels) string { return u.Temperature }
+	// rain is of the hour, snow the depth of it
+	unitOfRain    = func(u keli.UnitLabels) string { return u.Precipitation + "/h" }
+	unitOfSnow    = func(u keli.UnitLabels) string { return u.Snow }
+	unitOfWind    = func(u keli.UnitLabels) string { return u.WindSpeed }
+	unitOfPercent = func(u keli.UnitLabels) string { return "%" }
+	unitless      = func(u keli.UnitLabels) string { return "" }
+)
+
+var haSensors = []haSensor{
+	{"temperature", "Temperature", unitOfTemperature, "temperature", func(w WeatherData) float64 { return w.Temperature }},
+	{"temperatureFeelsLike", "Feels like", unitOfTemperature, "temperature", func(w WeatherData) float64 { return w.TemperatureFeelsLike }},
+	{"temperatureMin", "Min temperature", unitOfTemperature, "temperature", func(w WeatherData) float64 { return w.TemperatureMin }},
+	{"temperatureMax", "Max temperature", unitOfTemperature, "temperature", func(w WeatherData) float64 { return w.TemperatureMax }},
+	{"rainfall", "Rain", unitOfRain, "precipitation_intensity", func(w WeatherData) float64 { return w.Rainfall }},
+	{"snowfall", "Snow", unitOfSnow, "precipitation", func(w WeatherData) float64 { return w.Snowfall }},
+	{"rainChance", "Rain chance", unitOfPercent, "", func(w WeatherData) float64 { return float64(w.RainChance) }},
+	{"windSpeed", "Wind", unitOfWind, "wind_speed", func(w WeatherData) float64 { return float64(w.WindSpeed) }},
+	{"beaufort", "Beaufort", unitless, "", func(w WeatherData) float64 { return float64(w.Beaufort) }},
+}
+
+func (c *MQTTConfig) check() error {
+	if u, err := url.Parse(c.Broker); err != nil || u.Host == "" {
+		return fmt.Errorf("Invalid broker \"%s\", expected e.g. tcp://localhost:1883", c.Broker)
+	}
+	if len(c.Cities) == 0 {
+		return fmt.Errorf("Missing 'cities'")
+	}
+	if c.ClientID == "" {
+		c.ClientID = "keli"
+	}
+	if c.Topic == "" {
+		c.Topic = "keli"
+	}
+	c.Topic = strings.TrimSuffix(c.Topic, "/")
+	if c.Discovery == "" {
+		c.Discovery = "homeassistant"
+	}
+	return nil
+}
+
+// topicName is the city as a topic level, e.g. "jyvaskyla" for Jyväskylä.
+func topicName(city string) string {
+	return strings.ReplaceAll(strings.ToLower(keli.SanitizeCityName(city)), " ", "_")
+}
+
+// StartMQTT connects to the broker and publishes the weather of the cities
+// whenever it is refreshed. The connection is retried in the background, so
+// a broker that is down doesn't keep keli from starting.
+func StartMQTT(c MQTTConfig) {
+	status := c.Topic + "/status"
+	opts := mqtt.NewClientOptions().
+		AddBroker(c.Broker).
+		SetClientID(c.ClientID).
+		SetUsername(c.Username).
+		SetPassword(c.Password).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(30*time.Second).
+		SetAutoReconnect(true).
+		SetWill(status, "offline", 1, true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			log.Printf("Connected to MQTT broker %s", c.Broker)
+			client.Publish(status, 1, true, "online")
+		}).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			log.Printf("Lost connection to MQTT broker %s: %v", c.Broker, err)
+		})
+
+	client := mqtt.NewClient(opts)
+	client.Connect()
+
+	for _, city := range c.Cities {
+		go func(city string) {
+			discovered := false
+			WatchWeather(city, func(weather WeatherData) {
+				if !discovered && c.Discovery != "-" {
+					discovered = publishDiscovery(client, c, city)
+				}
+				publishWeather(client, c, city, weather)
+			})
+		}(city)
+	}
+}
+
+// publishWeather publishes the weather as JSON to keli/<city>/state and each
+// sensor field to its own topic, e.g. keli/helsinki/temperature. The messages
+// are retained, so new subscribers get the latest weather right away.
+func publishWeather(client mqtt.Client, c MQTTConfig, city string, weather WeatherData) {
+	prefix := c.Topic + "/" + topicName(city) + "/"
+
+	state, err := json.Marshal(weather)
+	if err != nil {
+		log.Printf("Error encoding weather for %s: %v", city, err)
+		return
+	}
+	client.Publish(prefix+"state", 0, true, state)
+
+	for _, sensor := range haSensors {
+		client.Publish(prefix+sensor.Key, 0, true, fmt.Sprint(sensor.Value(weather)))
+	}
+	client.Publish(prefix+"summary", 0, true, weather.WeatherSummary)
+	client.Publish(prefix+"symbolCode", 0, true, weather.SymbolCode)
+}
+
+// publishDiscovery publishes the Home Assistant discovery config of each
+// sensor of the city, grouping them under one device. Tells whether it got
+// through to the broker.
+func publishDiscovery(client mqtt.Client, c MQTTConfig, city string) bool {
+	name := topicName(city)
+	device := map[string]any{
+		"identifiers":  []string{"keli_" + name},
+		"name":         "Keli " + city,
+		"manufacturer": "keli",
+	}
+
+	for _, sensor := range haSensors {
+		id := "keli_" + name + "_" + strings.ToLower(sensor.Key)
+		discovery := map[string]any{
+			"name":               sensor.Name,
+			"unique_id":          id,
+			"object_id":          id,
+			"state_topic":        c.Topic + "/" + name + "/" + sensor.Key,
+			"availability_topic": c.Topic + "/status",
+			"state_class":        "measurement",
+			"device":             device,
+		}
+		if unit := sensor.Unit(keli.UnitsMetric.Labels()); unit != "" {
+			discovery["unit_of_measurement"] = unit
+		}
+		if sensor.DeviceClass != "" {
+			discovery["device_class"] = sensor.DeviceClass
+		}
+
+		payload, err := json.Marshal(discovery)
+		if err != nil {
+			log.Printf("Error encoding discovery for %s: %v", id, err)
+			return false
+		}
+		token := client.Publish(c.Discovery+"/sensor/"+id+"/config", 1, true, payload)
+		if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+			log.Printf("Error publishing discovery for %s: %v", id, token.Error())
+			return false
+		}
+	}
+	return true
+}
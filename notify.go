@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+)
+
+// telegramAPI is where Telegram messages are sent, the token and method
+// appended.
+var telegramAPI = "https://api.telegram.org/bot"
+
+// Notify is where the notifications of an alert go, any of: a URL POSTed to
+// like a webhook, an email address sent to through the SMTP server of the
+// email section, and a Telegram chat.
+type Notify struct {
+	Webhook  string          `json:"webhook"`
+	Email    string          `json:"email"`
+	Telegram *TelegramTarget `json:"telegram"`
+}
+
+// TelegramTarget is a Telegram chat messaged by a bot.
+type TelegramTarget struct {
+	// Token of the bot from @BotFather
+	Token string `json:"token"`
+	// Chat the bot messages, e.g. "-1001234567890" or "@channel"
+	ChatID string `json:"chatId"`
+}
+
+func (n *Notify) check(email *EmailConfig) error {
+	if n.Webhook == "" && n.Email == "" && n.Telegram == nil {
+		return fmt.Errorf("Missing 'webhook', 'email' or 'telegram'")
+	}
+	if n.Webhook != "" {
+		if u, err := url.Parse(n.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("Invalid webhook \"%s\", expected an http or https URL", n.Webhook)
+		}
+	}
+	if n.Email != "" {
+		if email == nil {
+			return fmt.Errorf("Email alerts need the email section")
+		}
+		if _, err := mail.ParseAddress(n.Email); err != nil {
+			return fmt.Errorf("Invalid email \"%s\"", n.Email)
+		}
+	}
+	if n.Telegram != nil && (n.Telegram.Token == "" || n.Telegram.ChatID == "") {
+		return fmt.Errorf("Missing 'token' or 'chatId' of telegram")
+	}
+	return nil
+}
+
+// send sends the notification of the alert named name everywhere it goes:
+// the notification as JSON to the webhook and its text to the others.
+func (n Notify) send(name, text string, notification any) {
+	if n.Webhook != "" {
+		hook := Webhook{Name: name, URL: n.Webhook, Retries: defaultWebhookRetries}
+		go deliverWebhook(hook, text, notification)
+	}
+	if n.Telegram != nil {
+		// named after the chat rather than the URL, which has the token
+		hook := Webhook{Name: "telegram " + n.Telegram.ChatID, URL: telegramAPI + n.Telegram.Token + "/sendMessage", Retries: defaultWebhookRetries}
+		go deliverWebhook(hook, text, map[string]string{"chat_id": n.Telegram.ChatID, "text": text})
+	}
+	if email := config().Email; n.Email != "" && email != nil {
+		if err := sendEmail(*email, n.Email, text, text, "", ""); err != nil {
+			log.Printf("Error emailing alert %s to %s: %v", name, n.Email, err)
+			return
+		}
+		log.Printf("Emailed alert %s to %s: %s", name, n.Email, text)
+	}
+}
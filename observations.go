@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fmiWFS is the open data service of the Finnish Meteorological Institute
+var fmiWFS = "https://opendata.fmi.fi/wfs"
+
+// fmiParameters are the parameters asked for from the stations and the
+// fields of StationObservations they go in
+var fmiParameters = []string{"t2m", "ws_10min", "wg_10min", "wd_10min", "rh", "p_sea", "r_1h", "snow_aws"}
+
+var fmiClient = &http.Client{Timeout: 10 * time.Second}
+
+// Station is a weather station of the Finnish Meteorological Institute.
+type Station struct {
+	Name string `json:"name"`
+	// Identifier of the station at FMI
+	FMISID    int     `json:"fmisid"`
+	Region    string  `json:"region"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// StationObservations are the latest measurements of a station as it made
+// them, unlike the weather merged from the sources. A measurement the
+// station doesn't make is null. The values are metric.
+type StationObservations struct {
+	Station Station `json:"station"`
+	// Distance from the city asked for to the station (km)
+	DistanceKm *float64 `json:"distanceKm,omitempty"`
+	// The time of the latest measurement
+	Time time.Time `json:"time"`
+	// Temperature (C) and relative humidity (%)
+	Temperature *float64 `json:"temperature"`
+	Humidity    *float64 `json:"humidity"`
+	// Mean wind speed and gusts of 10 minutes (m/s) and the direction the
+	// wind blows from (degrees)
+	WindSpeed     *float64 `json:"windSpeed"`
+	WindGust      *float64 `json:"windGust"`
+	WindDirection *float64 `json:"windDirection"`
+	// Air pressure at sea level (hPa)
+	Pressure *float64 `json:"pressure"`
+	// Rain of the last hour (mm)
+	Rainfall *float64 `json:"rainfall"`
+	// Depth of the snow (cm)
+	SnowDepth *float64 `json:"snowDepth"`
+}
+
+type cachedObservations struct {
+	observations StationObservations
+	fetched      time.Time
+}
+
+var (
+	observationsCache      = make(map[string]cachedObservations)
+	observationsCacheMutex sync.Mutex
+)
+
+// fmiQuery returns the query of the latest observations of the station,
+// given by name or FMISID, or of the station nearest to the place.
+func fmiQuery(place string, now time.Time) url.Values {
+	query := url.Values{
+		"service":        {"WFS"},
+		"version":        {"2.0.0"},
+		"request":        {"getFeature"},
+		"storedquery_id": {"fmi::observations::weather::timevaluepair"},
+		"parameters":     {strings.Join(fmiParameters, ",")},
+		"starttime":      {now.Add(-2 * time.Hour).UTC().Format(time.RFC3339)},
+		"maxlocations":   {"1"},
+	}
+	if _, err := strconv.Atoi(place); err == nil {
+		query.Set("fmisid", place)
+	} else {
+		query.Set("place", place)
+	}
+	return query
+}
+
+// parseFMIObservations parses the station and its latest measurements from
+// the timevaluepair XML of FMI. The elements are looked up by their local
+// names, so the many namespaces don't matter.
+func parseFMIObservations(r io.Reader) (StationObservations, error) {
+	var o StationObservations
+	values := make(map[string]*float64)
+	decoder := xml.NewDecoder(r)
+
+	var path []string
+	var nameCodeSpace, series, measuredAt string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return o, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			for _, attr := range t.Attr {
+				switch {
+				case t.Name.Local == "name" && attr.Name.Local == "codeSpace":
+					nameCodeSpace = attr.Value
+				case t.Name.Local == "MeasurementTimeseries" && attr.Name.Local == "id":
+					// e.g. "obs-obs-1-1-t2m"
+					series = attr.Value[strings.LastIndex(attr.Value, "-")+1:]
+				}
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" || len(path) < 2 {
+				continue
+			}
+			parent, element := path[len(path)-2], path[len(path)-1]
+			switch {
+			case parent == "Location" && element == "identifier":
+				o.Station.FMISID, _ = strconv.Atoi(text)
+			case parent == "Location" && element == "name" && strings.HasSuffix(nameCodeSpace, "/name"):
+				o.Station.Name = text
+			case parent == "Location" && element == "region":
+				o.Station.Region = text
+			case element == "pos" && o.Station.Latitude == 0:
+				fields := strings.Fields(text)
+				if len(fields) >= 2 {
+					o.Station.Latitude, _ = strconv.ParseFloat(fields[0], 64)
+					o.Station.Longitude, _ = strconv.ParseFloat(fields[1], 64)
+				}
+			case parent == "MeasurementTVP" && element == "time":
+				measuredAt = text
+			case parent == "MeasurementTVP" && element == "value":
+				value, err := strconv.ParseFloat(text, 64)
+				if err != nil || math.IsNaN(value) {
+					continue
+				}
+				// the series are oldest first, so the last value is the latest
+				values[series] = &value
+				if measured, err := time.Parse(time.RFC3339, measuredAt); err == nil && measured.After(o.Time) {
+					o.Time = measured
+				}
+			}
+		}
+	}
+	if o.Station.Name == "" {
+		return o, fmt.Errorf("No station in the observations")
+	}
+
+	o.Temperature, o.Humidity = values["t2m"], values["rh"]
+	o.WindSpeed, o.WindGust, o.WindDirection = values["ws_10min"], values["wg_10min"], values["wd_10min"]
+	o.Pressure, o.Rainfall, o.SnowDepth = values["p_sea"], values["r_1h"], values["snow_aws"]
+	return o, nil
+}
+
+// FetchObservations returns the latest observations of the station, given
+// by name or FMISID, or of the station nearest to the place, cached for as
+// long as the weather.
+func FetchObservations(place string) (StationObservations, error) {
+	key := foldPlace(place)
+	observationsCacheMutex.Lock()
+	cached, found := observationsCache[key]
+	observationsCacheMutex.Unlock()
+	if found && time.Since(cached.fetched) < cacheTTL() {
+		return cached.observations, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmiWFS+"?"+fmiQuery(place, time.Now()).Encode(), nil)
+	if err != nil {
+		return StationObservations{}, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	res, err := fmiClient.Do(req)
+	if err != nil {
+		return StationObservations{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		// FMI answers an unknown place with 400 and an exception report
+		return StationObservations{}, fmt.Errorf("No observations for \"%s\": %s", place, res.Status)
+	}
+	observations, err := parseFMIObservations(res.Body)
+	if err != nil {
+		return observations, fmt.Errorf("Error in the observations for \"%s\": %v", place, err)
+	}
+
+	observationsCacheMutex.Lock()
+	observationsCache[key] = cachedObservations{observations, time.Now()}
+	observationsCacheMutex.Unlock()
+	return observations, nil
+}
+
+// observationsHandler serves /observations?station= or ?city=, the latest
+// measurements of the station or the one nearest to the city and its
+// distance from the city.
+func observationsHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	place := r.URL.Query().Get("station")
+	city := r.URL.Query().Get("city")
+	if place == "" {
+		place = city
+	}
+	if place == "" {
+		http.Error(w, "Missing 'station' or 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	observations, err := FetchObservations(place)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if place == city {
+		if distance, err := stationDistance(city, observations.Station); err == nil {
+			observations.DistanceKm = &distance
+		} else {
+			logf(r.Context(), "Error locating %s: %v", city, err)
+		}
+	}
+
+	jsonData, err := json.Marshal(observations)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
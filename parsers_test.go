@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// TestParsers parses each page recorded in testdata/fixtures and compares
+// the weather data with its golden file, see checkFixture, so that a
+// selector change breaking a parser fails the tests before it is deployed.
+func TestParsers(t *testing.T) {
+	pages, err := filepath.Glob(filepath.Join("testdata", "fixtures", "*", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := make(map[string]bool)
+	for _, page := range pages {
+		source := filepath.Base(filepath.Dir(page))
+		recorded[source] = true
+		name := strings.TrimSuffix(page, ".html")
+		t.Run(source+"/"+filepath.Base(name), func(t *testing.T) {
+			if err := checkFixture(page, name+".json", false); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	for _, source := range keli.Sources {
+		if !recorded[source.Name] {
+			t.Errorf("No pages of %s in testdata/fixtures, record some with KELI_RECORD=testdata/fixtures", source.Name)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// partials maps the names of /partials/{name} to the templates of the blocks
+// of the weather page they render.
+var partials = map[string]string{
+	"current": "current.html",
+	"hourly":  "hourly.html",
+}
+
+// partialHandler serves /partials/{name}?city=X, a block of the weather page
+// on its own. The page fetches them to refresh the weather in place, and
+// they work as HTMX targets too.
+func partialHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	name, found := partials[strings.TrimPrefix(r.URL.Path, "/partials/")]
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	err = renderTemplate(w, name, templateContext{Request: r, Lang: lang, Weather: weather}, weather)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
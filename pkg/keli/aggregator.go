@@ -0,0 +1,275 @@
+package keli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// how long the weather of a city is kept by default
+	defaultCacheTTL = 5 * time.Minute
+	// how the Aggregator introduces itself to the sites by default
+	defaultUserAgent = "keli (+https://github.com/itsnibsi/keli)"
+)
+
+// Options configure an Aggregator. The zero value fetches all the sources
+// and keeps the weather of a city for 5 minutes, in metric units.
+type Options struct {
+	// Names of the sources to fetch, e.g. "ampparit", all of Sources if
+	// empty
+	Sources []string
+	// How long the weather of a city is kept before fetching it again, 5
+	// minutes if zero, nothing is kept if negative
+	CacheTTL time.Duration
+	// Units is metric (the default) or imperial
+	Units UnitSystem
+	// WindUnit of the wind speeds, by default the one of the unit system
+	WindUnit WindUnit
+	// How each field is merged from the sources, by its JSON name
+	Merge map[string]FieldMerge
+	// Selectors tried after the built-in ones, as in ParseOptions
+	Selectors map[string]map[string][]string
+	// HTTP client the sources are fetched with, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// User-Agent header of the fetches, that of keli if empty
+	UserAgent string
+	// Hooks into fetching the sources
+	Hooks Hooks
+}
+
+// Hooks let a program take part in fetching the sources, as the keli
+// server does for its circuit breakers, the limits of the sites and
+// conditional fetches. Any of them may be nil.
+type Hooks struct {
+	// Fetch returns the page of the city from the source instead of a GET
+	// with HTTPClient. It may return ErrSkipped to leave the source out, or
+	// a *SourceError to name the stage it failed at.
+	Fetch func(ctx context.Context, source Source, city string) (io.ReadCloser, error)
+	// Fetched is called with what each source that wasn't skipped gave,
+	// parsed and validated or failed, before it is merged.
+	Fetched func(ctx context.Context, source Source, city string, result SourceData)
+}
+
+// ErrSkipped is returned by a Fetch hook to leave the source out, as when
+// it is turned off.
+var ErrSkipped = errors.New("Skipped")
+
+// SourceError is why a source failed.
+type SourceError struct {
+	// Where it failed: "fetch", "parse" or what a Fetch hook names
+	Stage string
+	Err   error
+}
+
+func (e *SourceError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// Aggregator gets the weather of cities from the sources. It is safe for
+// concurrent use.
+type Aggregator struct {
+	options Options
+	sources []Source
+	// what is wrong with the options, returned by Get
+	err error
+
+	cache      map[string]Weather
+	cacheMutex sync.Mutex
+}
+
+// New returns an Aggregator with the options. Options that are not valid
+// make Get fail.
+func New(options Options) *Aggregator {
+	a := &Aggregator{options: options, sources: Sources, cache: make(map[string]Weather)}
+	if len(options.Sources) > 0 {
+		a.sources = nil
+		for _, name := range options.Sources {
+			i := SourceIndex(name)
+			if i < 0 {
+				a.err = fmt.Errorf("Unknown source \"%s\"", name)
+				return a
+			}
+			a.sources = append(a.sources, Sources[i])
+		}
+	}
+	if a.options.Units, a.err = ParseUnitSystem(string(options.Units)); a.err != nil {
+		return a
+	}
+	if a.options.WindUnit, a.err = ParseWindUnit(string(options.WindUnit)); a.err != nil {
+		return a
+	}
+	for field, merge := range options.Merge {
+		if a.err = merge.Check(field); a.err != nil {
+			return a
+		}
+	}
+	a.err = CheckSelectors(options.Selectors)
+	return a
+}
+
+// Get returns the weather of the city, fetched from the sources unless it
+// was fetched less than CacheTTL ago.
+func (a *Aggregator) Get(ctx context.Context, city string) (Weather, error) {
+	if a.err != nil {
+		return Weather{}, a.err
+	}
+	city = SanitizeCityName(city)
+
+	ttl := a.options.CacheTTL
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+	a.cacheMutex.Lock()
+	cached, found := a.cache[city]
+	a.cacheMutex.Unlock()
+	if found && time.Since(cached.LastUpdated) < ttl {
+		return ConvertUnits(cached, a.options.Units, a.options.WindUnit), nil
+	}
+
+	data, err := a.FetchSources(ctx, city)
+	if err != nil {
+		return Weather{}, err
+	}
+	weather := a.Merge(ctx, data)
+	if weather.City == "" {
+		errs := []error{fmt.Errorf("No weather data found for city \"%s\"", city)}
+		for _, sd := range data {
+			if sd.Err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", sd.Source, sd.Err))
+			}
+		}
+		return Weather{}, errors.Join(errs...)
+	}
+
+	if ttl > 0 {
+		a.cacheMutex.Lock()
+		a.cache[city] = weather
+		a.cacheMutex.Unlock()
+	}
+	return ConvertUnits(weather, a.options.Units, a.options.WindUnit), nil
+}
+
+// FetchSources fetches the page of the city from each source, whatever is
+// cached, and parses, validates and fills the hourly gaps of the weather
+// of each. The results are in the order of the sources, the failed ones
+// with their Err.
+func (a *Aggregator) FetchSources(ctx context.Context, city string) ([]SourceData, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	city = SanitizeCityName(city)
+
+	var (
+		data      []SourceData
+		dataMutex sync.Mutex
+		wg        sync.WaitGroup
+	)
+	for _, source := range a.sources {
+		wg.Add(1)
+		go func(source Source) {
+			defer wg.Done()
+			sd := a.fetch(ctx, source, city)
+			if errors.Is(sd.Err, ErrSkipped) {
+				return
+			}
+			if sd.Err != nil {
+				Logf(ctx, "Error getting weather data from %s: %v", source.URL+city, sd.Err)
+			}
+			if a.options.Hooks.Fetched != nil {
+				a.options.Hooks.Fetched(ctx, source, city, sd)
+			}
+			dataMutex.Lock()
+			data = append(data, sd)
+			dataMutex.Unlock()
+		}(source)
+	}
+	wg.Wait()
+	// in the order of the sources rather than the order they answered in
+	slices.SortStableFunc(data, func(a, b SourceData) int { return SourceIndex(a.Source) - SourceIndex(b.Source) })
+	return data, nil
+}
+
+// Merge merges what the sources gave as configured, in metric units, with
+// the wind on the Beaufort scale and the kind of precipitation of each
+// hour. The City of the weather is empty if no source had any.
+func (a *Aggregator) Merge(ctx context.Context, data []SourceData) Weather {
+	weather := Merge(ctx, data, a.options.Merge)
+	weather.Units = UnitsMetric
+	weather.WindSpeedUnit = WindMetersPerSecond
+	weather.Beaufort = BeaufortNumber(float64(weather.WindSpeed))
+	weather.LastUpdated = time.Now()
+	ClassifyHours(&weather)
+	return weather
+}
+
+// fetch fetches and parses the page of the city from the source.
+func (a *Aggregator) fetch(ctx context.Context, source Source, city string) SourceData {
+	sd := SourceData{Source: source.Name, Fetched: time.Now()}
+	fetch := a.fetchPage
+	if a.options.Hooks.Fetch != nil {
+		fetch = a.options.Hooks.Fetch
+	}
+	body, err := fetch(ctx, source, city)
+	var page []byte
+	if err == nil {
+		page, err = io.ReadAll(body)
+		body.Close()
+	}
+	if err != nil {
+		var sourceErr *SourceError
+		if !errors.As(err, &sourceErr) && !errors.Is(err, ErrSkipped) {
+			err = &SourceError{Stage: "fetch", Err: err}
+		}
+		sd.Err = err
+		return sd
+	}
+
+	data, err := ParsePage(ctx, source, bytes.NewReader(page), ParseOptions{Selectors: a.options.Selectors, Now: sd.Fetched})
+	if err != nil {
+		sd.Err = &SourceError{Stage: "parse", Err: err}
+		return sd
+	}
+	sd.Anomalies = Validate(ctx, source.Name, &data)
+	FillHourlyGaps(ctx, source.Name, &data)
+	sd.Data = data
+	return sd
+}
+
+// fetchPage fetches the page of the city from the source with the HTTP
+// client of the options.
+func (a *Aggregator) fetchPage(ctx context.Context, source Source, city string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.URL+city, nil)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := a.options.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	client := a.options.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("Unexpected status %s", res.Status)
+	}
+	return res.Body, nil
+}
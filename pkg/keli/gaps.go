@@ -0,0 +1,65 @@
+package keli
+
+import (
+	"context"
+	"math"
+	"strconv"
+)
+
+// longest run of missing hours filled in the hourly forecast, longer gaps
+// are left as they are rather than made up
+const maxHourlyGap = 3
+
+// FillHourlyGaps fills the hours missing between two hours of the hourly
+// forecast of a source, as when a row of the page didn't parse or was
+// dropped as unbelievable, interpolating between the hours around the gap.
+// The hours filled in are marked interpolated. Returns their number.
+func FillHourlyGaps(ctx context.Context, source string, data *Weather) (filled int) {
+	if len(data.HourlyForecast) < 2 {
+		return 0
+	}
+
+	hourly := []HourlyForecast{data.HourlyForecast[0]}
+	for _, next := range data.HourlyForecast[1:] {
+		prev := hourly[len(hourly)-1]
+		from, err1 := strconv.Atoi(prev.Hour)
+		to, err2 := strconv.Atoi(next.Hour)
+		if err1 == nil && err2 == nil {
+			missing := (to-from+24)%24 - 1
+			if missing > 0 && missing <= maxHourlyGap {
+				for i := 1; i <= missing; i++ {
+					hourly = append(hourly, interpolateHour(prev, next, (from+i)%24, float64(i)/float64(missing+1)))
+				}
+				filled += missing
+			}
+		}
+		hourly = append(hourly, next)
+	}
+
+	if filled > 0 {
+		Logf(ctx, "Filled %d missing hours in the hourly forecast of %s from %s", filled, data.City, source)
+	}
+	data.HourlyForecast = hourly
+	return filled
+}
+
+// interpolateHour returns the hour the share t of the way from a to b.
+func interpolateHour(a, b HourlyForecast, hour int, t float64) HourlyForecast {
+	lerp := func(x, y float64) float64 { return x + (y-x)*t }
+	h := HourlyForecast{
+		Hour:                 strconv.Itoa(hour),
+		Temperature:          roundTo(lerp(a.Temperature, b.Temperature), 1),
+		TemperatureFeelsLike: roundTo(lerp(a.TemperatureFeelsLike, b.TemperatureFeelsLike), 1),
+		WindSpeed:            int(math.Round(lerp(float64(a.WindSpeed), float64(b.WindSpeed)))),
+		Rainfall:             roundTo(lerp(a.Rainfall, b.Rainfall), 2),
+		RainChance:           int(math.Round(lerp(float64(a.RainChance), float64(b.RainChance)))),
+		Interpolated:         true,
+	}
+	// the weather can't be blended, it is that of the nearer hour
+	nearer := a
+	if t > 0.5 {
+		nearer = b
+	}
+	h.SymbolCode, h.WeatherSymbol = nearer.SymbolCode, nearer.WeatherSymbol
+	return h
+}
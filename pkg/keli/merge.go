@@ -0,0 +1,290 @@
+package keli
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FieldMerge configures how a field of the weather is merged from the
+// sources, as in the merge section of the config of the keli server.
+type FieldMerge struct {
+	// Names of the sources the field is taken from first, in order. The
+	// rest follow in the order of Sources.
+	Sources []string `json:"sources"`
+	// "first" (the default) takes the value of the first source having the
+	// field, "average" the average of all sources having it
+	Strategy string `json:"strategy"`
+	// Difference between sources flagged as a disagreement, the default of
+	// the field if zero
+	Tolerance float64 `json:"tolerance"`
+}
+
+// FieldConfidence is how much the sources agree on a field.
+type FieldConfidence struct {
+	// "high" when the values are well within the tolerance of the field,
+	// "medium" when within it and "low" when the sources disagree
+	Level string `json:"level"`
+	// Difference between the highest and the lowest value (metric), e.g.
+	// a spread of 4 °C is ±2 °C around the middle
+	Spread float64 `json:"spread"`
+	// Number of sources having the field
+	Sources int `json:"sources"`
+}
+
+// confidenceLevel returns the level of confidence in a field the values of
+// the sources spread over with the tolerance of the field.
+func confidenceLevel(spread, tolerance float64) string {
+	switch {
+	case spread <= tolerance/3:
+		return "high"
+	case spread <= tolerance:
+		return "medium"
+	}
+	return "low"
+}
+
+// NumericField gets and sets a numeric field of the weather.
+type NumericField struct {
+	Get func(Weather) float64
+	Set func(*Weather, float64)
+	// Default difference between sources flagged as a disagreement
+	Tolerance float64
+	// Range of believable values (metric), anything outside is a bad parse
+	Min, Max float64
+}
+
+// NumericFields are the numeric fields of the weather by their JSON names,
+// checked to be believable and compared across the sources.
+var NumericFields = map[string]NumericField{
+	"temperature": {
+		func(w Weather) float64 { return w.Temperature },
+		func(w *Weather, v float64) { w.Temperature = roundTo(v, 1) },
+		3, -60, 50,
+	},
+	"temperatureFeelsLike": {
+		func(w Weather) float64 { return w.TemperatureFeelsLike },
+		func(w *Weather, v float64) { w.TemperatureFeelsLike = roundTo(v, 1) },
+		3, -70, 55,
+	},
+	"temperatureMin": {
+		func(w Weather) float64 { return w.TemperatureMin },
+		func(w *Weather, v float64) { w.TemperatureMin = roundTo(v, 1) },
+		3, -60, 50,
+	},
+	"temperatureMax": {
+		func(w Weather) float64 { return w.TemperatureMax },
+		func(w *Weather, v float64) { w.TemperatureMax = roundTo(v, 1) },
+		3, -60, 50,
+	},
+	"temperatureTomorrow": {
+		func(w Weather) float64 { return w.TemperatureTomorrow },
+		func(w *Weather, v float64) { w.TemperatureTomorrow = roundTo(v, 1) },
+		3, -60, 50,
+	},
+	"temperatureMinTomorrow": {
+		func(w Weather) float64 { return w.TemperatureMinTomorrow },
+		func(w *Weather, v float64) { w.TemperatureMinTomorrow = roundTo(v, 1) },
+		3, -60, 50,
+	},
+	"rainfall": {
+		func(w Weather) float64 { return w.Rainfall },
+		func(w *Weather, v float64) { w.Rainfall = roundTo(v, 2) },
+		2, 0, 200,
+	},
+	"snowfall": {
+		func(w Weather) float64 { return w.Snowfall },
+		func(w *Weather, v float64) { w.Snowfall = roundTo(v, 2) },
+		2, 0, 200,
+	},
+	"windSpeed": {
+		func(w Weather) float64 { return float64(w.WindSpeed) },
+		func(w *Weather, v float64) { w.WindSpeed = int(math.Round(v)) },
+		3, 0, 60,
+	},
+}
+
+// Check checks the merge of the field by its JSON name.
+func (m FieldMerge) Check(field string) error {
+	if fields := MergedFields(); !slices.Contains(fields, field) {
+		return fmt.Errorf("Unknown field \"%s\", expected one of %s", field, strings.Join(fields, ", "))
+	}
+	for _, name := range m.Sources {
+		if SourceIndex(name) < 0 {
+			return fmt.Errorf("Unknown source \"%s\" for %s", name, field)
+		}
+	}
+	switch m.Strategy {
+	case "", "first":
+	case "average":
+		if _, found := NumericFields[field]; !found {
+			return fmt.Errorf("%s can't be averaged", field)
+		}
+	default:
+		return fmt.Errorf("Unknown strategy \"%s\" for %s, expected first or average", m.Strategy, field)
+	}
+	if m.Tolerance < 0 {
+		return fmt.Errorf("Negative tolerance for %s", field)
+	}
+	return nil
+}
+
+// FieldGroups are the fields updated together, by their JSON names. The
+// sources refresh them at very different paces: the current weather every
+// few minutes, the sun times once a day.
+var FieldGroups = map[string][]string{
+	"current":  {"temperature", "temperatureFeelsLike", "observationHour", "symbolCode", "weather", "rainfall", "rainChance", "snowfall", "windSpeed"},
+	"today":    {"temperatureMin", "temperatureMax", "weatherSummary"},
+	"tomorrow": {"temperatureTomorrow", "temperatureMinTomorrow"},
+	"sun":      {"sunrise", "sunset", "dayLength"},
+	"hourly":   {"hourlyForecast"},
+	"daily":    {"dailyForecast"},
+}
+
+// MergedFields returns the JSON names of the fields taken from the sources.
+func MergedFields() []string {
+	fields := []string{"city"}
+	for _, group := range FieldGroups {
+		fields = append(fields, group...)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// Merge merges the weather parsed from the sources into one, each field
+// merged as configured in merge by its JSON name, by default taken from
+// the first source in the order of Sources that has it. Sources that
+// failed are left out.
+func Merge(ctx context.Context, data []SourceData, merge map[string]FieldMerge) (md Weather) {
+	md.Provenance = make(map[string]FieldSource)
+	data = slices.DeleteFunc(slices.Clone(data), func(sd SourceData) bool { return sd.Err != nil })
+
+	// Foreca
+	chooseField(&md, data, merge, "temperatureMax", &md.TemperatureMax, func(d Weather) float64 { return d.TemperatureMax })
+	chooseField(&md, data, merge, "temperatureMin", &md.TemperatureMin, func(d Weather) float64 { return d.TemperatureMin })
+	chooseField(&md, data, merge, "rainfall", &md.Rainfall, func(d Weather) float64 { return d.Rainfall })
+	chooseField(&md, data, merge, "snowfall", &md.Snowfall, func(d Weather) float64 { return d.Snowfall })
+	chooseField(&md, data, merge, "windSpeed", &md.WindSpeed, func(d Weather) int { return d.WindSpeed })
+	chooseField(&md, data, merge, "weatherSummary", &md.WeatherSummary, func(d Weather) string { return d.WeatherSummary })
+	// Moisio
+	chooseField(&md, data, merge, "sunrise", &md.Sunrise, func(d Weather) string { return d.Sunrise })
+	chooseField(&md, data, merge, "sunset", &md.Sunset, func(d Weather) string { return d.Sunset })
+	chooseField(&md, data, merge, "dayLength", &md.DayLength, func(d Weather) string { return d.DayLength })
+	// Ampparit
+	chooseField(&md, data, merge, "city", &md.City, func(d Weather) string { return d.City })
+	chooseField(&md, data, merge, "temperature", &md.Temperature, func(d Weather) float64 { return d.Temperature })
+	chooseField(&md, data, merge, "temperatureFeelsLike", &md.TemperatureFeelsLike, func(d Weather) float64 { return d.TemperatureFeelsLike })
+	chooseField(&md, data, merge, "observationHour", &md.ObservationHour, func(d Weather) int { return d.ObservationHour })
+	chooseField(&md, data, merge, "temperatureTomorrow", &md.TemperatureTomorrow, func(d Weather) float64 { return d.TemperatureTomorrow })
+	chooseField(&md, data, merge, "temperatureMinTomorrow", &md.TemperatureMinTomorrow, func(d Weather) float64 { return d.TemperatureMinTomorrow })
+	chooseField(&md, data, merge, "symbolCode", &md.SymbolCode, func(d Weather) string { return d.SymbolCode })
+	chooseField(&md, data, merge, "weather", &md.WeatherSymbol, func(d Weather) string { return d.WeatherSymbol })
+	chooseField(&md, data, merge, "rainChance", &md.RainChance, func(d Weather) int { return d.RainChance })
+
+	for _, sd := range sourcesByPriority(merge["hourlyForecast"].Sources, data) {
+		if sd.Data.HourlyForecast != nil {
+			md.HourlyForecast = sd.Data.HourlyForecast
+			md.Provenance["hourlyForecast"] = FieldSource{Source: sd.Source, Fetched: sd.Fetched}
+			Logf(ctx, "Hourly forecast: %v", sd.Data.HourlyForecast)
+			break
+		}
+	}
+	for _, sd := range sourcesByPriority(merge["dailyForecast"].Sources, data) {
+		if sd.Data.DailyForecast != nil {
+			md.DailyForecast = sd.Data.DailyForecast
+			md.Provenance["dailyForecast"] = FieldSource{Source: sd.Source, Fetched: sd.Fetched}
+			break
+		}
+	}
+	mergeConsensus(ctx, &md, data, merge)
+	md.UpdateMissing()
+
+	return
+}
+
+// sourcesByPriority returns the data of the sources in the order a field
+// is taken from them: the sources configured for the field first, then the
+// rest in the order of Sources.
+func sourcesByPriority(priority []string, data []SourceData) []SourceData {
+	rank := func(source string) int {
+		if i := slices.Index(priority, source); i >= 0 {
+			return i
+		}
+		return len(priority) + SourceIndex(source)
+	}
+	sorted := slices.Clone(data)
+	slices.SortStableFunc(sorted, func(a, b SourceData) int { return rank(a.Source) - rank(b.Source) })
+	return sorted
+}
+
+// chooseField sets the field from the first source having a value for it,
+// in the priority order of the field. Zero is a value when the source
+// marked the field present.
+func chooseField[T comparable](md *Weather, data []SourceData, merge map[string]FieldMerge, field string, existing *T, get func(Weather) T) {
+	var zero T
+	for _, sd := range sourcesByPriority(merge[field].Sources, data) {
+		if value := get(sd.Data); value != zero || sd.Data.Present(field) {
+			*existing = value
+			md.Provenance[field] = FieldSource{Source: sd.Source, Fetched: sd.Fetched}
+			return
+		}
+	}
+}
+
+// mergeConsensus compares the numeric fields across the sources, rating
+// the confidence in each and flagging the ones they disagree on, and
+// averages the fields configured to be averaged.
+func mergeConsensus(ctx context.Context, md *Weather, data []SourceData, merge map[string]FieldMerge) {
+	for name, field := range NumericFields {
+		tolerance := merge[name].Tolerance
+		if tolerance == 0 {
+			tolerance = field.Tolerance
+		}
+
+		values := make(map[string]float64)
+		var sources []string
+		sum, low, high := 0.0, math.Inf(1), math.Inf(-1)
+		var fetched time.Time
+		for _, sd := range data {
+			value := field.Get(sd.Data)
+			if value == 0 && !sd.Data.Present(name) {
+				continue
+			}
+			values[sd.Source] = value
+			sources = append(sources, sd.Source)
+			sum += value
+			low, high = math.Min(low, value), math.Max(high, value)
+			if sd.Fetched.After(fetched) {
+				fetched = sd.Fetched
+			}
+		}
+		if len(values) < 2 {
+			continue
+		}
+
+		if md.Confidence == nil {
+			md.Confidence = make(map[string]FieldConfidence)
+		}
+		md.Confidence[name] = FieldConfidence{
+			Level:   confidenceLevel(high-low, tolerance),
+			Spread:  roundTo(high-low, 2),
+			Sources: len(values),
+		}
+
+		if high-low > tolerance {
+			Logf(ctx, "Sources disagree on %s of %s: %v", name, md.City, values)
+			if md.Disagreements == nil {
+				md.Disagreements = make(map[string]map[string]float64)
+			}
+			md.Disagreements[name] = values
+		}
+		if merge[name].Strategy == "average" {
+			field.Set(md, sum/float64(len(values)))
+			slices.Sort(sources)
+			md.Provenance[name] = FieldSource{Source: strings.Join(sources, ","), Fetched: fetched}
+		}
+	}
+}
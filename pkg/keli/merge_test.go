@@ -0,0 +1,121 @@
+package keli
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	fetched := time.Date(2024, 10, 16, 14, 0, 0, 0, location)
+	source := func(name string, weather Weather, present ...string) SourceData {
+		weather.SetPresent(present...)
+		return SourceData{Source: name, Fetched: fetched, Data: weather}
+	}
+
+	tests := []struct {
+		name  string
+		data  []SourceData
+		merge map[string]FieldMerge
+		// the merged temperature and wind speed and where they came from
+		temperature          float64
+		windSpeed            int
+		temperatureSource    string
+		disagree, hasMissing []string
+	}{
+		{
+			name: "first source in the order of Sources",
+			data: []SourceData{
+				source("ampparit", Weather{City: "Oulu", Temperature: 5, WindSpeed: 3}),
+				source("foreca", Weather{City: "Oulu", Temperature: 4, WindSpeed: 4}),
+			},
+			temperature: 4, windSpeed: 4, temperatureSource: "foreca",
+			hasMissing: []string{"rainfall"},
+		},
+		{
+			name: "configured sources first",
+			data: []SourceData{
+				source("foreca", Weather{City: "Oulu", Temperature: 4}),
+				source("ampparit", Weather{City: "Oulu", Temperature: 5}),
+			},
+			merge:       map[string]FieldMerge{"temperature": {Sources: []string{"ampparit"}}},
+			temperature: 5, temperatureSource: "ampparit",
+			hasMissing: []string{"windSpeed"},
+		},
+		{
+			name: "zero a source had",
+			data: []SourceData{
+				source("foreca", Weather{City: "Oulu", Temperature: 0}, "temperature"),
+				source("ampparit", Weather{City: "Oulu", Temperature: 1}),
+			},
+			temperature: 0, temperatureSource: "foreca",
+		},
+		{
+			name: "zero no source had",
+			data: []SourceData{
+				source("foreca", Weather{City: "Oulu"}),
+				source("ampparit", Weather{City: "Oulu", Temperature: 1}),
+			},
+			temperature: 1, temperatureSource: "ampparit",
+		},
+		{
+			name: "average",
+			data: []SourceData{
+				source("foreca", Weather{City: "Oulu", Temperature: 4}),
+				source("ampparit", Weather{City: "Oulu", Temperature: 5}),
+			},
+			merge:       map[string]FieldMerge{"temperature": {Strategy: "average"}},
+			temperature: 4.5, temperatureSource: "ampparit,foreca",
+		},
+		{
+			name: "disagreement",
+			data: []SourceData{
+				source("foreca", Weather{City: "Oulu", Temperature: 4}),
+				source("ampparit", Weather{City: "Oulu", Temperature: 12}),
+			},
+			temperature: 4, temperatureSource: "foreca",
+			disagree: []string{"temperature"},
+		},
+		{
+			name: "failed source left out",
+			data: []SourceData{
+				{Source: "foreca", Fetched: fetched, Data: Weather{City: "Oulu", Temperature: 4}, Err: &SourceError{Stage: "parse", Err: errors.New("No temperature")}},
+				source("ampparit", Weather{City: "Oulu", Temperature: 5}),
+			},
+			temperature: 5, temperatureSource: "ampparit",
+		},
+		{
+			name:       "no sources",
+			hasMissing: []string{"city", "temperature"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Merge(context.Background(), test.data, test.merge)
+			if got.Temperature != test.temperature || got.WindSpeed != test.windSpeed {
+				t.Errorf("Got temperature %v and wind %d, want %v and %d", got.Temperature, got.WindSpeed, test.temperature, test.windSpeed)
+			}
+			if source := got.Provenance["temperature"].Source; source != test.temperatureSource {
+				t.Errorf("Got the temperature from \"%s\", want \"%s\"", source, test.temperatureSource)
+			}
+			for _, field := range test.disagree {
+				if _, found := got.Disagreements[field]; !found {
+					t.Errorf("No disagreement on %s in %v", field, got.Disagreements)
+				}
+			}
+			if len(test.disagree) == 0 && len(got.Disagreements) > 0 {
+				t.Errorf("Unexpected disagreements %v", got.Disagreements)
+			}
+			for _, field := range test.hasMissing {
+				if !slices.Contains(got.Missing, field) {
+					t.Errorf("%s not in missing %v", field, got.Missing)
+				}
+			}
+			if test.temperatureSource != "" && slices.Contains(got.Missing, "temperature") {
+				t.Errorf("temperature in missing %v", got.Missing)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package keli
+
+// PrecipitationType is the kind of the precipitation of an hour.
+type PrecipitationType string
+
+const (
+	PrecipitationRain  PrecipitationType = "rain"
+	PrecipitationSleet PrecipitationType = "sleet"
+	PrecipitationSnow  PrecipitationType = "snow"
+)
+
+// Temperatures (C) below which precipitation without a symbol telling its
+// kind is taken to be snow, or sleet
+const (
+	SnowTemperature  = 0.5
+	SleetTemperature = 2.0
+)
+
+// ClassifyPrecipitation returns the kind of the precipitation of an hour by
+// its symbol code, or by the temperature (C) when the symbol has none but
+// the hour has rainfall (mm). Thunder is rain. An hour without either has
+// none.
+func ClassifyPrecipitation(symbolCode string, temperature, rainfall float64) PrecipitationType {
+	if IsWeatherSymbolCode(symbolCode) {
+		switch intensity, kind := symbolCode[2], symbolCode[3]; {
+		case intensity == '4':
+			return PrecipitationRain
+		case intensity >= '1' && intensity <= '3' && kind == '1':
+			return PrecipitationSleet
+		case intensity >= '1' && intensity <= '3' && kind == '2':
+			return PrecipitationSnow
+		case intensity >= '1' && intensity <= '3':
+			return PrecipitationRain
+		}
+	}
+	switch {
+	case rainfall <= 0:
+		return ""
+	case temperature < SnowTemperature:
+		return PrecipitationSnow
+	case temperature < SleetTemperature:
+		return PrecipitationSleet
+	}
+	return PrecipitationRain
+}
+
+// ClassifyHours sets the kind of the precipitation of each hour of the
+// forecast, which must still be metric.
+func ClassifyHours(weather *Weather) {
+	for i := range weather.HourlyForecast {
+		h := &weather.HourlyForecast[i]
+		h.PrecipitationType = ClassifyPrecipitation(h.SymbolCode, h.Temperature, h.Rainfall)
+	}
+}
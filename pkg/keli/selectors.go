@@ -0,0 +1,108 @@
+package keli
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// sourceSelectors are the CSS selectors of the fields parsed from each
+// source. A field can have several, tried in order until one finds the
+// field, so that a layout change of a site only needs a new selector added
+// to the list, or to the Selectors of the options until it is.
+// Fields like "hour.temperature" are looked up within one hour of the
+// "hours" of the forecast.
+var sourceSelectors = map[string]map[string][]string{
+	"foreca": {
+		"temperatureMax": {"#dailybox > div:nth-child(1) > a > div > p.tx > abbr"},
+		"temperatureMin": {"#dailybox > div:nth-child(1) > a > div > p.tn > abbr"},
+		"windSpeed":      {"#dailybox > div:nth-child(1) > a > div > p.w > span > em"},
+		"snowfall":       {"#dailybox > div:nth-child(1) > a > div > div.p > em"},
+		"weatherSummary": {".today .day .txt"},
+	},
+	"ampparit": {
+		"city":                      {".current-weather__location"},
+		"temperature":               {"span.current-weather__temperature"},
+		"temperatureFeelsLike":      {"span.weather-lighter.weather-temperature-feelslike"},
+		"rainfall":                  {".current-weather__precipitation .weather-value"},
+		"observationHour":           {"ol > li:nth-child(1) > div.weather-time > time"},
+		"hours":                     {".weather-hour-selector ol > li"},
+		"hour.time":                 {"time"},
+		"hour.temperature":          {".weather-temperature > span"},
+		"hour.temperatureFeelsLike": {".weather-temperature > span"},
+		"hour.windSpeed":            {".weather-wind > .weather-value"},
+		"hour.rainfall":             {".weather-precipitation-amount"},
+		"hour.rainChance":           {".weather-precipitation-probability", ".weather-precipitation-chance"},
+		"hour.symbol":               {".weather-symbol > span"},
+		"temperatureTomorrow":       {".weekly-weather-list-wrapper:nth-child(2) .weather-temperature"},
+		"temperatureMinTomorrow":    {".weekly-weather-list-wrapper:nth-child(2) .weather-min-temperature"},
+		"days":                      {".weekly-weather-list-wrapper"},
+		"day.temperatureMax":        {".weather-temperature"},
+		"day.temperatureMin":        {".weather-min-temperature"},
+		"day.rainfall":              {".weather-precipitation-amount"},
+		"day.symbol":                {".weather-symbol > span"},
+	},
+	"moisio": {
+		"sunrise":   {"td.tbl0:nth-child(4)"},
+		"sunset":    {"td.tbl0:nth-child(5)"},
+		"dayLength": {"td.tbl0:nth-child(6)"},
+	},
+}
+
+// selectorsOf returns the selectors of the field of the source of the
+// page, the built-in ones first and then those of the options.
+func (p *page) selectorsOf(field string) []string {
+	return slices.Concat(sourceSelectors[p.source][field], p.selectors[p.source][field])
+}
+
+// selectAll returns the elements under sel matching the first selector of
+// the field that matches any.
+func (p *page) selectAll(sel *goquery.Selection, field string) *goquery.Selection {
+	for _, selector := range p.selectorsOf(field) {
+		if found := sel.Find(selector); found.Length() > 0 {
+			return found
+		}
+	}
+	return sel.Find("#keli-nothing")
+}
+
+// selectFirst returns the first element under sel matching the selectors of
+// the field.
+func (p *page) selectFirst(sel *goquery.Selection, field string) *goquery.Selection {
+	return p.selectAll(sel, field).First()
+}
+
+// selectText returns the text of the first element under sel matching the
+// selectors of the field that has any text.
+func (p *page) selectText(sel *goquery.Selection, field string) string {
+	for _, selector := range p.selectorsOf(field) {
+		if text := sel.Find(selector).First().Text(); strings.TrimSpace(text) != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// CheckSelectors checks selectors to be added to the built-in ones, by
+// source and field as in ParseOptions.
+func CheckSelectors(selectors map[string]map[string][]string) error {
+	for source, fields := range selectors {
+		if _, found := sourceSelectors[source]; !found {
+			return fmt.Errorf("Unknown source \"%s\"", source)
+		}
+		for field, list := range fields {
+			if _, found := sourceSelectors[source][field]; !found {
+				return fmt.Errorf("Unknown field \"%s\" of %s", field, source)
+			}
+			for _, selector := range list {
+				if _, err := cascadia.Compile(selector); err != nil {
+					return fmt.Errorf("Invalid selector \"%s\" for %s of %s: %v", selector, field, source, err)
+				}
+			}
+		}
+	}
+	return nil
+}
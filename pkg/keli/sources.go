@@ -0,0 +1,314 @@
+package keli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Source is a site the weather is scraped from.
+type Source struct {
+	// Name of the source in the provenance of the weather
+	Name string
+	// URL of the page of a city without the city, e.g.
+	// "https://www.ampparit.com/saa/"
+	URL   string
+	parse func(*page) (Weather, error)
+}
+
+// Sources are the sources in the order their fields are preferred in.
+var Sources = []Source{
+	{Name: "foreca", URL: "https://www.foreca.fi/Finland/", parse: parseForecaData},
+	{Name: "ampparit", URL: "https://www.ampparit.com/saa/", parse: parseAmpparitData},
+	{Name: "moisio", URL: "http://www.moisio.fi/taivas/aurinko.php?paikka=", parse: parseMoisioData},
+}
+
+// SourceIndex returns the index of the source in Sources, -1 if there is
+// no such source.
+func SourceIndex(name string) int {
+	return slices.IndexFunc(Sources, func(source Source) bool { return source.Name == name })
+}
+
+// ParseOptions configure ParsePage.
+type ParseOptions struct {
+	// Selectors tried after the built-in ones by source and field, for when
+	// a site changes its layout, see selectors.go and CheckSelectors
+	Selectors map[string]map[string][]string
+	// The time the page is parsed at, e.g. for the dates of the daily
+	// forecast, now if zero
+	Now time.Time
+}
+
+// page is a page of a source being parsed.
+type page struct {
+	ctx       context.Context
+	source    string
+	doc       *goquery.Document
+	selectors map[string]map[string][]string
+	now       time.Time
+}
+
+// ParsePage parses the weather from a page of the source.
+func ParsePage(ctx context.Context, source Source, r io.Reader, options ParseOptions) (Weather, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return Weather{}, err
+	}
+	p := &page{ctx: ctx, source: source.Name, doc: doc, selectors: options.Selectors, now: options.Now}
+	if p.now.IsZero() {
+		p.now = time.Now()
+	}
+	return source.parse(p)
+}
+
+func parseForecaData(p *page) (data Weather, err error) {
+	// Temperature max
+	tempMaxText := p.selectText(p.doc.Selection, "temperatureMax")
+	// the min and max are derived from the hourly forecast if they fail
+	tempMax, err := cleanTemperatureString(tempMaxText)
+	if err != nil {
+		Logf(p.ctx, "Foreca - Error parsing temperature max: %v", err)
+	} else {
+		data.TemperatureMax = tempMax
+		data.SetPresent("temperatureMax")
+	}
+
+	// Temperature min
+	tempMinText := p.selectText(p.doc.Selection, "temperatureMin")
+	tempMin, err := cleanTemperatureString(tempMinText)
+	if err != nil {
+		Logf(p.ctx, "Foreca - Error parsing temperature min: %v", err)
+	} else {
+		data.TemperatureMin = tempMin
+		data.SetPresent("temperatureMin")
+	}
+
+	// Wind speed
+	windSpeedText := p.selectText(p.doc.Selection, "windSpeed")
+	windSpeed, err := strconv.Atoi(windSpeedText)
+	if err != nil {
+		Logf(p.ctx, "Foreca - Error parsing wind speed: %v", err)
+		return Weather{}, err
+	}
+	data.WindSpeed = windSpeed
+	data.SetPresent("windSpeed")
+
+	// Snowfall, which the page only has when it snows
+	snowfall, err := parseSnowfall(p.selectText(p.doc.Selection, "snowfall"))
+	if err != nil {
+		Logf(p.ctx, "Foreca - Error parsing snowfall: %v", err)
+	} else {
+		data.Snowfall = snowfall
+		data.SetPresent("snowfall")
+	}
+
+	// Weather summarized text
+	weatherSummary := p.selectText(p.doc.Selection, "weatherSummary")
+	data.WeatherSummary = strings.Split(weatherSummary, ".")[0]
+
+	return
+}
+
+// parseSnowfall parses an amount of snow like "2,2" or "2,2 cm", which is
+// no snow when there is none.
+func parseSnowfall(text string) (float64, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseFloat(strings.Replace(fields[0], ",", ".", -1), 64)
+}
+
+func parseAmpparitData(p *page) (data Weather, err error) {
+	// Parse the city name from the document title
+	city := p.selectText(p.doc.Selection, "city")
+	if city == "" {
+		return Weather{}, errors.New("failed to parse city name")
+	}
+	data.City = city
+
+	temperatureText := p.selectText(p.doc.Selection, "temperature")
+	temperature, err := cleanTemperatureString(temperatureText)
+	if err != nil {
+		return Weather{}, err
+	}
+	data.Temperature = temperature
+
+	temperatureFeelsLikeText := p.selectText(p.doc.Selection, "temperatureFeelsLike")
+	temperatureFeelsLike, err := cleanTemperatureString(temperatureFeelsLikeText)
+	if err != nil {
+		return Weather{}, err
+	}
+	data.TemperatureFeelsLike = temperatureFeelsLike
+
+	// Rainfall amount
+	rainfallText := p.selectText(p.doc.Selection, "rainfall")
+	rainfallText = strings.Replace(rainfallText, " mm", "", -1)
+	rainfall, err := strconv.ParseFloat(rainfallText, 64)
+	if err != nil {
+		return Weather{}, err
+	}
+	data.Rainfall = rainfall
+
+	// Updated hour
+	observationHour := p.selectText(p.doc.Selection, "observationHour")
+	observationHourInt, err := strconv.Atoi(observationHour)
+	if err != nil {
+		return Weather{}, err
+	}
+	data.ObservationHour = observationHourInt
+	data.SetPresent("temperature", "temperatureFeelsLike", "rainfall", "observationHour")
+
+	// the next 24 hours, fewer if the page has fewer
+	hours := p.selectAll(p.doc.Selection, "hours")
+	hours = hours.Slice(0, min(hours.Length(), 24))
+	hours.Each(func(i int, s *goquery.Selection) {
+		tempString := p.selectText(s, "hour.temperature")
+		temp, err := cleanTemperatureString(tempString)
+		if err != nil {
+			Logf(p.ctx, "Ampparit - Error parsing hourly temperature: %v", err)
+			return
+		}
+
+		tempFLString := p.selectText(s, "hour.temperatureFeelsLike")
+		tempFL, err := cleanTemperatureString(tempFLString)
+		if err != nil {
+			Logf(p.ctx, "Ampparit - Error parsing hourly temperature FL: %v", err)
+			return
+		}
+
+		windSpeedStr := p.selectText(s, "hour.windSpeed")
+		windSpeed, err := strconv.Atoi(windSpeedStr)
+		if err != nil {
+			Logf(p.ctx, "Ampparit - Error parsing hourly wind speed: %v", err)
+			return
+		}
+
+		rainfallStr := p.selectText(s, "hour.rainfall")
+		rainfallStr = strings.Replace(rainfallStr, " mm", "", -1)
+		rainfall, err := strconv.ParseFloat(rainfallStr, 64)
+		if err != nil {
+			Logf(p.ctx, "Ampparit - Error parsing hourly rainfall: %v", err)
+			return
+		}
+
+		// the chance of rain isn't on every hour, or in summer at all
+		rainChance := 0
+		if rainChanceStr := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(p.selectText(s, "hour.rainChance")), "%")); rainChanceStr != "" {
+			rainChance, err = strconv.Atoi(rainChanceStr)
+			if err != nil {
+				Logf(p.ctx, "Ampparit - Error parsing hourly rain chance: %v", err)
+				rainChance = 0
+			}
+		}
+
+		symbolCode := parseWeatherSymbolCode(p.selectFirst(s, "hour.symbol").AttrOr("class", ""))
+		if _, known := LookupWeatherSymbol(symbolCode); !known {
+			Logf(p.ctx, "Ampparit - Unknown weather symbol code %q", symbolCode)
+		}
+
+		data.HourlyForecast = append(data.HourlyForecast, HourlyForecast{
+			Hour:                 p.selectText(s, "hour.time"),
+			SymbolCode:           symbolCode,
+			WeatherSymbol:        WeatherSymbolEmoji(symbolCode),
+			Temperature:          temp,
+			TemperatureFeelsLike: tempFL,
+			WindSpeed:            windSpeed,
+			Rainfall:             rainfall,
+			RainChance:           rainChance,
+		})
+	})
+
+	// Current weather symbol and chance of rain are those of the first
+	// forecast hour
+	if len(data.HourlyForecast) > 0 {
+		data.SymbolCode = data.HourlyForecast[0].SymbolCode
+		data.WeatherSymbol = data.HourlyForecast[0].WeatherSymbol
+		data.RainChance = data.HourlyForecast[0].RainChance
+		data.SetPresent("rainChance")
+	}
+
+	// Tomorrow weather
+	temperatureTomorrowText := p.selectText(p.doc.Selection, "temperatureTomorrow")
+	temperatureTomorrow, err := cleanTemperatureString(temperatureTomorrowText)
+	if err != nil {
+		return Weather{}, err
+	}
+	data.TemperatureTomorrow = temperatureTomorrow
+
+	temperatureTomorrowMinText := p.selectText(p.doc.Selection, "temperatureMinTomorrow")
+	temperatureTomorrowMinText = strings.Replace(temperatureTomorrowMinText, "alin ", "", -1)
+	temperatureTomorrowMin, err := cleanTemperatureString(temperatureTomorrowMinText)
+	if err != nil {
+		return Weather{}, err
+	}
+	data.TemperatureMinTomorrow = temperatureTomorrowMin
+	data.SetPresent("temperatureTomorrow", "temperatureMinTomorrow")
+
+	// Daily forecast, the first day of the list is today
+	today := p.now.In(location)
+	p.selectAll(p.doc.Selection, "days").Each(func(i int, s *goquery.Selection) {
+		tempMax, err := cleanTemperatureString(p.selectText(s, "day.temperatureMax"))
+		if err != nil {
+			Logf(p.ctx, "Ampparit - Error parsing daily temperature: %v", err)
+			return
+		}
+
+		tempMinText := strings.Replace(p.selectText(s, "day.temperatureMin"), "alin ", "", -1)
+		tempMin, err := cleanTemperatureString(tempMinText)
+		if err != nil {
+			Logf(p.ctx, "Ampparit - Error parsing daily min temperature: %v", err)
+			return
+		}
+
+		// the amount of rain is left out on dry days
+		rainfallStr := strings.Replace(p.selectText(s, "day.rainfall"), " mm", "", -1)
+		rainfall, _ := strconv.ParseFloat(strings.Replace(rainfallStr, ",", ".", -1), 64)
+
+		symbolCode := parseWeatherSymbolCode(p.selectFirst(s, "day.symbol").AttrOr("class", ""))
+
+		data.DailyForecast = append(data.DailyForecast, DailyForecast{
+			Date:           today.AddDate(0, 0, i).Format(time.DateOnly),
+			SymbolCode:     symbolCode,
+			WeatherSymbol:  WeatherSymbolEmoji(symbolCode),
+			TemperatureMax: tempMax,
+			TemperatureMin: tempMin,
+			Rainfall:       rainfall,
+		})
+	})
+
+	data.WeatherSummary = ""
+
+	return
+}
+
+func parseMoisioData(p *page) (data Weather, err error) {
+	data.Sunrise = p.selectText(p.doc.Selection, "sunrise")
+	data.Sunset = p.selectText(p.doc.Selection, "sunset")
+	data.DayLength = p.selectText(p.doc.Selection, "dayLength")
+	return
+}
+
+func cleanTemperatureString(temperature string) (temp float64, err error) {
+	parser := strings.NewReplacer(
+		"°", "",
+		"C", "",
+		"F", "",
+		",", ".",
+	)
+
+	temperature = parser.Replace(temperature)
+	temperature = strings.TrimSpace(temperature)
+
+	temperatureFloat, err := strconv.ParseFloat(temperature, 64)
+	if err != nil {
+		return 0, err
+	}
+	return temperatureFloat, nil
+}
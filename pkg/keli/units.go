@@ -0,0 +1,246 @@
+package keli
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+)
+
+// UnitSystem is the unit system the weather is presented in. Sources always
+// produce metric data, other systems are converted to after merging.
+type UnitSystem string
+
+const (
+	UnitsMetric   UnitSystem = "metric"
+	UnitsImperial UnitSystem = "imperial"
+)
+
+// WindUnit is the unit wind speeds are presented in.
+type WindUnit string
+
+const (
+	WindMetersPerSecond   WindUnit = "m/s"
+	WindKilometersPerHour WindUnit = "km/h"
+	WindMilesPerHour      WindUnit = "mph"
+	WindKnots             WindUnit = "kn"
+	WindBeaufort          WindUnit = "Bft"
+)
+
+// UnitLabels holds the unit suffixes used in text output.
+type UnitLabels struct {
+	Temperature   string
+	WindSpeed     string
+	Precipitation string
+	Snow          string
+}
+
+// ParseUnitSystem parses a unit system like the units query parameter of
+// the keli server. An empty value means metric.
+func ParseUnitSystem(units string) (UnitSystem, error) {
+	switch UnitSystem(units) {
+	case "", UnitsMetric:
+		return UnitsMetric, nil
+	case UnitsImperial:
+		return UnitsImperial, nil
+	}
+	return "", fmt.Errorf("Unknown units \"%s\", expected metric or imperial", units)
+}
+
+// ParseWindUnit parses a wind unit like the wind_unit query parameter of
+// the keli server. An empty value means the default wind unit of the unit
+// system.
+func ParseWindUnit(unit string) (WindUnit, error) {
+	switch strings.ToLower(unit) {
+	case "":
+		return "", nil
+	case "m/s", "ms", "mps":
+		return WindMetersPerSecond, nil
+	case "km/h", "kmh", "kph":
+		return WindKilometersPerHour, nil
+	case "mph":
+		return WindMilesPerHour, nil
+	case "kn", "kt", "knots":
+		return WindKnots, nil
+	case "bft", "beaufort":
+		return WindBeaufort, nil
+	}
+	return "", fmt.Errorf("Unknown wind unit \"%s\", expected m/s, km/h, mph, knots or beaufort", unit)
+}
+
+// WindUnit returns the default wind unit of the unit system.
+func (u UnitSystem) WindUnit() WindUnit {
+	if u == UnitsImperial {
+		return WindMilesPerHour
+	}
+	return WindMetersPerSecond
+}
+
+// Labels returns the unit suffixes of the unit system.
+func (u UnitSystem) Labels() UnitLabels {
+	if u == UnitsImperial {
+		return UnitLabels{Temperature: "°F", WindSpeed: "mph", Precipitation: "in", Snow: "in"}
+	}
+	return UnitLabels{Temperature: "°C", WindSpeed: "m/s", Precipitation: "mm", Snow: "cm"}
+}
+
+// Labels returns the unit suffixes of the weather.
+func (weather Weather) Labels() UnitLabels {
+	labels := weather.Units.Labels()
+	if weather.WindSpeedUnit != "" {
+		labels.WindSpeed = string(weather.WindSpeedUnit)
+	}
+	return labels
+}
+
+// ConvertUnits returns a copy of the metric weather converted to the given
+// unit system. Wind speeds are converted to windUnit, or to the default wind
+// unit of the unit system if it is empty.
+func ConvertUnits(weather Weather, units UnitSystem, windUnit WindUnit) Weather {
+	if weather.Units == units && (windUnit == "" || weather.WindSpeedUnit == windUnit) {
+		return weather
+	}
+	if weather.Units != UnitsMetric || weather.WindSpeedUnit != WindMetersPerSecond {
+		log.Printf("Refusing to convert already converted weather data for %s", weather.City)
+		return weather
+	}
+	if windUnit == "" {
+		windUnit = units.WindUnit()
+	}
+
+	weather.WindSpeedUnit = windUnit
+	weather.WindSpeed = convertWindSpeed(weather.WindSpeed, windUnit)
+
+	// copy the forecast so the cached metric data stays untouched
+	if weather.HourlyForecast != nil {
+		hourly := make([]HourlyForecast, len(weather.HourlyForecast))
+		for i, h := range weather.HourlyForecast {
+			h.WindSpeed = convertWindSpeed(h.WindSpeed, windUnit)
+			hourly[i] = h
+		}
+		weather.HourlyForecast = hourly
+	}
+	weather.Disagreements = convertDisagreements(weather.Disagreements, units, windUnit)
+
+	if units != UnitsImperial {
+		return weather
+	}
+
+	weather.Units = UnitsImperial
+	weather.Temperature = CelsiusToFahrenheit(weather.Temperature)
+	weather.TemperatureFeelsLike = CelsiusToFahrenheit(weather.TemperatureFeelsLike)
+	weather.TemperatureMin = CelsiusToFahrenheit(weather.TemperatureMin)
+	weather.TemperatureMax = CelsiusToFahrenheit(weather.TemperatureMax)
+	weather.TemperatureTomorrow = CelsiusToFahrenheit(weather.TemperatureTomorrow)
+	weather.TemperatureMinTomorrow = CelsiusToFahrenheit(weather.TemperatureMinTomorrow)
+	weather.Rainfall = millimetersToInches(weather.Rainfall)
+	weather.Snowfall = centimetersToInches(weather.Snowfall)
+
+	for i := range weather.HourlyForecast {
+		h := &weather.HourlyForecast[i]
+		h.Temperature = CelsiusToFahrenheit(h.Temperature)
+		h.TemperatureFeelsLike = CelsiusToFahrenheit(h.TemperatureFeelsLike)
+		h.Rainfall = millimetersToInches(h.Rainfall)
+	}
+
+	if weather.DailyForecast != nil {
+		daily := make([]DailyForecast, len(weather.DailyForecast))
+		for i, d := range weather.DailyForecast {
+			d.TemperatureMax = CelsiusToFahrenheit(d.TemperatureMax)
+			d.TemperatureMin = CelsiusToFahrenheit(d.TemperatureMin)
+			d.Rainfall = millimetersToInches(d.Rainfall)
+			daily[i] = d
+		}
+		weather.DailyForecast = daily
+	}
+
+	return weather
+}
+
+// convertDisagreements returns a copy of the metric values of the sources
+// that disagree on a field, converted like the field itself.
+func convertDisagreements(disagreements map[string]map[string]float64, units UnitSystem, windUnit WindUnit) map[string]map[string]float64 {
+	if disagreements == nil {
+		return nil
+	}
+	converted := make(map[string]map[string]float64, len(disagreements))
+	for field, values := range disagreements {
+		convert := func(v float64) float64 { return v }
+		switch {
+		case field == "windSpeed":
+			convert = func(v float64) float64 { return float64(convertWindSpeed(int(math.Round(v)), windUnit)) }
+		case units != UnitsImperial:
+		case strings.HasPrefix(field, "temperature"):
+			convert = CelsiusToFahrenheit
+		case field == "rainfall":
+			convert = millimetersToInches
+		case field == "snowfall":
+			convert = centimetersToInches
+		}
+		converted[field] = make(map[string]float64, len(values))
+		for source, v := range values {
+			converted[field][source] = convert(v)
+		}
+	}
+	return converted
+}
+
+func convertWindSpeed(ms int, unit WindUnit) int {
+	switch unit {
+	case WindKilometersPerHour:
+		return int(math.Round(float64(ms) * 3.6))
+	case WindMilesPerHour:
+		return int(math.Round(float64(ms) * 2.23694))
+	case WindKnots:
+		return int(math.Round(float64(ms) * 1.94384))
+	case WindBeaufort:
+		return BeaufortNumber(float64(ms))
+	}
+	return ms
+}
+
+// beaufortLimits holds the upper limit (m/s) of each Beaufort number.
+// Anything above the last limit is 12.
+var beaufortLimits = []float64{0.3, 1.6, 3.4, 5.5, 8.0, 10.8, 13.9, 17.2, 20.8, 24.5, 28.5, 32.7}
+
+// BeaufortNumber returns the Beaufort number for a wind speed in m/s.
+func BeaufortNumber(ms float64) int {
+	for number, limit := range beaufortLimits {
+		if ms < limit {
+			return number
+		}
+	}
+	return len(beaufortLimits)
+}
+
+// CelsiusToFahrenheit converts a temperature, rounded to a decimal.
+func CelsiusToFahrenheit(c float64) float64 {
+	return roundTo(c*9/5+32, 1)
+}
+
+func fahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+// Celsius returns a temperature in the unit system in Celsius.
+func (u UnitSystem) Celsius(temperature float64) float64 {
+	if u == UnitsImperial {
+		return fahrenheitToCelsius(temperature)
+	}
+	return temperature
+}
+
+func millimetersToInches(mm float64) float64 {
+	return roundTo(mm/25.4, 2)
+}
+
+// centimetersToInches converts the depth of snow, which is in cm unlike
+// the rainfall.
+func centimetersToInches(cm float64) float64 {
+	return roundTo(cm/2.54, 2)
+}
+
+func roundTo(value float64, decimals int) float64 {
+	pow := math.Pow(10, float64(decimals))
+	return math.Round(value*pow) / pow
+}
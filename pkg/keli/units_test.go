@@ -0,0 +1,73 @@
+package keli
+
+import "testing"
+
+func TestConvertUnits(t *testing.T) {
+	metric := Weather{
+		City:          "Oulu",
+		Units:         UnitsMetric,
+		WindSpeedUnit: WindMetersPerSecond,
+		Temperature:   -10,
+		Rainfall:      2.54,
+		Snowfall:      5.08,
+		WindSpeed:     10,
+		HourlyForecast: []HourlyForecast{
+			{Hour: "14", Temperature: 20, Rainfall: 25.4, WindSpeed: 5},
+		},
+		Disagreements: map[string]map[string]float64{
+			"snowfall":    {"foreca": 2.54, "ampparit": 5.08},
+			"temperature": {"foreca": 0, "ampparit": 10},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		units    UnitSystem
+		windUnit WindUnit
+		// the converted temperature, rainfall, snowfall and wind speed
+		temperature, rainfall, snowfall float64
+		windSpeed                       int
+		windSpeedUnit                   WindUnit
+		// the converted hour and disagreement of foreca on the snowfall
+		hourTemperature, hourRainfall float64
+		hourWindSpeed                 int
+		snowfallDisagreement          float64
+	}{
+		{"metric", UnitsMetric, "", -10, 2.54, 5.08, 10, WindMetersPerSecond, 20, 25.4, 5, 2.54},
+		{"metric in km/h", UnitsMetric, WindKilometersPerHour, -10, 2.54, 5.08, 36, WindKilometersPerHour, 20, 25.4, 18, 2.54},
+		{"imperial", UnitsImperial, "", 14, 0.1, 2, 22, WindMilesPerHour, 68, 1, 11, 1},
+		{"imperial in knots", UnitsImperial, WindKnots, 14, 0.1, 2, 19, WindKnots, 68, 1, 10, 1},
+		{"imperial in beaufort", UnitsImperial, WindBeaufort, 14, 0.1, 2, 5, WindBeaufort, 68, 1, 3, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ConvertUnits(metric, test.units, test.windUnit)
+			if got.Temperature != test.temperature || got.Rainfall != test.rainfall || got.Snowfall != test.snowfall {
+				t.Errorf("Got temperature %v, rainfall %v and snowfall %v, want %v, %v and %v",
+					got.Temperature, got.Rainfall, got.Snowfall, test.temperature, test.rainfall, test.snowfall)
+			}
+			if got.WindSpeed != test.windSpeed || got.WindSpeedUnit != test.windSpeedUnit {
+				t.Errorf("Got wind %d %s, want %d %s", got.WindSpeed, got.WindSpeedUnit, test.windSpeed, test.windSpeedUnit)
+			}
+			h := got.HourlyForecast[0]
+			if h.Temperature != test.hourTemperature || h.Rainfall != test.hourRainfall || h.WindSpeed != test.hourWindSpeed {
+				t.Errorf("Got hour %+v, want temperature %v, rainfall %v and wind %d", h, test.hourTemperature, test.hourRainfall, test.hourWindSpeed)
+			}
+			if v := got.Disagreements["snowfall"]["foreca"]; v != test.snowfallDisagreement {
+				t.Errorf("Got snowfall disagreement %v, want %v", v, test.snowfallDisagreement)
+			}
+		})
+	}
+
+	// the cached metric weather the conversions were made from is untouched
+	if metric.HourlyForecast[0].Temperature != 20 || metric.Disagreements["snowfall"]["foreca"] != 2.54 {
+		t.Errorf("Converting changed the metric weather: %+v", metric)
+	}
+}
+
+func TestConvertUnitsConvertedOnce(t *testing.T) {
+	imperial := ConvertUnits(Weather{Units: UnitsMetric, WindSpeedUnit: WindMetersPerSecond, Temperature: 0}, UnitsImperial, "")
+	if got := ConvertUnits(imperial, UnitsImperial, WindKnots); got.Temperature != 32 || got.WindSpeedUnit != WindMilesPerHour {
+		t.Errorf("Converted weather converted again: %+v", got)
+	}
+}
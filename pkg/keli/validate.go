@@ -0,0 +1,51 @@
+package keli
+
+import (
+	"context"
+	"slices"
+)
+
+// Validate drops the values parsed from a source that are outside the
+// believable range of their field, as they are parser bugs rather than
+// weather, before they get merged. Forecast hours and days with such values
+// are dropped whole. Returns the fields dropped.
+func Validate(ctx context.Context, source string, data *Weather) (anomalies []string) {
+	for name, field := range NumericFields {
+		if value := field.Get(*data); !believable(field, value) {
+			Logf(ctx, "Parser anomaly in %s of %s: %s %v is not believable, dropping it", source, data.City, name, value)
+			anomalies = append(anomalies, name)
+			field.Set(data, 0)
+			data.ClearPresent(name)
+		}
+	}
+
+	temperature, feelsLike := NumericFields["temperature"], NumericFields["temperatureFeelsLike"]
+	wind, rainfall := NumericFields["windSpeed"], NumericFields["rainfall"]
+
+	data.HourlyForecast = slices.DeleteFunc(data.HourlyForecast, func(h HourlyForecast) bool {
+		if believable(temperature, h.Temperature) && believable(feelsLike, h.TemperatureFeelsLike) &&
+			believable(wind, float64(h.WindSpeed)) && believable(rainfall, h.Rainfall) &&
+			h.RainChance >= 0 && h.RainChance <= 100 {
+			return false
+		}
+		Logf(ctx, "Parser anomaly in %s of %s: hour %s is not believable, dropping it: %+v", source, data.City, h.Hour, h)
+		anomalies = append(anomalies, "hourlyForecast")
+		return true
+	})
+
+	data.DailyForecast = slices.DeleteFunc(data.DailyForecast, func(d DailyForecast) bool {
+		if believable(temperature, d.TemperatureMax) && believable(temperature, d.TemperatureMin) &&
+			believable(rainfall, d.Rainfall) {
+			return false
+		}
+		Logf(ctx, "Parser anomaly in %s of %s: day %s is not believable, dropping it: %+v", source, data.City, d.Date, d)
+		anomalies = append(anomalies, "dailyForecast")
+		return true
+	})
+
+	return anomalies
+}
+
+func believable(field NumericField, value float64) bool {
+	return value >= field.Min && value <= field.Max
+}
@@ -0,0 +1,200 @@
+// Package keli is the weather aggregation of keli for other Go programs: it
+// fetches the weather of a Finnish city from the sites keli scrapes, parses
+// their pages and merges them into one Weather.
+//
+//	weather, err := keli.New(keli.Options{Units: keli.UnitsImperial}).Get(ctx, "Oulu")
+//
+// The keli server is built on the same parsers and merge, adding the
+// weather of FMI, history, notifications and the pages on top.
+package keli
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+// Logf logs what the parsers and the merge notice, like a value dropped as
+// unbelievable. It logs with log.Printf unless set to something else, the
+// context being that of the Get or of the call.
+var Logf = func(ctx context.Context, format string, args ...any) {
+	log.Printf(format, args...)
+}
+
+// Finnish time, which the times of the sources are in
+var location = mustLoadLocation("Europe/Helsinki")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Fatalf("Error loading location %s: %v", name, err)
+	}
+	return loc
+}
+
+type HourlyForecast struct {
+	Hour                 string  `json:"hour"`
+	SymbolCode           string  `json:"symbolCode"`
+	WeatherSymbol        string  `json:"weather"`
+	Temperature          float64 `json:"temperature"`
+	TemperatureFeelsLike float64 `json:"temperatureFeelsLike"`
+	WindSpeed            int     `json:"windSpeed"`
+	Rainfall             float64 `json:"rainfall"`
+	RainChance           int     `json:"rainChance"`
+	// Chance of thunder (%), which the sources don't have: the keli
+	// server adds it from FMI
+	ThunderChance int `json:"thunderChance"`
+	// Rain, sleet or snow, empty when there is none, see precipitation.go
+	PrecipitationType PrecipitationType `json:"precipitationType,omitempty"`
+	// Whether the hour was missing from the source and is interpolated
+	// from the hours around it, see gaps.go
+	Interpolated bool `json:"interpolated,omitempty"`
+}
+
+// DailyForecast is the forecast of one day of the coming week.
+type DailyForecast struct {
+	// Date of the day, e.g. "2024-04-19"
+	Date           string  `json:"date"`
+	SymbolCode     string  `json:"symbolCode"`
+	WeatherSymbol  string  `json:"weather"`
+	TemperatureMax float64 `json:"temperatureMax"`
+	TemperatureMin float64 `json:"temperatureMin"`
+	Rainfall       float64 `json:"rainfall"`
+	// Highest chance of thunder (%) of the hours of the day
+	ThunderChance int `json:"thunderChance"`
+}
+
+// Weather is the weather of a city as parsed from the sources and merged.
+// The WeatherData of the keli server embeds it, adding what it knows from
+// elsewhere.
+type Weather struct {
+	// Human-readable name of the city we're looking at
+	City string `json:"city"`
+	// The hour the last observation update is from
+	ObservationHour int `json:"observationHour"`
+	// Text description of the weather
+	WeatherSummary string `json:"weatherSummary"`
+	// Symbol code of the current weather, see symbols.go
+	SymbolCode string `json:"symbolCode"`
+	// Emoji of the current weather
+	WeatherSymbol string `json:"weather"`
+	// Current temperature (C)
+	Temperature float64 `json:"temperature"`
+	// How current temperature feels (C)
+	TemperatureFeelsLike float64 `json:"temperatureFeelsLike"`
+	// Today's min temperature (C)
+	TemperatureMin float64 `json:"temperatureMin"`
+	// Today's max temperature (C)
+	TemperatureMax float64 `json:"temperatureMax"`
+	// Amount of rain (mm)
+	Rainfall float64 `json:"rainfall"`
+	// Amount of snow (cm)
+	Snowfall float64 `json:"snowfall"`
+	// Wind speed (m/s)
+	WindSpeed int `json:"windSpeed"`
+	// Unit of the wind speeds, m/s unless converted
+	WindSpeedUnit WindUnit `json:"windSpeedUnit"`
+	// Wind speed on the Beaufort scale
+	Beaufort int `json:"beaufort"`
+	// Rain chance (%)
+	RainChance int `json:"rainChance"`
+	// Tomorrow's temperature (C)
+	TemperatureTomorrow float64 `json:"temperatureTomorrow"`
+	// Tomorrow's min temperature (C)
+	TemperatureMinTomorrow float64 `json:"temperatureMinTomorrow"`
+	// The time the sun rises
+	Sunrise string `json:"sunrise"`
+	// The time the sun sets
+	Sunset string `json:"sunset"`
+	// The length of the day (HH:MM)
+	DayLength string `json:"dayLength"`
+	// Unit system of the values, metric unless converted
+	Units UnitSystem `json:"units"`
+	// The last time the weather was fetched
+	LastUpdated time.Time `json:"lastUpdated"`
+	// Hourly forecast
+	HourlyForecast []HourlyForecast `json:"hourlyForecast"`
+	// Daily forecast, starting from today
+	DailyForecast []DailyForecast `json:"dailyForecast"`
+	// The source of each field by its JSON name, left out of the JSON
+	Provenance map[string]FieldSource `json:"-"`
+	// The values of the sources by field when they disagree, see merge.go
+	Disagreements map[string]map[string]float64 `json:"disagreements,omitempty"`
+	// How much the sources agree on each field more than one has, see
+	// merge.go
+	Confidence map[string]FieldConfidence `json:"confidence,omitempty"`
+	// The fields no source had, by their JSON names, so that their zero
+	// values aren't taken for real ones, see UpdateMissing
+	Missing []string `json:"missing,omitempty"`
+
+	// numeric fields a source parsed, by their JSON names, so that a real 0
+	// isn't taken for a missing value when merging
+	present map[string]bool
+}
+
+// SetPresent marks numeric fields parsed from a source, including zeros.
+func (w *Weather) SetPresent(fields ...string) {
+	if w.present == nil {
+		w.present = make(map[string]bool)
+	}
+	for _, field := range fields {
+		w.present[field] = true
+	}
+}
+
+// Present tells whether the source had the numeric field, even if it is 0.
+// Merged weather has the fields some source had.
+func (w Weather) Present(field string) bool {
+	if _, found := w.Provenance[field]; found {
+		return true
+	}
+	return w.present[field]
+}
+
+// ClearPresent unmarks a numeric field dropped from the data of a source.
+func (w *Weather) ClearPresent(field string) {
+	delete(w.present, field)
+}
+
+// UpdateMissing sets Missing to the merged fields no source is in the
+// Provenance of. Merge calls it, and so should whatever fills in fields
+// after merging.
+func (w *Weather) UpdateMissing() {
+	w.Missing = nil
+	for _, field := range MergedFields() {
+		if _, found := w.Provenance[field]; !found {
+			w.Missing = append(w.Missing, field)
+		}
+	}
+}
+
+// FieldSource tells where a field of the weather came from.
+type FieldSource struct {
+	// Name of the source, e.g. "ampparit"
+	Source string `json:"source"`
+	// The time the source was fetched
+	Fetched time.Time `json:"fetched"`
+}
+
+// SourceData is the weather parsed from one source.
+type SourceData struct {
+	Source  string
+	Fetched time.Time
+	Data    Weather
+	// Fields dropped from Data as unbelievable, see Validate
+	Anomalies []string
+	// Why the source failed, a *SourceError, nil if it didn't
+	Err error
+}
+
+// SanitizeCityName turns a city name into the form the sources have it in
+// their URLs.
+func SanitizeCityName(city string) string {
+	replacer := strings.NewReplacer(
+		"ä", "a",
+		"ö", "o",
+	)
+	return replacer.Replace(city)
+}
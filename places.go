@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// suggestions returned unless the limit parameter asks for another number
+	defaultSuggestions = 8
+	maxSuggestions     = 50
+)
+
+// placeFolder makes place names comparable regardless of case and of
+// typing å, ä and ö without the dots, as in URLs like /Hyvinkaa.
+var placeFolder = strings.NewReplacer("å", "a", "ä", "a", "ö", "o")
+
+func foldPlace(place string) string {
+	return placeFolder.Replace(strings.ToLower(strings.TrimSpace(place)))
+}
+
+// placeRank tells how well a place matches the folded query, lower is
+// better: the whole name, the start of the name, the start of a later word
+// of the name, anywhere in the name. -1 means no match.
+func placeRank(place, query string) int {
+	place = foldPlace(place)
+	switch {
+	case place == query:
+		return 0
+	case strings.HasPrefix(place, query):
+		return 1
+	case strings.Contains(place, " "+query) || strings.Contains(place, "-"+query):
+		return 2
+	case strings.Contains(place, query):
+		return 3
+	}
+	return -1
+}
+
+// SuggestPlaces returns at most limit places matching query, best matches
+// first and otherwise in the order of places.
+func SuggestPlaces(places []string, query string, limit int) []string {
+	query = foldPlace(query)
+	if query == "" {
+		return []string{}
+	}
+
+	type match struct {
+		place string
+		rank  int
+	}
+	var matches []match
+	for _, place := range places {
+		if rank := placeRank(place, query); rank >= 0 {
+			matches = append(matches, match{place, rank})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int { return a.rank - b.rank })
+
+	suggestions := []string{}
+	for _, m := range matches {
+		if len(suggestions) == limit {
+			break
+		}
+		suggestions = append(suggestions, m.place)
+	}
+	return suggestions
+}
+
+// suggestHandler serves /places/suggest?q=X, the places matching what the
+// user has typed in the search box so far.
+func suggestHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	limit := defaultSuggestions
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSuggestions {
+			http.Error(w, fmt.Sprintf("Invalid limit \"%s\", expected 1 to %d", value, maxSuggestions), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	places, err := GetPlaces()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	json.NewEncoder(w).Encode(SuggestPlaces(places, r.URL.Query().Get("q"), limit))
+}
+
+// searchHandler serves /search?q=X, where the search box goes without
+// JavaScript. It redirects to the page of the best matching place, or of
+// the query as typed when no known place matches.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	places, err := GetPlaces()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	city := query
+	if suggestions := SuggestPlaces(places, query, 1); len(suggestions) > 0 {
+		city = suggestions[0]
+	}
+
+	target := "/" + url.PathEscape(city)
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		target += "?lang=" + url.QueryEscape(lang)
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	// fetches of a host a minute and least time between them by default
+	defaultPerMinute  = 30
+	defaultMinDelayMs = 1000
+	// longest a fetch queues for its host before it is given up, and the
+	// cached data served instead
+	defaultMaxWaitMs = 10000
+)
+
+// HostLimit limits how often a host of the sources is fetched.
+type HostLimit struct {
+	// Fetches a minute, 30 by default
+	PerMinute int `json:"perMinute"`
+	// Least time between two fetches, 1000 ms by default
+	MinDelayMs int `json:"minDelayMs"`
+}
+
+func (l *HostLimit) check(host string) error {
+	if l.PerMinute < 0 || l.MinDelayMs < 0 {
+		return fmt.Errorf("Invalid limits of %s, expected positive numbers", host)
+	}
+	if l.PerMinute == 0 {
+		l.PerMinute = defaultPerMinute
+	}
+	if l.MinDelayMs == 0 {
+		l.MinDelayMs = defaultMinDelayMs
+	}
+	return nil
+}
+
+// hostFetches are the start times of the recent and queued fetches of a
+// host.
+type hostFetches struct {
+	starts []time.Time
+}
+
+var (
+	hostFetchTimes      = make(map[string]*hostFetches)
+	hostFetchTimesMutex sync.Mutex
+)
+
+// hostLimit returns the limits of the host, configured or the defaults.
+func hostLimit(host string) HostLimit {
+	if fetch := config().Fetch; fetch != nil {
+		if limit, found := fetch.Hosts[host]; found {
+			return limit
+		}
+	}
+	return HostLimit{PerMinute: defaultPerMinute, MinDelayMs: defaultMinDelayMs}
+}
+
+// maxFetchWait returns how long a fetch may queue for its host.
+func maxFetchWait() time.Duration {
+	ms := defaultMaxWaitMs
+	if fetch := config().Fetch; fetch != nil {
+		ms = fetch.MaxWaitMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// waitForHost waits until the host of the source may be fetched again
+// within its limits, so that keli stays polite to the sites however many
+// requests it gets. Fetches queue in the order they come, and fail when the
+// wait would be longer than allowed.
+func waitForHost(source keli.Source) error {
+	if replayDir != "" {
+		return nil
+	}
+	u, err := url.Parse(source.URL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	limit := hostLimit(host)
+	minDelay := time.Duration(limit.MinDelayMs) * time.Millisecond
+
+	hostFetchTimesMutex.Lock()
+	fetches, found := hostFetchTimes[host]
+	if !found {
+		fetches = &hostFetches{}
+		hostFetchTimes[host] = fetches
+	}
+	now := time.Now()
+	// forget the fetches over a minute ago, only queued ones are later
+	for len(fetches.starts) > 0 && now.Sub(fetches.starts[0]) >= time.Minute {
+		fetches.starts = fetches.starts[1:]
+	}
+	start := now
+	if n := len(fetches.starts); n > 0 {
+		start = later(start, fetches.starts[n-1].Add(minDelay))
+	}
+	if n := len(fetches.starts); n >= limit.PerMinute {
+		start = later(start, fetches.starts[n-limit.PerMinute].Add(time.Minute))
+	}
+	wait := start.Sub(now)
+	if wait > maxFetchWait() {
+		hostFetchTimesMutex.Unlock()
+		return fmt.Errorf("Too many fetches of %s, the next one would wait %s", host, wait.Round(time.Second))
+	}
+	fetches.starts = append(fetches.starts, start)
+	hostFetchTimesMutex.Unlock()
+
+	time.Sleep(wait)
+	return nil
+}
+
+// later returns the later of the times.
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// rateLimited tells whether a source was given up on because of the limits
+// of its host.
+func rateLimited(results []sourceData) bool {
+	for _, result := range results {
+		if failure := result.failure(); failure != nil && failure.Stage == "ratelimit" {
+			return true
+		}
+	}
+	return false
+}
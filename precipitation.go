@@ -0,0 +1,17 @@
+package main
+
+import "github.com/itsnibsi/keli/pkg/keli"
+
+// PrecipitationType returns the name of the kind of precipitation, e.g.
+// "lunta", or "" for none.
+func (l Language) PrecipitationType(t keli.PrecipitationType) string {
+	switch t {
+	case keli.PrecipitationRain:
+		return l.T("precipitationRain")
+	case keli.PrecipitationSleet:
+		return l.T("precipitationSleet")
+	case keli.PrecipitationSnow:
+		return l.T("precipitationSnow")
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+const (
+	// the barometric tendency is the change of the pressure in 3 hours
+	pressureTendencyPeriod = 3 * time.Hour
+	// how far from 3 hours ago a reading may be, scaled to 3 hours
+	pressureTendencySlack = time.Hour
+	// change (hPa in 3 hours) under which the pressure is steady
+	steadyPressureChange = 1.0
+)
+
+// PressureTrend is the barometric tendency, how the air pressure changed in
+// the last 3 hours, from the pressures kept in the history.
+type PressureTrend struct {
+	// "rising", "steady" or "falling"
+	Tendency string `json:"tendency"`
+	// Change of the pressure in 3 hours (hPa)
+	Change float64 `json:"change"`
+}
+
+// Arrow returns an arrow of the tendency for text.
+func (t PressureTrend) Arrow() string {
+	switch t.Tendency {
+	case "rising":
+		return "↑"
+	case "falling":
+		return "↓"
+	}
+	return "→"
+}
+
+// stationPressure returns the air pressure at sea level (hPa) at the
+// station nearest to the city, when history is kept for the trend, or nil.
+func stationPressure(ctx context.Context, city string) *float64 {
+	if config().History == nil || offline() {
+		return nil
+	}
+	observations, err := FetchObservations(city)
+	if err != nil {
+		logf(ctx, "Error getting the air pressure of %s: %v", city, err)
+		return nil
+	}
+	return observations.Pressure
+}
+
+// pressureTrend returns the tendency of the pressure of the city at the
+// time from the pressure kept in the history about 3 hours before, or nil
+// if there is none.
+func pressureTrend(city string, at time.Time, pressure float64) *PressureTrend {
+	historyMutex.Lock()
+	observations := history[foldPlace(city)]
+	historyMutex.Unlock()
+
+	target := at.Add(-pressureTendencyPeriod)
+	var before *Observation
+	for i := len(observations) - 1; i >= 0; i-- {
+		o := &observations[i]
+		if o.Time.Before(target.Add(-pressureTendencySlack)) {
+			break
+		}
+		if o.Pressure == nil || o.Time.After(target.Add(pressureTendencySlack)) {
+			continue
+		}
+		if before == nil || o.Time.Sub(target).Abs() < before.Time.Sub(target).Abs() {
+			before = o
+		}
+	}
+	if before == nil {
+		return nil
+	}
+
+	change := (pressure - *before.Pressure) * float64(pressureTendencyPeriod) / float64(at.Sub(before.Time))
+	trend := &PressureTrend{Tendency: "steady", Change: roundTo(change, 1)}
+	switch {
+	case math.Abs(change) < steadyPressureChange:
+	case change > 0:
+		trend.Tendency = "rising"
+	default:
+		trend.Tendency = "falling"
+	}
+	return trend
+}
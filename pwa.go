@@ -0,0 +1,69 @@
+package main
+
+import (
+	"embed"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+
+	"github.com/fogleman/gg"
+)
+
+//go:embed static/sw.js static/manifest.webmanifest
+var pwaFiles embed.FS
+
+// appIconSizes are the sizes of the home screen icons in the manifest
+var appIconSizes = map[string]int{
+	"app-192.png": 192,
+	"app-512.png": 512,
+}
+
+// AppIcon draws the home screen icon: the partly cloudy icon on a blue
+// background. The icon is kept inside the safe zone of maskable icons.
+func AppIcon(size int) image.Image {
+	s := float64(size)
+	dc := gg.NewContext(size, size)
+	dc.SetHexColor("#4299e1")
+	dc.DrawRectangle(0, 0, s, s)
+	dc.Fill()
+
+	icon, err := IconImage("d200", int(s*0.7))
+	if err != nil {
+		log.Printf("Error drawing app icon: %v", err)
+		return dc.Image()
+	}
+	dc.DrawImageAnchored(icon, size/2, size/2, 0.5, 0.5)
+	return dc.Image()
+}
+
+func appIconHandler(w http.ResponseWriter, size int) {
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if err := png.Encode(w, AppIcon(size)); err != nil {
+		log.Printf("Error writing app icon: %v", err)
+	}
+}
+
+func serviceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	content, err := pwaFiles.ReadFile("static/sw.js")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	// browsers check for a new service worker on every visit
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(content)
+}
+
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	content, err := pwaFiles.ReadFile("static/manifest.webmanifest")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(content)
+}
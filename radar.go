@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// radar of FMI as a WMS, the reflectivity of the composite of Finland
+	defaultRadarURL   = "https://openwms.fmi.fi/geoserver/Radar/wms"
+	defaultRadarLayer = "Radar:suomi_dbz_eureffin"
+	// zoom of the radar image by default, 1 being 200 km around the city and
+	// every level halving it
+	defaultRadarZoom = 2
+	maxRadarZoom     = 5
+	// size of the radar image (px)
+	radarSize = 512
+	// the radar scans every 5 minutes and the images come out a while later
+	radarInterval = 5 * time.Minute
+	// frames of the animation and the time between them
+	radarFrames        = 6
+	radarFrameInterval = 10 * time.Minute
+)
+
+var radarClient = &http.Client{Timeout: 15 * time.Second}
+
+// RadarConfig shows the weather radar around the city on the weather page,
+// see radar.go.
+type RadarConfig struct {
+	// WMS of the radar images, FMI's by default
+	URL   string `json:"url"`
+	Layer string `json:"layer"`
+	// Zoom of the image on the page, from 1 to 5, 2 by default
+	Zoom int `json:"zoom"`
+	// Animate the last hour on the page instead of the latest image
+	Animate bool `json:"animate"`
+}
+
+type cachedRadar struct {
+	image       []byte
+	contentType string
+	fetched     time.Time
+}
+
+var (
+	radarCache      = make(map[string]cachedRadar)
+	radarCacheMutex sync.Mutex
+)
+
+func (rc *RadarConfig) check() error {
+	if rc.URL == "" {
+		rc.URL = defaultRadarURL
+	}
+	if u, err := url.Parse(rc.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid url \"%s\", expected an http or https URL", rc.URL)
+	}
+	if rc.Layer == "" {
+		rc.Layer = defaultRadarLayer
+	}
+	if rc.Zoom == 0 {
+		rc.Zoom = defaultRadarZoom
+	}
+	if rc.Zoom < 1 || rc.Zoom > maxRadarZoom {
+		return fmt.Errorf("Invalid zoom %d, expected 1 to %d", rc.Zoom, maxRadarZoom)
+	}
+	return nil
+}
+
+// radarConfig returns the radar of the config, or FMI's without.
+func radarConfig() RadarConfig {
+	if radar := config().Radar; radar != nil {
+		return *radar
+	}
+	return RadarConfig{URL: defaultRadarURL, Layer: defaultRadarLayer, Zoom: defaultRadarZoom}
+}
+
+// radarBBox returns the bounding box of the zoom around the coordinates.
+func radarBBox(latitude, longitude float64, zoom int) string {
+	return bboxAround(latitude, longitude, 400/math.Pow(2, float64(zoom)))
+}
+
+// fetchRadarImage fetches the radar image of the bounding box at the time,
+// or the latest one when the time is zero.
+func fetchRadarImage(rc RadarConfig, bbox string, at time.Time) (image.Image, error) {
+	query := url.Values{
+		"service":     {"WMS"},
+		"version":     {"1.1.1"},
+		"request":     {"GetMap"},
+		"layers":      {rc.Layer},
+		"styles":      {""},
+		"srs":         {"EPSG:4326"},
+		"bbox":        {bbox},
+		"width":       {strconv.Itoa(radarSize)},
+		"height":      {strconv.Itoa(radarSize)},
+		"format":      {"image/png"},
+		"transparent": {"true"},
+	}
+	if !at.IsZero() {
+		query.Set("time", at.UTC().Format(time.RFC3339))
+	}
+	req, err := http.NewRequest(http.MethodGet, rc.URL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	res, err := radarClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+	// a WMS reports errors as XML with 200 OK, which doesn't decode
+	img, err := png.Decode(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error in the radar image: %v", err)
+	}
+	return img, nil
+}
+
+// compositeRadar draws the radar image over a plain background with a
+// cross marking the city in the middle.
+func compositeRadar(radar image.Image) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, radarSize, radarSize))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{229, 231, 235, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, img.Bounds(), radar, radar.Bounds().Min, draw.Over)
+
+	marker := color.RGBA{220, 38, 38, 255}
+	center := radarSize / 2
+	for d := -8; d <= 8; d++ {
+		for w := -1; w <= 1; w++ {
+			img.Set(center+d, center+w, marker)
+			img.Set(center+w, center+d, marker)
+		}
+	}
+	return img
+}
+
+// RadarImage returns the radar image of the zoom around the city as PNG,
+// or the frames of the last hour as an animated GIF.
+func RadarImage(city string, zoom int, animate bool, now time.Time) (data []byte, contentType string, err error) {
+	rc := radarConfig()
+	coordinates, err := locatePlace(city)
+	if err != nil {
+		return nil, "", err
+	}
+	bbox := radarBBox(coordinates.latitude, coordinates.longitude, zoom)
+
+	var b bytes.Buffer
+	if !animate {
+		radar, err := fetchRadarImage(rc, bbox, time.Time{})
+		if err != nil {
+			return nil, "", err
+		}
+		if err := png.Encode(&b, compositeRadar(radar)); err != nil {
+			return nil, "", err
+		}
+		return b.Bytes(), "image/png", nil
+	}
+
+	// the latest scan surely out, and the ones before it
+	latest := now.Add(-radarInterval).Truncate(radarInterval)
+	animation := &gif.GIF{}
+	for i := radarFrames - 1; i >= 0; i-- {
+		radar, err := fetchRadarImage(rc, bbox, latest.Add(-time.Duration(i)*radarFrameInterval))
+		if err != nil {
+			return nil, "", err
+		}
+		frame := image.NewPaletted(image.Rect(0, 0, radarSize, radarSize), palette.Plan9)
+		draw.Draw(frame, frame.Bounds(), compositeRadar(radar), image.Point{}, draw.Src)
+		animation.Image = append(animation.Image, frame)
+		// hundredths of a second, lingering on the latest
+		delay := 50
+		if i == 0 {
+			delay = 200
+		}
+		animation.Delay = append(animation.Delay, delay)
+	}
+	if err := gif.EncodeAll(&b, animation); err != nil {
+		return nil, "", err
+	}
+	return b.Bytes(), "image/gif", nil
+}
+
+// cachedRadarImage returns the radar image, kept for as long as the radar
+// takes to scan again.
+func cachedRadarImage(city string, zoom int, animate bool) ([]byte, string, error) {
+	key := fmt.Sprintf("%s/%d/%t", foldPlace(city), zoom, animate)
+	radarCacheMutex.Lock()
+	cached, found := radarCache[key]
+	radarCacheMutex.Unlock()
+	if found && time.Since(cached.fetched) < radarInterval {
+		return cached.image, cached.contentType, nil
+	}
+
+	data, contentType, err := RadarImage(city, zoom, animate, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	radarCacheMutex.Lock()
+	radarCache[key] = cachedRadar{data, contentType, time.Now()}
+	radarCacheMutex.Unlock()
+	return data, contentType, nil
+}
+
+// radarPageURL returns the URL of the radar image on the weather page of
+// the city, or "" if the page has no radar.
+func radarPageURL(city string) string {
+	radar := config().Radar
+	if radar == nil {
+		return ""
+	}
+	u := "/radar?city=" + url.QueryEscape(city) + "&zoom=" + strconv.Itoa(radar.Zoom)
+	if radar.Animate {
+		u += "&format=gif"
+	}
+	return u
+}
+
+// radarHandler serves /radar?city=&zoom=&format=, the weather radar around
+// the city as PNG, or with format=gif the last hour as an animated GIF.
+func radarHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	zoom := radarConfig().Zoom
+	if value := r.URL.Query().Get("zoom"); value != "" {
+		var err error
+		zoom, err = strconv.Atoi(value)
+		if err != nil || zoom < 1 || zoom > maxRadarZoom {
+			http.Error(w, fmt.Sprintf("Invalid zoom \"%s\", expected 1 to %d", value, maxRadarZoom), http.StatusBadRequest)
+			return
+		}
+	}
+
+	animate := false
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "png":
+	case "gif":
+		animate = true
+	default:
+		http.Error(w, fmt.Sprintf("Invalid format \"%s\", expected png or gif", format), http.StatusBadRequest)
+		return
+	}
+
+	data, contentType, err := cachedRadarImage(city, zoom, animate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(radarInterval.Seconds())))
+
+	_, err = w.Write(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
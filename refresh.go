@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	// forced refreshes a minute across all cities by default
+	defaultRefreshesPerMinute = 10
+	// least time between forced refreshes of a city by default
+	defaultRefreshCityDelayMs = 60000
+)
+
+// RefreshConfig configures ?refresh=1, which fetches the weather again
+// instead of serving it from the cache, see refresh.go.
+type RefreshConfig struct {
+	// Key a refresh needs as the key parameter or a bearer token, if any
+	Key string `json:"key"`
+	// Refreshes a minute across all cities, 10 by default
+	PerMinute int `json:"perMinute"`
+	// Least time between refreshes of a city, 60000 ms by default
+	CityDelayMs int `json:"cityDelayMs"`
+}
+
+func (c *RefreshConfig) check() error {
+	if c.PerMinute < 0 || c.CityDelayMs < 0 {
+		return fmt.Errorf("Invalid limits, expected positive numbers")
+	}
+	if c.PerMinute == 0 {
+		c.PerMinute = defaultRefreshesPerMinute
+	}
+	if c.CityDelayMs == 0 {
+		c.CityDelayMs = defaultRefreshCityDelayMs
+	}
+	return nil
+}
+
+var (
+	// the last forced refresh of each city, and the times of those in the
+	// last minute
+	cityRefreshes   = make(map[string]time.Time)
+	recentRefreshes []time.Time
+	refreshesMutex  sync.Mutex
+)
+
+// refreshLimits returns the limits of refreshing in use.
+func refreshLimits() RefreshConfig {
+	if refresh := config().Refresh; refresh != nil {
+		return *refresh
+	}
+	return RefreshConfig{PerMinute: defaultRefreshesPerMinute, CityDelayMs: defaultRefreshCityDelayMs}
+}
+
+// checkRefresh tells whether the request asks for a refresh with
+// ?refresh=1, writing the error and returning !ok when it isn't allowed one.
+// Every response it is called for tells how many refreshes are left with
+// the rate limit headers.
+func checkRefresh(w http.ResponseWriter, r *http.Request, city string) (refresh, ok bool) {
+	city = keli.SanitizeCityName(city)
+	limits := refreshLimits()
+	setRateLimitHeaders(w, city, limits)
+
+	value := r.URL.Query().Get("refresh")
+	if value == "" {
+		return false, true
+	}
+	refresh, err := strconv.ParseBool(value)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid refresh \"%s\", expected 1 or 0", value), http.StatusBadRequest)
+		return false, false
+	}
+	if !refresh {
+		return false, true
+	}
+
+	if limits.Key != "" && !validKey(r, limits.Key) {
+		http.Error(w, "Refreshing needs a valid key", http.StatusForbidden)
+		return false, false
+	}
+
+	if wait := reserveRefresh(city, limits); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+		http.Error(w, fmt.Sprintf("Refreshed too often, try again in %s", wait.Round(time.Second)), http.StatusTooManyRequests)
+		return false, false
+	}
+	setRateLimitHeaders(w, city, limits)
+	return true, true
+}
+
+// setRateLimitHeaders sets the headers telling how many refreshes of the
+// city are left and in how many seconds there are all of them again, both
+// as RateLimit-* of the IETF draft and as the older X-RateLimit-*, whose
+// reset is a Unix time.
+func setRateLimitHeaders(w http.ResponseWriter, city string, limits RefreshConfig) {
+	refreshesMutex.Lock()
+	now := time.Now()
+	forgetRefreshes(now)
+	remaining := limits.PerMinute - len(recentRefreshes)
+	var reset time.Duration
+	if len(recentRefreshes) > 0 {
+		reset = recentRefreshes[len(recentRefreshes)-1].Add(time.Minute).Sub(now)
+	}
+	if last, found := cityRefreshes[city]; found {
+		if wait := last.Add(time.Duration(limits.CityDelayMs) * time.Millisecond).Sub(now); wait > 0 {
+			remaining = 0
+			reset = max(reset, wait)
+		}
+	}
+	refreshesMutex.Unlock()
+
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(limits.PerMinute))
+	header.Set("RateLimit-Remaining", strconv.Itoa(max(remaining, 0)))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=60", limits.PerMinute))
+	header.Set("X-RateLimit-Limit", header.Get("RateLimit-Limit"))
+	header.Set("X-RateLimit-Remaining", header.Get("RateLimit-Remaining"))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(reset).Unix(), 10))
+}
+
+// ceilSeconds returns the duration in whole seconds, rounded up.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// validKey tells whether the request has the key as the key parameter or
+// as a bearer token.
+func validKey(r *http.Request, key string) bool {
+	given := r.URL.Query().Get("key")
+	if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		given = bearer
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1
+}
+
+// reserveRefresh records a refresh of the city if it is within the limits,
+// or returns how long until it would be.
+func reserveRefresh(city string, limits RefreshConfig) time.Duration {
+	refreshesMutex.Lock()
+	defer refreshesMutex.Unlock()
+
+	now := time.Now()
+	forgetRefreshes(now)
+	var wait time.Duration
+	if last, found := cityRefreshes[city]; found {
+		wait = last.Add(time.Duration(limits.CityDelayMs) * time.Millisecond).Sub(now)
+	}
+	if len(recentRefreshes) >= limits.PerMinute {
+		wait = max(wait, recentRefreshes[len(recentRefreshes)-limits.PerMinute].Add(time.Minute).Sub(now))
+	}
+	if wait > 0 {
+		return wait
+	}
+	cityRefreshes[city] = now
+	recentRefreshes = append(recentRefreshes, now)
+	return 0
+}
+
+// forgetRefreshes drops the refreshes over a minute old from the recent
+// ones, refreshesMutex must be held.
+func forgetRefreshes(now time.Time) {
+	for len(recentRefreshes) > 0 && now.Sub(recentRefreshes[0]) >= time.Minute {
+		recentRefreshes = recentRefreshes[1:]
+	}
+}
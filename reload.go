@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// reloadMutex keeps reloads from running at the same time.
+var reloadMutex sync.Mutex
+
+// ReloadConfig loads the config file again and puts it in use, keeping the
+// one in use if the file has errors. Requests being served finish with the
+// config they started with where they already read it. The webhooks, frost
+// alerts and advisories read their thresholds from the config in use, the
+// cities new to them being watched from the reload on. The sections used
+// only when keli starts, like MQTT and the history, keep running as they
+// were: changes to them are logged as taking effect after a restart.
+func ReloadConfig() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	if configPath == "" {
+		return fmt.Errorf("No config file to reload, keli was started without -config")
+	}
+	loaded, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	old := config()
+	if restart := restartSections(*old, loaded); len(restart) > 0 {
+		log.Printf("Changes to %s of %s take effect after a restart", strings.Join(restart, ", "), configPath)
+	}
+	if loaded.Admin != nil && old.Admin == nil {
+		StartAdmin()
+	}
+	loadedConfig.Store(&loaded)
+	for _, hook := range loaded.Webhooks {
+		watchCity("webhooks", hook.City, notifyWebhooks)
+	}
+	StartFrostAlerts(loaded.Frost)
+	StartAdvisories(loaded.Advisories)
+	log.Printf("Reloaded config from %s", configPath)
+	return nil
+}
+
+// restartSections returns the sections of the config that changed but are
+// only used when keli starts.
+func restartSections(old, loaded Config) []string {
+	// the client and the number of fetches are set up on the first fetch
+	fetchSetup := func(c Config) any {
+		if c.Fetch == nil {
+			return nil
+		}
+		return []any{c.Fetch.Proxy, c.Fetch.MaxConcurrent}
+	}
+
+	// the registered webhooks are loaded from their file when keli starts
+	webhookFile := func(c Config) any {
+		if c.WebhookAPI == nil {
+			return nil
+		}
+		return c.WebhookAPI.File
+	}
+
+	type section struct {
+		name     string
+		old, new any
+	}
+	sections := []section{
+		{"file of webhookApi", webhookFile(old), webhookFile(loaded)},
+		{"mqtt", old.MQTT, loaded.MQTT},
+		{"discord", old.Discord, loaded.Discord},
+		{"mastodon", old.Mastodon, loaded.Mastodon},
+		{"email", old.Email, loaded.Email},
+		{"history", old.History, loaded.History},
+		{"streaks", old.Streaks, loaded.Streaks},
+		{"proxy and maxConcurrent of fetch", fetchSetup(old), fetchSetup(loaded)},
+	}
+
+	var changed []string
+	for _, s := range sections {
+		before, _ := json.Marshal(s.old)
+		after, _ := json.Marshal(s.new)
+		if string(before) != string(after) {
+			changed = append(changed, s.name)
+		}
+	}
+	return changed
+}
+
+// reloadOnHangup reloads the config whenever keli gets SIGHUP.
+func reloadOnHangup() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := ReloadConfig(); err != nil {
+			log.Printf("Error reloading config: %v", err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// longest X-Request-ID taken from a client
+const maxRequestIDLength = 128
+
+var (
+	// request IDs taken from clients, anything else is replaced so that
+	// the logs can't be forged with newlines
+	validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]+$`)
+	// traceparent of the W3C trace context: version, trace ID, parent ID and
+	// flags
+	validTraceparent = regexp.MustCompile(`^00-([0-9a-f]{32})-[0-9a-f]{16}-([0-9a-f]{2})$`)
+)
+
+// requestTrace identifies the request being served in the log lines, the
+// error responses and the fetches made for it.
+type requestTrace struct {
+	// The X-Request-ID of the client, or else the trace ID
+	ID string
+	// The trace ID of the traceparent of the client, or else a new one
+	TraceID string
+	// The trace flags of the traceparent, "01" when sampled
+	Flags string
+}
+
+type requestTraceKey struct{}
+
+// traceOf returns the trace of the request the context belongs to, if any.
+func traceOf(ctx context.Context) (requestTrace, bool) {
+	trace, ok := ctx.Value(requestTraceKey{}).(requestTrace)
+	return trace, ok
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newRequestTrace returns the trace of the request, continuing the one of
+// the client when it sent X-Request-ID or traceparent.
+func newRequestTrace(r *http.Request) requestTrace {
+	trace := requestTrace{Flags: "01"}
+	if match := validTraceparent.FindStringSubmatch(r.Header.Get("traceparent")); match != nil && match[1] != strings.Repeat("0", 32) {
+		trace.TraceID, trace.Flags = match[1], match[2]
+	} else {
+		trace.TraceID = randomHex(16)
+	}
+	trace.ID = trace.TraceID
+	if id := r.Header.Get("X-Request-ID"); len(id) <= maxRequestIDLength && validRequestID.MatchString(id) {
+		trace.ID = id
+	}
+	return trace
+}
+
+// withRequestIDs gives each request an ID, taken from its X-Request-ID or
+// else new, which its log lines start with, which is answered as
+// X-Request-ID and at the end of plain text errors, and which the fetches
+// made for it pass on with the trace context.
+func withRequestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace := newRequestTrace(r)
+		w.Header().Set("X-Request-ID", trace.ID)
+		ew := &requestIDWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r.WithContext(context.WithValue(r.Context(), requestTraceKey{}, trace)))
+		if ew.plainError {
+			fmt.Fprintf(ew.ResponseWriter, "Request ID: %s\n", trace.ID)
+		}
+	})
+}
+
+// requestIDWriter notes whether the response is a plain text error, as
+// written by http.Error, for the request ID to be added to it.
+type requestIDWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	plainError  bool
+}
+
+func (w *requestIDWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.plainError = status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *requestIDWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush and Hijack keep the server sent events and the websockets working
+// through the writer.
+func (w *requestIDWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *requestIDWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("Hijacking not supported")
+	}
+	w.plainError = false
+	return hijacker.Hijack()
+}
+
+func (w *requestIDWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logf logs like log.Printf, starting the line with the ID of the request
+// the context belongs to.
+func logf(ctx context.Context, format string, args ...any) {
+	if trace, ok := traceOf(ctx); ok {
+		format = "[" + trace.ID + "] " + format
+	}
+	log.Printf(format, args...)
+}
+
+// setTraceHeaders passes the request the context belongs to on to a fetch
+// made for it, as X-Request-ID and as the traceparent of the W3C trace
+// context with the fetch as a new span.
+func setTraceHeaders(req *http.Request, ctx context.Context) {
+	trace, ok := traceOf(ctx)
+	if !ok {
+		return
+	}
+	req.Header.Set("X-Request-ID", trace.ID)
+	req.Header.Set("traceparent", fmt.Sprintf("00-%s-%s-%s", trace.TraceID, randomHex(8), trace.Flags))
+}
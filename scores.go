@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// Score rates how good the weather of a time window is for an activity,
+// from 0 (stay in) to 100 (perfect).
+type Score struct {
+	City     string `json:"city"`
+	Activity string `json:"activity"`
+	// The hours of the forecast rated, e.g. the next commute
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Score int       `json:"score"`
+	// What took points off, worst first
+	Factors []ScoreFactor `json:"factors"`
+	// Each hour rated, for the activities looking for the best time, which
+	// is the one rated above
+	Hours []Score `json:"hours,omitempty"`
+}
+
+// ScoreFactor is a part of the weather taking points off a score. The
+// values are metric.
+type ScoreFactor struct {
+	// e.g. "wind" or "slipperiness"
+	Factor string  `json:"factor"`
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit"`
+	// Points taken off the score
+	Penalty int `json:"penalty"`
+}
+
+// scoreActivities rate the weather for each activity of /score/<activity>
+// at the time now.
+var scoreActivities = map[string]func(weather WeatherData, now time.Time) Score{
+	"cycling": cyclingScore,
+	"running": runningScore,
+	"laundry": laundryScore,
+	"terrace": terraceScore,
+}
+
+// forecastWindow returns the hours of the hourly forecast starting from
+// from until to.
+func forecastWindow(weather WeatherData, from, to time.Time) (hours []keli.HourlyForecast) {
+	for i, t := range ForecastTimes(weather) {
+		if !t.Before(from) && t.Before(to) {
+			hours = append(hours, weather.HourlyForecast[i])
+		}
+	}
+	return hours
+}
+
+// newScore returns the score of the window from 100 less the penalties of
+// the factors, leaving out the factors that cost nothing.
+func newScore(weather WeatherData, activity string, from, to time.Time, factors []ScoreFactor) Score {
+	score := Score{City: weather.City, Activity: activity, From: from, To: to, Score: 100, Factors: []ScoreFactor{}}
+	for _, factor := range factors {
+		if factor.Penalty <= 0 {
+			continue
+		}
+		score.Score -= factor.Penalty
+		score.Factors = append(score.Factors, factor)
+	}
+	score.Score = max(score.Score, 0)
+	sort.SliceStable(score.Factors, func(i, j int) bool { return score.Factors[i].Penalty > score.Factors[j].Penalty })
+	return score
+}
+
+// penalty returns the points taken off for value beyond limit, perUnit
+// points for each unit beyond up to most.
+func penalty(value, limit, perUnit, most float64) int {
+	if value <= limit {
+		return 0
+	}
+	return int(math.Round(math.Min((value-limit)*perUnit, most)))
+}
+
+// windowWeather is the worst of the weather of some hours.
+type windowWeather struct {
+	low, high, wind, rain, rainChance float64
+}
+
+func worstWeather(hours []keli.HourlyForecast) windowWeather {
+	w := windowWeather{low: math.Inf(1), high: math.Inf(-1)}
+	for _, h := range hours {
+		w.low, w.high = math.Min(w.low, h.Temperature), math.Max(w.high, h.Temperature)
+		w.wind = math.Max(w.wind, float64(h.WindSpeed))
+		w.rain += h.Rainfall
+		w.rainChance = math.Max(w.rainChance, float64(h.RainChance))
+	}
+	return w
+}
+
+// slipperiness rates from 0 to 3 how slippery the roads are likely to be:
+// freezing temperatures with rain or snow are worst, temperatures around
+// zero freezing and thawing next.
+func slipperiness(weather WeatherData, w windowWeather) float64 {
+	wet := w.rain > 0 || w.rainChance > 40 || weather.Snowfall > 0
+	switch {
+	case w.low <= 1 && wet:
+		return 3
+	case w.low <= 1 && w.high >= -3:
+		return 2
+	case w.low <= 1:
+		return 1
+	}
+	return 0
+}
+
+// nextCommute returns the next commute window from now: 7–9 in the
+// morning or 16–18 in the evening.
+func nextCommute(now time.Time) (from, to time.Time) {
+	now = now.In(location)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	switch {
+	case now.Hour() < 9:
+		return atHour(day, 7), atHour(day, 9)
+	case now.Hour() < 18:
+		return atHour(day, 16), atHour(day, 18)
+	}
+	day = day.AddDate(0, 0, 1)
+	return atHour(day, 7), atHour(day, 9)
+}
+
+// cyclingScore rates the next commute by bike: headwinds, rain, cold or
+// heat and slippery roads take points off.
+func cyclingScore(weather WeatherData, now time.Time) Score {
+	from, to := nextCommute(now)
+	w := worstWeather(forecastWindow(weather, from, to))
+	if math.IsInf(w.low, 1) {
+		// no forecast for the window, rate the current weather
+		w = windowWeather{low: weather.Temperature, high: weather.Temperature, wind: float64(weather.WindSpeed), rain: weather.Rainfall, rainChance: float64(weather.RainChance)}
+	}
+	slippery := slipperiness(weather, w)
+
+	return newScore(weather, "cycling", from, to, []ScoreFactor{
+		{"wind", w.wind, "m/s", penalty(w.wind, 4, 5, 40)},
+		{"rain", roundTo(w.rain, 1), "mm", max(penalty(w.rain, 0, 20, 40), penalty(w.rainChance, 30, 0.4, 25))},
+		{"cold", w.low, "°C", penalty(-w.low, -5, 2, 30)},
+		{"heat", w.high, "°C", penalty(w.high, 25, 3, 30)},
+		{"slipperiness", slippery, "", int(slippery * 12)},
+	})
+}
+
+// hours of the forecast the best time to run is looked for in
+const runningHours = 12
+
+// runningScore rates each of the next hours for a run and picks the best:
+// cold, heat, wind, rain and the dark after sunset take points off. keli has
+// no humidity, so muggy heat only counts as heat.
+func runningScore(weather WeatherData, now time.Time) Score {
+	var hours []Score
+	for i, t := range ForecastTimes(weather) {
+		if t.Add(time.Hour).Before(now) {
+			continue
+		}
+		if len(hours) == runningHours {
+			break
+		}
+		h := weather.HourlyForecast[i]
+		dark := 0.0
+		if isDark(weather, t) {
+			dark = 1
+		}
+		hours = append(hours, newScore(weather, "running", t, t.Add(time.Hour), []ScoreFactor{
+			{"cold", h.Temperature, "°C", penalty(-h.Temperature, 5, 2, 40)},
+			{"heat", h.Temperature, "°C", penalty(h.Temperature, 18, 4, 50)},
+			{"wind", float64(h.WindSpeed), "m/s", penalty(float64(h.WindSpeed), 6, 4, 30)},
+			{"rain", h.Rainfall, "mm", max(penalty(h.Rainfall, 0, 15, 35), penalty(float64(h.RainChance), 40, 0.3, 20))},
+			{"darkness", dark, "", int(dark * 20)},
+		}))
+	}
+	if len(hours) == 0 {
+		return newScore(weather, "running", now, now, nil)
+	}
+
+	best := hours[0]
+	for _, h := range hours[1:] {
+		if h.Score > best.Score {
+			best = h
+		}
+	}
+	best.Hours = hours
+	return best
+}
+
+// isDark tells whether the hour starting at t is before sunrise or after
+// sunset, taking the sun times of today for the next days too.
+func isDark(weather WeatherData, t time.Time) bool {
+	sunrise, err1 := clockTime(t, weather.Sunrise)
+	sunset, err2 := clockTime(t, weather.Sunset)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return t.Add(time.Hour).Before(sunrise) || !t.Before(sunset)
+}
+
+// hours of the forecast laundry hung out now has to dry in
+const laundryHours = 8
+
+// laundryScore rates how well laundry hung out now dries in the next hours:
+// rain, cold, still air and the dark take points off. keli has no humidity,
+// so damp air only counts through the temperature and the chance of rain.
+func laundryScore(weather WeatherData, now time.Time) Score {
+	from := now.In(location).Truncate(time.Hour)
+	to := from.Add(laundryHours * time.Hour)
+	hours := forecastWindow(weather, from, to)
+	if len(hours) == 0 {
+		hours = []keli.HourlyForecast{{Temperature: weather.Temperature, WindSpeed: weather.WindSpeed, Rainfall: weather.Rainfall, RainChance: weather.RainChance}}
+	}
+	w := worstWeather(hours)
+
+	mean, wind, dark := 0.0, 0.0, 0.0
+	for i, h := range hours {
+		mean += h.Temperature / float64(len(hours))
+		wind += float64(h.WindSpeed) / float64(len(hours))
+		if isDark(weather, from.Add(time.Duration(i)*time.Hour)) {
+			dark += 1 / float64(len(hours))
+		}
+	}
+
+	return newScore(weather, "laundry", from, to, []ScoreFactor{
+		{"rain", roundTo(w.rain, 1), "mm", max(penalty(w.rain, 0, 40, 70), penalty(w.rainChance, 20, 0.8, 50))},
+		{"cold", roundTo(mean, 1), "°C", penalty(-mean, -12, 3, 40)},
+		{"stillAir", roundTo(wind, 1), "m/s", penalty(-wind, -2, 10, 20)},
+		{"darkness", roundTo(dark, 2), "", int(math.Round(dark * 20))},
+	})
+}
+
+// terraceScore rates this evening, 17–22, for sitting out on a terrace or
+// grilling, or tomorrow evening once this one is over: cold, wind, the
+// chance of rain and the evening getting dark take points off.
+func terraceScore(weather WeatherData, now time.Time) Score {
+	now = now.In(location)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	if now.Hour() >= 22 {
+		day = day.AddDate(0, 0, 1)
+	}
+	from, to := day.Add(17*time.Hour), day.Add(22*time.Hour)
+	hours := forecastWindow(weather, from, to)
+	if len(hours) == 0 {
+		hours = []keli.HourlyForecast{{Temperature: weather.Temperature, WindSpeed: weather.WindSpeed, Rainfall: weather.Rainfall, RainChance: weather.RainChance}}
+	}
+	w := worstWeather(hours)
+
+	mean, dark := 0.0, 0.0
+	for _, h := range hours {
+		mean += h.Temperature / float64(len(hours))
+	}
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		if isDark(weather, t) {
+			dark++
+		}
+	}
+
+	return newScore(weather, "terrace", from, to, []ScoreFactor{
+		{"cold", roundTo(mean, 1), "°C", penalty(-mean, -18, 5, 60)},
+		{"wind", w.wind, "m/s", penalty(w.wind, 4, 6, 40)},
+		{"rain", roundTo(w.rainChance, 0), "%", max(penalty(w.rainChance, 20, 0.8, 60), penalty(w.rain, 0, 40, 60))},
+		{"darkness", dark, "h", int(dark * 6)},
+	})
+}
+
+// scoreHandler serves /score/<activity>?city=, how good the weather is
+// for the activity.
+func scoreHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	activity := strings.TrimPrefix(r.URL.Path, "/score/")
+	rate, found := scoreActivities[activity]
+	if !found {
+		names := make([]string, 0, len(scoreActivities))
+		for name := range scoreActivities {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		http.Error(w, fmt.Sprintf("Unknown activity \"%s\", expected one of %s", activity, strings.Join(names, ", ")), http.StatusNotFound)
+		return
+	}
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+
+	jsonData, err := json.Marshal(rate(weather, time.Now()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
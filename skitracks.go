@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long the tracks of a feed are kept before fetching it again
+const skiTrackCacheDuration = 15 * time.Minute
+
+// skiTrackFields are the fields of a track and the keys of them in a feed
+// unless the feed says otherwise.
+var skiTrackFields = map[string]string{
+	"name":       "name",
+	"status":     "status",
+	"snow":       "snow",
+	"maintained": "maintained",
+	"lengthKm":   "lengthKm",
+}
+
+var skiTrackClient = &http.Client{Timeout: 10 * time.Second}
+
+// SkiTrackFeed is a JSON feed of the ski tracks of a city, like those of
+// the track maps of the municipalities. Feeds differ, so where the fields
+// are in the JSON is configured.
+type SkiTrackFeed struct {
+	City string `json:"city"`
+	URL  string `json:"url"`
+	// Key of the list of the tracks in the JSON, e.g. "features", or "" when
+	// the JSON is the list
+	List string `json:"list"`
+	// Keys of the fields of a track by their names in keli: name, status,
+	// snow, maintained and lengthKm, the same as the names by default.
+	// Dotted keys like "properties.name" look inside objects.
+	Fields map[string]string `json:"fields"`
+}
+
+// SkiTrack is a ski track and its condition as the feed has them.
+type SkiTrack struct {
+	Name string `json:"name"`
+	// Maintenance status, e.g. "ajettu" or "open"
+	Status string `json:"status"`
+	// Snow condition, e.g. "pakkaslumi"
+	Snow string `json:"snow"`
+	// When the track was last maintained
+	Maintained string  `json:"maintained"`
+	LengthKm   float64 `json:"lengthKm"`
+}
+
+// SkiTracks are the ski tracks of a city alongside its weather.
+type SkiTracks struct {
+	City    string      `json:"city"`
+	Tracks  []SkiTrack  `json:"tracks"`
+	Weather WeatherData `json:"weather"`
+}
+
+type skiTrackCache struct {
+	tracks  []SkiTrack
+	fetched time.Time
+}
+
+var (
+	skiTrackCaches      = make(map[string]skiTrackCache)
+	skiTrackCachesMutex sync.Mutex
+)
+
+func (f *SkiTrackFeed) check() error {
+	if f.City == "" {
+		return fmt.Errorf("Missing 'city'")
+	}
+	if u, err := url.Parse(f.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid url \"%s\", expected an http or https URL", f.URL)
+	}
+	for field := range f.Fields {
+		if _, found := skiTrackFields[field]; !found {
+			return fmt.Errorf("Unknown field \"%s\", expected name, status, snow, maintained or lengthKm", field)
+		}
+	}
+	return nil
+}
+
+// lookupJSON returns the value at the dotted key in the decoded JSON, or
+// nil if there is none.
+func lookupJSON(value any, key string) any {
+	if key == "" {
+		return value
+	}
+	for _, part := range strings.Split(key, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
+
+// jsonString returns the value of the feed as text.
+func jsonString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// parseSkiTracks parses the tracks from the JSON of the feed.
+func (f SkiTrackFeed) parseSkiTracks(data []byte) ([]SkiTrack, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	list, ok := lookupJSON(doc, f.List).([]any)
+	if !ok {
+		return nil, fmt.Errorf("No list of tracks at \"%s\"", f.List)
+	}
+
+	key := func(field string) string {
+		if key, found := f.Fields[field]; found {
+			return key
+		}
+		return skiTrackFields[field]
+	}
+	tracks := make([]SkiTrack, 0, len(list))
+	for _, item := range list {
+		track := SkiTrack{
+			Name:       jsonString(lookupJSON(item, key("name"))),
+			Status:     jsonString(lookupJSON(item, key("status"))),
+			Snow:       jsonString(lookupJSON(item, key("snow"))),
+			Maintained: jsonString(lookupJSON(item, key("maintained"))),
+		}
+		if track.Name == "" {
+			continue
+		}
+		// some feeds have the length as text like "5,2"
+		length := strings.Replace(jsonString(lookupJSON(item, key("lengthKm"))), ",", ".", 1)
+		track.LengthKm, _ = strconv.ParseFloat(length, 64)
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// fetchSkiTracks returns the tracks of the feed, fetching them again when
+// the cached ones are older than skiTrackCacheDuration.
+func fetchSkiTracks(f SkiTrackFeed) ([]SkiTrack, error) {
+	skiTrackCachesMutex.Lock()
+	cached, found := skiTrackCaches[f.URL]
+	skiTrackCachesMutex.Unlock()
+	if found && time.Since(cached.fetched) < skiTrackCacheDuration {
+		return cached.tracks, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Accept", "application/json")
+	res, err := skiTrackClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := f.parseSkiTracks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	skiTrackCachesMutex.Lock()
+	skiTrackCaches[f.URL] = skiTrackCache{tracks: tracks, fetched: time.Now()}
+	skiTrackCachesMutex.Unlock()
+	return tracks, nil
+}
+
+// skiTracksOf returns the tracks of the feeds of the city. A feed failing
+// is logged and its tracks left out, the last ones fetched kept if any.
+func skiTracksOf(city string) []SkiTrack {
+	tracks := []SkiTrack{}
+	for _, feed := range config().SkiTracks {
+		if foldPlace(feed.City) != foldPlace(city) {
+			continue
+		}
+		feedTracks, err := fetchSkiTracks(feed)
+		if err != nil {
+			log.Printf("Error fetching ski tracks of %s from %s: %v", city, feed.URL, err)
+			skiTrackCachesMutex.Lock()
+			feedTracks = skiTrackCaches[feed.URL].tracks
+			skiTrackCachesMutex.Unlock()
+		}
+		tracks = append(tracks, feedTracks...)
+	}
+	return tracks
+}
+
+// skiTracksHandler serves /skitracks?city=, the ski tracks of the city and
+// its weather.
+func skiTracksHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+
+	jsonData, err := json.Marshal(SkiTracks{City: weather.City, Tracks: skiTracksOf(city), Weather: weather})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
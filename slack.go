@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	// oldest Slack request accepted, to keep requests from being replayed
+	slackMaxAge = 5 * time.Minute
+	// hours of the forecast shown under the current weather
+	slackForecastHours = 6
+)
+
+var slackClient = &http.Client{Timeout: 10 * time.Second}
+
+// SlackConfig is the Slack app whose slash command (e.g. /weather) posts to
+// /integrations/slack.
+type SlackConfig struct {
+	// Signing secret from the Basic Information of the Slack app
+	SigningSecret string `json:"signingSecret"`
+	// Address keli is reached at, e.g. https://keli.example.com, for the
+	// links and icons of the messages
+	BaseURL string `json:"baseUrl"`
+	Lang    string `json:"lang"`
+	Units   string `json:"units"`
+	// City when the command is given without one
+	City string `json:"city"`
+
+	lang  Language
+	units keli.UnitSystem
+}
+
+// slackMessage is a message of Block Kit blocks. The text is shown in
+// notifications and where the blocks can't be.
+type slackMessage struct {
+	ResponseType string       `json:"response_type"`
+	Text         string       `json:"text"`
+	Blocks       []slackBlock `json:"blocks,omitempty"`
+}
+
+type slackBlock struct {
+	Type      string          `json:"type"`
+	Text      *slackText      `json:"text,omitempty"`
+	Fields    []slackText     `json:"fields,omitempty"`
+	Elements  []slackText     `json:"elements,omitempty"`
+	Accessory *slackAccessory `json:"accessory,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackAccessory struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+func (s *SlackConfig) check() error {
+	if s.SigningSecret == "" {
+		return fmt.Errorf("Missing 'signingSecret'")
+	}
+	if u, err := url.Parse(s.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid baseUrl \"%s\", expected an http or https URL", s.BaseURL)
+	}
+	s.BaseURL = strings.TrimSuffix(s.BaseURL, "/")
+	var err error
+	if s.lang, err = ParseLanguage(s.Lang); err != nil {
+		return err
+	}
+	if s.units, err = keli.ParseUnitSystem(s.Units); err != nil {
+		return err
+	}
+	return nil
+}
+
+// slackHandler serves /integrations/slack, answering a Slack slash command
+// with the weather of the city given as its text.
+func slackHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	s := config().Slack
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.verify(r.Header, body, time.Now()) {
+		http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	city := strings.TrimSpace(form.Get("text"))
+	if city == "" {
+		city = s.City
+	}
+	if city == "" {
+		writeSlackMessage(w, slackMessage{ResponseType: "ephemeral", Text: "Missing city, e.g. " + form.Get("command") + " Tampere"})
+		return
+	}
+
+	// Slack waits for 3 seconds, so when the weather isn't cached the
+	// command is acknowledged and the weather sent to the response URL
+	message := make(chan slackMessage, 1)
+	go func() {
+		weather, err := GetWeatherData(r.Context(), city)
+		if err != nil {
+			message <- slackMessage{ResponseType: "ephemeral", Text: err.Error()}
+			return
+		}
+		message <- s.message(weather)
+	}()
+
+	select {
+	case m := <-message:
+		writeSlackMessage(w, m)
+	case <-time.After(2 * time.Second):
+		w.WriteHeader(http.StatusOK)
+		go func() {
+			if err := postSlackMessage(form.Get("response_url"), <-message); err != nil {
+				logf(r.Context(), "Error answering Slack command: %v", err)
+			}
+		}()
+	}
+}
+
+// verify checks the signature Slack makes of the request with the signing
+// secret, and that the request is recent.
+func (s *SlackConfig) verify(header http.Header, body []byte, now time.Time) bool {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Sub(time.Unix(seconds, 0)).Abs() > slackMaxAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.SigningSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature")))
+}
+
+// message shows the weather with its icon, the temperature, wind and rain,
+// and the coming hours.
+func (s *SlackConfig) message(weather WeatherData) slackMessage {
+	lang := s.lang
+	weather = ConvertUnits(weather, s.units, "")
+	title := lang.T("title", weather.City, weather.ObservationHour)
+	summary := lang.Summary(weather)
+	link := s.BaseURL + "/" + url.PathEscape(weather.City) + "?lang=" + string(lang)
+
+	current := slackBlock{
+		Type: "section",
+		Text: &slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n<%s|%s>", summary, link, weather.City)},
+	}
+	if weather.SymbolCode != "" {
+		current.Accessory = &slackAccessory{
+			Type:     "image",
+			ImageURL: s.BaseURL + "/icons/" + weather.SymbolCode + ".png",
+			AltText:  summary,
+		}
+	}
+
+	fields := slackBlock{Type: "section"}
+	for _, field := range Fields(weather, lang) {
+		fields.Fields = append(fields.Fields, slackText{Type: "mrkdwn", Text: "*" + field.Name + "*\n" + field.Value})
+	}
+
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: title}},
+		current,
+		fields,
+	}
+
+	var hours []string
+	for i, h := range weather.HourlyForecast {
+		if i >= slackForecastHours {
+			break
+		}
+		hours = append(hours, fmt.Sprintf("*%s* %s %s", h.Hour, h.WeatherSymbol, lang.Temperature(h.Temperature, weather.Labels().Temperature)))
+	}
+	if len(hours) > 0 {
+		blocks = append(blocks, slackBlock{
+			Type:     "context",
+			Elements: []slackText{{Type: "mrkdwn", Text: strings.Join(hours, "   ")}},
+		})
+	}
+
+	blocks = append(blocks, slackBlock{
+		Type:     "context",
+		Elements: []slackText{{Type: "mrkdwn", Text: lang.T("updated", lang.FormatDateTime(weather.LastUpdated.In(location)))}},
+	})
+
+	return slackMessage{
+		ResponseType: "in_channel",
+		Text:         ShortText(weather, lang),
+		Blocks:       blocks,
+	}
+}
+
+func writeSlackMessage(w http.ResponseWriter, message slackMessage) {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// postSlackMessage sends a delayed answer to the response URL of a command.
+func postSlackMessage(responseURL string, message slackMessage) error {
+	if !strings.HasPrefix(responseURL, "https://hooks.slack.com/") {
+		return fmt.Errorf("Invalid response_url \"%s\"", responseURL)
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	res, err := slackClient.Post(responseURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	// failed fetches in a row opening the circuit breaker of a source
+	breakerThreshold = 5
+	// how long an open breaker skips the source before trying it again
+	breakerCooldown = 5 * time.Minute
+	// weight of the latest fetch in the average latency
+	latencyWeight = 0.2
+)
+
+// SourceConfig configures a source in the sources section of the config.
+type SourceConfig struct {
+	// Disabled sources are not fetched
+	Disabled bool `json:"disabled"`
+	// Headers sent when fetching the source, e.g. a Cookie the site needs
+	Headers map[string]string `json:"headers"`
+}
+
+// SourceStatus is how a source has been doing, as shown on /sources.
+type SourceStatus struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Enabled bool   `json:"enabled"`
+	// "closed" when the source is fetched normally, "open" when it is
+	// skipped after failing breakerThreshold times in a row and "half-open"
+	// when it is tried again after breakerCooldown
+	Breaker       string     `json:"breaker"`
+	Fetches       int        `json:"fetches"`
+	Failures      int        `json:"failures"`
+	LastSuccess   *time.Time `json:"lastSuccess"`
+	LastError     string     `json:"lastError"`
+	LastErrorTime *time.Time `json:"lastErrorTime"`
+	// Moving average of the time to fetch the source
+	AverageLatencyMs float64 `json:"averageLatencyMs"`
+	// Recent share of the fetches that parsed, and of the fields the source
+	// has had that they filled, see breakage.go
+	ParseSuccessRate float64 `json:"parseSuccessRate"`
+	FieldFillRate    float64 `json:"fieldFillRate"`
+	// Whether the rates suddenly dropped, as when the site is redesigned
+	Degraded       bool   `json:"degraded"`
+	DegradedReason string `json:"degradedReason,omitempty"`
+
+	// failed fetches in a row, and when the breaker opened
+	failuresInRow int
+	openedAt      time.Time
+	// parses so far, the usual rates and the fields the source has had
+	parses                      int
+	parseBaseline, fillBaseline float64
+	fieldsSeen                  map[string]bool
+}
+
+var (
+	sourceStatuses      = make(map[string]*SourceStatus)
+	sourceStatusesMutex sync.Mutex
+)
+
+// sourceStatus returns the status of the source, sourceStatusesMutex must be
+// held.
+func sourceStatus(source keli.Source) *SourceStatus {
+	status, found := sourceStatuses[source.Name]
+	if !found {
+		status = &SourceStatus{Name: source.Name}
+		sourceStatuses[source.Name] = status
+	}
+	status.URL = source.URL
+	status.Enabled = !sourceDisabled(source.Name)
+	status.Breaker = "closed"
+	if status.failuresInRow >= breakerThreshold {
+		status.Breaker = "open"
+		if time.Since(status.openedAt) > breakerCooldown {
+			status.Breaker = "half-open"
+		}
+	}
+	return status
+}
+
+// allowSource tells whether the source is to be fetched, or why not.
+func allowSource(source keli.Source) (bool, error) {
+	sourceStatusesMutex.Lock()
+	defer sourceStatusesMutex.Unlock()
+
+	status := sourceStatus(source)
+	if !status.Enabled {
+		return false, nil
+	}
+	if status.Breaker == "open" {
+		return false, fmt.Errorf("Skipped after %d failures in a row, trying again at %s",
+			status.failuresInRow, status.openedAt.Add(breakerCooldown).In(location).Format("15:04"))
+	}
+	if status.Breaker == "half-open" {
+		// one try at a time, the next ones wait for the cooldown again
+		status.openedAt = time.Now()
+	}
+	return true, nil
+}
+
+// recordFetch records a fetch of the source taking latency. Only failures
+// to fetch count towards opening the breaker: a page without weather is
+// more likely an unknown city than a broken source, see recordParse.
+func recordFetch(ctx context.Context, source keli.Source, latency time.Duration, err error) {
+	sourceStatusesMutex.Lock()
+	defer sourceStatusesMutex.Unlock()
+
+	status := sourceStatus(source)
+	now := time.Now()
+	status.Fetches++
+
+	if err == nil {
+		ms := float64(latency) / float64(time.Millisecond)
+		if status.AverageLatencyMs != 0 {
+			ms = status.AverageLatencyMs*(1-latencyWeight) + ms*latencyWeight
+		}
+		status.AverageLatencyMs = roundTo(ms, 1)
+		status.failuresInRow = 0
+	} else {
+		status.failuresInRow++
+		if status.failuresInRow == breakerThreshold {
+			logf(ctx, "Source %s failed %d times in a row, skipping it for %s", source.Name, breakerThreshold, breakerCooldown)
+		}
+		if status.failuresInRow >= breakerThreshold {
+			status.openedAt = now
+		}
+	}
+
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+		status.LastErrorTime = &now
+	}
+}
+
+// SourceStatuses returns the status of each source.
+func SourceStatuses() []SourceStatus {
+	sourceStatusesMutex.Lock()
+	defer sourceStatusesMutex.Unlock()
+
+	statuses := make([]SourceStatus, len(keli.Sources))
+	for i, source := range keli.Sources {
+		statuses[i] = *sourceStatus(source)
+	}
+	return statuses
+}
+
+// sourcesHandler serves /sources, the status of each source.
+func sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	jsonData, err := json.Marshal(SourceStatuses())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
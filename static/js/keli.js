@@ -0,0 +1,109 @@
+// Script of the weather page, see templates/weather.html
+
+// Suggest places from /places/suggest as the user types, without
+// JavaScript the form goes to /search
+const searchInput = document.getElementById('search-input')
+const suggestions = document.getElementById('suggestions')
+let selected = -1
+let typing
+
+const placeURL = place => '/' + encodeURIComponent(place) + window.location.search
+
+function showSuggestions(places) {
+  suggestions.replaceChildren(...places.map(place => {
+    const item = document.createElement('li')
+    item.setAttribute('role', 'option')
+    const link = document.createElement('a')
+    link.href = placeURL(place)
+    link.textContent = place
+    link.className = 'block px-4 py-2 hover:bg-gray-100'
+    item.appendChild(link)
+    return item
+  }))
+  selected = -1
+  suggestions.classList.toggle('hidden', places.length === 0)
+}
+
+function select(index) {
+  const items = suggestions.children
+  if (items.length === 0) {
+    return
+  }
+  selected = (index + items.length) % items.length
+  Array.from(items).forEach((item, i) => {
+    item.classList.toggle('bg-gray-100', i === selected)
+    item.setAttribute('aria-selected', i === selected)
+  })
+}
+
+searchInput.addEventListener('input', () => {
+  clearTimeout(typing)
+  typing = setTimeout(() => {
+    fetch('/places/suggest?q=' + encodeURIComponent(searchInput.value))
+      .then(response => response.json())
+      .then(showSuggestions)
+      .catch(() => showSuggestions([]))
+  }, 150)
+})
+
+searchInput.addEventListener('keydown', event => {
+  switch (event.key) {
+    case 'ArrowDown':
+      event.preventDefault()
+      select(selected + 1)
+      break
+    case 'ArrowUp':
+      event.preventDefault()
+      select(selected - 1)
+      break
+    case 'Enter':
+      if (selected >= 0) {
+        event.preventDefault()
+        window.location.href = suggestions.children[selected].firstChild.href
+      }
+      break
+    case 'Escape':
+      showSuggestions([])
+      break
+  }
+})
+
+// Close the suggestions if the user clicks outside of them
+document.addEventListener('click', event => {
+  if (!document.getElementById('search').contains(event.target)) {
+    showSuggestions([])
+  }
+});
+
+// Show the last known weather when offline, see static/sw.js
+if ('serviceWorker' in navigator) {
+  navigator.serviceWorker.register('/sw.js')
+}
+const offline = document.getElementById('offline')
+const updateOffline = () => offline.classList.toggle('hidden', navigator.onLine)
+window.addEventListener('online', updateOffline)
+window.addEventListener('offline', updateOffline)
+updateOffline()
+
+// Refresh the blocks with a data-refresh URL in place, see partials.go. The
+// weather is cached on the server for data-refresh-every seconds, fetching
+// more often would give the same block.
+function refresh(block) {
+  if (!navigator.onLine || document.hidden) {
+    return
+  }
+  fetch(block.dataset.refresh)
+    .then(response => response.ok ? response.text() : Promise.reject(response.status))
+    .then(html => {
+      const updated = document.createRange().createContextualFragment(html).firstElementChild
+      if (updated) {
+        block.replaceWith(updated)
+      }
+    })
+    .catch(() => {})
+}
+
+document.querySelectorAll('[data-refresh]').forEach(block => {
+  const every = Number(block.dataset.refreshEvery || 300) * 1000
+  setInterval(() => refresh(document.getElementById(block.id)), every)
+})
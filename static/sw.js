@@ -0,0 +1,85 @@
+// Service worker of the keli page. Pages, weather data and the partials of
+// the in-place refresh are fetched from the network first and cached, so the
+// last known weather can be shown when offline. Suggestions of places are
+// never cached. Icons, charts and third party assets are served from the
+// cache and refreshed in the background.
+
+const VERSION = 'keli-v3'
+const SHELL = ['/', '/manifest.webmanifest', '/icons/app-192.png']
+
+self.addEventListener('install', event => {
+  event.waitUntil(caches.open(VERSION).then(cache => cache.addAll(SHELL)))
+  self.skipWaiting()
+})
+
+self.addEventListener('activate', event => {
+  event.waitUntil(
+    caches.keys()
+      .then(keys => Promise.all(keys.filter(key => key !== VERSION).map(key => caches.delete(key))))
+      .then(() => self.clients.claim())
+  )
+})
+
+self.addEventListener('fetch', event => {
+  const request = event.request
+  if (request.method !== 'GET') {
+    return
+  }
+  const url = new URL(request.url)
+  const sameOrigin = url.origin === location.origin
+
+  if (sameOrigin && url.pathname === '/places/suggest') {
+    // straight to the network
+    return
+  }
+  if (request.mode === 'navigate') {
+    event.respondWith(networkFirst(request, lastPage))
+  } else if (sameOrigin && (url.pathname === '/w' || url.pathname === '/api' || url.pathname.startsWith('/partials/'))) {
+    event.respondWith(networkFirst(request))
+  } else {
+    event.respondWith(staleWhileRevalidate(request))
+  }
+})
+
+async function networkFirst(request, fallback) {
+  const cache = await caches.open(VERSION)
+  try {
+    const response = await fetch(request)
+    if (response.ok) {
+      cache.put(request, response.clone())
+      if (request.mode === 'navigate') {
+        cache.put('/last-page', response.clone())
+      }
+    }
+    return response
+  } catch (err) {
+    const cached = await cache.match(request)
+    if (cached) {
+      return cached
+    }
+    if (fallback) {
+      return fallback(cache)
+    }
+    throw err
+  }
+}
+
+// lastPage returns the last page seen, for cities that were never opened
+// while online.
+async function lastPage(cache) {
+  return (await cache.match('/last-page')) || (await cache.match('/')) || Response.error()
+}
+
+async function staleWhileRevalidate(request) {
+  const cache = await caches.open(VERSION)
+  const cached = await cache.match(request)
+  const fetched = fetch(request)
+    .then(response => {
+      if (response.ok || response.type === 'opaque') {
+        cache.put(request, response.clone())
+      }
+      return response
+    })
+    .catch(() => cached || Response.error())
+  return cached || fetched
+}
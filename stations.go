@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mean radius of the earth (km)
+const earthRadius = 6371.0
+
+// NearestStation is the station of FMI nearest to a place and how far from
+// it the station is, so that in a small town one can tell where the
+// observations come from.
+type NearestStation struct {
+	Name   string `json:"name"`
+	FMISID int    `json:"fmisid"`
+	// Distance from the place to the station (km)
+	DistanceKm float64 `json:"distanceKm"`
+}
+
+type placeLocation struct {
+	latitude, longitude float64
+}
+
+var (
+	// the coordinates of the places by the folded name. A place doesn't
+	// move, so they are kept for good.
+	placeLocations      = make(map[string]placeLocation)
+	placeLocationsMutex sync.Mutex
+
+	// when finding the station nearest to a city last failed, by the folded
+	// name, so that FMI isn't asked again on every request while it's down
+	stationFailures      = make(map[string]time.Time)
+	stationFailuresMutex sync.Mutex
+)
+
+// distanceKm returns the great-circle distance between the coordinates.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// bboxAround returns the bounding box of the radius (km) around the
+// coordinates as FMI and WMS 1.1.1 have it, "minlon,minlat,maxlon,maxlat".
+func bboxAround(latitude, longitude, radius float64) string {
+	dLat := radius / 111.32
+	dLon := radius / (111.32 * math.Cos(latitude*math.Pi/180))
+	return fmt.Sprintf("%.4f,%.4f,%.4f,%.4f", longitude-dLon, latitude-dLat, longitude+dLon, latitude+dLat)
+}
+
+// parseFMILocation parses the coordinates of the place from a simple
+// forecast of FMI, which has them in every element.
+func parseFMILocation(r io.Reader) (placeLocation, error) {
+	decoder := xml.NewDecoder(r)
+	inPos := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return placeLocation{}, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			inPos = t.Name.Local == "pos"
+		case xml.EndElement:
+			inPos = false
+		case xml.CharData:
+			if !inPos {
+				continue
+			}
+			fields := strings.Fields(string(t))
+			if len(fields) < 2 {
+				continue
+			}
+			lat, err1 := strconv.ParseFloat(fields[0], 64)
+			lon, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 == nil && err2 == nil {
+				return placeLocation{lat, lon}, nil
+			}
+		}
+	}
+	return placeLocation{}, fmt.Errorf("No location in the forecast")
+}
+
+// locatePlace returns the coordinates of the place as FMI resolves it.
+func locatePlace(place string) (placeLocation, error) {
+	key := foldPlace(place)
+	placeLocationsMutex.Lock()
+	coordinates, found := placeLocations[key]
+	placeLocationsMutex.Unlock()
+	if found {
+		return coordinates, nil
+	}
+
+	query := url.Values{
+		"service":        {"WFS"},
+		"version":        {"2.0.0"},
+		"request":        {"getFeature"},
+		"storedquery_id": {"fmi::forecast::harmonie::surface::point::simple"},
+		"parameters":     {"Temperature"},
+		"timestep":       {"60"},
+		"place":          {place},
+	}
+	req, err := http.NewRequest(http.MethodGet, fmiWFS+"?"+query.Encode(), nil)
+	if err != nil {
+		return coordinates, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	res, err := fmiClient.Do(req)
+	if err != nil {
+		return coordinates, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return coordinates, fmt.Errorf("No coordinates for \"%s\": %s", place, res.Status)
+	}
+	coordinates, err = parseFMILocation(res.Body)
+	if err != nil {
+		return coordinates, fmt.Errorf("Error in the coordinates of \"%s\": %v", place, err)
+	}
+
+	placeLocationsMutex.Lock()
+	placeLocations[key] = coordinates
+	placeLocationsMutex.Unlock()
+	return coordinates, nil
+}
+
+// stationDistance returns the distance from the place to the station (km).
+func stationDistance(place string, station Station) (float64, error) {
+	coordinates, err := locatePlace(place)
+	if err != nil {
+		return 0, err
+	}
+	return roundTo(distanceKm(coordinates.latitude, coordinates.longitude, station.Latitude, station.Longitude), 1), nil
+}
+
+// FindNearestStation returns the station of FMI nearest to the place and
+// its distance from the place.
+func FindNearestStation(place string) (*NearestStation, error) {
+	observations, err := FetchObservations(place)
+	if err != nil {
+		return nil, err
+	}
+	distance, err := stationDistance(place, observations.Station)
+	if err != nil {
+		return nil, err
+	}
+	return &NearestStation{Name: observations.Station.Name, FMISID: observations.Station.FMISID, DistanceKm: distance}, nil
+}
+
+// nearestStation returns the station nearest to the city, logging the
+// error and returning nil if there is none, so that the weather is served
+// without it.
+func nearestStation(city string) *NearestStation {
+	if offline() {
+		// no FMI when replaying recorded pages or with -mock
+		return nil
+	}
+	key := foldPlace(city)
+	stationFailuresMutex.Lock()
+	failed, found := stationFailures[key]
+	stationFailuresMutex.Unlock()
+	if found && time.Since(failed) < cacheTTL() {
+		return nil
+	}
+
+	station, err := FindNearestStation(city)
+	if err != nil {
+		log.Printf("Error finding the station nearest to %s: %v", city, err)
+		stationFailuresMutex.Lock()
+		stationFailures[key] = time.Now()
+		stationFailuresMutex.Unlock()
+		return nil
+	}
+	return station
+}
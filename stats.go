@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// base temperature of the heating degree days by default, as in Finland
+const defaultHeatingBase = 17.0
+
+// SeasonStats are the statistics of the weather of a city over a period,
+// from the observations kept in the history.
+type SeasonStats struct {
+	City   string `json:"city"`
+	Period string `json:"period"`
+	// First and last day of the period
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Days of the period with observations
+	Days int `json:"days"`
+	// First and last day the temperature went below zero, if it did
+	FirstFrost string `json:"firstFrost,omitempty"`
+	LastFrost  string `json:"lastFrost,omitempty"`
+	// The coldest and the hottest temperature and the day of each
+	Coldest *DayTemperature `json:"coldest,omitempty"`
+	Hottest *DayTemperature `json:"hottest,omitempty"`
+	// Total rain (mm)
+	Rainfall float64 `json:"rainfall"`
+	// Heating degree days over the base temperature (C)
+	HeatingDegreeDays float64 `json:"heatingDegreeDays"`
+	Base              float64 `json:"base"`
+}
+
+// DayTemperature is a temperature (C) and the day of it.
+type DayTemperature struct {
+	Date        string  `json:"date"`
+	Temperature float64 `json:"temperature"`
+}
+
+// seasons by name and their first month. Winter starts in December of the
+// year and ends in the next one.
+var (
+	seasons       = map[string]time.Month{"spring": time.March, "summer": time.June, "autumn": time.September, "winter": time.December}
+	periodPattern = regexp.MustCompile(`^(spring|summer|autumn|winter)?(\d{4})(?:-(\d{2}))?$`)
+)
+
+// ParsePeriod parses a period like "2024", "2024-03" or a season of the
+// year like "winter2024", returning its first day and the day after it.
+func ParsePeriod(period string) (from, to time.Time, err error) {
+	m := periodPattern.FindStringSubmatch(period)
+	if m == nil || (m[1] != "" && m[3] != "") {
+		return from, to, fmt.Errorf("Invalid period \"%s\", expected e.g. 2024, 2024-03 or winter2024", period)
+	}
+	year, _ := strconv.Atoi(m[2])
+	switch {
+	case m[1] != "":
+		from = time.Date(year, seasons[m[1]], 1, 0, 0, 0, 0, location)
+		return from, from.AddDate(0, 3, 0), nil
+	case m[3] != "":
+		month, _ := strconv.Atoi(m[3])
+		if month < 1 || month > 12 {
+			return from, to, fmt.Errorf("Invalid period \"%s\", expected e.g. 2024, 2024-03 or winter2024", period)
+		}
+		from = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, location)
+		return from, from.AddDate(0, 1, 0), nil
+	}
+	from = time.Date(year, time.January, 1, 0, 0, 0, 0, location)
+	return from, from.AddDate(1, 0, 0), nil
+}
+
+// heatingDegreeDays returns how much colder than base the mean temperature
+// of the day was.
+func heatingDegreeDays(day HistoryDay, base float64) float64 {
+	return math.Max(base-day.TemperatureMean, 0)
+}
+
+// seasonStats returns the statistics of the days of the period.
+func seasonStats(days []HistoryDay, base float64) SeasonStats {
+	stats := SeasonStats{Days: len(days), Base: base}
+	for _, day := range days {
+		if day.TemperatureMin < 0 {
+			if stats.FirstFrost == "" {
+				stats.FirstFrost = day.Date
+			}
+			stats.LastFrost = day.Date
+		}
+		if stats.Coldest == nil || day.TemperatureMin < stats.Coldest.Temperature {
+			stats.Coldest = &DayTemperature{day.Date, day.TemperatureMin}
+		}
+		if stats.Hottest == nil || day.TemperatureMax > stats.Hottest.Temperature {
+			stats.Hottest = &DayTemperature{day.Date, day.TemperatureMax}
+		}
+		stats.Rainfall += day.Rainfall
+		stats.HeatingDegreeDays += heatingDegreeDays(day, base)
+	}
+	stats.Rainfall = roundTo(stats.Rainfall, 1)
+	stats.HeatingDegreeDays = roundTo(stats.HeatingDegreeDays, 1)
+	return stats
+}
+
+// parseBase parses the base temperature of the heating degree days, 17 by
+// default.
+func parseBase(value string) (float64, error) {
+	if value == "" {
+		return defaultHeatingBase, nil
+	}
+	base, err := strconv.ParseFloat(value, 64)
+	if err != nil || base < -50 || base > 50 {
+		return 0, fmt.Errorf("Invalid base \"%s\", expected a temperature like 17", value)
+	}
+	return base, nil
+}
+
+// statsHandler serves /stats?city=&period=&base=, the statistics of the
+// weather of the city over the period from its history.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	if config().History == nil {
+		http.Error(w, "No history is kept, see history in the configuration", http.StatusNotFound)
+		return
+	}
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	from, to, err := ParsePeriod(period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	base, err := parseBase(r.URL.Query().Get("base"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	days, name := historyDays(city, from, to)
+	if name == "" {
+		name = city
+	}
+	stats := seasonStats(days, base)
+	stats.City, stats.Period = name, period
+	stats.From, stats.To = from.Format(time.DateOnly), to.AddDate(0, 0, -1).Format(time.DateOnly)
+
+	jsonData, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
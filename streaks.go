@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StreaksConfig counts the time since things like quitting smoking, see
+// streaks.go.
+type StreaksConfig struct {
+	// JSON file the streaks made through the API are kept in, streaks.json
+	// by default
+	File string `json:"file"`
+	// Key the API needs as a bearer token to make or remove streaks.
+	// Without it the streaks are only those of the config.
+	Key     string   `json:"key"`
+	Streaks []Streak `json:"streaks"`
+	// Where the milestones of the streaks are notified, see milestones.go
+	Notify *Notify `json:"notify"`
+	// Language of the notification text, Finnish by default
+	Lang string `json:"lang"`
+
+	lang Language
+}
+
+// Streak counts the time since its start.
+type Streak struct {
+	// Name in the URL, lowercase letters, digits, - and _
+	Name string `json:"name"`
+	// Start like "2024-04-21 18:20" in the timezone, or in RFC 3339
+	Start string `json:"start"`
+	// Timezone of the start, Europe/Helsinki by default
+	Timezone string `json:"timezone"`
+
+	start time.Time
+	zone  *time.Location
+	// streaks of the config can't be changed through the API
+	configured bool
+}
+
+// StreakStatus is how long a streak has lasted.
+type StreakStatus struct {
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	Timezone string    `json:"timezone"`
+	// Whole days since the start and the hours, minutes and seconds over
+	Days    int `json:"days"`
+	Hours   int `json:"hours"`
+	Minutes int `json:"minutes"`
+	Seconds int `json:"seconds"`
+	// The whole time in seconds
+	TotalSeconds int64 `json:"totalSeconds"`
+	// The milestone the streak reaches next
+	NextMilestone StreakMilestone `json:"nextMilestone"`
+}
+
+var (
+	streakNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	// formats of the start besides RFC 3339, in the timezone of the streak
+	streakStartFormats = []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05", time.DateOnly}
+
+	streaks      []Streak
+	streaksMutex sync.Mutex
+)
+
+func (c *StreaksConfig) check(email *EmailConfig) error {
+	if c.File == "" {
+		c.File = "streaks.json"
+	}
+	if c.Notify != nil {
+		if err := c.Notify.check(email); err != nil {
+			return err
+		}
+	}
+	lang, err := ParseLanguage(c.Lang)
+	if err != nil {
+		return err
+	}
+	c.lang = lang
+	for i := range c.Streaks {
+		if err := c.Streaks[i].check(); err != nil {
+			return fmt.Errorf("Error in streak %d: %v", i+1, err)
+		}
+		c.Streaks[i].configured = true
+	}
+	return nil
+}
+
+func (s *Streak) check() error {
+	if !streakNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("Invalid name \"%s\", expected lowercase letters, digits, - and _", s.Name)
+	}
+	if s.Timezone == "" {
+		s.Timezone = "Europe/Helsinki"
+	}
+	var err error
+	if s.zone, err = time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("Invalid timezone \"%s\": %v", s.Timezone, err)
+	}
+	if s.start, err = time.Parse(time.RFC3339, s.Start); err == nil {
+		return nil
+	}
+	for _, format := range streakStartFormats {
+		if s.start, err = time.ParseInLocation(format, s.Start, s.zone); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid start \"%s\", expected e.g. 2024-04-21 18:20", s.Start)
+}
+
+// StartStreaks loads the streaks made through the API after those of the
+// config.
+func StartStreaks(c StreaksConfig) error {
+	var saved []Streak
+	data, err := os.ReadFile(c.File)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("Error in %s: %v", c.File, err)
+		}
+	}
+	for i := range saved {
+		if err := saved[i].check(); err != nil {
+			return fmt.Errorf("Error in streak %d of %s: %v", i+1, c.File, err)
+		}
+	}
+
+	streaksMutex.Lock()
+	streaks = append(slices.Clone(c.Streaks), saved...)
+	streaksMutex.Unlock()
+	if c.Notify != nil {
+		go watchMilestones(c)
+	}
+	return nil
+}
+
+// saveStreaks writes the streaks made through the API to their file. The
+// caller holds streaksMutex.
+func saveStreaks(c StreaksConfig) {
+	saved := []Streak{}
+	for _, s := range streaks {
+		if !s.configured {
+			saved = append(saved, s)
+		}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err == nil {
+		// write a temporary file first so a crash can't leave half a file
+		err = os.WriteFile(c.File+".tmp", data, 0o600)
+	}
+	if err == nil {
+		err = os.Rename(c.File+".tmp", c.File)
+	}
+	if err != nil {
+		log.Printf("Error saving streaks to %s: %v", c.File, err)
+	}
+}
+
+// findStreak returns the streak of the name.
+func findStreak(name string) (Streak, bool) {
+	streaksMutex.Lock()
+	defer streaksMutex.Unlock()
+	i := slices.IndexFunc(streaks, func(s Streak) bool { return s.Name == name })
+	if i < 0 {
+		return Streak{}, false
+	}
+	return streaks[i], true
+}
+
+// Status returns how long the streak has lasted at the time, nothing
+// before it started.
+func (s Streak) Status(now time.Time) StreakStatus {
+	elapsed := max(now.Sub(s.start), 0)
+	total := int64(elapsed / time.Second)
+	return StreakStatus{
+		Name:          s.Name,
+		Start:         s.start,
+		Timezone:      s.Timezone,
+		Days:          int(total / 86400),
+		Hours:         int(total % 86400 / 3600),
+		Minutes:       int(total % 3600 / 60),
+		Seconds:       int(total % 60),
+		TotalSeconds:  total,
+		NextMilestone: s.milestoneAfter(now),
+	}
+}
+
+// Elapsed returns how long the streak has lasted, e.g. "12 päivää 1 tunti 4
+// minuuttia", down to the seconds if asked. Parts that are zero are left
+// out unless all are.
+func (l Language) Elapsed(s StreakStatus, seconds bool) string {
+	parts := []struct {
+		count     int
+		one, many string
+	}{
+		{s.Days, "durationDay", "durationDays"},
+		{s.Hours, "durationHour", "durationHours"},
+		{s.Minutes, "durationMinute", "durationMinutes"},
+		{s.Seconds, "durationSecond", "durationSeconds"},
+	}
+	if !seconds {
+		parts = parts[:3]
+	}
+
+	var words []string
+	for _, part := range parts {
+		switch part.count {
+		case 0:
+		case 1:
+			words = append(words, l.T(part.one))
+		default:
+			words = append(words, l.T(part.many, part.count))
+		}
+	}
+	if len(words) == 0 {
+		return l.T(parts[len(parts)-1].many, 0)
+	}
+	return strings.Join(words, " ")
+}
+
+// writeJSON writes the value as JSON with the status code.
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// streaksHandler serves /streaks, GET for the statuses of all the streaks
+// and POST of a streak as JSON to make it, or start it again if it was made
+// before, with the key of the config.
+func streaksHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	c := config().Streaks
+	if c == nil {
+		http.Error(w, "No streaks, see streaks in the configuration", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		now := time.Now()
+		streaksMutex.Lock()
+		statuses := []StreakStatus{}
+		for _, s := range streaks {
+			statuses = append(statuses, s.Status(now))
+		}
+		streaksMutex.Unlock()
+		writeJSON(w, http.StatusOK, statuses)
+
+	case http.MethodPost:
+		if c.Key == "" || !validKey(r, c.Key) {
+			http.Error(w, "Making streaks needs a valid key", http.StatusForbidden)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var s Streak
+		if err := json.Unmarshal(body, &s); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid streak: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := s.check(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		streaksMutex.Lock()
+		status := http.StatusCreated
+		i := slices.IndexFunc(streaks, func(old Streak) bool { return old.Name == s.Name })
+		switch {
+		case i >= 0 && streaks[i].configured:
+			streaksMutex.Unlock()
+			http.Error(w, fmt.Sprintf("Streak \"%s\" is in the configuration", s.Name), http.StatusConflict)
+			return
+		case i >= 0:
+			streaks[i], status = s, http.StatusOK
+		default:
+			streaks = append(streaks, s)
+		}
+		saveStreaks(*c)
+		streaksMutex.Unlock()
+		writeJSON(w, status, s.Status(time.Now()))
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// streakHandler serves /streaks/<name>, GET for the status of the streak,
+// see serveStreak, and DELETE with the key of the config
+// to remove one made through the API.
+func streakHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	c := config().Streaks
+	if c == nil {
+		http.Error(w, "No streaks, see streaks in the configuration", http.StatusNotFound)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/streaks/")
+	s, found := findStreak(name)
+	if !found {
+		http.Error(w, fmt.Sprintf("Unknown streak \"%s\"", name), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		serveStreak(w, r, s)
+
+	case http.MethodDelete:
+		if c.Key == "" || !validKey(r, c.Key) {
+			http.Error(w, "Removing streaks needs a valid key", http.StatusForbidden)
+			return
+		}
+		if s.configured {
+			http.Error(w, fmt.Sprintf("Streak \"%s\" is in the configuration", name), http.StatusConflict)
+			return
+		}
+		streaksMutex.Lock()
+		streaks = slices.DeleteFunc(streaks, func(old Streak) bool { return old.Name == name })
+		saveStreaks(*c)
+		streaksMutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveStreak writes the status of the streak as JSON, as text with
+// format=text or as a card for embedding in an iframe with format=html.
+func serveStreak(w http.ResponseWriter, r *http.Request, s Streak) {
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := s.Status(time.Now())
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, status)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, lang.Elapsed(status, true))
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := renderTemplate(w, "streak.html", templateContext{Request: r, Lang: lang}, status)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Invalid format \"%s\", expected json, text or html", format), http.StatusBadRequest)
+	}
+}
+
+// defaultSmokeStreak returns the streak /smoke counted before streaks could
+// be configured, for when there is none named smoke.
+func defaultSmokeStreak() Streak {
+	s := Streak{Name: "smoke", Start: "2024-04-21 18:20"}
+	s.check()
+	return s
+}
+
+// exampleStreak returns a made up streak status for checking templates.
+func exampleStreak() StreakStatus {
+	return defaultSmokeStreak().Status(time.Date(2024, 10, 16, 14, 5, 0, 0, location))
+}
+
+// legacyText is the time of the streak the way /smoke had it before there
+// were streaks, all four parts whether zero or not and never singular, as
+// scripts reading it may expect, e.g. "1 days 0 hours 5 minutes 1 seconds".
+func (s StreakStatus) legacyText() string {
+	return fmt.Sprintf("%d days %d hours %d minutes %d seconds", s.Days, s.Hours, s.Minutes, s.Seconds)
+}
+
+// smokeHandler serves /smoke, the streak named smoke in the fixed format of
+// the old endpoint, see legacyText, from 2024-04-21 18:20 unless configured
+// otherwise. The other streaks and languages are at /streaks.
+func smokeHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	s, found := findStreak("smoke")
+	if !found {
+		s = defaultSmokeStreak()
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, s.Status(time.Now()).legacyText())
+}
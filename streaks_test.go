@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSmokeText checks that /smoke keeps the format it had before the
+// streaks, every part even when zero and never singular.
+func TestSmokeText(t *testing.T) {
+	s := defaultSmokeStreak()
+	start := time.Date(2024, 4, 21, 18, 20, 0, 0, location)
+	tests := []struct {
+		elapsed time.Duration
+		want    string
+	}{
+		{0, "0 days 0 hours 0 minutes 0 seconds"},
+		{24*time.Hour + 5*time.Minute + time.Second, "1 days 0 hours 5 minutes 1 seconds"},
+		{178*24*time.Hour + 19*time.Hour + 45*time.Minute, "178 days 19 hours 45 minutes 0 seconds"},
+	}
+	for _, test := range tests {
+		if got := s.Status(start.Add(test.elapsed)).legacyText(); got != test.want {
+			t.Errorf("Got \"%s\" after %s, want \"%s\"", got, test.elapsed, test.want)
+		}
+	}
+}
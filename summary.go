@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	// hours ahead the generated summary looks at
+	summaryHours = 6
+	// change of temperature (°C) in those hours called warming or cooling
+	summaryTrend = 3.0
+)
+
+// Summary returns the summary of the weather in the language: the summary
+// of the source if it is Finnish or translates, or else one generated from
+// the fields of the weather, see GenerateSummary.
+func (l Language) Summary(weather WeatherData) string {
+	if weather.WeatherSummary != "" {
+		if l == LangFinnish {
+			return weather.WeatherSummary
+		}
+		if translated, ok := l.translateSummary(weather.WeatherSummary); ok {
+			return translated
+		}
+	}
+	return l.GenerateSummary(weather)
+}
+
+// GenerateSummary builds a summary like "Light rain, getting colder. Gentle
+// breeze, 2.5 mm of rain in the next 6 hours." from the weather symbol, the
+// temperature trend, the wind and the rain of the coming hours.
+func (l Language) GenerateSummary(weather WeatherData) string {
+	labels := weather.Labels()
+	hours := weather.HourlyForecast[:min(summaryHours, len(weather.HourlyForecast))]
+
+	var sky []string
+	if weather.SymbolCode != "" {
+		sky = append(sky, l.SymbolDescription(weather.SymbolCode))
+	}
+	if len(hours) > 1 {
+		trend := summaryTrend
+		if weather.Units == keli.UnitsImperial {
+			trend *= 1.8
+		}
+		switch change := hours[len(hours)-1].Temperature - hours[0].Temperature; {
+		case change >= trend:
+			sky = append(sky, l.T("summaryWarming"))
+		case change <= -trend:
+			sky = append(sky, l.T("summaryCooling"))
+		}
+	}
+
+	conditions := []string{l.WindDescription(weather.Beaufort)}
+	if len(hours) > 0 {
+		rain := 0.0
+		for _, h := range hours {
+			rain += h.Rainfall
+		}
+		if rain > 0 {
+			conditions = append(conditions, l.T("summaryRain", l.Precipitation(rain, weather.Units), labels.Precipitation, len(hours)))
+		} else {
+			conditions = append(conditions, l.T("summaryDry", len(hours)))
+		}
+	}
+
+	var sentences []string
+	for _, parts := range [][]string{sky, conditions} {
+		if len(parts) > 0 {
+			sentences = append(sentences, capitalize(strings.Join(parts, ", "))+".")
+		}
+	}
+	return strings.Join(sentences, " ")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// plausibleJump is how much a field can change between two fetches: by
+// base at once and by perHour more for each hour between them.
+type plausibleJump struct {
+	base, perHour float64
+}
+
+// plausibleJumps are the numeric fields checked against their previous
+// values by their JSON names. Rain and snow come and go in showers, so
+// they aren't checked.
+var plausibleJumps = map[string]plausibleJump{
+	"temperature":            {5, 5},
+	"temperatureFeelsLike":   {7, 6},
+	"temperatureMin":         {5, 5},
+	"temperatureMax":         {5, 5},
+	"temperatureTomorrow":    {6, 4},
+	"temperatureMinTomorrow": {6, 4},
+	"windSpeed":              {10, 5},
+}
+
+// dropSuspects drops the values of the data of a source that jumped more
+// from the previous weather of the city than the weather could have in the
+// time between, like +15 °C in five minutes, as they are more likely scrape
+// glitches. The merge then prefers the other sources, see keepSuspected.
+// As more is plausible the longer it has been, a real change is let
+// through by the time it could have happened. Returns the warnings about
+// the values dropped.
+func dropSuspects(ctx context.Context, sd *keli.SourceData, previous WeatherData) (suspects []Warning) {
+	for name, jump := range plausibleJumps {
+		field := keli.NumericFields[name]
+		value := field.Get(sd.Data)
+		if value == 0 && !sd.Data.Present(name) {
+			continue
+		}
+		before, found := previous.Provenance[name]
+		if !found {
+			continue
+		}
+		old := field.Get(previous.Weather)
+		elapsed := sd.Fetched.Sub(before.Fetched)
+		if limit := jump.base + jump.perHour*elapsed.Hours(); value-old <= limit && old-value <= limit {
+			continue
+		}
+
+		logf(ctx, "Suspect %s of %s from %s: %v jumped from %v in %s, dropping it", name, sd.Data.City, sd.Source, value, old, elapsed.Round(time.Second))
+		field.Set(&sd.Data, 0)
+		sd.Data.ClearPresent(name)
+		suspects = append(suspects, Warning{
+			Code:    "suspect",
+			Field:   name,
+			Message: fmt.Sprintf("Ignored %s %v from %s, a jump from %v in %.0f min", name, value, sd.Source, old, elapsed.Minutes()),
+		})
+	}
+	return suspects
+}
+
+// keepSuspected keeps the previous value of each field a suspect value was
+// dropped of and no other source had.
+func keepSuspected(weather *WeatherData, results []sourceData, previous WeatherData) {
+	for _, result := range results {
+		for _, suspect := range result.Suspects {
+			if _, found := weather.Provenance[suspect.Field]; found {
+				continue
+			}
+			field := keli.NumericFields[suspect.Field]
+			field.Set(&weather.Weather, field.Get(previous.Weather))
+			weather.Provenance[suspect.Field] = previous.Provenance[suspect.Field]
+		}
+	}
+}
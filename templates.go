@@ -0,0 +1,301 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// templates holds the parsed templates by file name. The embedded ones are
+// used unless LoadTemplates finds working overrides.
+var templates map[string]*template.Template
+
+// init parses the embedded templates. This runs after the message catalogs
+// are loaded in i18n.go, which the example data needs.
+func init() {
+	parsed, err := parseTemplates("")
+	if err != nil {
+		log.Fatalf("Error parsing templates: %v", err)
+	}
+	templates = parsed
+}
+
+// templateContext is what the template functions need to know about the
+// request being served.
+type templateContext struct {
+	Request   *http.Request
+	Lang      Language
+	Weather   WeatherData
+	Theme     Theme
+	Favorites Favorites
+	// Day of the daily forecast opened on the page, if any
+	Day *DayDetail
+	// templates to use instead of the loaded ones, when checking templates
+	templates map[string]*template.Template
+}
+
+// funcs returns the functions available in every template.
+func (c templateContext) funcs() template.FuncMap {
+	r, lang, weather := c.Request, c.Lang, c.Weather
+	return template.FuncMap{
+		"t":        lang.T,
+		"lang":     func() Language { return lang },
+		"num":      lang.Number,
+		"summary":  lang.TranslateSummary,
+		"describe": lang.SymbolDescription,
+		"temperature": func(t float64) string {
+			return lang.Temperature(t, weather.Labels().Temperature)
+		},
+		"weekday": func(date string) string { return weekday(lang, date) },
+		"day":     func() *DayDetail { return c.Day },
+		"rainBar": func(rainfall float64) float64 {
+			return RainBar(rainfall, weather.Units)
+		},
+		"icon":     iconDataURI,
+		"iconURL":  iconURL,
+		"asset":    assetURL,
+		"baseURL":  func() string { return baseURL(r) },
+		"page":     func() string { return baseURL(r) + "/" + url.PathEscape(weather.City) + "?lang=" + string(lang) },
+		"updated":  func() string { return lang.FormatDateTime(weather.LastUpdated.In(location)) },
+		"theme":    func() Theme { return c.Theme },
+		"themes":   func() []Theme { return themes },
+		"themeURL": func(t Theme) string { return themeURL(r, t) },
+		"cityURL": func(city string) string {
+			return "/" + url.PathEscape(city) + "?lang=" + string(lang)
+		},
+		"favorites": func() (template.HTML, error) {
+			var b strings.Builder
+			err := renderTemplate(&b, "favorites.html", c, c.Favorites)
+			return template.HTML(b.String()), err
+		},
+		"partial": func(name string) (template.HTML, error) {
+			var b strings.Builder
+			err := renderTemplate(&b, name, c, weather)
+			return template.HTML(b.String()), err
+		},
+		"radarURL":          func() string { return radarPageURL(weather.City) },
+		"precipitationType": lang.PrecipitationType,
+		"summaryOf":         lang.Summary,
+		"elapsed":           lang.Elapsed,
+		"milestone":         lang.Milestone,
+		"cacheSeconds":      func() int { return int(cacheTTL().Seconds()) },
+		"add":               func(a, b float64) float64 { return b + a },
+		"sub":               func(a, b float64) float64 { return b - a },
+	}
+}
+
+// templateSpec is a template and the data it is run with.
+type templateSpec struct {
+	Name string
+	Data func(c templateContext) any
+}
+
+// templateData lists the templates with a function returning example data
+// for them, which overrides are checked with before they are used. Partials
+// come before the templates using them.
+var templateData = []templateSpec{
+	{"favorites.html", func(c templateContext) any { return c.Favorites }},
+	{"current.html", func(c templateContext) any { return c.Weather }},
+	{"hourly.html", func(c templateContext) any { return c.Weather }},
+	{"weather.html", func(c templateContext) any { return c.Weather }},
+	{"widget.html", func(c templateContext) any { return c.Weather }},
+	{"streak.html", func(c templateContext) any { return exampleStreak() }},
+	{"admin.html", func(c templateContext) any { return exampleAdminPage(c.Weather) }},
+	{"badge.svg", func(c templateContext) any { return NewBadge(c.Weather, c.Lang, "") }},
+	{"chart.svg", func(c templateContext) any { return NewChart(c.Weather, c.Lang, chartHours) }},
+	{"digest.html", func(c templateContext) any {
+		return Digest{Cities: []DigestCity{{c.Weather, "https://keli.example.com/Hyvink%C3%A4%C3%A4"}}, Unsubscribe: "https://keli.example.com/digest/unsubscribe"}
+	}},
+}
+
+// exampleContext is the context templates are checked in.
+func exampleContext() templateContext {
+	r, _ := http.NewRequest(http.MethodGet, "http://localhost/Hyvinkää", nil)
+	favorites := Favorites{City: "Hyvinkää", Starred: true, Favorites: []string{"Hyvinkää", "Espoo"}, Recent: []string{"Riihimäki"}}
+	weather := exampleWeather()
+	return templateContext{
+		Request:   r,
+		Lang:      LangFinnish,
+		Weather:   weather,
+		Theme:     ThemeAuto,
+		Favorites: favorites,
+		Day:       ForecastDay(weather, weather.DailyForecast[0].Date),
+	}
+}
+
+// exampleWeather returns made up weather data with every field set.
+func exampleWeather() WeatherData {
+	weather := WeatherData{
+		Weather: keli.Weather{
+			City:                   "Hyvinkää",
+			ObservationHour:        14,
+			WeatherSummary:         "Puolipilvistä",
+			SymbolCode:             "d200",
+			WeatherSymbol:          keli.WeatherSymbolEmoji("d200"),
+			Temperature:            2.1,
+			TemperatureFeelsLike:   -1.5,
+			TemperatureMin:         -1,
+			TemperatureMax:         4,
+			Rainfall:               0.4,
+			WindSpeed:              5,
+			WindSpeedUnit:          keli.WindMetersPerSecond,
+			Beaufort:               keli.BeaufortNumber(5),
+			RainChance:             40,
+			TemperatureTomorrow:    5,
+			TemperatureMinTomorrow: -2,
+			Sunrise:                "7:49",
+			Sunset:                 "17:52",
+			DayLength:              "10:03",
+			Units:                  keli.UnitsMetric,
+			LastUpdated:            time.Date(2024, 10, 16, 14, 5, 0, 0, location),
+		},
+		WindDescription: BeaufortDescription(5),
+	}
+	for i, code := range []string{"d200", "d210", "d310", "n300", "n000", "n410"} {
+		weather.HourlyForecast = append(weather.HourlyForecast, keli.HourlyForecast{
+			Hour:          fmt.Sprint(15 + i),
+			SymbolCode:    code,
+			WeatherSymbol: keli.WeatherSymbolEmoji(code),
+			Temperature:   2 - float64(i)/2,
+			WindSpeed:     5,
+			Rainfall:      float64(i%3) * 0.2,
+			RainChance:    i * 10,
+		})
+	}
+	weather.Electricity = &Electricity{Now: 14.2, Prices: []SpotPrice{}}
+	for i := range 6 {
+		weather.Electricity.Prices = append(weather.Electricity.Prices, SpotPrice{
+			Time:  weather.LastUpdated.Truncate(time.Hour).Add(time.Duration(i) * time.Hour),
+			Price: 14.2 + float64(i%3)*3.1,
+		})
+	}
+	weather.Electricity.Cheapest = weather.Electricity.Prices[0]
+	for i, code := range []string{"d200", "d310", "d100", "d000", "d410", "d600", "d210"} {
+		weather.DailyForecast = append(weather.DailyForecast, keli.DailyForecast{
+			Date:           weather.LastUpdated.AddDate(0, 0, i).Format(time.DateOnly),
+			SymbolCode:     code,
+			WeatherSymbol:  keli.WeatherSymbolEmoji(code),
+			TemperatureMax: 4 - float64(i),
+			TemperatureMin: -1 - float64(i),
+			Rainfall:       float64(i%2) * 1.5,
+		})
+	}
+	return weather
+}
+
+// parseTemplates parses the embedded templates, using the files in dir
+// instead when they parse and run with the example data.
+func parseTemplates(dir string) (map[string]*template.Template, error) {
+	parsed := make(map[string]*template.Template)
+	example := exampleContext()
+	example.templates = parsed
+
+	for _, t := range templateData {
+		name, data := t.Name, t.Data
+		embedded, err := fs.ReadFile(embeddedTemplates, "templates/"+name)
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := checkTemplate(name, string(embedded), example, data(example))
+		if err != nil {
+			return nil, err
+		}
+		parsed[name] = tmpl
+
+		if dir == "" {
+			continue
+		}
+		override, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			tmpl, err = checkTemplate(name, string(override), example, data(example))
+		}
+		if err != nil {
+			log.Printf("Error in template %s, using the built-in one: %v", filepath.Join(dir, name), err)
+			continue
+		}
+		parsed[name] = tmpl
+	}
+
+	return parsed, nil
+}
+
+// checkTemplate parses the template and runs a copy of it with the example
+// data. The template itself is left unexecuted, as html/template can only
+// clone those for renderTemplate.
+func checkTemplate(name, text string, example templateContext, data any) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(example.funcs()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	check, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if err := check.Execute(io.Discard, data); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// LoadTemplates loads template overrides from dir. Files missing from the
+// directory, or ones that fail to parse or run, fall back to the templates
+// built into the binary. A missing directory is fine.
+func LoadTemplates(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	parsed, err := parseTemplates(dir)
+	if err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		known := slices.ContainsFunc(templateData, func(t templateSpec) bool { return t.Name == file.Name() })
+		if !known {
+			log.Printf("Unknown template %s, ignoring it", filepath.Join(dir, file.Name()))
+		}
+	}
+
+	templates = parsed
+	return nil
+}
+
+// renderTemplate runs the named template with functions bound to the
+// request.
+func renderTemplate(w io.Writer, name string, c templateContext, data any) error {
+	set := templates
+	if c.templates != nil {
+		set = c.templates
+	}
+	tmpl, found := set[name]
+	if !found {
+		return fmt.Errorf("Unknown template \"%s\"", name)
+	}
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	return tmpl.Funcs(c.funcs()).Execute(w, data)
+}
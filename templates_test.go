@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestStreakCardEscapesName renders the streak card with markup in the
+// name, which the names of the config can't have today but the card must
+// not rely on.
+func TestStreakCardEscapesName(t *testing.T) {
+	status := exampleStreak()
+	status.Name = `<script>alert(1)</script>`
+
+	var b strings.Builder
+	if err := renderTemplate(&b, "streak.html", templateContext{Lang: LangEnglish}, status); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), status.Name) {
+		t.Errorf("Streak name not escaped in:\n%s", b.String())
+	}
+	if !strings.Contains(b.String(), "&lt;script&gt;") {
+		t.Errorf("Streak name missing from:\n%s", b.String())
+	}
+}
+
+// TestWidgetEscapesInput asks for the widget with markup in the Host header,
+// which goes in the links of the widget, and in the city, which isn't a
+// known place even to the made up weather.
+func TestWidgetEscapesInput(t *testing.T) {
+	StartMock()
+
+	r := httptest.NewRequest(http.MethodGet, "/widget?city=Oulu", nil)
+	r.Host = `keli.example.com"><script>alert(1)</script>`
+	w := httptest.NewRecorder()
+	widgetHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got %d for the widget of Oulu: %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), "<script>alert(1)") {
+		t.Errorf("Host not escaped in:\n%s", w.Body)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/widget?city="+url.QueryEscape("<script>alert(1)</script>"), nil)
+	w = httptest.NewRecorder()
+	widgetHandler(w, r)
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Got %d %s for an unknown city, want 404 text/plain", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+// TestWeatherPageUnknownCity asks for the page of a city with markup in its
+// name, which must be answered as text rather than HTML.
+func TestWeatherPageUnknownCity(t *testing.T) {
+	StartMock()
+
+	r := httptest.NewRequest(http.MethodGet, "/"+url.PathEscape("<script>alert(1)</script>"), nil)
+	w := httptest.NewRecorder()
+	weatherPageHandler(w, r)
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Got %d %s for an unknown city, want 404 text/plain", w.Code, w.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Theme is the color theme of the weather page.
+type Theme string
+
+const (
+	// ThemeAuto follows the light or dark setting of the browser
+	ThemeAuto  Theme = "auto"
+	ThemeLight Theme = "light"
+	ThemeDark  Theme = "dark"
+)
+
+// themes lists the themes in the order they are offered on the page
+var themes = []Theme{ThemeAuto, ThemeLight, ThemeDark}
+
+// themeCookie remembers the theme between visits
+const themeCookie = "theme"
+
+// ParseTheme parses the theme query parameter. An empty value means auto.
+func ParseTheme(theme string) (Theme, error) {
+	switch Theme(theme) {
+	case "", ThemeAuto:
+		return ThemeAuto, nil
+	case ThemeLight, ThemeDark:
+		return Theme(theme), nil
+	}
+	return "", fmt.Errorf("Unknown theme \"%s\", expected auto, light or dark", theme)
+}
+
+// requestTheme returns the theme of the request. A theme parameter is
+// remembered in a cookie, without one the cookie is used.
+func requestTheme(w http.ResponseWriter, r *http.Request) (Theme, error) {
+	if value := r.URL.Query().Get("theme"); value != "" {
+		theme, err := ParseTheme(value)
+		if err != nil {
+			return "", err
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     themeCookie,
+			Value:    string(theme),
+			Path:     "/",
+			Expires:  time.Now().AddDate(1, 0, 0),
+			SameSite: http.SameSiteLaxMode,
+		})
+		return theme, nil
+	}
+
+	if cookie, err := r.Cookie(themeCookie); err == nil {
+		if theme, err := ParseTheme(cookie.Value); err == nil {
+			return theme, nil
+		}
+	}
+	return ThemeAuto, nil
+}
+
+// themeURL returns the URL of the request with the theme changed.
+func themeURL(r *http.Request, theme Theme) string {
+	query := r.URL.Query()
+	query.Set("theme", string(theme))
+	return r.URL.Path + "?" + query.Encode()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// chance of thunder (%) from which the symbol of an hour or a day is one of
+// thunder, whatever the site shows
+const thunderThreshold = 30
+
+// parseFMIForecast parses the values of a simple point forecast of FMI by
+// their time in UTC. The forecast is asked for one parameter, so each time
+// has one value.
+func parseFMIForecast(r io.Reader) (map[time.Time]float64, error) {
+	values := make(map[time.Time]float64)
+	decoder := xml.NewDecoder(r)
+
+	var element string
+	var at time.Time
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			element = t.Name.Local
+		case xml.EndElement:
+			element = ""
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			switch element {
+			case "Time":
+				at, _ = time.Parse(time.RFC3339, text)
+			case "ParameterValue":
+				value, err := strconv.ParseFloat(text, 64)
+				if err != nil || math.IsNaN(value) || at.IsZero() {
+					continue
+				}
+				values[at.UTC()] = value
+			}
+		}
+	}
+	return values, nil
+}
+
+// FetchThunderChances returns the chance of thunder (%) of the hours of the
+// forecast of FMI for the place.
+func FetchThunderChances(place string) (map[time.Time]float64, error) {
+	query := url.Values{
+		"service":        {"WFS"},
+		"version":        {"2.0.0"},
+		"request":        {"getFeature"},
+		"storedquery_id": {"fmi::forecast::edited::weather::scandinavia::point::simple"},
+		"parameters":     {"ProbabilityThunderstorm"},
+		"timestep":       {"60"},
+		"place":          {place},
+	}
+	req, err := http.NewRequest(http.MethodGet, fmiWFS+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	res, err := fmiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("No forecast for \"%s\": %s", place, res.Status)
+	}
+	chances, err := parseFMIForecast(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error in the forecast of \"%s\": %v", place, err)
+	}
+	return chances, nil
+}
+
+// thunderChances returns the chances of thunder for the city, logging the
+// error and returning none if they can't be had, so that the weather is
+// served without them.
+func thunderChances(ctx context.Context, city string) map[time.Time]float64 {
+	if offline() {
+		// no FMI when replaying recorded pages or with -mock
+		return nil
+	}
+	chances, err := FetchThunderChances(city)
+	if err != nil {
+		logf(ctx, "Error getting the chance of thunder in %s: %v", city, err)
+		return nil
+	}
+	return chances
+}
+
+// thunderSymbol returns the symbol code of thunder with the day or night
+// and the clouds of the code, as the codes of thunder are all rain.
+func thunderSymbol(code string) string {
+	if !keli.IsWeatherSymbolCode(code) || code[2] == '4' {
+		return code
+	}
+	clouds := code[1]
+	if clouds != '3' && clouds != '4' {
+		// showers
+		clouds = '2'
+	}
+	return string(code[0]) + string(clouds) + "40"
+}
+
+// applyThunderChances sets the chance of thunder of the hours and the days
+// of the forecast, the highest of its hours for a day, and turns the
+// symbols of those likely to thunder into ones of thunder. The current
+// symbol follows the first hour.
+func applyThunderChances(weather *WeatherData, chances map[time.Time]float64) {
+	if len(chances) == 0 {
+		return
+	}
+
+	times := ForecastTimes(*weather)
+	for i := range weather.HourlyForecast {
+		h := &weather.HourlyForecast[i]
+		h.ThunderChance = int(math.Round(chances[times[i].UTC()]))
+		if h.ThunderChance >= thunderThreshold {
+			h.SymbolCode = thunderSymbol(h.SymbolCode)
+			h.WeatherSymbol = keli.WeatherSymbolEmoji(h.SymbolCode)
+		}
+	}
+	if len(weather.HourlyForecast) > 0 {
+		weather.SymbolCode = weather.HourlyForecast[0].SymbolCode
+		weather.WeatherSymbol = weather.HourlyForecast[0].WeatherSymbol
+	}
+
+	daily := make(map[string]int)
+	for at, chance := range chances {
+		date := at.In(location).Format(time.DateOnly)
+		daily[date] = max(daily[date], int(math.Round(chance)))
+	}
+	for i := range weather.DailyForecast {
+		d := &weather.DailyForecast[i]
+		d.ThunderChance = daily[d.Date]
+		if d.ThunderChance >= thunderThreshold {
+			d.SymbolCode = thunderSymbol(d.SymbolCode)
+			d.WeatherSymbol = keli.WeatherSymbolEmoji(d.SymbolCode)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// ConvertUnits returns a copy of the metric weather data converted to the
+// given unit system, see keli.ConvertUnits. The advisories are converted
+// along with the temperatures.
+func ConvertUnits(weather WeatherData, units keli.UnitSystem, windUnit keli.WindUnit) WeatherData {
+	converted := keli.ConvertUnits(weather.Weather, units, windUnit)
+	if weather.Units == keli.UnitsMetric && converted.Units == keli.UnitsImperial && weather.Advisories != nil {
+		advisories := make([]Advisory, len(weather.Advisories))
+		for i, a := range weather.Advisories {
+			a.Threshold = keli.CelsiusToFahrenheit(a.Threshold)
+			a.Temperature = keli.CelsiusToFahrenheit(a.Temperature)
+			advisories[i] = a
+		}
+		weather.Advisories = advisories
+	}
+	weather.Weather = converted
+	return weather
+}
+
+// BeaufortDescription returns the Finnish name of the wind speed (m/s) on
+// the Beaufort scale, e.g. "navakka tuuli".
+func BeaufortDescription(ms float64) string {
+	return LangFinnish.WindDescription(keli.BeaufortNumber(ms))
+}
+
+func roundTo(value float64, decimals int) float64 {
+	pow := math.Pow(10, float64(decimals))
+	return math.Round(value*pow) / pow
+}
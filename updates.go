@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+var (
+	// channels of the subscribers to the weather of each city, by the
+	// sanitized city name the cache uses
+	subscribers      = make(map[string]map[chan WeatherData]bool)
+	subscribersMutex sync.Mutex
+
+	// the cities each kind of notification watches, see watchCity
+	watchedCities      = make(map[string]bool)
+	watchedCitiesMutex sync.Mutex
+)
+
+// Subscribe returns a channel receiving the weather of the city whenever it
+// is refreshed in the cache, and a function ending the subscription. A
+// subscriber that falls behind only gets the latest weather.
+func Subscribe(city string) (<-chan WeatherData, func()) {
+	city = keli.SanitizeCityName(city)
+	updates := make(chan WeatherData, 1)
+
+	subscribersMutex.Lock()
+	if subscribers[city] == nil {
+		subscribers[city] = make(map[chan WeatherData]bool)
+	}
+	subscribers[city][updates] = true
+	subscribersMutex.Unlock()
+
+	unsubscribe := func() {
+		subscribersMutex.Lock()
+		delete(subscribers[city], updates)
+		if len(subscribers[city]) == 0 {
+			delete(subscribers, city)
+		}
+		subscribersMutex.Unlock()
+	}
+	return updates, unsubscribe
+}
+
+// publish sends refreshed weather to the subscribers of the city.
+func publish(city string, weather WeatherData) {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+
+	for updates := range subscribers[city] {
+		// replace an update the subscriber hasn't read yet
+		select {
+		case <-updates:
+		default:
+		}
+		updates <- weather
+	}
+}
+
+// WatchWeather calls handle with the weather of the city, and again whenever
+// it is refreshed. The cache only refreshes when asked for the weather, so
+// it is asked for whenever it may have expired. Runs forever.
+func WatchWeather(city string, handle func(WeatherData)) {
+	updates, _ := Subscribe(city)
+
+	ticker := time.NewTicker(cacheTTL())
+	defer ticker.Stop()
+
+	weather, err := GetWeatherData(context.Background(), city)
+	if err != nil {
+		log.Printf("Error getting weather for %s: %v", city, err)
+	} else {
+		// the weather may have been refreshed just now, don't handle it twice
+		select {
+		case <-updates:
+		default:
+		}
+		handle(weather)
+	}
+
+	for {
+		select {
+		case weather := <-updates:
+			handle(weather)
+		case <-ticker.C:
+			if _, err := GetWeatherData(context.Background(), city); err != nil {
+				log.Printf("Error getting weather for %s: %v", city, err)
+			}
+		}
+	}
+}
+
+// cityKey is the city as the notifications watching it tell it apart.
+func cityKey(city string) string {
+	return strings.ToLower(keli.SanitizeCityName(city))
+}
+
+// watchCity starts watching the weather of the city for the kind of
+// notification, e.g. "webhooks", unless it already is, calling handle with
+// the cityKey of the city and the weather. The notifications read their
+// thresholds from the config in use on each refresh, so a reload only
+// needs to start watching the cities new to them.
+func watchCity(kind, city string, handle func(city string, weather WeatherData)) {
+	key := cityKey(city)
+	watchedCitiesMutex.Lock()
+	defer watchedCitiesMutex.Unlock()
+	if watchedCities[kind+"|"+key] {
+		return
+	}
+	watchedCities[kind+"|"+key] = true
+	go WatchWeather(city, func(weather WeatherData) { handle(key, weather) })
+}
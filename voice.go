@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	// oldest Alexa request accepted, as Amazon requires
+	alexaMaxAge = 150 * time.Second
+	// the certificate Alexa requests are signed with is for this name
+	alexaCertName = "echo-api.amazon.com"
+)
+
+var (
+	alexaClient = &http.Client{Timeout: 10 * time.Second}
+	// signing certificates of Alexa by the URL of their chain
+	alexaCerts      = make(map[string]*x509.Certificate)
+	alexaCertsMutex sync.Mutex
+)
+
+// alexaRequest is the part of an Alexa skill request keli looks at.
+type alexaRequest struct {
+	Request struct {
+		Type      string    `json:"type"`
+		Timestamp time.Time `json:"timestamp"`
+		Locale    string    `json:"locale"`
+		Intent    struct {
+			Name  string `json:"name"`
+			Slots map[string]struct {
+				Value string `json:"value"`
+			} `json:"slots"`
+		} `json:"intent"`
+	} `json:"request"`
+}
+
+type alexaResponse struct {
+	Version  string            `json:"version"`
+	Response alexaResponseBody `json:"response"`
+}
+
+type alexaResponseBody struct {
+	OutputSpeech     *alexaSpeech `json:"outputSpeech,omitempty"`
+	Reprompt         *alexaPrompt `json:"reprompt,omitempty"`
+	ShouldEndSession *bool        `json:"shouldEndSession,omitempty"`
+}
+
+type alexaPrompt struct {
+	OutputSpeech alexaSpeech `json:"outputSpeech"`
+}
+
+type alexaSpeech struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// dialogflowRequest is the part of a Dialogflow ES fulfillment request keli
+// looks at.
+type dialogflowRequest struct {
+	QueryResult struct {
+		Parameters   map[string]any `json:"parameters"`
+		LanguageCode string         `json:"languageCode"`
+	} `json:"queryResult"`
+}
+
+// voiceOptions are the query parameters of the voice endpoints, set in the
+// endpoint URL of the skill or agent.
+type voiceOptions struct {
+	// City when the user doesn't name one
+	city     string
+	units    keli.UnitSystem
+	windUnit keli.WindUnit
+	// Language when keli doesn't speak the user's
+	lang Language
+}
+
+func parseVoiceOptions(r *http.Request) (voiceOptions, error) {
+	o := voiceOptions{city: r.URL.Query().Get("city")}
+	var err error
+	if o.units, err = keli.ParseUnitSystem(r.URL.Query().Get("units")); err != nil {
+		return o, err
+	}
+	if o.windUnit, err = keli.ParseWindUnit(r.URL.Query().Get("wind_unit")); err != nil {
+		return o, err
+	}
+	if o.lang, err = ParseLanguage(r.URL.Query().Get("lang")); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// language returns the language of a locale like "sv-SE" if keli speaks it.
+func (o voiceOptions) language(locale string) Language {
+	lang, err := ParseLanguage(strings.Split(locale, "-")[0])
+	if err != nil || locale == "" {
+		return o.lang
+	}
+	return lang
+}
+
+// speak returns the spoken weather of the city, or what went wrong.
+func (o voiceOptions) speak(ctx context.Context, city string, lang Language) string {
+	weather, err := GetWeatherData(ctx, city)
+	if err != nil {
+		logf(ctx, "Error getting weather for %s: %v", city, err)
+		return lang.T("voiceUnknownCity", city)
+	}
+	return SpeechText(ConvertUnits(weather, o.units, o.windUnit), lang)
+}
+
+// alexaHandler serves /voice/alexa, the endpoint of an Alexa skill. Any
+// intent with a city slot gets the weather of the city read out.
+func alexaHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	options, err := parseVoiceOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := verifyAlexa(r.Header, body); err != nil {
+		logf(r.Context(), "Refusing Alexa request: %v", err)
+		http.Error(w, "Invalid request signature", http.StatusBadRequest)
+		return
+	}
+
+	var req alexaRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if time.Since(req.Request.Timestamp).Abs() > alexaMaxAge {
+		http.Error(w, "Request is too old", http.StatusBadRequest)
+		return
+	}
+
+	lang := options.language(req.Request.Locale)
+	end := true
+	speech := ""
+	switch {
+	case req.Request.Type == "SessionEndedRequest",
+		req.Request.Intent.Name == "AMAZON.StopIntent",
+		req.Request.Intent.Name == "AMAZON.CancelIntent":
+	case req.Request.Intent.Name == "AMAZON.HelpIntent":
+		speech, end = lang.T("voiceHelp"), false
+	default:
+		city := req.Request.Intent.Slots["city"].Value
+		if city == "" {
+			city = options.city
+		}
+		if city == "" {
+			speech, end = lang.T("voiceAsk"), false
+		} else {
+			speech = options.speak(r.Context(), city, lang)
+		}
+	}
+
+	response := alexaResponse{Version: "1.0"}
+	if speech != "" {
+		response.Response.OutputSpeech = &alexaSpeech{Type: "PlainText", Text: speech}
+		response.Response.ShouldEndSession = &end
+		if !end {
+			response.Response.Reprompt = &alexaPrompt{OutputSpeech: alexaSpeech{Type: "PlainText", Text: lang.T("voiceAsk")}}
+		}
+	}
+	writeVoiceResponse(w, response)
+}
+
+// verifyAlexa checks the signature of an Alexa request, as Amazon requires
+// of skills not hosted by it: the signing certificate is fetched from the
+// URL in the request, which has to point to Amazon's certificates.
+func verifyAlexa(header http.Header, body []byte) error {
+	chainURL := header.Get("SignatureCertChainUrl")
+	u, err := url.Parse(chainURL)
+	if err != nil || !strings.EqualFold(u.Scheme, "https") || !strings.EqualFold(u.Hostname(), "s3.amazonaws.com") ||
+		(u.Port() != "" && u.Port() != "443") || !strings.HasPrefix(path.Clean(u.Path), "/echo.api/") {
+		return fmt.Errorf("Invalid certificate URL \"%s\"", chainURL)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(header.Get("Signature-256"))
+	if err != nil {
+		return fmt.Errorf("Invalid signature: %v", err)
+	}
+
+	cert, err := alexaCert(chainURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("Unexpected key in the certificate")
+	}
+	hash := sha256.Sum256(body)
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+}
+
+// alexaCert returns the signing certificate from the chain at the URL,
+// checked to be valid now and issued for Alexa.
+func alexaCert(chainURL string) (*x509.Certificate, error) {
+	alexaCertsMutex.Lock()
+	cert, found := alexaCerts[chainURL]
+	alexaCertsMutex.Unlock()
+
+	if !found {
+		res, err := alexaClient.Get(chainURL)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var chain []*x509.Certificate
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, c)
+		}
+		if len(chain) == 0 {
+			return nil, fmt.Errorf("No certificates at %s", chainURL)
+		}
+		cert = chain[0]
+
+		intermediates := x509.NewCertPool()
+		for _, c := range chain[1:] {
+			intermediates.AddCert(c)
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: alexaCertName, Intermediates: intermediates}); err != nil {
+			return nil, err
+		}
+
+		alexaCertsMutex.Lock()
+		alexaCerts[chainURL] = cert
+		alexaCertsMutex.Unlock()
+	}
+
+	// a cached certificate may have expired since
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) || !slices.Contains(cert.DNSNames, alexaCertName) {
+		return nil, fmt.Errorf("Certificate at %s is not valid now", chainURL)
+	}
+	return cert, nil
+}
+
+// dialogflowHandler serves /voice/dialogflow, the fulfillment webhook of a
+// Dialogflow ES agent, also used by Google Assistant actions. The city is
+// the geo-city or city parameter of the intent.
+func dialogflowHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	options, err := parseVoiceOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req dialogflowRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lang := options.language(req.QueryResult.LanguageCode)
+	city := options.city
+	for _, name := range []string{"geo-city", "city"} {
+		if value, ok := req.QueryResult.Parameters[name].(string); ok && value != "" {
+			city = value
+			break
+		}
+	}
+
+	speech, end := lang.T("voiceAsk"), false
+	if city != "" {
+		speech, end = options.speak(r.Context(), city, lang), true
+	}
+
+	writeVoiceResponse(w, map[string]any{
+		"fulfillmentText": speech,
+		"payload": map[string]any{
+			"google": map[string]any{
+				"expectUserResponse": !end,
+				"richResponse": map[string]any{
+					"items": []any{
+						map[string]any{"simpleResponse": map[string]string{"textToSpeech": speech}},
+					},
+				},
+			},
+		},
+	})
+}
+
+func writeVoiceResponse(w http.ResponseWriter, response any) {
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	_, err = w.Write(jsonData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// retries of a failed delivery unless the webhook sets its own
+	defaultWebhookRetries = 3
+	// delay before the first retry, doubling for each next one
+	webhookRetryDelay = 2 * time.Second
+)
+
+var (
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+	// webhooks registered through the API, kept in the file of webhookApi
+	registeredWebhooks []Webhook
+	// what each webhook was last notified of, see webhookStateOf
+	webhookStates = make(map[string]*webhookState)
+	webhooksMutex sync.Mutex
+)
+
+// WebhookAPIConfig lets webhooks be registered through /webhooks besides
+// those of the config, see webhooks.go.
+type WebhookAPIConfig struct {
+	// JSON file the webhooks registered through the API are kept in,
+	// webhooks.json by default
+	File string `json:"file"`
+	// Key the API needs as a bearer token or the key parameter
+	Key string `json:"key"`
+}
+
+func (c *WebhookAPIConfig) check() error {
+	if c.Key == "" {
+		return fmt.Errorf("Missing 'key'")
+	}
+	if c.File == "" {
+		c.File = "webhooks.json"
+	}
+	return nil
+}
+
+// Webhook is a URL notified with a POST when one of its rules starts to
+// match the weather of its city.
+type Webhook struct {
+	// Name of the webhook in the logs and the notifications
+	Name  string        `json:"name"`
+	URL   string        `json:"url"`
+	City  string        `json:"city"`
+	Rules []WebhookRule `json:"rules"`
+	// Language of the notification text, Finnish by default
+	Lang string `json:"lang"`
+	// Retries of a failed delivery, 3 by default and -1 for none
+	Retries int `json:"retries"`
+
+	lang Language
+}
+
+// WebhookRule is a condition of the weather. The values are metric.
+type WebhookRule struct {
+	// rain, temperatureBelow, temperatureAbove, windAbove, thunder, which
+	// matches lightning near the city in the last hour, or warning, which
+	// notifies of each new warning of the weather like a flood warning
+	Type string `json:"type"`
+	// Limit of the temperature (°C), wind (m/s) or hourly rain (mm). Rain
+	// matches when there is more than this, so 0 means any rain.
+	Value float64 `json:"value"`
+	// Hours of the forecast to look at besides the current weather, e.g. 2
+	// for rain expected within 2 hours
+	Hours int `json:"hours"`
+}
+
+// WebhookNotification is the JSON body posted to a webhook.
+type WebhookNotification struct {
+	Webhook string      `json:"webhook"`
+	City    string      `json:"city"`
+	Rule    WebhookRule `json:"rule"`
+	// Text describing the notification, e.g. "Rain in Espoo within 2 hours"
+	Text string `json:"text"`
+	// The new warning a warning rule notifies of
+	Warning *Warning    `json:"warning,omitempty"`
+	Weather WeatherData `json:"weather"`
+}
+
+func (hook *Webhook) check() error {
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid url \"%s\", expected an http or https URL", hook.URL)
+	}
+	if hook.City == "" {
+		return fmt.Errorf("Missing 'city'")
+	}
+	if len(hook.Rules) == 0 {
+		return fmt.Errorf("Missing 'rules'")
+	}
+	for _, rule := range hook.Rules {
+		switch rule.Type {
+		case "rain", "temperatureBelow", "temperatureAbove", "windAbove", "thunder", "warning":
+		default:
+			return fmt.Errorf("Unknown rule \"%s\", expected rain, temperatureBelow, temperatureAbove, windAbove, thunder or warning", rule.Type)
+		}
+		if rule.Hours < 0 || rule.Hours > 24 {
+			return fmt.Errorf("Invalid hours %d, expected 0 to 24", rule.Hours)
+		}
+	}
+	lang, err := ParseLanguage(hook.Lang)
+	if err != nil {
+		return err
+	}
+	hook.lang = lang
+	if hook.Retries == 0 {
+		hook.Retries = defaultWebhookRetries
+	}
+	if hook.Name == "" {
+		hook.Name = hook.URL
+	}
+	return nil
+}
+
+// Match tells whether the rule matches the current weather or the forecast
+// hours it looks at.
+func (rule WebhookRule) Match(weather WeatherData) bool {
+	if rule.Type == "thunder" {
+		// strikes are observed, not forecast
+		return weather.Lightning != nil && weather.Lightning.ThunderNearby
+	}
+	if rule.Type == "warning" {
+		return len(weatherWarnings(weather)) > 0
+	}
+
+	match := func(temperature, rainfall float64, wind int) bool {
+		switch rule.Type {
+		case "rain":
+			return rainfall > rule.Value
+		case "temperatureBelow":
+			return temperature < rule.Value
+		case "temperatureAbove":
+			return temperature > rule.Value
+		case "windAbove":
+			return float64(wind) > rule.Value
+		}
+		return false
+	}
+
+	if match(weather.Temperature, weather.Rainfall, weather.WindSpeed) {
+		return true
+	}
+	for i, h := range weather.HourlyForecast {
+		if i >= rule.Hours {
+			break
+		}
+		if match(h.Temperature, h.Rainfall, h.WindSpeed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Text describes the rule matching the weather of the city.
+func (rule WebhookRule) Text(lang Language, city string) string {
+	var text string
+	switch rule.Type {
+	case "rain":
+		text = lang.T("webhookRain", city)
+	case "temperatureBelow":
+		text = lang.T("webhookTemperatureBelow", city, lang.Temperature(rule.Value, "°C"))
+	case "temperatureAbove":
+		text = lang.T("webhookTemperatureAbove", city, lang.Temperature(rule.Value, "°C"))
+	case "windAbove":
+		text = lang.T("webhookWindAbove", city, lang.Number(rule.Value)+" m/s")
+	case "thunder":
+		return lang.T("webhookThunder", city)
+	}
+	if rule.Hours > 0 {
+		text = lang.T("webhookWithin", text, rule.Hours)
+	}
+	return text
+}
+
+// webhookState is what a webhook was last notified of: the rules that
+// matched and the warnings there were at the previous refresh.
+type webhookState struct {
+	rules   []WebhookRule
+	matched []bool
+	warned  map[string]bool
+}
+
+// StartWebhooks loads the webhooks registered through the API and starts
+// watching the weather of the cities of all the webhooks.
+func StartWebhooks(c Config) error {
+	if c.WebhookAPI != nil {
+		var saved []Webhook
+		data, err := os.ReadFile(c.WebhookAPI.File)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &saved); err != nil {
+				return fmt.Errorf("Error in %s: %v", c.WebhookAPI.File, err)
+			}
+		}
+		for i := range saved {
+			if err := saved[i].check(); err != nil {
+				return fmt.Errorf("Error in webhook %d of %s: %v", i+1, c.WebhookAPI.File, err)
+			}
+		}
+		webhooksMutex.Lock()
+		registeredWebhooks = saved
+		webhooksMutex.Unlock()
+	}
+
+	for _, hook := range allWebhooks() {
+		watchCity("webhooks", hook.City, notifyWebhooks)
+	}
+	return nil
+}
+
+// allWebhooks returns the webhooks of the config and those registered
+// through the API.
+func allWebhooks() []Webhook {
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+	return append(slices.Clone(config().Webhooks), registeredWebhooks...)
+}
+
+// webhookStateOf returns what the webhook was last notified of, starting
+// over when its rules have changed.
+func webhookStateOf(hook Webhook) *webhookState {
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+
+	key := hook.Name + "|" + hook.URL
+	state := webhookStates[key]
+	if state == nil || !slices.Equal(state.rules, hook.Rules) {
+		state = &webhookState{
+			rules:   slices.Clone(hook.Rules),
+			matched: make([]bool, len(hook.Rules)),
+			warned:  make(map[string]bool),
+		}
+		webhookStates[key] = state
+	}
+	return state
+}
+
+// notifyWebhooks checks the rules of the webhooks of the city whenever its
+// weather is refreshed. A rule notifies when it starts to match, not again
+// until it has stopped matching in between. A warning rule notifies of each
+// warning that wasn't there at the previous refresh. A URL gets each
+// notification once, however many of its webhooks and rules match it.
+func notifyWebhooks(city string, weather WeatherData) {
+	delivered := make(map[string]bool)
+	deliver := func(hook Webhook, event string, notification WebhookNotification) {
+		if delivered[hook.URL+"|"+event] {
+			return
+		}
+		delivered[hook.URL+"|"+event] = true
+		go deliverWebhook(hook, notification.Text, notification)
+	}
+
+	warnings := weatherWarnings(weather)
+	for _, hook := range allWebhooks() {
+		if cityKey(hook.City) != city {
+			continue
+		}
+		state := webhookStateOf(hook)
+
+		for _, rule := range hook.Rules {
+			if rule.Type != "warning" {
+				continue
+			}
+			for _, warning := range warnings {
+				if state.warned[warningKey(warning)] {
+					continue
+				}
+				deliver(hook, "warning|"+warningKey(warning), WebhookNotification{
+					Webhook: hook.Name,
+					City:    weather.City,
+					Rule:    rule,
+					Text:    hook.lang.T("weatherWarning", weather.City, warning.Message),
+					Warning: &warning,
+					Weather: weather,
+				})
+			}
+		}
+		clear(state.warned)
+		for _, warning := range warnings {
+			state.warned[warningKey(warning)] = true
+		}
+
+		for i, rule := range hook.Rules {
+			if rule.Type == "warning" {
+				continue
+			}
+			match := rule.Match(weather)
+			if match && !state.matched[i] {
+				deliver(hook, fmt.Sprintf("%s|%v|%d", rule.Type, rule.Value, rule.Hours), WebhookNotification{
+					Webhook: hook.Name,
+					City:    weather.City,
+					Rule:    rule,
+					Text:    rule.Text(hook.lang, weather.City),
+					Weather: weather,
+				})
+			}
+			state.matched[i] = match
+		}
+	}
+}
+
+// saveWebhooks writes the webhooks registered through the API to their
+// file. The caller holds webhooksMutex.
+func saveWebhooks(c WebhookAPIConfig) {
+	data, err := json.MarshalIndent(registeredWebhooks, "", "  ")
+	if err == nil {
+		// write a temporary file first so a crash can't leave half a file
+		err = os.WriteFile(c.File+".tmp", data, 0o600)
+	}
+	if err == nil {
+		err = os.Rename(c.File+".tmp", c.File)
+	}
+	if err != nil {
+		log.Printf("Error saving webhooks to %s: %v", c.File, err)
+	}
+}
+
+// webhooksHandler serves /webhooks, GET for the webhooks registered through
+// the API and POST of a webhook as JSON to register it, or replace the one
+// of the same name, with the key of webhookApi.
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	c := config().WebhookAPI
+	if c == nil {
+		http.Error(w, "No webhook API, see webhookApi in the configuration", http.StatusNotFound)
+		return
+	}
+	// the URLs of the webhooks may have secrets in them
+	if !validKey(r, c.Key) {
+		http.Error(w, "Webhooks need a valid key", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		webhooksMutex.Lock()
+		hooks := append([]Webhook{}, registeredWebhooks...)
+		webhooksMutex.Unlock()
+		writeJSON(w, http.StatusOK, hooks)
+
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 16<<10))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var hook Webhook
+		if err := json.Unmarshal(body, &hook); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid webhook: %v", err), http.StatusBadRequest)
+			return
+		}
+		// the name is in the URL of the webhook in the API
+		if hook.Name == "" {
+			http.Error(w, "Missing 'name'", http.StatusBadRequest)
+			return
+		}
+		if err := hook.check(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if slices.ContainsFunc(config().Webhooks, func(old Webhook) bool { return old.Name == hook.Name }) {
+			http.Error(w, fmt.Sprintf("Webhook \"%s\" is in the configuration", hook.Name), http.StatusConflict)
+			return
+		}
+
+		webhooksMutex.Lock()
+		status := http.StatusCreated
+		if i := slices.IndexFunc(registeredWebhooks, func(old Webhook) bool { return old.Name == hook.Name }); i >= 0 {
+			registeredWebhooks[i], status = hook, http.StatusOK
+		} else {
+			registeredWebhooks = append(registeredWebhooks, hook)
+		}
+		saveWebhooks(*c)
+		webhooksMutex.Unlock()
+		watchCity("webhooks", hook.City, notifyWebhooks)
+		writeJSON(w, status, hook)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// webhookHandler serves /webhooks/<name>, GET for a webhook registered
+// through the API and DELETE to remove it, with the key of webhookApi.
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	c := config().WebhookAPI
+	if c == nil {
+		http.Error(w, "No webhook API, see webhookApi in the configuration", http.StatusNotFound)
+		return
+	}
+	if !validKey(r, c.Key) {
+		http.Error(w, "Webhooks need a valid key", http.StatusForbidden)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+	i := slices.IndexFunc(registeredWebhooks, func(hook Webhook) bool { return hook.Name == name })
+	if i < 0 {
+		http.Error(w, fmt.Sprintf("Unknown webhook \"%s\"", name), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, registeredWebhooks[i])
+
+	case http.MethodDelete:
+		hook := registeredWebhooks[i]
+		registeredWebhooks = slices.Delete(registeredWebhooks, i, i+1)
+		delete(webhookStates, hook.Name+"|"+hook.URL)
+		saveWebhooks(*c)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// deliverWebhook posts the notification described by text, retrying with a
+// growing delay when the webhook can't be reached or has trouble on its
+// side.
+func deliverWebhook(hook Webhook, text string, notification any) {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		log.Printf("Error encoding notification for webhook %s: %v", hook.Name, err)
+		return
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := postWebhook(hook.URL, body)
+		if err == nil {
+			log.Printf("Notified webhook %s: %s", hook.Name, text)
+			return
+		}
+		if !retry || attempt >= hook.Retries {
+			log.Printf("Error notifying webhook %s, giving up: %v", hook.Name, err)
+			return
+		}
+		log.Printf("Error notifying webhook %s, retrying in %s: %v", hook.Name, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postWebhook posts the body to the URL, telling whether a failure is worth
+// retrying.
+func postWebhook(target string, body []byte) (retry bool, err error) {
+	res, err := webhookClient.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("%s", res.Status)
+	}
+	return false, fmt.Errorf("%s", res.Status)
+}
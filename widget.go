@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+const (
+	// size of the widget iframe unless the consumer asks for a smaller one
+	widgetWidth  = 320
+	widgetHeight = 120
+)
+
+// OEmbed is an oEmbed response, see https://oembed.com
+type OEmbed struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	CacheAge     int    `json:"cache_age"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// widgetHandler serves /widget?city=X, a small self-contained weather box
+// for embedding in an iframe.
+func widgetHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		http.Error(w, "Missing 'city' parameter", http.StatusBadRequest)
+		return
+	}
+
+	units, err := keli.ParseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lang, err := ParseLanguage(r.URL.Query().Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+	weather = ConvertUnits(weather, units, "")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	err = renderTemplate(w, "widget.html", templateContext{Request: r, Lang: lang, Weather: weather}, weather)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// oembedCity returns the city of a keli page or widget URL.
+func oembedCity(r *http.Request, pageURL string) (string, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return "", fmt.Errorf("Not a keli URL \"%s\"", pageURL)
+	}
+	if city := u.Query().Get("city"); city != "" {
+		return city, nil
+	}
+	if city := strings.Trim(u.Path, "/"); city != "" && !strings.Contains(city, "/") {
+		return city, nil
+	}
+	return "", fmt.Errorf("No city in URL \"%s\"", pageURL)
+}
+
+// oembedHandler serves /oembed?url=X, which turns links to keli pages into
+// the widget on sites that support oEmbed.
+func oembedHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "json" {
+		http.Error(w, fmt.Sprintf("Unsupported format \"%s\", only json is supported", format), http.StatusNotImplemented)
+		return
+	}
+
+	city, err := oembedCity(r, query.Get("url"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	lang, err := ParseLanguage(query.Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	width, height := widgetWidth, widgetHeight
+	if max, err := strconv.Atoi(query.Get("maxwidth")); err == nil && max > 0 && max < width {
+		width = max
+	}
+	if max, err := strconv.Atoi(query.Get("maxheight")); err == nil && max > 0 && max < height {
+		height = max
+	}
+
+	src := baseURL(r) + "/widget?city=" + url.QueryEscape(city) + "&lang=" + string(lang)
+	embed := OEmbed{
+		Version:      "1.0",
+		Type:         "rich",
+		Title:        lang.T("feedTitle", weather.City),
+		ProviderName: "keli",
+		ProviderURL:  baseURL(r) + "/",
+		CacheAge:     int(cacheTTL().Seconds()),
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" scrolling="no" title="%s"></iframe>`,
+			template.HTMLEscapeString(src), width, height, template.HTMLEscapeString(lang.T("feedTitle", weather.City))),
+		Width:  width,
+		Height: height,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(embed)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// most cities one WebSocket client can subscribe to
+const maxSubscriptions = 10
+
+var upgrader = websocket.Upgrader{
+	// the weather is public, dashboards on any site may connect
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsCommand is a message from a WebSocket client:
+//
+//	{"type": "subscribe", "city": "Espoo"}
+//	{"type": "unsubscribe", "city": "Espoo"}
+//	{"type": "units", "units": "imperial", "windUnit": "mph"}
+type wsCommand struct {
+	Type     string `json:"type"`
+	City     string `json:"city,omitempty"`
+	Units    string `json:"units,omitempty"`
+	WindUnit string `json:"windUnit,omitempty"`
+}
+
+// wsMessage is a message to a WebSocket client, either the weather of a
+// subscribed city, a new warning of its weather or an error about a
+// command.
+type wsMessage struct {
+	Type    string       `json:"type"`
+	City    string       `json:"city,omitempty"`
+	Weather *WeatherData `json:"weather,omitempty"`
+	Warning *Warning     `json:"warning,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// wsClient is the state of one WebSocket connection. Only the goroutine
+// running serve writes to the connection.
+type wsClient struct {
+	// context of the request that opened the connection, whose ID the log
+	// lines and fetches of the connection go with
+	ctx      context.Context
+	conn     *websocket.Conn
+	units    keli.UnitSystem
+	windUnit keli.WindUnit
+	// cancel functions of the subscriptions by sanitized city name
+	subscriptions map[string]func()
+	// weather of all subscriptions
+	updates chan WeatherData
+	// keys of the warnings sent by city, see warningKey
+	warned map[string]map[string]bool
+}
+
+// wsHandler serves /ws, a WebSocket where clients subscribe to cities and
+// get their weather whenever it is refreshed. The city, units and wind_unit
+// parameters set up the first subscriptions, the rest is done with
+// commands, see wsCommand.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	logf(r.Context(), "Received request for %s", r.URL.Path)
+
+	units, err := keli.ParseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	windUnit, err := keli.ParseWindUnit(r.URL.Query().Get("wind_unit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has responded already
+		logf(r.Context(), "Error upgrading to WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	client := &wsClient{
+		ctx:           r.Context(),
+		conn:          conn,
+		units:         units,
+		windUnit:      windUnit,
+		subscriptions: make(map[string]func()),
+		updates:       make(chan WeatherData, maxSubscriptions),
+		warned:        make(map[string]map[string]bool),
+	}
+	defer client.unsubscribeAll()
+
+	for _, city := range r.URL.Query()["city"] {
+		if err := client.handle(wsCommand{Type: "subscribe", City: city}); err != nil {
+			return
+		}
+	}
+	client.serve()
+}
+
+// serve reads commands and sends updates until the connection closes.
+func (c *wsClient) serve() {
+	done := make(chan struct{})
+	defer close(done)
+
+	commands := make(chan []byte)
+	go func() {
+		defer close(commands)
+		c.conn.SetReadLimit(4096)
+		for {
+			_, message, err := c.conn.ReadMessage()
+			if err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					logf(c.ctx, "Error reading WebSocket command: %v", err)
+				}
+				return
+			}
+			select {
+			case commands <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// the cache only refreshes when asked for the weather, so ask for it
+	// whenever it may have expired. A refresh arrives as an update.
+	ticker := time.NewTicker(cacheTTL())
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case message, open := <-commands:
+			if !open {
+				return
+			}
+			var command wsCommand
+			if jsonErr := json.Unmarshal(message, &command); jsonErr != nil {
+				err = c.conn.WriteJSON(wsMessage{Type: "error", Error: "Invalid command: " + jsonErr.Error()})
+				break
+			}
+			err = c.handle(command)
+		case weather := <-c.updates:
+			err = c.sendWeather(weather)
+		case <-ticker.C:
+			for city := range c.subscriptions {
+				if _, err := GetWeatherData(c.ctx, city); err != nil {
+					logf(c.ctx, "Error refreshing weather for %s: %v", city, err)
+				}
+			}
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle runs a command. Mistakes in commands are sent back to the client,
+// the returned error is for failing to write to the connection.
+func (c *wsClient) handle(command wsCommand) error {
+	var err error
+	switch command.Type {
+	case "subscribe":
+		err = c.subscribe(command.City)
+	case "unsubscribe":
+		if cancel, found := c.subscriptions[keli.SanitizeCityName(command.City)]; found {
+			cancel()
+			delete(c.subscriptions, keli.SanitizeCityName(command.City))
+		}
+	case "units":
+		err = c.setUnits(command.Units, command.WindUnit)
+	default:
+		err = fmt.Errorf("Unknown command \"%s\", expected subscribe, unsubscribe or units", command.Type)
+	}
+	if err != nil {
+		return c.conn.WriteJSON(wsMessage{Type: "error", City: command.City, Error: err.Error()})
+	}
+	return nil
+}
+
+func (c *wsClient) subscribe(city string) error {
+	if city == "" {
+		return fmt.Errorf("Missing 'city'")
+	}
+	key := keli.SanitizeCityName(city)
+	if _, found := c.subscriptions[key]; found {
+		return nil
+	}
+	if len(c.subscriptions) >= maxSubscriptions {
+		return fmt.Errorf("Too many cities, at most %d", maxSubscriptions)
+	}
+
+	updates, unsubscribe := Subscribe(city)
+	weather, err := GetWeatherData(c.ctx, city)
+	if err != nil {
+		unsubscribe()
+		return err
+	}
+	// the weather may have been refreshed just now, don't send it twice
+	select {
+	case <-updates:
+	default:
+	}
+
+	// forward the updates of the city to the client
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case weather := <-updates:
+				select {
+				case c.updates <- weather:
+				case <-stop:
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	c.subscriptions[key] = func() {
+		unsubscribe()
+		close(stop)
+	}
+
+	// the warnings in force are new to the subscription
+	delete(c.warned, weather.City)
+	return c.sendWeather(weather)
+}
+
+// setUnits changes the units and sends the weather again in them.
+func (c *wsClient) setUnits(unitsParam, windUnitParam string) error {
+	units, err := keli.ParseUnitSystem(unitsParam)
+	if err != nil {
+		return err
+	}
+	windUnit, err := keli.ParseWindUnit(windUnitParam)
+	if err != nil {
+		return err
+	}
+	c.units, c.windUnit = units, windUnit
+
+	for city := range c.subscriptions {
+		weather, err := GetWeatherData(c.ctx, city)
+		if err != nil {
+			return err
+		}
+		if err := c.sendWeather(weather); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendWeather sends the weather, and then each of its warnings that wasn't
+// in force when the weather of the city was sent last.
+func (c *wsClient) sendWeather(weather WeatherData) error {
+	weather = ConvertUnits(weather, c.units, c.windUnit)
+	if err := c.conn.WriteJSON(wsMessage{Type: "weather", City: weather.City, Weather: &weather}); err != nil {
+		return err
+	}
+
+	warned := make(map[string]bool)
+	for _, warning := range weatherWarnings(weather) {
+		key := warningKey(warning)
+		warned[key] = true
+		if c.warned[weather.City][key] {
+			continue
+		}
+		if err := c.conn.WriteJSON(wsMessage{Type: "warning", City: weather.City, Warning: &warning}); err != nil {
+			return err
+		}
+	}
+	c.warned[weather.City] = warned
+	return nil
+}
+
+func (c *wsClient) unsubscribeAll() {
+	for _, cancel := range c.subscriptions {
+		cancel()
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/itsnibsi/keli/pkg/keli"
+)
+
+// wttrPresets are the numbered one-line formats of wttr.in
+var wttrPresets = map[string]string{
+	"1": "%c %t\n",
+	"2": "%c 🌡️%t 🌬️%w\n",
+	"3": "%l: %c %t\n",
+	"4": "%l: %c 🌡️%t 🌬️%w\n",
+}
+
+// moonPhases are the emoji of the eight phases of the moon, from new moon
+var moonPhases = []string{"🌑", "🌒", "🌓", "🌔", "🌕", "🌖", "🌗", "🌘"}
+
+const (
+	// length of the lunar month in days
+	synodicMonth = 29.530588853
+	// hours of rain wttr.in shows as %p
+	wttrRainHours = 3
+)
+
+// a known new moon the phase is counted from
+var newMoon = time.Date(2000, 1, 6, 18, 14, 0, 0, time.UTC)
+
+// IsWttrFormat tells whether the format parameter is one of wttr.in's, a
+// preset number or a format with % placeholders.
+func IsWttrFormat(format string) bool {
+	_, preset := wttrPresets[format]
+	return preset || strings.Contains(format, "%")
+}
+
+// WttrText formats the weather the way wttr.in does its one-line formats.
+// Placeholders keli has no data for, like %h humidity or %P pressure, are
+// left empty.
+func WttrText(weather WeatherData, lang Language, format string, now time.Time) string {
+	if preset, found := wttrPresets[format]; found {
+		format = preset
+	}
+	units := weather.Labels()
+	temperature := func(t float64) string {
+		return fmt.Sprintf("%+d%s", int(math.Round(t)), units.Temperature)
+	}
+	clock := func(hhmm string) string {
+		t, err := time.Parse("15:04", hhmm)
+		if err != nil {
+			return ""
+		}
+		return t.Format("15:04:05")
+	}
+	moonAge := math.Mod(now.Sub(newMoon).Hours()/24, synodicMonth)
+
+	placeholders := map[byte]func() string{
+		'c': func() string { return weather.WeatherSymbol },
+		'C': func() string { return lang.SymbolDescription(weather.SymbolCode) },
+		'x': func() string { return wttrPlainSymbol(weather.SymbolCode) },
+		't': func() string { return temperature(weather.Temperature) },
+		'f': func() string { return temperature(weather.TemperatureFeelsLike) },
+		'w': func() string { return fmt.Sprintf("%d%s", weather.WindSpeed, units.WindSpeed) },
+		'l': func() string { return weather.City },
+		'p': func() string {
+			return lang.Precipitation(rainNextHours(weather, wttrRainHours), weather.Units) + units.Precipitation
+		},
+		'm': func() string { return moonPhases[int(moonAge/synodicMonth*8+0.5)%8] },
+		'M': func() string { return fmt.Sprint(int(moonAge)) },
+		'S': func() string { return clock(weather.Sunrise) },
+		's': func() string { return clock(weather.Sunset) },
+		'z': func() string {
+			sunrise, err1 := time.Parse("15:04", weather.Sunrise)
+			sunset, err2 := time.Parse("15:04", weather.Sunset)
+			if err1 != nil || err2 != nil {
+				return ""
+			}
+			return sunrise.Add(sunset.Sub(sunrise) / 2).Format("15:04:05")
+		},
+		'T': func() string { return now.In(location).Format("15:04:05-0700") },
+		'Z': func() string { return location.String() },
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if placeholder, found := placeholders[format[i]]; found {
+			b.WriteString(placeholder())
+		} else if format[i] == '%' {
+			b.WriteByte('%')
+		} else if !strings.ContainsRune("hPuDd", rune(format[i])) {
+			// not a placeholder of wttr.in either, keep it as it is
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
+
+// wttrPlainSymbol returns the plain text weather symbol of wttr.in's %x for
+// a symbol code, e.g. "o" for clear or "//" for rain.
+func wttrPlainSymbol(code string) string {
+	if !keli.IsWeatherSymbolCode(code) {
+		return "?"
+	}
+	digits := code[1:]
+	intensity, kind := int(digits[1]-'0'), digits[2]
+	switch {
+	case intensity == 0:
+		return map[byte]string{'0': "o", '1': "m", '2': "m", '3': "mm", '4': "mmm", '5': "m", '6': "="}[digits[0]]
+	case intensity == 4:
+		return "/!/"
+	case kind == '1':
+		return "*/*"
+	case kind == '2':
+		return strings.Repeat("*", intensity)
+	}
+	return strings.Repeat("/", intensity)
+}
+
+// wttrHandler answers a request with a wttr.in format. Like wttr.in, the
+// wind is in km/h unless ?M asks for m/s, and ?u is for US units.
+func wttrHandler(w http.ResponseWriter, r *http.Request, city string) {
+	query := r.URL.Query()
+
+	units, err := keli.ParseUnitSystem(query.Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	windUnit, err := keli.ParseWindUnit(query.Get("wind_unit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Has("u") {
+		units = keli.UnitsImperial
+	}
+	if windUnit == "" && units == keli.UnitsMetric {
+		windUnit = keli.WindKilometersPerHour
+		if query.Has("M") {
+			windUnit = keli.WindMetersPerSecond
+		}
+	}
+
+	lang, err := ParseLanguage(query.Get("lang"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	weather, err := GetWeatherData(r.Context(), city)
+	if err != nil {
+		http.Error(w, err.Error(), weatherErrorStatus(err))
+		return
+	}
+	weather = ConvertUnits(weather, units, windUnit)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cacheTTL().Seconds())))
+
+	_, err = w.Write([]byte(WttrText(weather, lang, query.Get("format"), time.Now())))
+	if err != nil {
+		logf(r.Context(), "Error writing wttr.in format for %s: %v", city, err)
+	}
+}